@@ -2,21 +2,80 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"stackagent/pkg/config"
+	stackctx "stackagent/pkg/context"
 	"stackagent/pkg/web"
 )
 
 func main() {
+	// Defaults, then the user's and the project's config.yaml, then
+	// STACKAGENT_* env vars, then flags
+	cfg, err := config.Load(config.Options{Args: os.Args[1:]})
+	if err != nil {
+		log.Fatal(err)
+	}
+	
+	// Load persistent AI context for this workspace
+	contextManager := stackctx.NewGitContextManager("")
+	if err := contextManager.AttachWorkspace(); err != nil {
+		log.Printf("Warning: Failed to load workspace context: %v", err)
+	}
+	// Batch the frequent small updates (active files, command history);
+	// pending changes are written on shutdown
+	contextManager.SetSaveDelay(time.Second)
+	if format := cfg.Context.Format; format != "" {
+		// "markdown" stores memory and knowledge as hand-editable files
+		if err := contextManager.SetStorageFormat(format); err != nil {
+			log.Printf("Warning: Failed to switch context storage to %s: %v", format, err)
+		}
+	}
+	if url := cfg.Context.EmbeddingsURL; url != "" {
+		// Semantic recall through an OpenAI-compatible endpoint instead of
+		// the built-in offline embedder
+		contextManager.SetEmbedder(stackctx.NewOpenAIEmbedder(url, cfg.Context.EmbeddingsKey, cfg.Context.EmbeddingsModel))
+	}
+	
 	// Create WebSocket server
 	wsServer := web.NewWebSocketServer()
+	wsServer.SetContextManager(contextManager)
+	if agent := wsServer.Agent(); agent != nil {
+		if err := agent.ApplySettings(cfg.AgentSettings()); err != nil {
+			log.Fatalf("Invalid agent settings: %v", err)
+		}
+		if cfg.Agent.DebugLog != "" {
+			if err := agent.EnableDebugLogging(cfg.Agent.DebugLog); err != nil {
+				log.Fatalf("Failed to enable debug logging: %v", err)
+			}
+			log.Printf("📝 Logging API calls to %s", cfg.Agent.DebugLog)
+		}
+		log.Printf("🤖 Model %s, up to %d tokens and %d tool rounds per turn", cfg.Agent.Model, cfg.Agent.MaxTokens, cfg.Agent.MaxRounds)
+	}
+	if path := cfg.Server.AuthConfig; path != "" {
+		// Static tokens and/or JWTs, roles and allowed browser origins
+		authConfig, err := web.LoadAuthConfig(path)
+		if err != nil {
+			log.Fatalf("Failed to load auth config: %v", err)
+		}
+		auth, err := web.NewAuthenticator(*authConfig)
+		if err != nil {
+			log.Fatalf("Invalid auth config %s: %v", path, err)
+		}
+		wsServer.SetAuthenticator(auth)
+	} else {
+		log.Println("Warning: server.authConfig is not set, only local connections are accepted")
+	}
 	
 	// Create HTTP server
 	mux := http.NewServeMux()
@@ -25,7 +84,7 @@ func main() {
 	mux.HandleFunc("/ws", wsServer.HandleWebSocket)
 	
 	// Serve static files from the GUI build directory
-	guiPath := filepath.Join("web", "gui", "dist")
+	guiPath := cfg.Server.GUIPath
 	if _, err := os.Stat(guiPath); os.IsNotExist(err) {
 		log.Printf("GUI build directory not found: %s", guiPath)
 		log.Println("To build the GUI, run: cd web/gui && npm install && npm run build")
@@ -102,49 +161,79 @@ func main() {
 		log.Printf("Serving GUI from: %s", guiPath)
 	}
 	
-	// API endpoints
-	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		response := fmt.Sprintf(`{
-			"status": "ok",
-			"timestamp": "%s",
-			"clients": %d,
-			"version": "1.0.0"
-		}`, fmt.Sprintf("%d", os.Getpid()), wsServer.GetClientCount())
-		w.Write([]byte(response))
-	})
+	// REST API: /api/health and the versioned API under /api/v1/
+	mux.HandleFunc("/api/", wsServer.HandleAPI)
 	
-	// Start HTTP server with graceful shutdown
-	port := "8080"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
+	// Start HTTP server with graceful shutdown; localhost unless configured
+	// otherwise, and "none" serves the Unix socket only
+	port := strconv.Itoa(cfg.Server.Port)
+	bind := cfg.Server.Bind
+	if bind != "none" {
+		// Local clients may also address the server by the name it binds to
+		wsServer.SetLocalHostNames(bind)
 	}
 	
 	// Create HTTP server with timeouts
 	server := &http.Server{
-		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
+		ConnContext:  web.ConnContext,
 	}
 	
-	log.Printf("🚀 StackAgent Server starting on port %s", port)
-	log.Printf("📡 WebSocket endpoint: ws://localhost:%s/ws", port)
-	log.Printf("🌐 GUI available at: http://localhost:%s", port)
-	log.Printf("🔧 API health check: http://localhost:%s/api/health", port)
+	var listeners []net.Listener
+	if bind != "none" {
+		listener, scheme, err := listenTCP(cfg.Server, net.JoinHostPort(bind, port))
+		if err != nil {
+			log.Fatal("Server failed to start: ", err)
+		}
+		listeners = append(listeners, listener)
+		host := bind
+		if ip := net.ParseIP(bind); ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+			host = "localhost"
+		}
+		address := net.JoinHostPort(host, port)
+		log.Printf("🚀 StackAgent Server listening on %s", listener.Addr())
+		log.Printf("📡 WebSocket endpoint: %s://%s/ws", map[string]string{"http": "ws", "https": "wss"}[scheme], address)
+		log.Printf("🌐 GUI available at: %s://%s", scheme, address)
+		log.Printf("🔧 API health check: %s://%s/api/health", scheme, address)
+		log.Printf("🔌 REST API: %s://%s/api/%s/", scheme, address, web.APIVersion)
+	}
+	if path := cfg.Server.Socket; path != "" {
+		// The mode decides who may connect, e.g. 0660 lets the owner's
+		// group in too
+		mode := cfg.Server.SocketMode
+		listener, err := web.ListenUnix(path, mode)
+		if err != nil {
+			log.Fatal("Server failed to start: ", err)
+		}
+		listeners = append(listeners, listener)
+		log.Printf("🔌 Unix socket: %s (mode %04o)", path, mode)
+	}
 	log.Printf("⚖️  Core principle: Don't be evil")
 	
-	// Start server in a goroutine
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("Server failed to start:", err)
-		}
-	}()
+	// Serve each listener in a goroutine
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Server failed: ", err)
+			}
+		}(listener)
+	}
 	
 	// Set up signal handling for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	// SIGHUP reloads the config; agent settings take effect from each
+	// conversation's next turn
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reloadConfig(cfg, wsServer)
+		}
+	}()
 	
 	// Wait for shutdown signal
 	<-quit
@@ -165,5 +254,75 @@ func main() {
 	log.Println("🧹 Cleaning up WebSocket connections...")
 	wsServer.Shutdown()
 	
+	// Persist AI context
+	if err := contextManager.SaveContext(); err != nil {
+		log.Printf("❌ Failed to save context: %v", err)
+	} else {
+		log.Println("💾 Context saved")
+	}
+	
 	log.Println("👋 Goodbye!")
-} 
\ No newline at end of file
+}
+
+// reloadConfig loads the config again and applies what can change while
+// the server runs. An invalid config leaves the running one in place.
+func reloadConfig(running *config.Config, wsServer *web.WebSocketServer) {
+	next, err := config.Load(config.Options{Args: os.Args[1:]})
+	if err != nil {
+		log.Printf("❌ Config not reloaded: %v", err)
+		return
+	}
+	changed, restart := running.Reload(next)
+	if agent := wsServer.Agent(); agent != nil && len(changed) > 0 {
+		// Load validated the settings, so they apply
+		if err := agent.ApplySettings(running.AgentSettings()); err != nil {
+			log.Printf("❌ Failed to apply agent settings: %v", err)
+		}
+	}
+	if len(restart) > 0 {
+		log.Printf("⚠️  Restart to apply: %s", strings.Join(restart, ", "))
+	}
+	if len(changed) == 0 {
+		log.Println("🔄 Config reloaded, no changes to apply")
+		return
+	}
+	log.Printf("🔄 Config reloaded: %s", strings.Join(changed, ", "))
+}
+
+// listenTCP listens on address, with TLS given a certificate and key or,
+// for local use, a self-signed certificate. It returns the listener and
+// its URL scheme.
+func listenTCP(server config.ServerConfig, address string) (net.Listener, string, error) {
+	certFile := server.TLSCert
+	keyFile := server.TLSKey
+	if server.SelfSigned {
+		configDir, err := config.Dir()
+		if err != nil {
+			return nil, "", err
+		}
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if host, _, _ := net.SplitHostPort(address); host != "" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				hosts = append(hosts, host)
+			}
+		}
+		if certFile, keyFile, err = web.SelfSignedCertificate(filepath.Join(configDir, "tls"), hosts); err != nil {
+			return nil, "", err
+		}
+		log.Printf("🔐 Using the self-signed certificate %s", certFile)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, "", err
+	}
+	if certFile == "" {
+		return listener, "http", nil
+	}
+	config, err := web.LoadTLSConfig(certFile, keyFile)
+	if err != nil {
+		listener.Close()
+		return nil, "", err
+	}
+	return tls.NewListener(listener, config), "https", nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"stackagent/pkg/ai"
+	"stackagent/pkg/config"
+	stackctx "stackagent/pkg/context"
+)
+
+const usage = `Usage: stackagent context [-dir path] <command> [args]
+       stackagent config show [flags]
+
+Commands:
+  save [branch]      Save the workspace context for a branch (default: current)
+  restore <branch>   Make a branch's saved context the workspace context
+  list               List branches with saved context
+  stats              Show context statistics per branch
+  cleanup            Remove the context of deleted branches
+  merge [flags] <from> [into]
+                     Merge a branch's context into another (default: current)
+  sync               Record the current branch and commit
+  snapshot           Pin the current context to the checked out commit
+  snapshots [ref]    List snapshots along the history of ref (default: HEAD)
+  show <ref>         Show the context as of a commit, tag or branch
+  diff <ref> <ref>   Show how the context changed between two refs
+  install-hooks      Install Git hooks that keep the context in step with Git
+  hook <name> [args] Run by the installed Git hooks
+
+Config commands:
+  show [flags]       Show the server's configuration and where each value
+                     comes from; takes the server's flags
+`
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfig(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) < 2 || os.Args[1] != "context" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("context", flag.ExitOnError)
+	dir := flags.String("dir", ".stackagent/context", "context directory")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[2:])
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	gcm := stackctx.NewGitContextManager(*dir)
+	if err := runContext(gcm, flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runConfig runs one `stackagent config` command
+func runConfig(args []string) error {
+	if len(args) < 1 || args[0] != "show" {
+		return fmt.Errorf("usage: stackagent config show [flags]\n\nFlags:\n%s", config.Usage())
+	}
+	cfg, err := config.Load(config.Options{Args: args[1:], ProgramName: "stackagent config show"})
+	if err != nil {
+		return err
+	}
+	cfg.Show(os.Stdout)
+	return nil
+}
+
+// runContext runs one `stackagent context` command
+func runContext(gcm *stackctx.GitContextManager, command string, args []string) error {
+	// Hooks load the context themselves
+	if command == "hook" {
+		if len(args) < 1 {
+			return fmt.Errorf("usage: stackagent context hook <name> [args]")
+		}
+		return gcm.RunGitHook(args[0], args[1:])
+	}
+
+	if err := gcm.LoadContext(); err != nil {
+		return fmt.Errorf("failed to load context: %w", err)
+	}
+
+	switch command {
+	case "save":
+		branch := gcm.CurrentBranch()
+		if len(args) > 0 {
+			branch = args[0]
+		}
+		if branch == "" || branch == "HEAD" {
+			return fmt.Errorf("not on a branch; name the branch to save")
+		}
+		if err := gcm.SaveContextFor(branch); err != nil {
+			return err
+		}
+		fmt.Printf("💾 Saved context for branch: %s\n", branch)
+		return nil
+
+	case "restore":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: stackagent context restore <branch>")
+		}
+		if !gcm.ContextExistsAt(args[0]) {
+			return fmt.Errorf("no saved context for branch %s", args[0])
+		}
+		if err := gcm.LoadContextFrom(args[0]); err != nil {
+			return err
+		}
+		if err := gcm.SaveContext(); err != nil {
+			return err
+		}
+		fmt.Printf("🔄 Restored AI context from branch: %s\n", args[0])
+		return nil
+
+	case "list":
+		current := gcm.CurrentBranch()
+		for _, branch := range gcm.ListBranches() {
+			marker := " "
+			if branch == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, branch)
+		}
+		return nil
+
+	case "stats":
+		stats := gcm.GetBranchStats()
+		branches := make([]string, 0, len(stats))
+		for branch := range stats {
+			branches = append(branches, branch)
+		}
+		sort.Strings(branches)
+
+		workspace := gcm.GetStats()
+		fmt.Printf("%-30s %8s %10s %9s  %s\n", "BRANCH", "MEMORY", "KNOWLEDGE", "COMMANDS", "UPDATED")
+		fmt.Printf("%-30s %8d %10d %9d  %s\n", "(workspace)", workspace.MemoryEntries, workspace.KnowledgeEntries,
+			workspace.CommandHistory, formatTime(workspace.LastUpdated))
+		for _, branch := range branches {
+			s := stats[branch]
+			fmt.Printf("%-30s %8d %10d %9d  %s\n", branch, s.MemoryEntries, s.KnowledgeEntries,
+				s.CommandHistory, formatTime(s.LastUpdated))
+		}
+		return nil
+
+	case "cleanup":
+		return gcm.CleanupBranches()
+
+	case "merge":
+		return runMerge(gcm, args)
+
+	case "sync":
+		if err := gcm.SyncWithGit(); err != nil {
+			return err
+		}
+		return gcm.SaveContext()
+
+	case "snapshot":
+		commit, err := gcm.TakeSnapshot()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("📌 Context pinned to %s\n", shortCommit(commit))
+		return nil
+
+	case "snapshots":
+		ref := ""
+		if len(args) > 0 {
+			ref = args[0]
+		}
+		infos, err := gcm.ListSnapshots(ref, 0)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			tags := ""
+			if len(info.Tags) > 0 {
+				tags = " (" + strings.Join(info.Tags, ", ") + ")"
+			}
+			fmt.Printf("%s %s %3d memory %3d knowledge  %s%s\n", shortCommit(info.Commit), formatTime(info.Time),
+				info.MemoryEntries, info.KnowledgeEntries, info.Subject, tags)
+		}
+		return nil
+
+	case "show":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: stackagent context show <ref>")
+		}
+		snapshot, err := gcm.ContextAt(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Context as of %s (snapshot of %s):\n", args[0], shortCommit(snapshot.Commit))
+		printEntries("Knowledge", snapshot.Knowledge)
+		printEntries("Memory", snapshot.Memory)
+		return nil
+
+	case "diff":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: stackagent context diff <ref> <ref>")
+		}
+		diff, err := gcm.DiffSnapshots(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if len(diff.Changes) == 0 {
+			fmt.Printf("No context changes between %s and %s\n", args[0], args[1])
+		}
+		for _, c := range diff.Changes {
+			switch c.Change {
+			case "added":
+				fmt.Printf("+ [%s] %s: %s\n", c.Kind, c.Key, c.After)
+			case "removed":
+				fmt.Printf("- [%s] %s: %s\n", c.Kind, c.Key, c.Before)
+			default:
+				fmt.Printf("~ [%s] %s: %s -> %s\n", c.Kind, c.Key, c.Before, c.After)
+			}
+		}
+		return nil
+
+	case "install-hooks":
+		return gcm.InitializeGitHooks()
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// keyStrategies collects repeated -key key=strategy flags
+type keyStrategies map[string]stackctx.MergeStrategy
+
+func (k keyStrategies) String() string { return fmt.Sprint(map[string]stackctx.MergeStrategy(k)) }
+
+func (k keyStrategies) Set(value string) error {
+	key, strategy, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=strategy, got %q", value)
+	}
+	k[key] = stackctx.MergeStrategy(strategy)
+	return nil
+}
+
+// runMerge merges one branch's context into another
+func runMerge(gcm *stackctx.GitContextManager, args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	strategy := flags.String("strategy", string(stackctx.MergeKeepBoth),
+		"for conflicting entries: prefer-ours, prefer-theirs, keep-both or ask-model")
+	keys := keyStrategies{}
+	flags.Var(keys, "key", "strategy for one entry, as key=strategy (repeatable)")
+	knowledgeOnly := flags.Bool("knowledge-only", false, "merge knowledge but not memory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: stackagent context merge [flags] <from> [into]")
+	}
+
+	from, into := flags.Arg(0), gcm.CurrentBranch()
+	if flags.NArg() > 1 {
+		into = flags.Arg(1)
+	}
+	opts := stackctx.MergeOptions{
+		Strategy:   stackctx.MergeStrategy(*strategy),
+		Keys:       keys,
+		SkipMemory: *knowledgeOnly,
+	}
+
+	// Only ask for a model if some entry may need one
+	needsModel := opts.Strategy == stackctx.MergeAskModel
+	for _, s := range keys {
+		needsModel = needsModel || s == stackctx.MergeAskModel
+	}
+	if needsModel {
+		client, err := ai.NewClaudeClient()
+		if err != nil {
+			return fmt.Errorf("ask-model needs a model: %w", err)
+		}
+		opts.Reconcile = client.ReconcileContextEntry
+	}
+
+	report, err := gcm.MergeContext(from, into, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🔀 %s\n", report)
+	return nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
+
+// printEntries prints a heading and the entries in key order
+func printEntries(heading string, entries map[string]string) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("%s (%d):\n", heading, len(keys))
+	for _, key := range keys {
+		fmt.Printf("  %s: %s\n", key, entries[key])
+	}
+}
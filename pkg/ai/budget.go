@@ -0,0 +1,317 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// summaryPrefix starts the message that replaces summarised turns
+	summaryPrefix = "[Summary of the earlier conversation]\n"
+
+	// Messages longer than this are shortened when summarising does not
+	// bring a conversation within its budget
+	elideAboveTokens = 400
+
+	// Characters kept at each end of a shortened message or tool result
+	elideKeepChars = 600
+
+	// Characters of each message sent to the summariser
+	summaryInputChars = 8000
+)
+
+// ContextBudget caps the input tokens of a conversation's requests. Over
+// MaxTokens, old turns are summarised and long content elided until the
+// conversation fits three quarters of it, so it isn't compacted every turn.
+type ContextBudget struct {
+	Preset     string `json:"preset,omitempty"`
+	MaxTokens  int    `json:"maxTokens"`  // 0 for no budget
+	KeepRecent int    `json:"keepRecent"` // Latest messages never summarised
+}
+
+// budgetPresets are the budgets selectable by name
+var budgetPresets = map[string]ContextBudget{
+	"default":        {Preset: "default", MaxTokens: 180_000, KeepRecent: 20},
+	"quick-fix":      {Preset: "quick-fix", MaxTokens: 20_000, KeepRecent: 6},
+	"cost-conscious": {Preset: "cost-conscious", MaxTokens: 50_000, KeepRecent: 10},
+	"exploration":    {Preset: "exploration", MaxTokens: 150_000, KeepRecent: 20},
+}
+
+// DefaultContextBudget keeps conversations within the model's context window
+func DefaultContextBudget() ContextBudget {
+	return budgetPresets["default"]
+}
+
+// BudgetPreset returns the named preset budget
+func BudgetPreset(name string) (ContextBudget, error) {
+	budget, ok := budgetPresets[name]
+	if !ok {
+		return ContextBudget{}, fmt.Errorf("unknown budget preset %q (have %s)", name, strings.Join(BudgetPresetNames(), ", "))
+	}
+	return budget, nil
+}
+
+// BudgetPresetNames lists the presets, smallest budget first
+func BudgetPresetNames() []string {
+	names := make([]string, 0, len(budgetPresets))
+	for name := range budgetPresets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return budgetPresets[names[i]].MaxTokens < budgetPresets[names[j]].MaxTokens
+	})
+	return names
+}
+
+// Target is the size compaction reduces a conversation to
+func (b ContextBudget) Target() int {
+	return b.MaxTokens * 3 / 4
+}
+
+// SetContextBudget sets the default budget of conversations, in input
+// tokens per request (0 for none)
+func (c *ClaudeClient) SetContextBudget(maxTokens int) {
+	keepRecent := c.budget.KeepRecent
+	if keepRecent == 0 {
+		keepRecent = DefaultContextBudget().KeepRecent
+	}
+	c.budget = ContextBudget{MaxTokens: maxTokens, KeepRecent: keepRecent}
+}
+
+// UsePreset sets the default budget of conversations to a preset
+func (c *ClaudeClient) UsePreset(name string) error {
+	budget, err := BudgetPreset(name)
+	if err != nil {
+		return err
+	}
+	c.budget = budget
+	return nil
+}
+
+// Budget returns the default budget of conversations
+func (c *ClaudeClient) Budget() ContextBudget {
+	return c.budget
+}
+
+// CompactionReport describes a conversation's size before and after
+// compaction
+type CompactionReport struct {
+	Before     int       `json:"before"` // Input tokens
+	After      int       `json:"after"`
+	Summarised int       `json:"summarised"` // Messages replaced by a summary
+	Elided     int       `json:"elided"`     // Messages shortened
+	Cost       TokenCost `json:"cost"`       // Of writing the summary
+}
+
+// Compacted reports whether the conversation was changed
+func (r *CompactionReport) Compacted() bool {
+	return r.Summarised > 0 || r.Elided > 0
+}
+
+// String describes the compaction
+func (r *CompactionReport) String() string {
+	if !r.Compacted() {
+		return fmt.Sprintf("Context is %s tokens", formatTokens(r.Before))
+	}
+	var done []string
+	if r.Summarised > 0 {
+		done = append(done, fmt.Sprintf("summarised %d earlier messages", r.Summarised))
+	}
+	if r.Elided > 0 {
+		done = append(done, fmt.Sprintf("shortened %d long messages", r.Elided))
+	}
+	return fmt.Sprintf("Compacted the conversation: %s. Reduced from %s to %s tokens.",
+		strings.Join(done, " and "), formatTokens(r.Before), formatTokens(r.After))
+}
+
+// CompactConversation measures messages and, if they exceed the budget,
+// reduces them to its target. The report is returned either way, with the
+// size of the messages returned.
+func (c *ClaudeClient) CompactConversation(messages []ConversationMessage, budget ContextBudget) ([]ConversationMessage, *CompactionReport, error) {
+	tokens := c.conversationTokens(messages)
+	if budget.MaxTokens <= 0 || tokens <= budget.MaxTokens {
+		return messages, &CompactionReport{Before: tokens, After: tokens}, nil
+	}
+	return c.reduce(messages, budget.Target(), budget.KeepRecent, tokens)
+}
+
+// ReduceToTarget compacts messages to about target input tokens: turns
+// before the latest KeepRecent messages of the client's budget are
+// summarised, then long messages are shortened, oldest first. The last
+// message is kept as it is.
+func (c *ClaudeClient) ReduceToTarget(messages []ConversationMessage, target int) ([]ConversationMessage, *CompactionReport, error) {
+	keepRecent := c.budget.KeepRecent
+	if keepRecent == 0 {
+		keepRecent = DefaultContextBudget().KeepRecent
+	}
+	return c.reduce(messages, target, keepRecent, c.conversationTokens(messages))
+}
+
+func (c *ClaudeClient) reduce(messages []ConversationMessage, target, keepRecent, before int) ([]ConversationMessage, *CompactionReport, error) {
+	report := &CompactionReport{Before: before, After: before}
+	if before <= target {
+		return messages, report, nil
+	}
+
+	// The estimate of each message, scaled so they add up to the measured size
+	overhead := estimateRequestTokens(ClaudeRequest{System: c.systemPromptBlocks(), Tools: c.getAvailableTools()})
+	total := overhead
+	for _, msg := range messages {
+		total += messageOverheadTokens + estimateTextTokens(msg.Content)
+	}
+	scale := float64(before) / float64(total)
+	size := func(msg ConversationMessage) int {
+		return int(float64(messageOverheadTokens+estimateTextTokens(msg.Content)) * scale)
+	}
+
+	// Summarise everything before the kept messages. The kept part starts
+	// with an assistant message, so the summary keeps the roles alternating.
+	cut := len(messages) - keepRecent
+	if cut < 1 {
+		cut = 1
+	}
+	for cut < len(messages)-1 && messages[cut].Role != "assistant" {
+		cut++
+	}
+	compacted := messages
+	if cut < len(messages)-1 && messages[cut].Role == "assistant" {
+		summary, cost, err := c.summarise(messages[:cut])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to summarise conversation: %w", err)
+		}
+		compacted = append([]ConversationMessage{{
+			Role:      "user",
+			Content:   summaryPrefix + summary,
+			Timestamp: time.Now(),
+		}}, messages[cut:]...)
+		report.Summarised = cut
+		report.Cost = cost
+	}
+
+	current := int(float64(overhead) * scale)
+	for _, msg := range compacted {
+		current += size(msg)
+	}
+
+	// Shorten long messages, oldest first, leaving the question being asked
+	if current > target {
+		shortened := make([]ConversationMessage, len(compacted))
+		copy(shortened, compacted)
+		for i := 0; i < len(shortened)-1 && current > target; i++ {
+			if size(shortened[i]) <= elideAboveTokens || strings.HasPrefix(shortened[i].Content, summaryPrefix) {
+				continue
+			}
+			content := elideMiddle(shortened[i].Content, "to fit the context budget")
+			if content == shortened[i].Content {
+				continue
+			}
+			old := size(shortened[i])
+			shortened[i].Content = content
+			current -= old - size(shortened[i])
+			report.Elided++
+		}
+		compacted = shortened
+	}
+
+	report.After = current
+	return compacted, report, nil
+}
+
+// summarise asks the model for a summary of messages that the conversation
+// can continue from
+func (c *ClaudeClient) summarise(messages []ConversationMessage) (string, TokenCost, error) {
+	systemPrompt := `You compress the earlier part of a conversation between a developer and StackAgent, an AI coding assistant, so the conversation can continue within a token budget.
+Keep decisions and their reasons, facts learned about the code, file paths, commands run and their outcomes, open questions and the current goal.
+Drop pleasantries, repetition and details that later messages superseded. Write concise bullet points without a preamble.`
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		content := msg.Content
+		if len(content) > summaryInputChars {
+			content = content[:summaryInputChars/2] + "\n[...]\n" + content[len(content)-summaryInputChars/2:]
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", role, content)
+	}
+
+	response, err := c.makeAPICall(systemPrompt, transcript.String())
+	if err != nil {
+		return "", TokenCost{}, err
+	}
+	if len(response.Content) == 0 || strings.TrimSpace(response.Content[0].Text) == "" {
+		return "", TokenCost{}, fmt.Errorf("empty response")
+	}
+	return strings.TrimSpace(response.Content[0].Text), c.CalculateCost(response), nil
+}
+
+// elideStaleToolResults shortens the long tool results of all but the last
+// keepRounds rounds of a turn, in place. The model can call a tool again
+// for a result it still needs.
+func elideStaleToolResults(messages []ClaudeMessage, keepRounds int) int {
+	elided := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		results, ok := messages[i].Content.([]interface{})
+		if !ok {
+			continue
+		}
+		if keepRounds > 0 {
+			keepRounds--
+			continue
+		}
+		for j, item := range results {
+			result, ok := item.(ToolResult)
+			if !ok || len(result.Content) <= 2*elideKeepChars {
+				continue
+			}
+			result.Content = elideMiddle(result.Content, "from this earlier tool result; call the tool again if you need them")
+			results[j] = result
+			elided++
+		}
+	}
+	return elided
+}
+
+// elideMiddle keeps both ends of content and notes what was left out
+func elideMiddle(content, why string) string {
+	if len(content) <= 2*elideKeepChars {
+		return content
+	}
+	end, start := elideKeepChars, len(content)-elideKeepChars
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	for start < len(content) && !utf8.RuneStart(content[start]) {
+		start++
+	}
+	return fmt.Sprintf("%s\n[... %d characters elided %s ...]\n%s", content[:end], start-end, why, content[start:])
+}
+
+// formatTokens writes a token count as 950, 12.3k or 150k
+func formatTokens(tokens int) string {
+	switch {
+	case tokens < 1000:
+		return fmt.Sprintf("%d", tokens)
+	case tokens < 100_000:
+		return fmt.Sprintf("%.1fk", float64(tokens)/1000)
+	default:
+		return fmt.Sprintf("%dk", tokens/1000)
+	}
+}
+
+// fitBudget elides stale tool results from a request over the budget and
+// returns the request's raw estimate, to calibrate against its usage
+func (c *ClaudeClient) fitBudget(request ClaudeRequest, budget ContextBudget) int {
+	raw := estimateRequestTokens(request)
+	if budget.MaxTokens > 0 && c.scaleTokens(raw) > budget.MaxTokens {
+		if elideStaleToolResults(request.Messages, 1) > 0 {
+			raw = estimateRequestTokens(request)
+		}
+	}
+	return raw
+}
@@ -0,0 +1,169 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBudgetPresets(t *testing.T) {
+	budget, err := BudgetPreset("quick-fix")
+	if err != nil || budget.MaxTokens != 20_000 || budget.Target() != 15_000 {
+		t.Errorf("Unexpected quick-fix budget %+v (%v)", budget, err)
+	}
+	if _, err := BudgetPreset("unlimited"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+
+	expected := []string{"quick-fix", "cost-conscious", "exploration", "default"}
+	if names := BudgetPresetNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	client := &ClaudeClient{}
+	if err := client.UsePreset("exploration"); err != nil || client.Budget().MaxTokens != 150_000 {
+		t.Errorf("Expected the exploration budget, got %+v (%v)", client.Budget(), err)
+	}
+	client.SetContextBudget(50_000)
+	if client.Budget().MaxTokens != 50_000 || client.Budget().KeepRecent != 20 {
+		t.Errorf("Expected a 50k budget keeping 20 messages, got %+v", client.Budget())
+	}
+}
+
+// summaryServer answers the count endpoint with 404, so the estimator is
+// used, and messages with a summary
+func summaryServer(t *testing.T) (*httptest.Server, *[]ClaudeRequest) {
+	var requests []ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/count_tokens") {
+			http.NotFound(w, r)
+			return
+		}
+		var request ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		w.Write([]byte(`{"model": "test-model", "content": [{"type": "text", "text": "- Decided to use sqlite"}], "usage": {"input_tokens": 500, "output_tokens": 50}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func conversation(n, words int) []ConversationMessage {
+	var messages []ConversationMessage
+	for i := 0; i < n; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		content := fmt.Sprintf("message %d: %s", i, strings.Repeat("word ", words))
+		messages = append(messages, ConversationMessage{Role: role, Content: content})
+	}
+	return messages
+}
+
+func TestCompactConversationWithinBudget(t *testing.T) {
+	server, requests := summaryServer(t)
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model"}
+
+	messages := conversation(4, 10)
+	compacted, report, err := client.CompactConversation(messages, ContextBudget{MaxTokens: 20_000, KeepRecent: 2})
+	if err != nil {
+		t.Fatalf("CompactConversation failed: %v", err)
+	}
+	if report.Compacted() || !reflect.DeepEqual(compacted, messages) || report.Before == 0 {
+		t.Errorf("Expected a measured, unchanged conversation, got %+v", report)
+	}
+	if len(*requests) != 0 {
+		t.Error("Expected no summary to be written")
+	}
+}
+
+func TestCompactConversationOverBudget(t *testing.T) {
+	server, requests := summaryServer(t)
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model"}
+
+	// 12 messages of about 3000 tokens against a 20k budget
+	messages := conversation(12, 3000)
+	budget := ContextBudget{MaxTokens: 20_000, KeepRecent: 6}
+	compacted, report, err := client.CompactConversation(messages, budget)
+	if err != nil {
+		t.Fatalf("CompactConversation failed: %v", err)
+	}
+
+	// Messages 0-6 are summarised; the kept part starts with assistant message 7
+	if report.Summarised != 7 || len(compacted) != 6 {
+		t.Fatalf("Expected 7 messages summarised and 6 left, got %d and %d", report.Summarised, len(compacted))
+	}
+	if compacted[0].Role != "user" || compacted[0].Content != summaryPrefix+"- Decided to use sqlite" {
+		t.Errorf("Unexpected summary message %+v", compacted[0])
+	}
+	if compacted[1].Role != "assistant" || !strings.HasPrefix(compacted[1].Content, "message 7:") {
+		t.Errorf("Expected the kept part to start at message 7, got %.20q", compacted[1].Content)
+	}
+	prompt, _ := (*requests)[0].Messages[0].Content.(string)
+	if !strings.Contains(prompt, "message 0:") || strings.Contains(prompt, "message 7:") {
+		t.Error("Expected only the older messages to be summarised")
+	}
+
+	// The kept messages are still too long, so all but the question are shortened
+	if report.Elided == 0 || report.After > budget.Target() {
+		t.Errorf("Expected long messages shortened to within %d tokens, got %+v", budget.Target(), report)
+	}
+	if compacted[len(compacted)-1] != messages[len(messages)-1] {
+		t.Error("Expected the last message to be kept as it is")
+	}
+	if report.Cost.TotalCost == 0 || !strings.Contains(report.String(), "summarised 7 earlier messages") {
+		t.Errorf("Unexpected report %q, cost %+v", report.String(), report.Cost)
+	}
+	if !strings.Contains(messages[0].Content, "word word") || strings.Contains(messages[7].Content, "elided") {
+		t.Error("Expected the original messages to be left alone")
+	}
+}
+
+func TestElideStaleToolResults(t *testing.T) {
+	long := strings.Repeat("line of output\n", 200)
+	messages := []ClaudeMessage{
+		{Role: "user", Content: "Check the logs"},
+		{Role: "assistant", Content: "..."},
+		{Role: "user", Content: []interface{}{ToolResult{Type: "tool_result", ToolUseID: "1", Content: long}}},
+		{Role: "assistant", Content: "..."},
+		{Role: "user", Content: []interface{}{ToolResult{Type: "tool_result", ToolUseID: "2", Content: long}}},
+	}
+
+	if elided := elideStaleToolResults(messages, 1); elided != 1 {
+		t.Fatalf("Expected 1 result elided, got %d", elided)
+	}
+	first := messages[2].Content.([]interface{})[0].(ToolResult)
+	last := messages[4].Content.([]interface{})[0].(ToolResult)
+	if !strings.Contains(first.Content, "characters elided") || len(first.Content) >= len(long) {
+		t.Errorf("Expected the earlier result to be shortened, got %d bytes", len(first.Content))
+	}
+	if last.Content != long {
+		t.Error("Expected the last round's result to be kept")
+	}
+
+	// A request over the budget is fitted before it's sent
+	client := &ClaudeClient{}
+	messages[2].Content = []interface{}{ToolResult{Type: "tool_result", ToolUseID: "1", Content: long}}
+	before := estimateRequestTokens(ClaudeRequest{Messages: messages})
+	after := client.fitBudget(ClaudeRequest{Messages: messages}, ContextBudget{MaxTokens: 100})
+	if after >= before {
+		t.Errorf("Expected fitting to shrink the request from %d tokens, got %d", before, after)
+	}
+}
+
+func TestElideMiddleKeepsRunes(t *testing.T) {
+	content := strings.Repeat("é", 2000)
+	elided := elideMiddle(content, "for the test")
+	if !utf8.ValidString(elided) || !strings.Contains(elided, "elided for the test") {
+		t.Errorf("Expected valid UTF-8 with a note, got %.40q", elided)
+	}
+	if short := "short"; elideMiddle(short, "") != short {
+		t.Error("Expected short content to be kept")
+	}
+}
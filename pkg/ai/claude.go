@@ -8,13 +8,18 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	stackctx "stackagent/pkg/context"
+	"stackagent/pkg/search"
 	"stackagent/pkg/shell"
 )
 
+// SystemPrompt is the base system prompt for tool-enabled conversations
+const SystemPrompt = "You are StackAgent, a helpful AI coding assistant with access to powerful file manipulation and shell command tools. Available functions: run_with_capture (shell commands), read_file (read files), write_file (create/write files), edit_file (find/replace in files), apply_patch (atomic multi-file unified diffs), search_in_file (search with context), search_code (gitignore-aware search across a directory tree), list_directory (list files or a tree with filters, sizes and depth limits), go_symbols/go_definition/go_references/go_function_body (Go code navigation), consult (ask your past self at a Git ref or another model, read-only). Use these functions to efficiently help with coding tasks, file operations, and system administration. Prefer apply_patch over repeated edit_file calls for changes spanning several hunks or files, and the go_* tools over reading whole Go files. Be concise but helpful. Remember context from previous messages in this conversation.\n\nCore principle: Don't be evil. Always prioritize user safety, privacy, and ethical behavior."
+
 // Claude API client
 type ClaudeClient struct {
 	apiKey      string
@@ -26,6 +31,16 @@ type ClaudeClient struct {
 	shellManager *shell.ShellManager
 	debugCallback func(string, interface{}) // Add callback for debug information
 	streamingCallback func(string, interface{}) // Add callback for streaming events
+	contextManager *stackctx.ContextManager // Persistent context, optional
+	snapshots SnapshotSource // Context history for ForkPastSelf, optional
+	readOnly bool // Only read-only tools, for forks
+	basePrompt string // Replaces SystemPrompt if set, for forks
+	consultMutex sync.Mutex
+	consultCost TokenCost // Spent by forks, apart from the conversation
+	budget ContextBudget // Default budget of conversations
+	usageMutex sync.Mutex
+	calibration *tokenCalibration // Calibrates token estimates, shared with sessions
+	settings *sharedSettings // Model, limits and tools, shared with sessions; model is used if nil
 }
 
 // Tool definition for function calling
@@ -217,8 +232,10 @@ func NewClaudeClient() (*ClaudeClient, error) {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		model:        "claude-sonnet-4-20250514", // Latest Claude Sonnet 4
+		model:        DefaultSettings().Model,
 		shellManager: shell.NewShellManager(),
+		budget:       DefaultContextBudget(),
+		settings:     &sharedSettings{settings: DefaultSettings()},
 	}, nil
 }
 
@@ -277,9 +294,21 @@ func (c *ClaudeClient) writeDebugEntry(entry DebugLogEntry) {
 	c.debugFile.Sync() // Ensure data is written immediately
 }
 
+// SetBaseURL points the client at another Messages API endpoint, e.g. a
+// proxy
+func (c *ClaudeClient) SetBaseURL(url string) {
+	c.baseURL = url
+}
+
 // SetModel allows switching between Claude models
 func (c *ClaudeClient) SetModel(model string) {
 	c.model = model
+	if c.settings == nil {
+		return
+	}
+	c.settings.mutex.Lock()
+	c.settings.settings.Model = model
+	c.settings.mutex.Unlock()
 }
 
 // GetAvailableModels returns list of available Claude models
@@ -300,12 +329,6 @@ func (c *ClaudeClient) EstimateCost(inputTokens, outputTokens int) float64 {
 	return inputCost + outputCost
 }
 
-// EstimateTokens roughly estimates token count from text
-func (c *ClaudeClient) EstimateTokens(text string) int {
-	// Rough estimation: ~4 characters per token
-	return len(text) / 4
-}
-
 // AnalyzeCommand analyzes a command and its output using Claude
 func (c *ClaudeClient) AnalyzeCommand(sm *shell.ShellManager, handleID uint64, question string) (*CommandAnalysis, error) {
 	// Get command output
@@ -429,7 +452,7 @@ If the request is potentially dangerous, suggest safer alternatives.`
 // makeAPICall makes the actual HTTP request to Claude API
 func (c *ClaudeClient) makeAPICall(system, user string) (*ClaudeResponse, error) {
 	request := ClaudeRequest{
-		Model:     c.model,
+		Model:     c.Settings().Model,
 		MaxTokens: 1024,
 		System:    system,
 		Messages: []ClaudeMessage{
@@ -542,7 +565,7 @@ func (c *ClaudeClient) SetStreamingCallback(callback func(string, interface{}))
 
 // getAvailableTools returns the list of available tools for function calling
 func (c *ClaudeClient) getAvailableTools() []Tool {
-	return []Tool{
+	tools := []Tool{
 		{
 			Name:        "run_with_capture",
 			Description: "Execute a shell command and capture its output for analysis. Returns a handle that can be used to query the output.",
@@ -559,7 +582,7 @@ func (c *ClaudeClient) getAvailableTools() []Tool {
 		},
 		{
 			Name:        "read_file",
-			Description: "Read the contents of a file. Much more efficient than using cat command for file reading.",
+			Description: "Read the contents of a file, or a line range or byte range of it. Binary files are detected and shown as a hex preview, and output is capped with a truncation notice. Much more efficient than using cat command for file reading.",
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
@@ -567,9 +590,37 @@ func (c *ClaudeClient) getAvailableTools() []Tool {
 						Type:        "string",
 						Description: "The path to the file to read",
 					},
+					"start_line": {
+						Type:        "integer",
+						Description: "First line to return, 1-indexed (optional, default: 1)",
+					},
+					"end_line": {
+						Type:        "integer",
+						Description: "Last line to return, inclusive (optional, default: end of file)",
+					},
 					"max_lines": {
 						Type:        "integer",
-						Description: "Maximum number of lines to read (optional, default: all)",
+						Description: "Maximum number of lines to read from start_line (optional, default: all)",
+					},
+					"offset": {
+						Type:        "integer",
+						Description: "Byte offset to read from; switches to byte mode, reading length bytes or up to max_bytes (optional)",
+					},
+					"length": {
+						Type:        "integer",
+						Description: "Number of bytes to read from offset; switches to byte mode (optional)",
+					},
+					"line_numbers": {
+						Type:        "boolean",
+						Description: "Prefix each line with its line number (optional, default: false)",
+					},
+					"hex": {
+						Type:        "boolean",
+						Description: "Return a hex dump of offset/length instead of text, e.g. for binary files (optional, default: false)",
+					},
+					"max_bytes": {
+						Type:        "integer",
+						Description: "Cap on returned content in bytes (optional, default: 102400); longer output is truncated with a notice",
 					},
 				},
 				Required: []string{"file_path"},
@@ -623,6 +674,28 @@ func (c *ClaudeClient) getAvailableTools() []Tool {
 				Required: []string{"file_path", "find", "replace"},
 			},
 		},
+		{
+			Name:        "apply_patch",
+			Description: "Apply a unified diff (or a list of find/replace edits) across one or more files atomically: every hunk is validated against the current file contents (tolerating line offsets and small context drift) and either all files are written or none are. Returns a per-hunk report. Much more efficient than many edit_file calls for multi-hunk or multi-file changes.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"patch": {
+						Type:        "string",
+						Description: "Unified diff text with ---/+++ file headers and @@ hunks. Use /dev/null as the old path to create a file or as the new path to delete one.",
+					},
+					"edits": {
+						Type:        "array",
+						Description: "Alternative to patch: list of {file_path, find, replace, all_occurrences} objects applied in order. Each find must match exactly once unless all_occurrences is true.",
+					},
+					"dry_run": {
+						Type:        "boolean",
+						Description: "Validate the patch and report per-hunk results without writing any files (optional, default: false)",
+					},
+				},
+				Required: []string{},
+			},
+		},
 		{
 			Name:        "search_in_file",
 			Description: "Search for patterns in a file and return matching lines with context. More efficient than grep for simple searches.",
@@ -641,13 +714,71 @@ func (c *ClaudeClient) getAvailableTools() []Tool {
 						Type:        "integer",
 						Description: "Number of context lines to show around matches (optional, default: 2)",
 					},
+					"regex": {
+						Type:        "boolean",
+						Description: "Treat pattern as a Go regular expression (optional, default: false)",
+					},
+					"ignore_case": {
+						Type:        "boolean",
+						Description: "Case-insensitive matching (optional, default: false)",
+					},
 				},
 				Required: []string{"file_path", "pattern"},
 			},
 		},
+		{
+			Name:        "search_code",
+			Description: "Search all text files under a directory for a pattern, respecting .gitignore (node_modules, build output etc. are skipped). Supports regular expressions, include/exclude file globs, context lines and a result cap. Much more efficient than grep -r.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"pattern": {
+						Type:        "string",
+						Description: "The text or regular expression to search for",
+					},
+					"directory_path": {
+						Type:        "string",
+						Description: "The directory to search (optional, default: current directory)",
+					},
+					"regex": {
+						Type:        "boolean",
+						Description: "Treat pattern as a Go regular expression (optional, default: false)",
+					},
+					"ignore_case": {
+						Type:        "boolean",
+						Description: "Case-insensitive matching (optional, default: false)",
+					},
+					"include": {
+						Type:        "string",
+						Description: "Comma-separated globs of files to search, e.g. '*.go,cmd/**' (optional)",
+					},
+					"exclude": {
+						Type:        "string",
+						Description: "Comma-separated globs of files or directories to skip, e.g. '*_test.go,vendor' (optional)",
+					},
+					"context_lines": {
+						Type:        "integer",
+						Description: "Number of context lines to show around matches (optional, default: 0)",
+					},
+					"max_results": {
+						Type:        "integer",
+						Description: "Maximum number of matches to return (optional, default: 100)",
+					},
+					"no_ignore": {
+						Type:        "boolean",
+						Description: "Also search files ignored by .gitignore (optional, default: false)",
+					},
+					"show_hidden": {
+						Type:        "boolean",
+						Description: "Also search hidden files and directories (optional, default: false)",
+					},
+				},
+				Required: []string{"pattern"},
+			},
+		},
 		{
 			Name:        "list_directory",
-			Description: "List directory contents with filtering options. More efficient than ls with complex filtering.",
+			Description: "List directory contents with filtering options, as a flat list or a tree. Respects .gitignore, supports depth limits, glob filters, sizes and sorting. More efficient than ls with complex filtering.",
 			InputSchema: InputSchema{
 				Type: "object",
 				Properties: map[string]Property{
@@ -667,12 +798,60 @@ func (c *ClaudeClient) getAvailableTools() []Tool {
 						Type:        "boolean",
 						Description: "Whether to list recursively (optional, default: false)",
 					},
+					"format": {
+						Type:        "string",
+						Description: "Output format: 'list' (default, relative paths) or 'tree' (indented tree)",
+					},
+					"max_depth": {
+						Type:        "integer",
+						Description: "Maximum depth to descend; implies recursive (optional, 1 = direct children only)",
+					},
+					"details": {
+						Type:        "boolean",
+						Description: "Show sizes and modification times; directory sizes are totals of listed files (optional, default: false)",
+					},
+					"include": {
+						Type:        "string",
+						Description: "Comma-separated globs files must match (optional, e.g. '*.go,cmd/**')",
+					},
+					"exclude": {
+						Type:        "string",
+						Description: "Comma-separated globs of files and directories to skip (optional, e.g. '*_test.go,node_modules')",
+					},
+					"sort": {
+						Type:        "string",
+						Description: "Sort order: 'name' (default, directories first), 'size' (largest first) or 'mtime' (newest first)",
+					},
+					"max_entries": {
+						Type:        "integer",
+						Description: "Maximum entries to show before summarising the rest as 'N more' (optional, default: 500)",
+					},
+					"no_ignore": {
+						Type:        "boolean",
+						Description: "Include files ignored by .gitignore (optional, default: false)",
+					},
 				},
 				Required: []string{"directory_path"},
 			},
-			CacheControl: &CacheControl{Type: "ephemeral"}, // Cache all tool definitions
 		},
 	}
+
+	tools = append(tools, goTools()...)
+	if c.contextManager != nil {
+		tools = append(tools, memoryTools()...)
+	}
+	if c.readOnly {
+		tools = readOnlyToolsOf(tools)
+	} else {
+		tools = append(tools, consultTool())
+	}
+	tools = c.enabledToolsOf(tools)
+
+	// Cache all tool definitions (the cache breakpoint goes on the last tool)
+	if len(tools) > 0 {
+		tools[len(tools)-1].CacheControl = &CacheControl{Type: "ephemeral"}
+	}
+	return tools
 }
 
 // ExecuteFunction executes a function call and returns the result
@@ -685,6 +864,13 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 		return time.Since(startTime).Seconds()
 	}
 	
+	if c.readOnly && !readOnlyTools[toolUse.Name] {
+		return "", fmt.Errorf("%s is not available to a consulted agent", toolUse.Name)
+	}
+	if !c.Settings().allows(toolUse.Name) {
+		return "", fmt.Errorf("%s is disabled in this configuration", toolUse.Name)
+	}
+
 	// Send streaming event for function start
 	if c.streamingCallback != nil {
 		c.streamingCallback("function_call_start", map[string]interface{}{
@@ -732,6 +918,8 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			output = "No output captured yet"
 		}
 
+		go c.recordCommand(handle)
+
 		result := fmt.Sprintf("Command executed successfully. Handle ID: %d\n\nOutput:\n%s", handle.ID, output)
 		
 		if handle.Complete {
@@ -785,7 +973,7 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			return "", fmt.Errorf("invalid file_path parameter")
 		}
 
-		content, err := os.ReadFile(filePath)
+		readResult, err := ReadFileRange(filePath, readFileOptionsFromInput(toolUse.Input))
 		if err != nil {
 			if c.streamingCallback != nil {
 				c.streamingCallback("function_call_error", map[string]interface{}{
@@ -797,35 +985,7 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			return "", fmt.Errorf("failed to read file: %w", err)
 		}
 
-		lines := strings.Split(string(content), "\n")
-		
-		// Check if max_lines is specified
-		if maxLinesVal, exists := toolUse.Input["max_lines"]; exists {
-			if maxLinesFloat, ok := maxLinesVal.(float64); ok {
-				maxLines := int(maxLinesFloat)
-				if maxLines > 0 && maxLines < len(lines) {
-					lines = lines[:maxLines]
-					result := fmt.Sprintf("File: %s (showing first %d lines)\n\n%s", filePath, maxLines, strings.Join(lines, "\n"))
-					
-					// Send file operation completed event
-					if c.streamingCallback != nil {
-						c.streamingCallback("file_operation_completed", map[string]interface{}{
-							"id":         toolUse.ID,
-							"type":       "read",
-							"filePath":   filePath,
-							"size":       len(result),
-							"lines":      maxLines,
-							"duration":   getDuration(),
-							"timestamp":  time.Now(),
-						})
-					}
-					
-					return result, nil
-				}
-			}
-		}
-
-		result := fmt.Sprintf("File: %s (%d lines)\n\n%s", filePath, len(lines), string(content))
+		result := FormatFileReadResult(readResult)
 		
 		// Send file operation completed event
 		if c.streamingCallback != nil {
@@ -833,8 +993,10 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 				"id":         toolUse.ID,
 				"type":       "read",
 				"filePath":   filePath,
-				"size":       len(string(content)),
-				"lines":      len(lines),
+				"size":       len(readResult.Content),
+				"lines":      readResult.LinesShown(),
+				"binary":     readResult.Binary,
+				"truncated":  readResult.Truncated,
 				"duration":   getDuration(),
 				"timestamp":  time.Now(),
 			})
@@ -1012,6 +1174,62 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 
 		return fmt.Sprintf("Successfully replaced %d occurrence(s) of '%s' with '%s' in %s", count, find, replace, filePath), nil
 
+	case "apply_patch":
+		// Send file operation started event
+		if c.streamingCallback != nil {
+			c.streamingCallback("file_operation_started", map[string]interface{}{
+				"id":         toolUse.ID,
+				"type":       "patch",
+				"timestamp":  time.Now(),
+			})
+		}
+
+		patches, err := parsePatchInput(toolUse.Input)
+		if err != nil {
+			if c.streamingCallback != nil {
+				c.streamingCallback("function_call_error", map[string]interface{}{
+					"id":        toolUse.ID,
+					"error":     fmt.Sprintf("invalid patch: %v", err),
+					"timestamp": time.Now(),
+				})
+			}
+			return "", fmt.Errorf("invalid patch: %w", err)
+		}
+
+		dryRun, _ := toolUse.Input["dry_run"].(bool)
+		patchResult, err := ApplyPatch(patches, dryRun)
+		if err != nil {
+			if c.streamingCallback != nil {
+				c.streamingCallback("function_call_error", map[string]interface{}{
+					"id":        toolUse.ID,
+					"error":     fmt.Sprintf("failed to apply patch: %v", err),
+					"timestamp": time.Now(),
+				})
+			}
+			return "", fmt.Errorf("failed to apply patch: %w", err)
+		}
+
+		result := FormatPatchResult(patchResult)
+
+		// Send file operation completed event with the full change set
+		if c.streamingCallback != nil {
+			c.streamingCallback("file_operation_completed", map[string]interface{}{
+				"id":         toolUse.ID,
+				"type":       "patch",
+				"files":      patchResult.Files,
+				"applied":    patchResult.Applied,
+				"dryRun":     patchResult.DryRun,
+				"fileCount":  len(patchResult.Files),
+				"duration":   getDuration(),
+				"timestamp":  time.Now(),
+			})
+		}
+
+		if !patchResult.Applied && !dryRun {
+			return "", fmt.Errorf("%s", result)
+		}
+		return result, nil
+
 	case "search_in_file":
 		filePath, ok := toolUse.Input["file_path"].(string)
 		if !ok {
@@ -1023,12 +1241,6 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			return "", fmt.Errorf("invalid pattern parameter")
 		}
 
-		file, err := os.Open(filePath)
-		if err != nil {
-			return "", fmt.Errorf("failed to open file: %w", err)
-		}
-		defer file.Close()
-
 		contextLines := 2
 		if contextVal, exists := toolUse.Input["context_lines"]; exists {
 			if contextFloat, ok := contextVal.(float64); ok {
@@ -1036,48 +1248,98 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			}
 		}
 
+		regex, _ := toolUse.Input["regex"].(bool)
+		ignoreCase, _ := toolUse.Input["ignore_case"].(bool)
+		matcher, err := search.NewMatcher(pattern, regex, ignoreCase)
+		if err != nil {
+			return "", err
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+
 		var lines []string
-		var matches []string
 		scanner := bufio.NewScanner(file)
-		lineNum := 0
-		
 		for scanner.Scan() {
-			lineNum++
-			line := scanner.Text()
-			lines = append(lines, line)
-			
-			if strings.Contains(line, pattern) {
-				start := max(0, lineNum-contextLines-1)
-				end := min(len(lines), lineNum+contextLines)
-				
-				matchResult := fmt.Sprintf("Line %d: %s", lineNum, line)
-				if contextLines > 0 {
-					matchResult += "\nContext:"
-					for i := start; i < end; i++ {
-						if i == lineNum-1 {
-							matchResult += fmt.Sprintf("  > %d: %s", i+1, lines[i])
-						} else {
-							matchResult += fmt.Sprintf("    %d: %s", i+1, lines[i])
-						}
-						if i < end-1 {
-							matchResult += "\n"
-						}
-					}
-				}
-				matches = append(matches, matchResult)
-			}
+			lines = append(lines, scanner.Text())
 		}
 
 		if err := scanner.Err(); err != nil {
 			return "", fmt.Errorf("error reading file: %w", err)
 		}
 
+		var matches []string
+		for _, match := range search.FindMatches(lines, matcher, contextLines, 0) {
+			matchResult := fmt.Sprintf("Line %d: %s", match.LineNumber, match.Line)
+			if contextLines > 0 {
+				matchResult += "\nContext:"
+				for i, line := range match.Context {
+					lineNum := match.ContextStart + i
+					if lineNum == match.LineNumber {
+						matchResult += fmt.Sprintf("  > %d: %s", lineNum, line)
+					} else {
+						matchResult += fmt.Sprintf("    %d: %s", lineNum, line)
+					}
+					if i < len(match.Context)-1 {
+						matchResult += "\n"
+					}
+				}
+			}
+			matches = append(matches, matchResult)
+		}
+
 		if len(matches) == 0 {
 			return fmt.Sprintf("No matches found for pattern '%s' in %s", pattern, filePath), nil
 		}
 
 		return fmt.Sprintf("Found %d match(es) for pattern '%s' in %s:\n\n%s", len(matches), pattern, filePath, strings.Join(matches, "\n\n")), nil
 
+	case "search_code":
+		// Send file operation started event
+		if c.streamingCallback != nil {
+			c.streamingCallback("file_operation_started", map[string]interface{}{
+				"id":         toolUse.ID,
+				"type":       "search",
+				"dirPath":    toolUse.Input["directory_path"],
+				"pattern":    toolUse.Input["pattern"],
+				"timestamp":  time.Now(),
+			})
+		}
+
+		root, opts, err := codeSearchOptionsFromInput(toolUse.Input)
+		if err == nil {
+			var searchResult *search.TreeResult
+			searchResult, err = search.SearchTree(root, opts)
+			if err == nil {
+				// Send file operation completed event with structured matches
+				if c.streamingCallback != nil {
+					c.streamingCallback("file_operation_completed", map[string]interface{}{
+						"id":            toolUse.ID,
+						"type":          "search",
+						"dirPath":       root,
+						"matches":       searchResult.Matches,
+						"filesSearched": searchResult.FilesSearched,
+						"truncated":     searchResult.Truncated,
+						"duration":      getDuration(),
+						"timestamp":     time.Now(),
+					})
+				}
+				return formatCodeSearchResult(searchResult), nil
+			}
+		}
+
+		if c.streamingCallback != nil {
+			c.streamingCallback("function_call_error", map[string]interface{}{
+				"id":        toolUse.ID,
+				"error":     fmt.Sprintf("search failed: %v", err),
+				"timestamp": time.Now(),
+			})
+		}
+		return "", fmt.Errorf("search failed: %w", err)
+
 	case "list_directory":
 		// Send file operation started event
 		if c.streamingCallback != nil {
@@ -1101,94 +1363,8 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			return "", fmt.Errorf("invalid directory_path parameter")
 		}
 
-		// Get optional parameters
-		var fileExt string
-		if extVal, exists := toolUse.Input["file_extension"]; exists {
-			if extStr, ok := extVal.(string); ok {
-				fileExt = extStr
-			}
-		}
-
-		showHidden := false
-		if hiddenVal, exists := toolUse.Input["show_hidden"]; exists {
-			if hiddenBool, ok := hiddenVal.(bool); ok {
-				showHidden = hiddenBool
-			}
-		}
-
-		recursive := false
-		if recVal, exists := toolUse.Input["recursive"]; exists {
-			if recBool, ok := recVal.(bool); ok {
-				recursive = recBool
-			}
-		}
-
-		var files []string
-		var err error
-
-		if recursive {
-			err = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				// Skip hidden files if not requested
-				if !showHidden && strings.HasPrefix(info.Name(), ".") {
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-
-				// Filter by extension if specified
-				if fileExt != "" && !strings.HasSuffix(info.Name(), fileExt) {
-					return nil
-				}
-
-				relPath, _ := filepath.Rel(dirPath, path)
-				if relPath == "." {
-					return nil
-				}
-
-				if info.IsDir() {
-					files = append(files, fmt.Sprintf("%s/", relPath))
-				} else {
-					files = append(files, relPath)
-				}
-				return nil
-			})
-		} else {
-			entries, err := os.ReadDir(dirPath)
-			if err != nil {
-				if c.streamingCallback != nil {
-					c.streamingCallback("function_call_error", map[string]interface{}{
-						"id":        toolUse.ID,
-						"error":     fmt.Sprintf("failed to read directory: %v", err),
-						"timestamp": time.Now(),
-					})
-				}
-				return "", fmt.Errorf("failed to read directory: %w", err)
-			}
-
-			for _, entry := range entries {
-				// Skip hidden files if not requested
-				if !showHidden && strings.HasPrefix(entry.Name(), ".") {
-					continue
-				}
-
-				// Filter by extension if specified
-				if fileExt != "" && !strings.HasSuffix(entry.Name(), fileExt) {
-					continue
-				}
-
-				if entry.IsDir() {
-					files = append(files, fmt.Sprintf("%s/", entry.Name()))
-				} else {
-					files = append(files, entry.Name())
-				}
-			}
-		}
-
+		opts := listOptionsFromInput(toolUse.Input)
+		listing, err := ListDirectory(dirPath, opts)
 		if err != nil {
 			if c.streamingCallback != nil {
 				c.streamingCallback("function_call_error", map[string]interface{}{
@@ -1200,40 +1376,31 @@ func (c *ClaudeClient) ExecuteFunction(toolUse ToolUse) (string, error) {
 			return "", fmt.Errorf("failed to list directory: %w", err)
 		}
 
-		if len(files) == 0 {
-			result := fmt.Sprintf("No files found in %s with the specified criteria", dirPath)
-			
-			// Send file operation completed event
-			if c.streamingCallback != nil {
-				c.streamingCallback("file_operation_completed", map[string]interface{}{
-					"id":         toolUse.ID,
-					"type":       "list",
-					"dirPath":    dirPath,
-					"fileCount":  0,
-					"duration":   getDuration(),
-					"timestamp":  time.Now(),
-				})
-			}
-			
-			return result, nil
-		}
+		result := listing.Format(opts.Tree, opts.Details, opts.SortBy, opts.MaxEntries)
 
-		result := fmt.Sprintf("Found %d item(s) in %s:\n\n%s", len(files), dirPath, strings.Join(files, "\n"))
-		
 		// Send file operation completed event
 		if c.streamingCallback != nil {
 			c.streamingCallback("file_operation_completed", map[string]interface{}{
 				"id":         toolUse.ID,
 				"type":       "list",
 				"dirPath":    dirPath,
-				"fileCount":  len(files),
+				"fileCount":  len(listing.Entries),
 				"duration":   getDuration(),
 				"timestamp":  time.Now(),
 			})
 		}
-		
+
 		return result, nil
 
+	case "go_symbols", "go_definition", "go_references", "go_function_body":
+		return executeGoTool(toolUse)
+
+	case "memory_set", "memory_get", "memory_list", "memory_delete", "knowledge_set", "knowledge_get", "search_context", "recall", "set_task":
+		return c.executeMemoryTool(toolUse)
+
+	case "consult":
+		return c.executeConsult(toolUse)
+
 	default:
 		// Send function call error for unknown function
 		if c.streamingCallback != nil {
@@ -1323,32 +1490,29 @@ func (c *ClaudeClient) ChatWithTools(message string) (string, TokenCost, error)
 	totalCost := TokenCost{}
 	
 	// Safety: Limit the number of tool execution rounds to prevent infinite loops
-	maxRounds := 10
+	settings := c.Settings()
+	maxRounds := settings.MaxRounds
 	round := 0
 
 	for round < maxRounds {
 		round++
-		// Create cached system prompt
-		systemPrompt := []ContentBlock{
-			{
-				Type: "text",
-				Text: "You are StackAgent, a helpful AI coding assistant with access to powerful file manipulation and shell command tools. Available functions: run_with_capture (shell commands), read_file (read files), write_file (create/write files), edit_file (find/replace in files), search_in_file (search with context), list_directory (list files with filters). Use these functions to efficiently help with coding tasks, file operations, and system administration. Be concise but helpful. Remember context from previous messages in this conversation.\n\nCore principle: Don't be evil. Always prioritize user safety, privacy, and ethical behavior.",
-				CacheControl: &CacheControl{Type: "ephemeral"}, // Cache system prompt
-			},
-		}
+		// Create cached system prompt, followed by the persistent context index
+		systemPrompt := c.systemPromptBlocks()
 
 		request := ClaudeRequest{
-			Model:     c.model,
-			MaxTokens: 4000,
+			Model:     settings.Model,
+			MaxTokens: settings.MaxTokens,
 			Messages:  messages,
 			Tools:     tools,
 			System:    systemPrompt,
 		}
 
+		raw := c.fitBudget(request, c.budget)
 		response, err := c.makeRequestWithTools(request)
 		if err != nil {
 			return "", TokenCost{}, err
 		}
+		c.observeUsage(raw, response)
 
 		// Calculate cost for the current turn
 		currentTurnCost := c.CalculateCost(response)
@@ -1410,6 +1574,8 @@ func (c *ClaudeClient) ChatWithTools(message string) (string, TokenCost, error)
 					IsError:   true,
 				})
 			} else {
+				c.trackActiveFiles(toolUse)
+
 				// Log function call success
 				if c.debugCallback != nil {
 					c.debugCallback("function_call_success", map[string]interface{}{
@@ -1455,6 +1621,12 @@ func (c *ClaudeClient) ChatWithTools(message string) (string, TokenCost, error)
 
 // ChatWithToolsAndContext sends a conversation with context and function calling support
 func (c *ClaudeClient) ChatWithToolsAndContext(conversationMessages []ConversationMessage) (string, TokenCost, OperationSummary, error) {
+	return c.ChatWithBudget(conversationMessages, c.budget)
+}
+
+// ChatWithBudget is ChatWithToolsAndContext with a conversation's own budget:
+// when a round's request exceeds it, earlier rounds' tool results are elided
+func (c *ClaudeClient) ChatWithBudget(conversationMessages []ConversationMessage, budget ContextBudget) (string, TokenCost, OperationSummary, error) {
 	tools := c.getAvailableTools()
 	
 	// Initialize operation summary tracking
@@ -1496,32 +1668,29 @@ func (c *ClaudeClient) ChatWithToolsAndContext(conversationMessages []Conversati
 	totalCost := TokenCost{}
 	
 	// Safety: Limit the number of tool execution rounds to prevent infinite loops
-	maxRounds := 10
+	settings := c.Settings()
+	maxRounds := settings.MaxRounds
 	round := 0
 
 	for round < maxRounds {
 		round++
-		// Create cached system prompt
-		systemPrompt := []ContentBlock{
-			{
-				Type: "text",
-				Text: "You are StackAgent, a helpful AI coding assistant with access to powerful file manipulation and shell command tools. Available functions: run_with_capture (shell commands), read_file (read files), write_file (create/write files), edit_file (find/replace in files), search_in_file (search with context), list_directory (list files with filters). Use these functions to efficiently help with coding tasks, file operations, and system administration. Be concise but helpful. Remember context from previous messages in this conversation.\n\nCore principle: Don't be evil. Always prioritize user safety, privacy, and ethical behavior.",
-				CacheControl: &CacheControl{Type: "ephemeral"}, // Cache system prompt
-			},
-		}
+		// Create cached system prompt, followed by the persistent context index
+		systemPrompt := c.systemPromptBlocks()
 
 		request := ClaudeRequest{
-			Model:     c.model,
-			MaxTokens: 4000,
+			Model:     settings.Model,
+			MaxTokens: settings.MaxTokens,
 			Messages:  messages,
 			Tools:     tools,
 			System:    systemPrompt,
 		}
 
+		raw := c.fitBudget(request, budget)
 		response, err := c.makeRequestWithTools(request)
 		if err != nil {
 			return "", TokenCost{}, operationSummary, err
 		}
+		c.observeUsage(raw, response)
 
 		// Calculate cost for the current turn
 		currentTurnCost := c.CalculateCost(response)
@@ -1583,6 +1752,8 @@ func (c *ClaudeClient) ChatWithToolsAndContext(conversationMessages []Conversati
 					IsError:   true,
 				})
 			} else {
+				c.trackActiveFiles(toolUse)
+
 				// Log function call success
 				if c.debugCallback != nil {
 					c.debugCallback("function_call_success", map[string]interface{}{
@@ -1673,6 +1844,10 @@ func (c *ClaudeClient) ChatWithToolsAndContext(conversationMessages []Conversati
 						operationSummary.FileOperations = append(operationSummary.FileOperations, fileOp)
 					}
 					
+				case "apply_patch":
+					// Track every file touched by the patch
+					operationSummary.FileOperations = append(operationSummary.FileOperations, patchFileOperations(toolUse, time.Now())...)
+					
 				case "search_in_file":
 					// Track file search operation
 					if filePath, ok := toolUse.Input["file_path"].(string); ok {
@@ -1690,6 +1865,24 @@ func (c *ClaudeClient) ChatWithToolsAndContext(conversationMessages []Conversati
 						operationSummary.FileOperations = append(operationSummary.FileOperations, fileOp)
 					}
 					
+				case "search_code":
+					// Track repository search operation
+					dirPath, _ := toolUse.Input["directory_path"].(string)
+					if dirPath == "" {
+						dirPath = "."
+					}
+					pattern, _ := toolUse.Input["pattern"].(string)
+					fileOp := FileOperation{
+						ID:            toolUse.ID,
+						Type:          "search",
+						FilePath:      dirPath,
+						Content:       result,
+						SearchResults: []string{pattern},
+						Timestamp:     time.Now(),
+						Size:          len(result),
+					}
+					operationSummary.FileOperations = append(operationSummary.FileOperations, fileOp)
+					
 				case "list_directory":
 					// Track directory listing operation
 					if dirPath, ok := toolUse.Input["directory_path"].(string); ok {
@@ -107,10 +107,10 @@ func TestEstimateTokens(t *testing.T) {
 		t.Fatalf("Failed to create client: %v", err)
 	}
 	
-	// Test token estimation (4 chars per token)
+	// Short words are a token each, with their leading space
 	text := "This is a test message"
 	tokens := client.EstimateTokens(text)
-	expected := len(text) / 4
+	expected := 5
 	
 	if tokens != expected {
 		t.Errorf("Expected %d tokens, got %d", expected, tokens)
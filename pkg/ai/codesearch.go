@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"stackagent/pkg/search"
+)
+
+// codeSearchOptionsFromInput extracts search_code tool arguments
+func codeSearchOptionsFromInput(input map[string]interface{}) (string, search.TreeOptions, error) {
+	pattern, ok := input["pattern"].(string)
+	if !ok || pattern == "" {
+		return "", search.TreeOptions{}, fmt.Errorf("invalid pattern parameter")
+	}
+
+	root, _ := input["directory_path"].(string)
+	if root == "" {
+		root = "."
+	}
+
+	opts := search.TreeOptions{Pattern: pattern}
+	opts.Regex, _ = input["regex"].(bool)
+	opts.IgnoreCase, _ = input["ignore_case"].(bool)
+	opts.Include, _ = input["include"].(string)
+	opts.Exclude, _ = input["exclude"].(string)
+	opts.NoIgnore, _ = input["no_ignore"].(bool)
+	opts.ShowHidden, _ = input["show_hidden"].(bool)
+	if v, ok := input["context_lines"].(float64); ok && v > 0 {
+		opts.ContextLines = int(v)
+	}
+	if v, ok := input["max_results"].(float64); ok && v > 0 {
+		opts.MaxResults = int(v)
+	}
+	return root, opts, nil
+}
+
+// formatCodeSearchResult renders matches grouped by file in the same
+// style as search_in_file
+func formatCodeSearchResult(r *search.TreeResult) string {
+	if len(r.Matches) == 0 {
+		return fmt.Sprintf("No matches found for pattern '%s' under %s (searched %d files)", r.Pattern, r.Root, r.FilesSearched)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Found %d match(es) for pattern '%s' in %d file(s) under %s (searched %d files):\n",
+		len(r.Matches), r.Pattern, r.FilesMatched, r.Root, r.FilesSearched)
+
+	lastPath := ""
+	for _, m := range r.Matches {
+		if m.Path != lastPath {
+			fmt.Fprintf(&b, "\n%s\n", m.Path)
+			lastPath = m.Path
+		}
+		if len(m.Context) == 0 {
+			fmt.Fprintf(&b, "  %d: %s\n", m.LineNumber, m.Line)
+			continue
+		}
+		for i, line := range m.Context {
+			lineNum := m.ContextStart + i
+			if lineNum == m.LineNumber {
+				fmt.Fprintf(&b, "  > %d: %s\n", lineNum, line)
+			} else {
+				fmt.Fprintf(&b, "    %d: %s\n", lineNum, line)
+			}
+		}
+		b.WriteString("  --\n")
+	}
+
+	if r.Truncated {
+		fmt.Fprintf(&b, "\n[Stopped after %d matches. Narrow the search with include/exclude globs or a more specific pattern]", len(r.Matches))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,348 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Hard cap on the text returned by read_file (~25k tokens)
+	defaultReadMaxBytes = 100 * 1024
+	// Bytes sniffed to decide whether a file is binary
+	binarySniffBytes = 8000
+	// Bytes shown in the hex preview of a binary file
+	hexPreviewBytes = 256
+)
+
+// ReadFileOptions selects which part of a file read_file returns
+type ReadFileOptions struct {
+	StartLine   int   // 1-indexed first line (0 means from the top)
+	EndLine     int   // 1-indexed last line, inclusive (0 means to the end)
+	Offset      int64 // Byte offset to start from; switches to byte mode
+	Length      int64 // Number of bytes to read (0 means up to MaxBytes); switches to byte mode
+	LineNumbers bool  // Prefix each line with its line number
+	MaxBytes    int   // Output cap (0 means defaultReadMaxBytes)
+	Hex         bool  // Return a hex dump instead of refusing binary files
+}
+
+// FileReadResult is the outcome of a ranged read
+type FileReadResult struct {
+	Path       string
+	Content    string
+	FileSize   int64
+	StartLine  int
+	EndLine    int   // Last line included in Content
+	TotalLines int   // Only known when the whole file was scanned
+	Offset     int64 // Byte mode only
+	BytesRead  int64 // Byte mode only
+	Binary     bool
+	HexDump    bool
+	Encoding   string
+	Truncated  bool
+	CutLine    int   // Line that was longer than MaxBytes and cut short, if any
+	CutOffset  int64 // Byte offset where the rest of CutLine starts
+}
+
+// LinesShown returns how many lines Content holds (0 in byte mode)
+func (r *FileReadResult) LinesShown() int {
+	if r.StartLine == 0 || r.EndLine < r.StartLine {
+		return 0
+	}
+	return r.EndLine - r.StartLine + 1
+}
+
+// ReadFileRange reads part of a file without loading all of it into memory
+func ReadFileRange(path string, opts ReadFileOptions) (*FileReadResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultReadMaxBytes
+	}
+	if opts.StartLine > 0 && opts.EndLine > 0 && opts.EndLine < opts.StartLine {
+		return nil, fmt.Errorf("end_line %d is before start_line %d", opts.EndLine, opts.StartLine)
+	}
+
+	result := &FileReadResult{Path: path, FileSize: info.Size()}
+
+	// Sniff the start of the file for binary content and encoding
+	sniff := make([]byte, binarySniffBytes)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	sniff = sniff[:n]
+	result.Encoding, result.Binary = detectEncoding(sniff)
+
+	if result.Binary || opts.Hex {
+		return readHexPreview(file, result, opts)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if opts.Offset > 0 || opts.Length > 0 {
+		return readByteRange(file, result, opts)
+	}
+	return readLineRange(file, result, opts)
+}
+
+// detectEncoding guesses the text encoding and whether the data is binary
+func detectEncoding(sniff []byte) (encoding string, binary bool) {
+	switch {
+	case bytes.HasPrefix(sniff, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8-bom", false
+	case bytes.HasPrefix(sniff, []byte{0xFF, 0xFE}), bytes.HasPrefix(sniff, []byte{0xFE, 0xFF}):
+		return "utf-16", true
+	case bytes.IndexByte(sniff, 0) >= 0:
+		return "binary", true
+	}
+
+	// Allow a multi-byte rune to be cut off at the end of the sniff window
+	check := sniff
+	if len(check) > utf8.UTFMax {
+		check = check[:len(check)-utf8.UTFMax]
+	}
+	if !utf8.Valid(check) {
+		return "non-utf-8", true
+	}
+	return "utf-8", false
+}
+
+// readHexPreview returns a hex dump instead of garbage text
+func readHexPreview(file *os.File, result *FileReadResult, opts ReadFileOptions) (*FileReadResult, error) {
+	length := opts.Length
+	if length <= 0 || !opts.Hex {
+		length = hexPreviewBytes
+	}
+	// A hex dump is ~4.5x the input size
+	if limit := int64(opts.MaxBytes) * 2 / 9; length > limit {
+		length = limit
+		result.Truncated = true
+	}
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, opts.Offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	result.Offset = opts.Offset
+	result.BytesRead = int64(n)
+	result.HexDump = true
+	result.Content = hex.Dump(buf[:n])
+	return result, nil
+}
+
+// readByteRange returns Length bytes starting at Offset, or without a
+// Length the rest of the file up to MaxBytes
+func readByteRange(file *os.File, result *FileReadResult, opts ReadFileOptions) (*FileReadResult, error) {
+	length := opts.Length
+	remaining := result.FileSize - opts.Offset
+	if remaining < 0 {
+		remaining = 0
+	}
+	if length == 0 || length > remaining {
+		length = remaining
+	}
+	if length > int64(opts.MaxBytes) {
+		length = int64(opts.MaxBytes)
+		result.Truncated = true
+	}
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, opts.Offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if result.Truncated && n > 0 {
+		// End on a whole rune, so the continuation starts on one too
+		if last := utf8Boundary(string(buf[:n]), n-1); !utf8.FullRune(buf[last:n]) {
+			n = last
+		}
+	}
+	result.Offset = opts.Offset
+	result.BytesRead = int64(n)
+	result.Content = strings.ToValidUTF8(string(buf[:n]), "�")
+	return result, nil
+}
+
+// readLineRange streams the file and keeps only the requested lines
+func readLineRange(file *os.File, result *FileReadResult, opts ReadFileOptions) (*FileReadResult, error) {
+	start := opts.StartLine
+	if start < 1 {
+		start = 1
+	}
+	result.StartLine = start
+
+	reader := bufio.NewReader(file)
+	var out strings.Builder
+	lineNum := 0
+	scannedAll := true
+	var lineStart int64 // Byte offset of the current line
+
+	for {
+		raw, err := reader.ReadString('\n')
+		if raw == "" && err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		lineNum++
+		offset := lineStart
+		lineStart += int64(len(raw))
+		line := strings.TrimRight(raw, "\r\n")
+		bom := 0
+		if lineNum == 1 && strings.HasPrefix(line, "\ufeff") {
+			line = line[len("\ufeff"):]
+			bom = len("\ufeff")
+		}
+
+		if lineNum >= start && (opts.EndLine == 0 || lineNum <= opts.EndLine) && !result.Truncated {
+			prefix := ""
+			if opts.LineNumbers {
+				prefix = fmt.Sprintf("%6d\t", lineNum)
+			}
+			if out.Len() == 0 && len(prefix)+len(line)+1 > opts.MaxBytes {
+				// A single line longer than the limit (minified code, one-line
+				// JSON): show its beginning so the reader still makes progress
+				cut := utf8Boundary(line, max(0, opts.MaxBytes-len(prefix)-1))
+				out.WriteString(prefix + line[:cut] + "\n")
+				result.EndLine = lineNum
+				result.CutLine = lineNum
+				result.CutOffset = offset + int64(bom+cut)
+				result.Truncated = true
+				if opts.EndLine != 0 && lineNum < opts.EndLine {
+					scannedAll = false
+					break
+				}
+			} else if out.Len()+len(prefix)+len(line)+1 > opts.MaxBytes {
+				result.Truncated = true
+				// Keep scanning for the line count unless the caller bounded the range
+				if opts.EndLine != 0 {
+					scannedAll = false
+					break
+				}
+			} else {
+				out.WriteString(prefix + line)
+				out.WriteString("\n")
+				result.EndLine = lineNum
+			}
+		}
+
+		if opts.EndLine != 0 && lineNum >= opts.EndLine {
+			// Stop early: the rest of the file isn't needed
+			_, peekErr := reader.Peek(1)
+			scannedAll = peekErr == io.EOF
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if scannedAll {
+		result.TotalLines = lineNum
+	}
+	if start > lineNum && lineNum > 0 {
+		return nil, fmt.Errorf("start_line %d is past the end of the file (%d lines)", start, lineNum)
+	}
+
+	result.Content = strings.TrimSuffix(out.String(), "\n")
+	return result, nil
+}
+
+// utf8Boundary returns the largest index <= n that does not split a UTF-8
+// sequence in s
+func utf8Boundary(s string, n int) int {
+	if n >= len(s) {
+		return len(s)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
+
+// FormatFileReadResult renders a read result with a header and any notices
+func FormatFileReadResult(r *FileReadResult) string {
+	var header string
+	switch {
+	case r.Binary:
+		header = fmt.Sprintf("File: %s (binary, %s, %d bytes) - refusing to show as text; hex preview of bytes %d-%d:",
+			r.Path, r.Encoding, r.FileSize, r.Offset, r.Offset+r.BytesRead)
+	case r.FileSize == 0:
+		header = fmt.Sprintf("File: %s (empty file)", r.Path)
+	case r.HexDump:
+		header = fmt.Sprintf("File: %s (hex dump of bytes %d-%d of %d)", r.Path, r.Offset, r.Offset+r.BytesRead, r.FileSize)
+	case r.StartLine == 0:
+		header = fmt.Sprintf("File: %s (bytes %d-%d of %d)", r.Path, r.Offset, r.Offset+r.BytesRead, r.FileSize)
+	case r.TotalLines > 0 && r.StartLine == 1 && r.EndLine == r.TotalLines:
+		header = fmt.Sprintf("File: %s (%d lines)", r.Path, r.TotalLines)
+	case r.TotalLines > 0:
+		header = fmt.Sprintf("File: %s (showing lines %d-%d of %d)", r.Path, r.StartLine, r.EndLine, r.TotalLines)
+	default:
+		header = fmt.Sprintf("File: %s (showing lines %d-%d)", r.Path, r.StartLine, r.EndLine)
+	}
+
+	text := header + "\n\n" + r.Content
+	if r.CutLine > 0 {
+		text += fmt.Sprintf("\n\n[Line %d is longer than the output limit and was cut. Read the rest of it with offset=%d]", r.CutLine, r.CutOffset)
+	}
+	if r.Truncated {
+		if r.StartLine > 0 {
+			text += fmt.Sprintf("\n\n[Output truncated at %d bytes. Continue with start_line=%d]", len(r.Content), r.EndLine+1)
+		} else {
+			text += fmt.Sprintf("\n\n[Output truncated at %d bytes. Continue with offset=%d]", r.BytesRead, r.Offset+r.BytesRead)
+		}
+	}
+	return text
+}
+
+// readFileOptionsFromInput extracts ReadFileOptions from tool arguments
+func readFileOptionsFromInput(input map[string]interface{}) ReadFileOptions {
+	intArg := func(name string) int64 {
+		if v, ok := input[name].(float64); ok && v > 0 {
+			return int64(v)
+		}
+		return 0
+	}
+
+	opts := ReadFileOptions{
+		StartLine: int(intArg("start_line")),
+		EndLine:   int(intArg("end_line")),
+		Offset:    intArg("offset"),
+		Length:    intArg("length"),
+		MaxBytes:  int(intArg("max_bytes")),
+	}
+	opts.LineNumbers, _ = input["line_numbers"].(bool)
+	opts.Hex, _ = input["hex"].(bool)
+
+	// Backwards compatible max_lines: the first N lines of the range
+	if maxLines := int(intArg("max_lines")); maxLines > 0 && opts.EndLine == 0 {
+		start := opts.StartLine
+		if start < 1 {
+			start = 1
+		}
+		opts.EndLine = start + maxLines - 1
+	}
+	return opts
+}
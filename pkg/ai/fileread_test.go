@@ -0,0 +1,190 @@
+package ai
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadFileRangeLines(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "log.txt")
+
+	var content strings.Builder
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	writeTestFile(t, path, content.String())
+
+	result, err := ReadFileRange(path, ReadFileOptions{StartLine: 500, EndLine: 502, LineNumbers: true})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+
+	expected := "   500\tline 500\n   501\tline 501\n   502\tline 502"
+	if result.Content != expected {
+		t.Errorf("Expected %q, got %q", expected, result.Content)
+	}
+
+	if result.TotalLines != 0 {
+		t.Errorf("Expected unknown total lines for a bounded read, got %d", result.TotalLines)
+	}
+
+	// Reading to the end reports the total line count
+	result, err = ReadFileRange(path, ReadFileOptions{StartLine: 999})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+
+	if result.Content != "line 999\nline 1000" || result.TotalLines != 1000 {
+		t.Errorf("Unexpected tail read: %q (%d lines)", result.Content, result.TotalLines)
+	}
+
+	if _, err := ReadFileRange(path, ReadFileOptions{StartLine: 2000}); err == nil {
+		t.Error("Expected error for start_line past end of file")
+	}
+}
+
+func TestReadFileRangeTruncation(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "big.txt")
+	writeTestFile(t, path, strings.Repeat("0123456789\n", 100))
+
+	result, err := ReadFileRange(path, ReadFileOptions{MaxBytes: 55})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+
+	if !result.Truncated {
+		t.Error("Expected output to be truncated")
+	}
+
+	if result.EndLine != 5 {
+		t.Errorf("Expected 5 lines to fit, got %d", result.EndLine)
+	}
+
+	if !strings.Contains(FormatFileReadResult(result), "Continue with start_line=6") {
+		t.Errorf("Expected truncation notice, got %q", FormatFileReadResult(result))
+	}
+}
+
+func TestReadFileRangeBytes(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "bytes.txt")
+	writeTestFile(t, path, "hello, world")
+
+	result, err := ReadFileRange(path, ReadFileOptions{Offset: 7, Length: 5})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+
+	if result.Content != "world" {
+		t.Errorf("Expected 'world', got %q", result.Content)
+	}
+}
+
+func TestReadFileRangeBinary(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "blob.bin")
+	writeTestFile(t, path, "\x7fELF\x00\x01\x02\x03garbage")
+
+	result, err := ReadFileRange(path, ReadFileOptions{})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+
+	if !result.Binary {
+		t.Error("Expected binary file to be detected")
+	}
+
+	formatted := FormatFileReadResult(result)
+	if !strings.Contains(formatted, "refusing to show as text") || !strings.Contains(formatted, "7f 45 4c 46") {
+		t.Errorf("Expected refusal with hex preview, got %q", formatted)
+	}
+}
+
+func TestReadFileOptionsMaxLines(t *testing.T) {
+	opts := readFileOptionsFromInput(map[string]interface{}{
+		"start_line": float64(10),
+		"max_lines":  float64(5),
+	})
+
+	if opts.StartLine != 10 || opts.EndLine != 14 {
+		t.Errorf("Expected lines 10-14, got %d-%d", opts.StartLine, opts.EndLine)
+	}
+}
+
+func TestReadFileRangeLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.min.js")
+	long := strings.Repeat("x", 200*1024)
+	writeTestFile(t, path, long+"\nsecond\n")
+
+	result, err := ReadFileRange(path, ReadFileOptions{StartLine: 1, MaxBytes: 1000})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+	if len(result.Content) != 999 {
+		t.Errorf("Expected the first 999 bytes of the long line, got %d bytes", len(result.Content))
+	}
+	if result.EndLine != 1 || result.LinesShown() != 1 {
+		t.Errorf("Expected line 1 to be shown, got EndLine %d, LinesShown %d", result.EndLine, result.LinesShown())
+	}
+	if result.CutLine != 1 || result.CutOffset != 999 {
+		t.Errorf("Expected line 1 cut at offset 999, got line %d offset %d", result.CutLine, result.CutOffset)
+	}
+
+	text := FormatFileReadResult(result)
+	if !strings.Contains(text, "Continue with start_line=2") || !strings.Contains(text, "offset=999") {
+		t.Errorf("Expected continuation hints to move forward, got %q", text[len(text)-200:])
+	}
+
+	// The continuation reads the next line normally
+	result, err = ReadFileRange(path, ReadFileOptions{StartLine: 2, MaxBytes: 1000})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+	if result.Content != "second" || result.CutLine != 0 {
+		t.Errorf("Expected 'second', got %q (cut line %d)", result.Content, result.CutLine)
+	}
+
+	bytes, err := ReadFileRange(path, ReadFileOptions{Offset: 10, Length: 5})
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %v", err)
+	}
+	if bytes.LinesShown() != 0 {
+		t.Errorf("Expected no line count in byte mode, got %d", bytes.LinesShown())
+	}
+}
+
+func TestReadFileFollowingOffsetHints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	content := strings.Repeat("é", 3000) + "\nsecond\n"
+	writeTestFile(t, path, content)
+
+	// Read the file as the model would, passing only what the hints name
+	hint := regexp.MustCompile(`offset=(\d+)\]`)
+	input := map[string]interface{}{"file_path": path, "max_bytes": float64(1001)}
+	var read strings.Builder
+	for reads := 0; ; reads++ {
+		if reads == 20 {
+			t.Fatalf("Expected the hints to reach the end, read %d of %d bytes", read.Len(), len(content))
+		}
+		result, err := ReadFileRange(path, readFileOptionsFromInput(input))
+		if err != nil {
+			t.Fatalf("ReadFileRange failed: %v", err)
+		}
+		read.WriteString(result.Content)
+		match := hint.FindStringSubmatch(FormatFileReadResult(result))
+		if match == nil {
+			break
+		}
+		offset, _ := strconv.Atoi(match[1])
+		input = map[string]interface{}{"file_path": path, "offset": float64(offset), "max_bytes": float64(1001)}
+	}
+	if read.String() != content {
+		t.Errorf("Expected the whole file back, got %d bytes instead of %d", read.Len(), len(content))
+	}
+}
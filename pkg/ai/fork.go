@@ -0,0 +1,289 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	stackctx "stackagent/pkg/context"
+)
+
+// consultPrompt is the system prompt of a forked agent
+const consultPrompt = "You are StackAgent, consulted by another StackAgent session working on the same project. Answer its question concisely and precisely, citing files and context entries where they support the answer. Your tools are read-only: read_file, search_in_file, search_code, list_directory, the go_* navigation tools and the memory and knowledge lookups. You cannot change files or run commands."
+
+// readOnlyTools are the tools a forked agent may use
+var readOnlyTools = map[string]bool{
+	"read_file":        true,
+	"search_in_file":   true,
+	"search_code":      true,
+	"list_directory":   true,
+	"go_symbols":       true,
+	"go_definition":    true,
+	"go_references":    true,
+	"go_function_body": true,
+	"memory_get":       true,
+	"memory_list":      true,
+	"knowledge_get":    true,
+	"search_context":   true,
+	"recall":           true,
+}
+
+// SnapshotSource looks up the persistent context as of a Git ref; the
+// GitContextManager is one
+type SnapshotSource interface {
+	ContextAt(ref string) (*stackctx.Snapshot, error)
+}
+
+// SetSnapshotSource gives the client the context history ForkPastSelf
+// loads from
+func (c *ClaudeClient) SetSnapshotSource(source SnapshotSource) {
+	c.snapshots = source
+}
+
+// Fork is an isolated agent to consult: a client with its own conversation,
+// no callbacks, read-only tools and optionally another model or the
+// persistent context of an earlier commit. What it spends is kept apart
+// from the conversation that consults it.
+type Fork struct {
+	Label  string // Who is consulted, e.g. "past self at v1.0 (1a2b3c4d)"
+	Model  string
+	Commit string // Commit of the past self's context, "" for the current context
+
+	client  *ClaudeClient
+	cleanup func()
+	history []ConversationMessage
+	cost    TokenCost
+	closed  bool
+	mutex   sync.Mutex
+}
+
+// ForkPastSelf forks an agent whose persistent context is the snapshot as
+// of ref (a commit, tag or branch). Files on disk are still the current
+// ones. The fork must be closed.
+func (c *ClaudeClient) ForkPastSelf(ref string) (*Fork, error) {
+	return c.forkAt(ref, c.Settings().Model)
+}
+
+// ForkDifferentModel forks an agent with the current persistent context,
+// read-only, running on another model
+func (c *ClaudeClient) ForkDifferentModel(model string) (*Fork, error) {
+	if model == "" {
+		return nil, fmt.Errorf("no model to fork to")
+	}
+	f := c.newFork(model, c.contextManager)
+	f.Label = model
+	return f, nil
+}
+
+// forkAt forks a past self as of ref running on model
+func (c *ClaudeClient) forkAt(ref, model string) (*Fork, error) {
+	if c.snapshots == nil {
+		return nil, fmt.Errorf("no context history to fork from")
+	}
+	snapshot, err := c.snapshots.ContextAt(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot gets a scratch directory, so lookups such as recall
+	// never write into the live context
+	dir, err := os.MkdirTemp("", "stackagent-fork-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fork directory: %w", err)
+	}
+
+	f := c.newFork(model, snapshot.ContextManager(dir))
+	f.Commit = snapshot.Commit
+	f.Label = fmt.Sprintf("past self at %s (%s)", ref, shortHash(snapshot.Commit))
+	if model != c.Settings().Model {
+		f.Label += " on " + model
+	}
+	f.client.basePrompt += fmt.Sprintf("\n\nYour persistent memory and knowledge are as they were at commit %s (%s). The files on disk are the current ones, so say where your notes may be out of date.", snapshot.Commit, ref)
+	f.cleanup = func() { os.RemoveAll(dir) }
+	return f, nil
+}
+
+// newFork copies the client's connection settings into an isolated,
+// read-only client
+func (c *ClaudeClient) newFork(model string, cm *stackctx.ContextManager) *Fork {
+	return &Fork{
+		Model: model,
+		client: &ClaudeClient{
+			apiKey:         c.apiKey,
+			baseURL:        c.baseURL,
+			httpClient:     c.httpClient,
+			model:          model,
+			debugFile:      c.debugFile,
+			debugEnabled:   c.debugEnabled,
+			contextManager: cm,
+			readOnly:       true,
+			basePrompt:     consultPrompt,
+			budget:         c.budget,
+		},
+		cleanup: func() {},
+	}
+}
+
+// AskForkedAI asks a fork a question and returns its answer and what the
+// question cost. Follow-up questions see the earlier ones. The cost is
+// added to the fork's and the client's consult costs, not the conversation's.
+func (c *ClaudeClient) AskForkedAI(f *Fork, question string) (string, TokenCost, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return "", TokenCost{}, fmt.Errorf("fork %s is closed", f.Label)
+	}
+
+	messages := append(f.history, ConversationMessage{Role: "user", Content: question, Timestamp: time.Now()})
+	answer, cost, _, err := f.client.ChatWithToolsAndContext(messages)
+	if err != nil {
+		return "", TokenCost{}, fmt.Errorf("failed to consult %s: %w", f.Label, err)
+	}
+	f.history = append(messages, ConversationMessage{Role: "assistant", Content: answer, Timestamp: time.Now()})
+	addTokenCost(&f.cost, cost)
+
+	c.consultMutex.Lock()
+	addTokenCost(&c.consultCost, cost)
+	c.consultMutex.Unlock()
+	return answer, cost, nil
+}
+
+// Cost returns what the fork has spent
+func (f *Fork) Cost() TokenCost {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.cost
+}
+
+// Close releases the fork's scratch context. Asking it again fails.
+func (f *Fork) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.closed {
+		f.closed = true
+		f.cleanup()
+	}
+}
+
+// ConsultCost returns what forks have spent for this client
+func (c *ClaudeClient) ConsultCost() TokenCost {
+	c.consultMutex.Lock()
+	defer c.consultMutex.Unlock()
+	return c.consultCost
+}
+
+// Consult forks a past self (if ref is set), a different model (if model is
+// set) or both, asks it question and closes it
+func (c *ClaudeClient) Consult(question, ref, model string) (*Fork, string, TokenCost, error) {
+	if strings.TrimSpace(question) == "" {
+		return nil, "", TokenCost{}, fmt.Errorf("no question to ask")
+	}
+	if model == "" {
+		model = c.Settings().Model
+	}
+
+	var f *Fork
+	var err error
+	switch {
+	case ref != "":
+		f, err = c.forkAt(ref, model)
+	case model != c.Settings().Model:
+		f, err = c.ForkDifferentModel(model)
+	default:
+		err = fmt.Errorf("consult needs a ref for a past self or a different model")
+	}
+	if err != nil {
+		return nil, "", TokenCost{}, err
+	}
+	defer f.Close()
+
+	answer, cost, err := c.AskForkedAI(f, question)
+	return f, answer, cost, err
+}
+
+// consultTool describes the consult tool
+func consultTool() Tool {
+	return Tool{
+		Name:        "consult",
+		Description: "Ask an isolated agent with read-only tools a question: your past self with the persistent context as it was at a Git ref (to recover why something was decided), or another model for a second opinion. Its answer is returned; its cost is accounted separately.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"question": {
+					Type:        "string",
+					Description: "The question, with the background the other agent needs",
+				},
+				"ref": {
+					Type:        "string",
+					Description: "Commit, tag or branch whose context snapshot the past self has (optional)",
+				},
+				"model": {
+					Type:        "string",
+					Description: "Model to consult, e.g. claude-3-opus-20240229 (optional, default: the current model)",
+				},
+			},
+			Required: []string{"question"},
+		},
+	}
+}
+
+// executeConsult runs the consult tool
+func (c *ClaudeClient) executeConsult(toolUse ToolUse) (string, error) {
+	question, _ := toolUse.Input["question"].(string)
+	ref, _ := toolUse.Input["ref"].(string)
+	model, _ := toolUse.Input["model"].(string)
+
+	f, answer, cost, err := c.Consult(question, ref, model)
+	if err != nil {
+		return "", err
+	}
+
+	if c.streamingCallback != nil {
+		c.streamingCallback("consult_completed", map[string]interface{}{
+			"id":        toolUse.ID,
+			"label":     f.Label,
+			"model":     f.Model,
+			"commit":    f.Commit,
+			"question":  question,
+			"answer":    answer,
+			"cost":      cost,
+			"timestamp": time.Now(),
+		})
+	}
+	return fmt.Sprintf("Answer from %s (cost $%.4f, accounted separately):\n\n%s", f.Label, cost.TotalCost, answer), nil
+}
+
+// readOnlyToolsOf keeps the read-only tools of tools
+func readOnlyToolsOf(tools []Tool) []Tool {
+	var kept []Tool
+	for _, tool := range tools {
+		if readOnlyTools[tool.Name] {
+			kept = append(kept, tool)
+		}
+	}
+	return kept
+}
+
+// addTokenCost adds cost to total, including the breakdown
+func addTokenCost(total *TokenCost, cost TokenCost) {
+	total.InputTokens += cost.InputTokens
+	total.OutputTokens += cost.OutputTokens
+	total.CacheCreationInputTokens += cost.CacheCreationInputTokens
+	total.CacheReadInputTokens += cost.CacheReadInputTokens
+	total.TotalCost += cost.TotalCost
+	total.CostBreakdown.InputCost += cost.CostBreakdown.InputCost
+	total.CostBreakdown.OutputCost += cost.CostBreakdown.OutputCost
+	total.CostBreakdown.CacheWriteCost += cost.CostBreakdown.CacheWriteCost
+	total.CostBreakdown.CacheReadCost += cost.CostBreakdown.CacheReadCost
+	total.CostBreakdown.CacheSavings += cost.CostBreakdown.CacheSavings
+}
+
+// shortHash abbreviates a commit hash for display
+func shortHash(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
@@ -0,0 +1,165 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	stackctx "stackagent/pkg/context"
+)
+
+type fakeSnapshots map[string]*stackctx.Snapshot
+
+func (f fakeSnapshots) ContextAt(ref string) (*stackctx.Snapshot, error) {
+	if snapshot, ok := f[ref]; ok {
+		return snapshot, nil
+	}
+	return nil, fmt.Errorf("unknown commit %q", ref)
+}
+
+// forkServer answers the first request with a knowledge_get call and the
+// rest with text, recording the requests
+func forkServer(t *testing.T) (*httptest.Server, *[]ClaudeRequest) {
+	var mutex sync.Mutex
+	var requests []ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		mutex.Lock()
+		requests = append(requests, request)
+		first := len(requests) == 1
+		mutex.Unlock()
+
+		usage := `"model": "claude-3-opus-20240229", "usage": {"input_tokens": 1000, "output_tokens": 100}`
+		if first {
+			fmt.Fprintf(w, `{"content": [{"type": "tool_use", "id": "t1", "name": "knowledge_get", "input": {"key": "database"}}], %s}`, usage)
+			return
+		}
+		fmt.Fprintf(w, `{"content": [{"type": "text", "text": "We chose sqlite for simplicity"}], %s}`, usage)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestForkPastSelf(t *testing.T) {
+	server, requests := forkServer(t)
+	live := stackctx.NewContextManager(t.TempDir())
+	live.SetKnowledge("database", "use postgres")
+
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model", contextManager: live}
+	client.SetSnapshotSource(fakeSnapshots{"v1.0": {
+		Commit:    "0123456789abcdef",
+		Knowledge: map[string]string{"database": "use sqlite"},
+		Memory:    map[string]string{},
+	}})
+
+	pastMe, err := client.ForkPastSelf("v1.0")
+	if err != nil {
+		t.Fatalf("ForkPastSelf failed: %v", err)
+	}
+	answer, cost, err := client.AskForkedAI(pastMe, "Why sqlite?")
+	if err != nil {
+		t.Fatalf("AskForkedAI failed: %v", err)
+	}
+	if answer != "We chose sqlite for simplicity" {
+		t.Errorf("Unexpected answer %q", answer)
+	}
+	if pastMe.Label != "past self at v1.0 (01234567)" {
+		t.Errorf("Unexpected label %q", pastMe.Label)
+	}
+
+	// The past self read the snapshot, not the live context
+	if len(*requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(*requests))
+	}
+	result, _ := json.Marshal((*requests)[1].Messages)
+	if !strings.Contains(string(result), "use sqlite") || strings.Contains(string(result), "use postgres") {
+		t.Errorf("Expected the snapshot's knowledge in the tool result, got %s", result)
+	}
+	for _, tool := range (*requests)[0].Tools {
+		if !readOnlyTools[tool.Name] {
+			t.Errorf("Expected only read-only tools, got %s", tool.Name)
+		}
+	}
+	system, _ := json.Marshal((*requests)[0].System)
+	if !strings.Contains(string(system), "0123456789abcdef") {
+		t.Errorf("Expected the system prompt to name the commit, got %s", system)
+	}
+
+	// The cost is the fork's and the client's consult cost, twice 1000 in and 100 out
+	if cost.InputTokens != 2000 || pastMe.Cost().TotalCost != cost.TotalCost {
+		t.Errorf("Expected the fork to account 2000 input tokens, got %+v", pastMe.Cost())
+	}
+	if client.ConsultCost().TotalCost != cost.TotalCost {
+		t.Errorf("Expected the client's consult cost to be %f, got %f", cost.TotalCost, client.ConsultCost().TotalCost)
+	}
+
+	pastMe.Close()
+	if _, _, err := client.AskForkedAI(pastMe, "Again?"); err == nil {
+		t.Error("Expected a closed fork to refuse questions")
+	}
+	if value, _ := live.GetKnowledge("database"); value != "use postgres" {
+		t.Errorf("Expected the live context to be untouched, got %q", value)
+	}
+}
+
+func TestConsultToolDifferentModel(t *testing.T) {
+	server, requests := forkServer(t)
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model"}
+
+	var events []string
+	client.SetStreamingCallback(func(eventType string, data interface{}) {
+		events = append(events, eventType)
+	})
+
+	result, err := client.ExecuteFunction(ToolUse{ID: "c1", Name: "consult", Input: map[string]interface{}{
+		"question": "Is this design sound?",
+		"model":    "claude-3-opus-20240229",
+	}})
+	if err != nil {
+		t.Fatalf("consult failed: %v", err)
+	}
+	if !strings.HasPrefix(result, "Answer from claude-3-opus-20240229") {
+		t.Errorf("Unexpected result %q", result)
+	}
+	if (*requests)[0].Model != "claude-3-opus-20240229" {
+		t.Errorf("Expected the other model to be asked, got %s", (*requests)[0].Model)
+	}
+	if client.ConsultCost().TotalCost == 0 {
+		t.Error("Expected the consult cost to be accounted")
+	}
+	if !strings.Contains(strings.Join(events, ","), "consult_completed") {
+		t.Errorf("Expected a consult_completed event, got %v", events)
+	}
+}
+
+func TestConsultErrors(t *testing.T) {
+	client := &ClaudeClient{model: "test-model"}
+	if _, _, _, err := client.Consult("Why?", "", ""); err == nil {
+		t.Error("Expected an error without a ref or another model")
+	}
+	if _, _, _, err := client.Consult("Why?", "v1.0", ""); err == nil {
+		t.Error("Expected an error without context history")
+	}
+	if _, _, _, err := client.Consult(" ", "", "other-model"); err == nil {
+		t.Error("Expected an error without a question")
+	}
+
+	fork, err := client.ForkDifferentModel("other-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Close()
+	path := t.TempDir() + "/written.txt"
+	if _, err := fork.client.ExecuteFunction(ToolUse{Name: "write_file", Input: map[string]interface{}{"file_path": path, "content": "x"}}); err == nil {
+		t.Error("Expected a fork to refuse write_file")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Expected nothing written by a fork")
+	}
+}
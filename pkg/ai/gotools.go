@@ -0,0 +1,174 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"stackagent/pkg/codeintel"
+)
+
+// Default cap on references returned by go_references
+const defaultMaxReferences = 200
+
+// goTools returns the Go code intelligence tool definitions
+func goTools() []Tool {
+	rootProperty := Property{
+		Type:        "string",
+		Description: "Workspace root to search (optional, default: current directory)",
+	}
+
+	return []Tool{
+		{
+			Name:        "go_symbols",
+			Description: "List the top-level declarations (funcs, methods, types, consts, vars) of a Go package directory as a compact outline with signatures and line numbers. Much cheaper than reading whole files.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"directory_path": {
+						Type:        "string",
+						Description: "The package directory (optional, default: current directory)",
+					},
+					"include_unexported": {
+						Type:        "boolean",
+						Description: "Also list unexported declarations (optional, default: true)",
+					},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			Name:        "go_definition",
+			Description: "Find where a Go symbol is declared. Accepts 'Name' or 'Type.Method'. Returns file, line, signature and doc comment.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"symbol": {
+						Type:        "string",
+						Description: "The symbol name, e.g. 'NewClaudeClient' or 'ShellManager.GetTail'",
+					},
+					"root": rootProperty,
+				},
+				Required: []string{"symbol"},
+			},
+		},
+		{
+			Name:        "go_references",
+			Description: "Find references to a Go identifier across the workspace (syntactic match on identifiers, not text). 'Type.Method' restricts to selector expressions like x.Method.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"symbol": {
+						Type:        "string",
+						Description: "The identifier to find, e.g. 'RunWithCapture' or 'ShellManager.GetTail'",
+					},
+					"root": rootProperty,
+					"max_results": {
+						Type:        "integer",
+						Description: "Maximum number of references to return (optional, default: 200)",
+					},
+				},
+				Required: []string{"symbol"},
+			},
+		},
+		{
+			Name:        "go_function_body",
+			Description: "Show the full source (with doc comment) of a Go function or method by name, without reading the rest of the file.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"symbol": {
+						Type:        "string",
+						Description: "The function name, or 'Type.Method' for a method",
+					},
+					"root": rootProperty,
+				},
+				Required: []string{"symbol"},
+			},
+		},
+	}
+}
+
+// executeGoTool runs a Go code intelligence tool
+func executeGoTool(toolUse ToolUse) (string, error) {
+	root, _ := toolUse.Input["root"].(string)
+	if root == "" {
+		root = "."
+	}
+	symbol, _ := toolUse.Input["symbol"].(string)
+	if toolUse.Name != "go_symbols" && symbol == "" {
+		return "", fmt.Errorf("invalid symbol parameter")
+	}
+
+	switch toolUse.Name {
+	case "go_symbols":
+		dir, _ := toolUse.Input["directory_path"].(string)
+		if dir == "" {
+			dir = "."
+		}
+		includeUnexported := true
+		if v, ok := toolUse.Input["include_unexported"].(bool); ok {
+			includeUnexported = v
+		}
+		symbols, err := codeintel.PackageSymbols(dir, includeUnexported)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse package: %w", err)
+		}
+		return codeintel.FormatOutline(symbols), nil
+
+	case "go_definition":
+		symbols, err := codeintel.FindDefinitions(root, symbol)
+		if err != nil {
+			return "", fmt.Errorf("failed to search definitions: %w", err)
+		}
+		if len(symbols) == 0 {
+			return fmt.Sprintf("No definition found for '%s' under %s", symbol, root), nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Found %d definition(s) of '%s':\n", len(symbols), symbol)
+		for _, sym := range symbols {
+			fmt.Fprintf(&b, "\n%s:%d-%d (%s, package %s)\n%s\n", sym.File, sym.Line, sym.EndLine, sym.Kind, sym.Package, sym.Signature)
+			if sym.Doc != "" {
+				fmt.Fprintf(&b, "// %s\n", sym.Doc)
+			}
+		}
+		return strings.TrimRight(b.String(), "\n"), nil
+
+	case "go_references":
+		maxResults := defaultMaxReferences
+		if v, ok := toolUse.Input["max_results"].(float64); ok && v > 0 {
+			maxResults = int(v)
+		}
+		refs, truncated, err := codeintel.FindReferences(root, symbol, maxResults)
+		if err != nil {
+			return "", fmt.Errorf("failed to search references: %w", err)
+		}
+		if len(refs) == 0 {
+			return fmt.Sprintf("No references found for '%s' under %s", symbol, root), nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Found %d reference(s) to '%s':\n\n", len(refs), symbol)
+		for _, ref := range refs {
+			fmt.Fprintf(&b, "%s:%d:%d: %s\n", ref.File, ref.Line, ref.Column, ref.Text)
+		}
+		if truncated {
+			fmt.Fprintf(&b, "\n[Stopped after %d references]", maxResults)
+		}
+		return strings.TrimRight(b.String(), "\n"), nil
+
+	case "go_function_body":
+		funcs, err := codeintel.FindFunctionSource(root, symbol)
+		if err != nil {
+			return "", fmt.Errorf("failed to search functions: %w", err)
+		}
+		if len(funcs) == 0 {
+			return fmt.Sprintf("No function named '%s' found under %s", symbol, root), nil
+		}
+		var parts []string
+		for _, fn := range funcs {
+			parts = append(parts, fmt.Sprintf("%s:%d-%d\n\n%s", fn.File, fn.Line, fn.EndLine, fn.Source))
+		}
+		return strings.Join(parts, "\n\n"), nil
+	}
+
+	return "", fmt.Errorf("unknown function: %s", toolUse.Name)
+}
@@ -0,0 +1,279 @@
+package ai
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"stackagent/pkg/search"
+)
+
+// Default cap on the number of entries list_directory prints
+const defaultMaxListEntries = 500
+
+// ListOptions controls list_directory
+type ListOptions struct {
+	Recursive  bool
+	Tree       bool   // Render as an indented tree instead of a flat list
+	MaxDepth   int    // 0 means unlimited when recursive
+	Details    bool   // Show sizes and modification times
+	FileExt    string // Only files with this extension
+	Include    string // Comma-separated globs files must match
+	Exclude    string // Comma-separated globs to skip
+	SortBy     string // "name" (default), "size" or "mtime"
+	MaxEntries int    // 0 means defaultMaxListEntries
+	search.WalkOptions
+}
+
+// ListEntry is a single file or directory found by ListDirectory
+type ListEntry struct {
+	Path    string    `json:"path"` // Relative to the listed directory
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"` // Directories: total size of listed files below
+	ModTime time.Time `json:"modTime"`
+	Depth   int       `json:"depth"`
+}
+
+// ListResult holds everything ListDirectory collected
+type ListResult struct {
+	Root     string
+	Entries  []ListEntry
+	children map[string][]*ListEntry // Parent path ("" for root) -> children
+	less     func(a, b *ListEntry) bool
+}
+
+// ListDirectory collects directory entries honouring .gitignore, depth
+// limits and filters
+func ListDirectory(root string, opts ListOptions) (*ListResult, error) {
+	maxDepth := opts.MaxDepth
+	if !opts.Recursive {
+		maxDepth = 1
+	}
+	include := search.NewGlobMatcher(opts.Include)
+	exclude := search.NewGlobMatcher(opts.Exclude)
+	fileFilter := opts.FileExt != "" || include != nil
+
+	result := &ListResult{Root: root}
+	err := search.Walk(root, opts.WalkOptions, func(p, rel string, d fs.DirEntry) error {
+		depth := strings.Count(rel, "/") + 1
+		if exclude != nil && exclude.Match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if opts.FileExt != "" && !strings.HasSuffix(d.Name(), opts.FileExt) {
+				return nil
+			}
+			if include != nil && !include.Match(rel, false) {
+				return nil
+			}
+		}
+
+		entry := ListEntry{Path: rel, Name: d.Name(), IsDir: d.IsDir(), Depth: depth}
+		if info, err := d.Info(); err == nil {
+			entry.ModTime = info.ModTime()
+			if !d.IsDir() {
+				entry.Size = info.Size()
+			}
+		}
+		result.Entries = append(result.Entries, entry)
+
+		if d.IsDir() && maxDepth > 0 && depth >= maxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.index(fileFilter && opts.Recursive)
+	result.sort(opts.SortBy)
+	return result, nil
+}
+
+// index builds the parent -> children map, rolls file sizes up into their
+// directories and optionally prunes directories with no matching files
+func (r *ListResult) index(pruneEmptyDirs bool) {
+	byPath := make(map[string]*ListEntry, len(r.Entries))
+	for i := range r.Entries {
+		byPath[r.Entries[i].Path] = &r.Entries[i]
+	}
+
+	hasFiles := make(map[string]bool)
+	for i := range r.Entries {
+		e := &r.Entries[i]
+		if e.IsDir {
+			continue
+		}
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			hasFiles[dir] = true
+			if parent, ok := byPath[dir]; ok {
+				parent.Size += e.Size
+			}
+		}
+	}
+
+	r.children = make(map[string][]*ListEntry)
+	kept := r.Entries[:0:0]
+	for i := range r.Entries {
+		e := r.Entries[i]
+		if pruneEmptyDirs && e.IsDir && !hasFiles[e.Path] {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	r.Entries = kept
+	for i := range r.Entries {
+		e := &r.Entries[i]
+		parent := path.Dir(e.Path)
+		if parent == "." {
+			parent = ""
+		}
+		r.children[parent] = append(r.children[parent], e)
+	}
+}
+
+// sort orders the children of every directory
+func (r *ListResult) sort(by string) {
+	less := func(a, b *ListEntry) bool {
+		switch by {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+		case "mtime":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.After(b.ModTime)
+			}
+		default:
+			if a.IsDir != b.IsDir {
+				return a.IsDir // Directories first
+			}
+		}
+		return a.Name < b.Name
+	}
+
+	for _, kids := range r.children {
+		sort.SliceStable(kids, func(i, j int) bool { return less(kids[i], kids[j]) })
+	}
+	r.less = less
+}
+
+// Format renders the listing as a flat list or a tree, printing at most
+// maxEntries lines and summarising the rest as "N more"
+func (r *ListResult) Format(tree, details bool, sortBy string, maxEntries int) string {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxListEntries
+	}
+	if len(r.Entries) == 0 {
+		return fmt.Sprintf("No files found in %s with the specified criteria", r.Root)
+	}
+
+	var b strings.Builder
+	printed := 0
+	omitted := 0
+
+	// A flat list sorted by size or time is most useful sorted globally
+	// rather than per directory
+	children := r.children
+	global := !tree && sortBy != "" && sortBy != "name"
+	if global {
+		flat := make([]*ListEntry, len(r.Entries))
+		for i := range r.Entries {
+			flat[i] = &r.Entries[i]
+		}
+		sort.SliceStable(flat, func(i, j int) bool { return r.less(flat[i], flat[j]) })
+		children = map[string][]*ListEntry{"": flat}
+	}
+
+	var walk func(dir, indent string)
+	walk = func(dir, indent string) {
+		kids := children[dir]
+		for i, e := range kids {
+			if printed >= maxEntries {
+				n := len(kids) - i
+				omitted += n
+				if tree {
+					fmt.Fprintf(&b, "%s└── ... %d more\n", indent, n)
+				}
+				return
+			}
+			printed++
+
+			name := e.Path
+			branch, childIndent := "", ""
+			if tree {
+				name = e.Name
+				branch, childIndent = "├── ", "│   "
+				if i == len(kids)-1 {
+					branch, childIndent = "└── ", "    "
+				}
+			}
+			if e.IsDir {
+				name += "/"
+			}
+			b.WriteString(indent + branch + name)
+			if details {
+				fmt.Fprintf(&b, "  (%s, %s)", formatSize(e.Size), e.ModTime.Format("2006-01-02 15:04"))
+			}
+			b.WriteString("\n")
+
+			if e.IsDir && !global {
+				walk(e.Path, indent+childIndent)
+			}
+		}
+	}
+	walk("", "")
+
+	header := fmt.Sprintf("Found %d item(s) in %s:\n\n", len(r.Entries), r.Root)
+	text := header + strings.TrimRight(b.String(), "\n")
+	if omitted > 0 || printed < len(r.Entries) {
+		text += fmt.Sprintf("\n\n[Showing %d of %d entries. Use max_depth, include/exclude or a subdirectory to narrow the listing]", printed, len(r.Entries))
+	}
+	return text
+}
+
+// formatSize renders a byte count for humans
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%dB", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(size)/1024)
+	case size < 1024*1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(size)/1024/1024)
+	default:
+		return fmt.Sprintf("%.1fGB", float64(size)/1024/1024/1024)
+	}
+}
+
+// listOptionsFromInput extracts list_directory tool arguments
+func listOptionsFromInput(input map[string]interface{}) ListOptions {
+	var opts ListOptions
+	opts.FileExt, _ = input["file_extension"].(string)
+	opts.ShowHidden, _ = input["show_hidden"].(bool)
+	opts.Recursive, _ = input["recursive"].(bool)
+	opts.Details, _ = input["details"].(bool)
+	opts.Include, _ = input["include"].(string)
+	opts.Exclude, _ = input["exclude"].(string)
+	opts.SortBy, _ = input["sort"].(string)
+	opts.NoIgnore, _ = input["no_ignore"].(bool)
+	if format, _ := input["format"].(string); format == "tree" {
+		opts.Tree = true
+	}
+	if v, ok := input["max_depth"].(float64); ok && v > 0 {
+		opts.MaxDepth = int(v)
+		opts.Recursive = true
+	}
+	if v, ok := input["max_entries"].(float64); ok && v > 0 {
+		opts.MaxEntries = int(v)
+	}
+	return opts
+}
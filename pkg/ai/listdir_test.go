@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stackagent/pkg/search"
+)
+
+// setupListTree creates:
+//
+//	.gitignore (ignores build/)
+//	README.md
+//	build/out.bin
+//	cmd/main.go
+//	pkg/a/a.go
+//	pkg/a/a_test.go
+//	pkg/b/big.txt
+func setupListTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range []string{"build", "cmd", "pkg/a", "pkg/b"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, ".gitignore"), "build/\n")
+	writeTestFile(t, filepath.Join(root, "README.md"), "readme\n")
+	writeTestFile(t, filepath.Join(root, "build", "out.bin"), "binary")
+	writeTestFile(t, filepath.Join(root, "cmd", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(root, "pkg", "a", "a.go"), "package a\n")
+	writeTestFile(t, filepath.Join(root, "pkg", "a", "a_test.go"), "package a\n")
+	writeTestFile(t, filepath.Join(root, "pkg", "b", "big.txt"), strings.Repeat("x", 4096))
+	return root
+}
+
+func entryPaths(r *ListResult) []string {
+	var paths []string
+	for _, e := range r.Entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestListDirectoryRespectsGitignore(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	for _, p := range entryPaths(listing) {
+		if strings.HasPrefix(p, "build") || p == ".gitignore" {
+			t.Errorf("Expected %s to be filtered out", p)
+		}
+	}
+
+	listing, err = ListDirectory(root, ListOptions{Recursive: true, WalkOptions: search.WalkOptions{NoIgnore: true}})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	found := false
+	for _, p := range entryPaths(listing) {
+		if p == "build/out.bin" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected build/out.bin with no_ignore, got %v", entryPaths(listing))
+	}
+}
+
+func TestListDirectoryMaxDepth(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{Recursive: true, MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	for _, e := range listing.Entries {
+		if e.Depth > 2 {
+			t.Errorf("Expected depth <= 2, got %s at depth %d", e.Path, e.Depth)
+		}
+	}
+
+	listing, err = ListDirectory(root, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	if len(listing.Entries) != 3 {
+		t.Errorf("Expected 3 top-level entries, got %v", entryPaths(listing))
+	}
+}
+
+func TestListDirectoryFiltersPruneEmptyDirs(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{Recursive: true, Include: "*.go", Exclude: "*_test.go"})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	got := strings.Join(entryPaths(listing), ",")
+	want := "cmd,cmd/main.go,pkg,pkg/a,pkg/a/a.go"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestListDirectoryTreeFormat(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	out := listing.Format(true, false, "", 0)
+
+	for _, want := range []string{
+		"├── cmd/\n│   └── main.go",
+		"└── README.md",
+		"│   ├── a/\n│   │   ├── a.go\n│   │   └── a_test.go",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected tree to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestListDirectoryMaxEntries(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+	out := listing.Format(true, false, "", 2)
+	if !strings.Contains(out, "└── ... 1 more") {
+		t.Errorf("Expected '... 1 more' summary, got:\n%s", out)
+	}
+	if !strings.Contains(out, "[Showing 2 of 3 entries") {
+		t.Errorf("Expected truncation notice, got:\n%s", out)
+	}
+}
+
+func TestListDirectorySortBySize(t *testing.T) {
+	root := setupListTree(t)
+
+	listing, err := ListDirectory(root, ListOptions{Recursive: true, SortBy: "size"})
+	if err != nil {
+		t.Fatalf("ListDirectory failed: %v", err)
+	}
+
+	// Directory sizes are rolled up from their files
+	for _, e := range listing.Entries {
+		if e.Path == "pkg" && e.Size != 4096+20 {
+			t.Errorf("Expected pkg size %d, got %d", 4096+20, e.Size)
+		}
+	}
+
+	out := listing.Format(false, true, "size", 0)
+	lines := strings.Split(out, "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[2], "pkg/  (4.0KB") {
+		t.Errorf("Expected pkg/ first when sorted by size, got:\n%s", out)
+	}
+}
@@ -0,0 +1,435 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	stackctx "stackagent/pkg/context"
+)
+
+// Maximum number of keys per section listed in the system prompt index
+const maxIndexedKeys = 100
+
+// memoryTools returns the persistent memory and knowledge tool definitions
+func memoryTools() []Tool {
+	keyProperty := Property{
+		Type:        "string",
+		Description: "The entry key, e.g. 'build-command' or 'auth/design-decision'",
+	}
+	tagsProperty := Property{
+		Type:        "string",
+		Description: "Comma-separated tags (optional, e.g. 'auth,jwt')",
+	}
+
+	return []Tool{
+		{
+			Name:        "memory_set",
+			Description: "Store a protected memory entry that survives across sessions (decisions, preferences, progress notes). Overwrites an existing entry with the same key.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"key": keyProperty,
+					"value": {
+						Type:        "string",
+						Description: "The content to remember",
+					},
+					"tags": tagsProperty,
+				},
+				Required: []string{"key", "value"},
+			},
+		},
+		{
+			Name:        "memory_get",
+			Description: "Read a protected memory entry by key. The system prompt lists the available keys.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"key": keyProperty,
+				},
+				Required: []string{"key"},
+			},
+		},
+		{
+			Name:        "memory_list",
+			Description: "List all protected memory keys with a short preview of each value.",
+			InputSchema: InputSchema{
+				Type:       "object",
+				Properties: map[string]Property{},
+				Required:   []string{},
+			},
+		},
+		{
+			Name:        "memory_delete",
+			Description: "Delete a protected memory entry that is obsolete or wrong.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"key": keyProperty,
+				},
+				Required: []string{"key"},
+			},
+		},
+		{
+			Name:        "knowledge_set",
+			Description: "Store a learned pattern or insight about the project (conventions, gotchas, how things fit together).",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"key": keyProperty,
+					"value": {
+						Type:        "string",
+						Description: "The pattern or insight",
+					},
+					"tags": tagsProperty,
+				},
+				Required: []string{"key", "value"},
+			},
+		},
+		{
+			Name:        "knowledge_get",
+			Description: "Read a knowledge entry by key, or list all knowledge keys when no key is given.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"key": {
+						Type:        "string",
+						Description: "The entry key (optional)",
+					},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			Name:        "search_context",
+			Description: "Search memory, knowledge and command history by relevance. Use this before asking the user about something that may have been decided or learned in an earlier session.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"query": {
+						Type:        "string",
+						Description: "Words to search for, e.g. 'refresh tokens'",
+					},
+					"scope": {
+						Type:        "string",
+						Description: "Only search entries whose key starts with this prefix or that have this tag (optional, e.g. 'jwt-research')",
+					},
+					"kind": {
+						Type:        "string",
+						Description: "Only search one kind of context: memory, knowledge or command (optional)",
+					},
+					"limit": {
+						Type:        "integer",
+						Description: "Maximum number of results (optional, default 10)",
+					},
+				},
+				Required: []string{"query"},
+			},
+		},
+		{
+			Name:        "recall",
+			Description: "Find memory and knowledge entries by meaning rather than exact words, e.g. 'how did we handle token expiry' finds notes about refresh token rotation. Use search_context for exact terms such as identifiers or commands.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"query": {
+						Type:        "string",
+						Description: "A description of what you're looking for",
+					},
+					"scope": {
+						Type:        "string",
+						Description: "Only recall entries whose key starts with this prefix or that have this tag (optional)",
+					},
+					"kind": {
+						Type:        "string",
+						Description: "Only recall one kind of entry: memory or knowledge (optional)",
+					},
+					"limit": {
+						Type:        "integer",
+						Description: "Maximum number of results (optional, default 10)",
+					},
+				},
+				Required: []string{"query"},
+			},
+		},
+		{
+			Name:        "set_task",
+			Description: "Record the current task and/or a project description so the next session can pick up where this one left off.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"current_task": {
+						Type:        "string",
+						Description: "What we're working on right now and what's left (optional)",
+					},
+					"project_context": {
+						Type:        "string",
+						Description: "A short description of the project (optional)",
+					},
+				},
+				Required: []string{},
+			},
+		},
+	}
+}
+
+// executeMemoryTool runs a persistent memory or knowledge tool
+func (c *ClaudeClient) executeMemoryTool(toolUse ToolUse) (string, error) {
+	cm := c.contextManager
+	if cm == nil {
+		return "", fmt.Errorf("persistent context is not available")
+	}
+
+	key, _ := toolUse.Input["key"].(string)
+	value, _ := toolUse.Input["value"].(string)
+	needsKey := toolUse.Name == "memory_set" || toolUse.Name == "memory_get" ||
+		toolUse.Name == "memory_delete" || toolUse.Name == "knowledge_set"
+	if needsKey && key == "" {
+		return "", fmt.Errorf("invalid key parameter")
+	}
+
+	switch toolUse.Name {
+	case "memory_set":
+		if err := cm.SetProtected(key, value); err != nil {
+			return "", fmt.Errorf("failed to save memory: %w", err)
+		}
+		if err := setTagsFromInput(cm, "memory", key, toolUse.Input); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Saved memory '%s' (%d bytes)", key, len(value)), nil
+
+	case "memory_get":
+		value, exists := cm.GetProtected(key)
+		if !exists {
+			return fmt.Sprintf("No memory entry named '%s'", key), nil
+		}
+		return value, nil
+
+	case "memory_list":
+		keys := cm.ListProtected()
+		if len(keys) == 0 {
+			return "No memory entries", nil
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d memory entries:\n", len(keys))
+		for _, k := range keys {
+			v, _ := cm.GetProtected(k)
+			fmt.Fprintf(&b, "\n%s: %s", k, previewValue(v, 80))
+		}
+		return b.String(), nil
+
+	case "memory_delete":
+		if _, exists := cm.GetProtected(key); !exists {
+			return fmt.Sprintf("No memory entry named '%s'", key), nil
+		}
+		if err := cm.DeleteProtected(key); err != nil {
+			return "", fmt.Errorf("failed to delete memory: %w", err)
+		}
+		return fmt.Sprintf("Deleted memory '%s'", key), nil
+
+	case "knowledge_set":
+		if err := cm.SetKnowledge(key, value); err != nil {
+			return "", fmt.Errorf("failed to save knowledge: %w", err)
+		}
+		if err := setTagsFromInput(cm, "knowledge", key, toolUse.Input); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Saved knowledge '%s' (%d bytes)", key, len(value)), nil
+
+	case "knowledge_get":
+		if key == "" {
+			keys := cm.ListKnowledge()
+			if len(keys) == 0 {
+				return "No knowledge entries", nil
+			}
+			sort.Strings(keys)
+			return fmt.Sprintf("%d knowledge entries:\n\n%s", len(keys), strings.Join(keys, "\n")), nil
+		}
+		value, exists := cm.GetKnowledge(key)
+		if !exists {
+			return fmt.Sprintf("No knowledge entry named '%s'", key), nil
+		}
+		return value, nil
+
+	case "search_context", "recall":
+		query, _ := toolUse.Input["query"].(string)
+		if strings.TrimSpace(query) == "" {
+			return "", fmt.Errorf("invalid query parameter")
+		}
+		opts := stackctx.SearchOptions{}
+		opts.Scope, _ = toolUse.Input["scope"].(string)
+		if kind, _ := toolUse.Input["kind"].(string); kind != "" {
+			switch {
+			case kind == stackctx.KindMemory, kind == stackctx.KindKnowledge,
+				kind == stackctx.KindCommand && toolUse.Name == "search_context":
+				opts.Kinds = []string{kind}
+			default:
+				return "", fmt.Errorf("invalid kind: %s", kind)
+			}
+		}
+		if limit, ok := toolUse.Input["limit"].(float64); ok {
+			opts.Limit = int(limit)
+		}
+		if toolUse.Name == "search_context" {
+			return formatSearchResults(query, cm.SearchContextWith(query, opts)), nil
+		}
+		results, err := cm.Recall(query, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to recall context: %w", err)
+		}
+		return formatSearchResults(query, results), nil
+
+	case "set_task":
+		task, hasTask := toolUse.Input["current_task"].(string)
+		project, hasProject := toolUse.Input["project_context"].(string)
+		if !hasTask && !hasProject {
+			return "", fmt.Errorf("provide current_task and/or project_context")
+		}
+		var updated []string
+		if hasTask {
+			if err := cm.SetCurrentTask(task); err != nil {
+				return "", fmt.Errorf("failed to save current task: %w", err)
+			}
+			updated = append(updated, "current task")
+		}
+		if hasProject {
+			if err := cm.SetProjectContext(project); err != nil {
+				return "", fmt.Errorf("failed to save project context: %w", err)
+			}
+			updated = append(updated, "project context")
+		}
+		return fmt.Sprintf("Updated %s", strings.Join(updated, " and ")), nil
+	}
+
+	return "", fmt.Errorf("unknown function: %s", toolUse.Name)
+}
+
+// formatSearchResults renders ranked context matches for the model
+func formatSearchResults(query string, results []stackctx.ContextSearchResult) string {
+	if len(results) == 0 {
+		return fmt.Sprintf("No context matches '%s'", query)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d matches for '%s':\n", len(results), query)
+	for _, r := range results {
+		fmt.Fprintf(&b, "\n[%s] %s (score %.2f)", r.Kind, r.Key, r.Score)
+		if len(r.Tags) > 0 {
+			fmt.Fprintf(&b, " tags: %s", strings.Join(r.Tags, ", "))
+		}
+		if r.Snippet != "" {
+			fmt.Fprintf(&b, "\n  %s", r.Snippet)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// setTagsFromInput applies the optional comma-separated tags argument
+func setTagsFromInput(cm *stackctx.ContextManager, kind, key string, input map[string]interface{}) error {
+	raw, ok := input["tags"].(string)
+	if !ok {
+		return nil
+	}
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if err := cm.SetEntryTags(kind, key, tags); err != nil {
+		return fmt.Errorf("failed to save tags: %w", err)
+	}
+	return nil
+}
+
+// contextIndexPrompt summarises the persistent context for the system
+// prompt: the current task, project description and the available memory
+// and knowledge keys. Values are fetched on demand with the memory tools.
+func (c *ClaudeClient) contextIndexPrompt() string {
+	cm := c.contextManager
+	if cm == nil {
+		return ""
+	}
+
+	ws := cm.GetWorkspace()
+	memoryKeys := cm.ListProtected()
+	knowledgeKeys := cm.ListKnowledge()
+	sort.Strings(memoryKeys)
+	sort.Strings(knowledgeKeys)
+
+	var b strings.Builder
+	if c.readOnly {
+		b.WriteString("Persistent context (read-only). Use memory_get/memory_list and knowledge_get to read entries, and search_context (exact terms) or recall (by meaning) to find notes.\n")
+	} else {
+		b.WriteString("Persistent context (survives across sessions). Use memory_set/memory_get/memory_list/memory_delete for decisions and progress notes, knowledge_set/knowledge_get for learned project patterns, search_context (exact terms) or recall (by meaning) to find earlier notes, and set_task to record what you're working on so the next session can pick up where this one left off.\n")
+	}
+	if ws.ProjectContext != "" {
+		fmt.Fprintf(&b, "\nProject: %s\n", ws.ProjectContext)
+	}
+	if ws.CurrentTask != "" {
+		fmt.Fprintf(&b, "\nCurrent task: %s\n", ws.CurrentTask)
+	}
+	writeKeyIndex(&b, "Memory keys", memoryKeys, "memory_list")
+	writeKeyIndex(&b, "Knowledge keys", knowledgeKeys, "knowledge_get")
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// systemPromptBlocks returns the cached base system prompt followed by the
+// persistent context index, which changes between turns and is not cached
+func (c *ClaudeClient) systemPromptBlocks() []ContentBlock {
+	base := SystemPrompt
+	if c.basePrompt != "" {
+		base = c.basePrompt
+	}
+	blocks := []ContentBlock{
+		{
+			Type:         "text",
+			Text:         base,
+			CacheControl: &CacheControl{Type: "ephemeral"}, // Cache system prompt
+		},
+	}
+	if index := c.contextIndexPrompt(); index != "" {
+		blocks = append(blocks, ContentBlock{Type: "text", Text: index})
+	}
+	return blocks
+}
+
+// SystemPromptText returns the full system prompt as sent on the next request
+func (c *ClaudeClient) SystemPromptText() string {
+	var parts []string
+	for _, block := range c.systemPromptBlocks() {
+		parts = append(parts, block.Text)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// writeKeyIndex writes a capped, comma-separated key list pointing at
+// listTool for the rest
+func writeKeyIndex(b *strings.Builder, title string, keys []string, listTool string) {
+	if len(keys) == 0 {
+		fmt.Fprintf(b, "\n%s: none\n", title)
+		return
+	}
+	shown := keys
+	if len(shown) > maxIndexedKeys {
+		shown = shown[:maxIndexedKeys]
+	}
+	fmt.Fprintf(b, "\n%s: %s", title, strings.Join(shown, ", "))
+	if len(keys) > len(shown) {
+		fmt.Fprintf(b, " (and %d more, see %s)", len(keys)-len(shown), listTool)
+	}
+	b.WriteString("\n")
+}
+
+// previewValue returns the first line of value, truncated to maxLen runes
+func previewValue(value string, maxLen int) string {
+	if i := strings.IndexByte(value, '\n'); i >= 0 {
+		value = value[:i] + " ..."
+	}
+	runes := []rune(value)
+	if len(runes) > maxLen {
+		return string(runes[:maxLen]) + "..."
+	}
+	return value
+}
@@ -0,0 +1,151 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+
+	"stackagent/pkg/shell"
+)
+
+func runMemoryTool(t *testing.T, client *ClaudeClient, name string, input map[string]interface{}) string {
+	t.Helper()
+	result, err := client.ExecuteFunction(ToolUse{ID: "call_" + name, Name: name, Input: input})
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+	return result
+}
+
+func TestMemoryTools(t *testing.T) {
+	client, cm := newContextTestClient(t)
+
+	runMemoryTool(t, client, "memory_set", map[string]interface{}{"key": "build", "value": "make all\nthen make test"})
+	if v, _ := cm.GetProtected("build"); v != "make all\nthen make test" {
+		t.Errorf("Expected memory to be stored, got %q", v)
+	}
+
+	if got := runMemoryTool(t, client, "memory_get", map[string]interface{}{"key": "build"}); got != "make all\nthen make test" {
+		t.Errorf("Expected stored value, got %q", got)
+	}
+
+	list := runMemoryTool(t, client, "memory_list", map[string]interface{}{})
+	if !strings.Contains(list, "build: make all ...") {
+		t.Errorf("Expected preview in list, got %q", list)
+	}
+
+	runMemoryTool(t, client, "memory_delete", map[string]interface{}{"key": "build"})
+	if _, exists := cm.GetProtected("build"); exists {
+		t.Error("Expected memory entry to be deleted")
+	}
+	if got := runMemoryTool(t, client, "memory_get", map[string]interface{}{"key": "build"}); !strings.Contains(got, "No memory entry") {
+		t.Errorf("Expected missing entry message, got %q", got)
+	}
+
+	if _, err := client.ExecuteFunction(ToolUse{Name: "memory_set", Input: map[string]interface{}{"value": "x"}}); err == nil {
+		t.Error("Expected error for missing key")
+	}
+}
+
+func TestKnowledgeTools(t *testing.T) {
+	client, cm := newContextTestClient(t)
+
+	runMemoryTool(t, client, "knowledge_set", map[string]interface{}{"key": "errors", "value": "wrap with %w"})
+	runMemoryTool(t, client, "knowledge_set", map[string]interface{}{"key": "tests", "value": "t.TempDir"})
+	if v, _ := cm.GetKnowledge("errors"); v != "wrap with %w" {
+		t.Errorf("Expected knowledge to be stored, got %q", v)
+	}
+
+	if got := runMemoryTool(t, client, "knowledge_get", map[string]interface{}{"key": "tests"}); got != "t.TempDir" {
+		t.Errorf("Expected 't.TempDir', got %q", got)
+	}
+	if got := runMemoryTool(t, client, "knowledge_get", map[string]interface{}{}); !strings.Contains(got, "errors\ntests") {
+		t.Errorf("Expected sorted key list, got %q", got)
+	}
+}
+
+func TestSetTaskTool(t *testing.T) {
+	client, cm := newContextTestClient(t)
+
+	runMemoryTool(t, client, "set_task", map[string]interface{}{"current_task": "add auth middleware"})
+	runMemoryTool(t, client, "set_task", map[string]interface{}{"project_context": "Go agent server"})
+
+	ws := cm.GetWorkspace()
+	if ws.CurrentTask != "add auth middleware" {
+		t.Errorf("Expected current task to be set, got %q", ws.CurrentTask)
+	}
+	if ws.ProjectContext != "Go agent server" {
+		t.Errorf("Expected project context to be set, got %q", ws.ProjectContext)
+	}
+
+	if _, err := client.ExecuteFunction(ToolUse{Name: "set_task", Input: map[string]interface{}{}}); err == nil {
+		t.Error("Expected error when neither field is given")
+	}
+}
+
+func TestSystemPromptIncludesContextIndex(t *testing.T) {
+	client, cm := newContextTestClient(t)
+	cm.SetProtected("zeta", "1")
+	cm.SetProtected("alpha", "2")
+	cm.SetCurrentTask("finish the parser")
+
+	blocks := client.systemPromptBlocks()
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 system blocks, got %d", len(blocks))
+	}
+	if blocks[0].Text != SystemPrompt || blocks[0].CacheControl == nil {
+		t.Error("Expected the cached base prompt first")
+	}
+	if blocks[1].CacheControl != nil {
+		t.Error("Expected the context index not to be cached")
+	}
+	for _, want := range []string{"Current task: finish the parser", "Memory keys: alpha, zeta", "Knowledge keys: none"} {
+		if !strings.Contains(blocks[1].Text, want) {
+			t.Errorf("Expected index to contain %q, got:\n%s", want, blocks[1].Text)
+		}
+	}
+
+	// Without persistent context only the base prompt and tools are sent
+	plain := &ClaudeClient{shellManager: shell.NewShellManager()}
+	if n := len(plain.systemPromptBlocks()); n != 1 {
+		t.Errorf("Expected 1 system block without context, got %d", n)
+	}
+	for _, tool := range plain.getAvailableTools() {
+		if strings.HasPrefix(tool.Name, "memory_") {
+			t.Errorf("Expected no memory tools without context, got %s", tool.Name)
+		}
+	}
+}
+
+func TestSearchContextTool(t *testing.T) {
+	client, cm := newContextTestClient(t)
+	cm.SetKnowledge("jwt-research/refresh", "Refresh tokens rotate on every use")
+	cm.SetProtected("build", "make all")
+
+	got := runMemoryTool(t, client, "search_context", map[string]interface{}{"query": "refresh tokens", "scope": "jwt-research"})
+	if !strings.Contains(got, "[knowledge] jwt-research/refresh") || !strings.Contains(got, "Refresh tokens rotate") {
+		t.Errorf("Expected the refresh entry with a snippet, got %q", got)
+	}
+
+	if got := runMemoryTool(t, client, "search_context", map[string]interface{}{"query": "kubernetes"}); !strings.Contains(got, "No context matches") {
+		t.Errorf("Expected no matches message, got %q", got)
+	}
+
+	if _, err := client.ExecuteFunction(ToolUse{Name: "search_context", Input: map[string]interface{}{"query": "x", "kind": "files"}}); err == nil {
+		t.Error("Expected error for unknown kind")
+	}
+}
+
+func TestRecallTool(t *testing.T) {
+	client, cm := newContextTestClient(t)
+	cm.SetKnowledge("auth/rotation", "Refresh tokens are rotated on every use")
+	cm.SetKnowledge("db/pool", "Postgres pool is capped at 20 connections")
+
+	got := runMemoryTool(t, client, "recall", map[string]interface{}{"query": "rotating refresh tokens", "limit": float64(1)})
+	if !strings.Contains(got, "[knowledge] auth/rotation") || strings.Contains(got, "db/pool") {
+		t.Errorf("Expected only the rotation entry, got %q", got)
+	}
+
+	if _, err := client.ExecuteFunction(ToolUse{Name: "recall", Input: map[string]interface{}{"query": "x", "kind": "command"}}); err == nil {
+		t.Error("Expected error for command kind, which recall does not cover")
+	}
+}
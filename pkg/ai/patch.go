@@ -0,0 +1,669 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum number of context lines that may be dropped from either end of a
+// hunk when it does not apply cleanly (same idea as GNU patch's --fuzz)
+const maxPatchFuzz = 2
+
+// FilePatch describes the changes to a single file in a patch
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+	Edits   []FindReplaceEdit // Used by the simple edit envelope instead of hunks
+}
+
+// Hunk is a single @@ section of a unified diff
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []string // Each line keeps its ' ', '-' or '+' prefix
+}
+
+// FindReplaceEdit is one entry of the simple multi-file edit envelope
+type FindReplaceEdit struct {
+	FilePath       string
+	Find           string
+	Replace        string
+	AllOccurrences bool
+}
+
+// HunkResult reports how a single hunk (or edit) was applied
+type HunkResult struct {
+	Index     int    `json:"index"`
+	OldStart  int    `json:"oldStart,omitempty"`
+	AppliedAt int    `json:"appliedAt,omitempty"`
+	Offset    int    `json:"offset,omitempty"`
+	Fuzz      int    `json:"fuzz,omitempty"`
+	Applied   bool   `json:"applied"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FilePatchResult reports the outcome for one file of a patch
+type FilePatchResult struct {
+	Path       string       `json:"path"`
+	Action     string       `json:"action"` // "modify", "create" or "delete"
+	Hunks      []HunkResult `json:"hunks"`
+	Diff       string       `json:"diff,omitempty"`
+	oldContent string
+	newContent string
+	existed    bool
+}
+
+// PatchResult is the outcome of applying a whole patch
+type PatchResult struct {
+	Files   []FilePatchResult `json:"files"`
+	Applied bool              `json:"applied"`
+	DryRun  bool              `json:"dryRun"`
+}
+
+// Path returns the path the patch applies to, ignoring /dev/null
+func (fp FilePatch) Path() string {
+	if fp.NewPath != "" && fp.NewPath != "/dev/null" {
+		return fp.NewPath
+	}
+	return fp.OldPath
+}
+
+// IsCreate reports whether the patch creates a new file
+func (fp FilePatch) IsCreate() bool {
+	return fp.OldPath == "/dev/null"
+}
+
+// IsDelete reports whether the patch deletes the file
+func (fp FilePatch) IsDelete() bool {
+	return fp.NewPath == "/dev/null"
+}
+
+// ParseUnifiedDiff parses a (possibly multi-file) unified diff
+func ParseUnifiedDiff(diff string) ([]FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	var patches []FilePatch
+	var current *FilePatch
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, FilePatch{
+				OldPath: parseDiffPath(line[4:]),
+				NewPath: parseDiffPath(lines[i+1][4:]),
+			})
+			current = &patches[len(patches)-1]
+			i++
+
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk header before file header", i+1)
+			}
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+
+			// Consume hunk body until both sides are satisfied
+			oldSeen, newSeen := 0, 0
+			for i+1 < len(lines) && (oldSeen < hunk.OldLines || newSeen < hunk.NewLines) {
+				body := lines[i+1]
+				if strings.HasPrefix(body, `\`) {
+					// "\ No newline at end of file"
+					i++
+					continue
+				}
+				if body == "" {
+					// Some tools strip the trailing space from empty context lines
+					body = " "
+				}
+				switch body[0] {
+				case ' ':
+					oldSeen++
+					newSeen++
+				case '-':
+					oldSeen++
+				case '+':
+					newSeen++
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk: %q", i+2, body)
+				}
+				hunk.Lines = append(hunk.Lines, body)
+				i++
+			}
+			if oldSeen != hunk.OldLines || newSeen != hunk.NewLines {
+				return nil, fmt.Errorf("hunk at line %d is truncated (expected -%d +%d, got -%d +%d)",
+					hunk.OldStart, hunk.OldLines, hunk.NewLines, oldSeen, newSeen)
+			}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file headers found in patch")
+	}
+	return patches, nil
+}
+
+// parseDiffPath strips timestamps and the conventional a/ and b/ prefixes
+func parseDiffPath(raw string) string {
+	path := raw
+	if tab := strings.Index(path, "\t"); tab >= 0 {
+		path = path[:tab]
+	}
+	path = strings.TrimSpace(path)
+	if path == "/dev/null" {
+		return path
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		path = path[2:]
+	}
+	return path
+}
+
+// parseHunkHeader parses "@@ -l,s +l,s @@"
+func parseHunkHeader(header string) (Hunk, error) {
+	var hunk Hunk
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return hunk, fmt.Errorf("malformed hunk header: %q", header)
+	}
+
+	var err error
+	if hunk.OldStart, hunk.OldLines, err = parseHunkRange(fields[1][1:]); err != nil {
+		return hunk, err
+	}
+	if hunk.NewStart, hunk.NewLines, err = parseHunkRange(fields[2][1:]); err != nil {
+		return hunk, err
+	}
+	return hunk, nil
+}
+
+func parseHunkRange(r string) (start, count int, err error) {
+	count = 1
+	parts := strings.SplitN(r, ",", 2)
+	if start, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk range %q", r)
+	}
+	if len(parts) == 2 {
+		if count, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid hunk range %q", r)
+		}
+	}
+	return start, count, nil
+}
+
+// ApplyPatch validates every file patch against the current file contents
+// and writes all of them, or none of them if any hunk fails. Several
+// sections for the same path apply in order on top of each other.
+func ApplyPatch(patches []FilePatch, dryRun bool) (*PatchResult, error) {
+	result := &PatchResult{DryRun: dryRun}
+	failed := false
+	byPath := make(map[string]int)
+
+	for _, fp := range patches {
+		var fileResult FilePatchResult
+		idx, seen := byPath[fp.Path()]
+		if seen {
+			fileResult = applyFilePatchTo(result.Files[idx], fp)
+			result.Files[idx] = fileResult
+		} else {
+			fileResult = applyFilePatch(fp)
+			byPath[fp.Path()] = len(result.Files)
+			result.Files = append(result.Files, fileResult)
+		}
+		for _, h := range fileResult.Hunks {
+			if !h.Applied {
+				failed = true
+			}
+		}
+	}
+
+	if failed || dryRun {
+		return result, nil
+	}
+
+	if err := commitPatchResults(result.Files); err != nil {
+		return result, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// applyFilePatch computes the new content of one file in memory
+func applyFilePatch(fp FilePatch) FilePatchResult {
+	path := fp.Path()
+	res := FilePatchResult{Path: path, Action: "modify"}
+
+	content, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		res.existed = true
+		res.oldContent = string(content)
+	case os.IsNotExist(err) && fp.IsCreate():
+	default:
+		res.Hunks = []HunkResult{{Index: 1, Error: fmt.Sprintf("failed to read file: %v", err)}}
+		return res
+	}
+
+	if fp.IsCreate() {
+		res.Action = "create"
+		if res.existed {
+			res.Hunks = []HunkResult{{Index: 1, Error: "file already exists"}}
+			return res
+		}
+	} else if fp.IsDelete() {
+		res.Action = "delete"
+	}
+
+	if len(fp.Edits) > 0 {
+		res.newContent, res.Hunks = applyEdits(res.oldContent, fp.Edits)
+	} else {
+		res.newContent, res.Hunks = applyHunks(res.oldContent, fp.Hunks)
+	}
+	res.Diff = renderFileDiff(fp)
+	return res
+}
+
+// applyFilePatchTo applies a further section for an already patched file
+// on top of the content produced by the earlier sections
+func applyFilePatchTo(prev FilePatchResult, fp FilePatch) FilePatchResult {
+	res := prev
+	if prev.Action == "delete" {
+		res.Hunks = append(res.Hunks, HunkResult{Index: len(prev.Hunks) + 1, Error: "file is deleted by an earlier section"})
+		return res
+	}
+	if fp.IsCreate() {
+		res.Hunks = append(res.Hunks, HunkResult{Index: len(prev.Hunks) + 1, Error: "file already exists"})
+		return res
+	}
+	if fp.IsDelete() {
+		res.Action = "delete"
+	}
+
+	var hunks []HunkResult
+	if len(fp.Edits) > 0 {
+		res.newContent, hunks = applyEdits(prev.newContent, fp.Edits)
+	} else {
+		res.newContent, hunks = applyHunks(prev.newContent, fp.Hunks)
+	}
+	res.Hunks = append([]HunkResult{}, prev.Hunks...)
+	for _, h := range hunks {
+		h.Index += len(prev.Hunks)
+		res.Hunks = append(res.Hunks, h)
+	}
+	res.Diff = prev.Diff + renderFileDiff(fp)
+	return res
+}
+
+// applyHunks applies unified diff hunks to content, tolerating line offsets
+// and up to maxPatchFuzz lines of mismatched context
+func applyHunks(content string, hunks []Hunk) (string, []HunkResult) {
+	var lines []string
+	if content != "" {
+		lines = strings.Split(content, "\n")
+	}
+	trailingNewline := len(lines) > 0 && lines[len(lines)-1] == ""
+	if trailingNewline {
+		lines = lines[:len(lines)-1]
+	}
+
+	results := make([]HunkResult, len(hunks))
+	offset := 0 // Cumulative line shift from earlier hunks
+
+	for i, hunk := range hunks {
+		hr := HunkResult{Index: i + 1, OldStart: hunk.OldStart}
+
+		oldSide, newSide := splitHunk(hunk.Lines)
+		// A zero-length old range (diff -U0) names the line to insert after
+		expected := max(0, hunk.OldStart-1+offset)
+		if hunk.OldLines == 0 {
+			expected = max(0, hunk.OldStart+offset)
+		}
+		pos, fuzz, ok := locateHunk(lines, hunk, oldSide, expected)
+		if !ok {
+			hr.Error = "context does not match current file contents"
+			results[i] = hr
+			continue
+		}
+
+		// Drop the fuzzed context from both sides so it is left untouched
+		lead, trail := fuzzTrim(hunk.Lines, fuzz)
+		oldSide = oldSide[lead : len(oldSide)-trail]
+		newSide = newSide[lead : len(newSide)-trail]
+
+		updated := make([]string, 0, len(lines)-len(oldSide)+len(newSide))
+		updated = append(updated, lines[:pos]...)
+		updated = append(updated, newSide...)
+		updated = append(updated, lines[pos+len(oldSide):]...)
+		lines = updated
+
+		hr.Applied = true
+		hr.AppliedAt = pos - lead + 1
+		hr.Offset = pos - lead - expected
+		hr.Fuzz = fuzz
+		offset += hr.Offset + len(newSide) - len(oldSide)
+		results[i] = hr
+	}
+
+	if len(lines) == 0 {
+		return "", results
+	}
+	out := strings.Join(lines, "\n")
+	if trailingNewline || content == "" {
+		out += "\n"
+	}
+	return out, results
+}
+
+// splitHunk returns the old-side and new-side lines of a hunk without prefixes
+func splitHunk(hunkLines []string) (oldSide, newSide []string) {
+	for _, l := range hunkLines {
+		switch l[0] {
+		case ' ':
+			oldSide = append(oldSide, l[1:])
+			newSide = append(newSide, l[1:])
+		case '-':
+			oldSide = append(oldSide, l[1:])
+		case '+':
+			newSide = append(newSide, l[1:])
+		}
+	}
+	return oldSide, newSide
+}
+
+// fuzzTrim returns how many leading and trailing context lines to ignore
+// for a given fuzz factor (never trimming past the first/last change)
+func fuzzTrim(hunkLines []string, fuzz int) (lead, trail int) {
+	for lead < fuzz && lead < len(hunkLines) && hunkLines[lead][0] == ' ' {
+		lead++
+	}
+	for trail < fuzz && trail < len(hunkLines)-lead && hunkLines[len(hunkLines)-1-trail][0] == ' ' {
+		trail++
+	}
+	return lead, trail
+}
+
+// locateHunk finds where the old side of a hunk matches, searching outward
+// from the expected position, first exactly and then with increasing fuzz
+func locateHunk(lines []string, hunk Hunk, oldSide []string, expected int) (pos, fuzz int, ok bool) {
+	for fuzz = 0; fuzz <= maxPatchFuzz; fuzz++ {
+		lead, trail := fuzzTrim(hunk.Lines, fuzz)
+		if fuzz > 0 && lead+trail == 0 {
+			break // Nothing left to fuzz
+		}
+		want := oldSide[lead : len(oldSide)-trail]
+		start := expected + lead
+		if len(want) == 0 {
+			// Pure insertion (e.g. into an empty file)
+			return clamp(start, 0, len(lines)), fuzz, true
+		}
+		for delta := 0; delta <= len(lines); delta++ {
+			for _, candidate := range []int{start + delta, start - delta} {
+				if candidate < 0 || candidate+len(want) > len(lines) {
+					continue
+				}
+				if linesMatch(lines[candidate:candidate+len(want)], want) {
+					return candidate, fuzz, true
+				}
+				if delta == 0 {
+					break
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func linesMatch(have, want []string) bool {
+	for i := range want {
+		if strings.TrimRight(have[i], " \t") != strings.TrimRight(want[i], " \t") {
+			return false
+		}
+	}
+	return true
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// applyEdits applies find/replace edits from the simple envelope
+func applyEdits(content string, edits []FindReplaceEdit) (string, []HunkResult) {
+	results := make([]HunkResult, len(edits))
+	for i, edit := range edits {
+		hr := HunkResult{Index: i + 1}
+		count := strings.Count(content, edit.Find)
+		switch {
+		case edit.Find == "":
+			hr.Error = "find text is empty"
+		case count == 0:
+			hr.Error = "find text not found"
+		case count > 1 && !edit.AllOccurrences:
+			hr.Error = fmt.Sprintf("find text is ambiguous (%d occurrences); add context or set all_occurrences", count)
+		default:
+			hr.AppliedAt = strings.Count(content[:strings.Index(content, edit.Find)], "\n") + 1
+			content = strings.ReplaceAll(content, edit.Find, edit.Replace)
+			hr.Applied = true
+		}
+		results[i] = hr
+	}
+	return content, results
+}
+
+// renderFileDiff renders the hunks (or edits) of one file for display
+func renderFileDiff(fp FilePatch) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fp.OldPath, fp.NewPath)
+	for _, h := range fp.Hunks {
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+		for _, l := range h.Lines {
+			b.WriteString(l)
+			b.WriteString("\n")
+		}
+	}
+	for _, e := range fp.Edits {
+		for _, l := range strings.Split(e.Find, "\n") {
+			b.WriteString("-" + l + "\n")
+		}
+		for _, l := range strings.Split(e.Replace, "\n") {
+			b.WriteString("+" + l + "\n")
+		}
+	}
+	return b.String()
+}
+
+// commitPatchResults writes all files via temp file + rename. If any step
+// fails, files that were already replaced are restored.
+func commitPatchResults(files []FilePatchResult) error {
+	type staged struct {
+		res     FilePatchResult
+		tmpPath string
+	}
+	var pending []staged
+
+	cleanup := func() {
+		for _, s := range pending {
+			if s.tmpPath != "" {
+				os.Remove(s.tmpPath)
+			}
+		}
+	}
+
+	// Stage every new file next to its target first
+	for _, res := range files {
+		if res.Action == "delete" {
+			pending = append(pending, staged{res: res})
+			continue
+		}
+		dir := filepath.Dir(res.Path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+		tmp, err := os.CreateTemp(dir, "."+filepath.Base(res.Path)+".patch-*")
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to stage %s: %w", res.Path, err)
+		}
+		_, werr := tmp.WriteString(res.newContent)
+		cerr := tmp.Close()
+		pending = append(pending, staged{res: res, tmpPath: tmp.Name()})
+		if werr != nil || cerr != nil {
+			cleanup()
+			return fmt.Errorf("failed to stage %s", res.Path)
+		}
+		if info, err := os.Stat(res.Path); err == nil {
+			os.Chmod(tmp.Name(), info.Mode().Perm())
+		} else {
+			os.Chmod(tmp.Name(), 0644)
+		}
+	}
+
+	// Swap them into place, rolling back on failure
+	for i, s := range pending {
+		var err error
+		if s.res.Action == "delete" {
+			err = os.Remove(s.res.Path)
+		} else {
+			err = os.Rename(s.tmpPath, s.res.Path)
+		}
+		if err != nil {
+			for _, done := range pending[:i] {
+				if done.res.existed {
+					os.WriteFile(done.res.Path, []byte(done.res.oldContent), 0644)
+				} else {
+					os.Remove(done.res.Path)
+				}
+			}
+			cleanup()
+			return fmt.Errorf("failed to write %s (all changes rolled back): %w", s.res.Path, err)
+		}
+		pending[i].tmpPath = ""
+	}
+	return nil
+}
+
+// FormatPatchResult renders a per-hunk report for the model
+func FormatPatchResult(result *PatchResult) string {
+	var b strings.Builder
+	switch {
+	case result.Applied:
+		fmt.Fprintf(&b, "Patch applied to %d file(s)\n", len(result.Files))
+	case result.DryRun && patchResultOK(result):
+		fmt.Fprintf(&b, "Dry run: patch applies cleanly to %d file(s), nothing written\n", len(result.Files))
+	default:
+		b.WriteString("Patch NOT applied: one or more hunks failed, no files were modified\n")
+	}
+
+	for _, f := range result.Files {
+		fmt.Fprintf(&b, "\n%s (%s)\n", f.Path, f.Action)
+		for _, h := range f.Hunks {
+			if !h.Applied {
+				fmt.Fprintf(&b, "  hunk %d: FAILED - %s\n", h.Index, h.Error)
+				continue
+			}
+			note := ""
+			if h.Offset != 0 {
+				note += fmt.Sprintf(", offset %d", h.Offset)
+			}
+			if h.Fuzz != 0 {
+				note += fmt.Sprintf(", fuzz %d", h.Fuzz)
+			}
+			fmt.Fprintf(&b, "  hunk %d: applied at line %d%s\n", h.Index, h.AppliedAt, note)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func patchResultOK(result *PatchResult) bool {
+	for _, f := range result.Files {
+		for _, h := range f.Hunks {
+			if !h.Applied {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseEditEnvelope converts the tool's "edits" argument into file patches,
+// grouping edits to the same file so they apply in order
+func parseEditEnvelope(raw []interface{}) ([]FilePatch, error) {
+	var patches []FilePatch
+	index := make(map[string]int)
+
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("edit %d: expected an object", i+1)
+		}
+		path, _ := m["file_path"].(string)
+		find, _ := m["find"].(string)
+		replace, ok := m["replace"].(string)
+		if path == "" || find == "" || !ok {
+			return nil, fmt.Errorf("edit %d: file_path, find and replace are required", i+1)
+		}
+		all, _ := m["all_occurrences"].(bool)
+
+		edit := FindReplaceEdit{FilePath: path, Find: find, Replace: replace, AllOccurrences: all}
+		if idx, exists := index[path]; exists {
+			patches[idx].Edits = append(patches[idx].Edits, edit)
+			continue
+		}
+		index[path] = len(patches)
+		patches = append(patches, FilePatch{OldPath: path, NewPath: path, Edits: []FindReplaceEdit{edit}})
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no edits provided")
+	}
+	return patches, nil
+}
+
+// parsePatchInput builds file patches from the apply_patch tool arguments
+func parsePatchInput(input map[string]interface{}) ([]FilePatch, error) {
+	if diff, ok := input["patch"].(string); ok && strings.TrimSpace(diff) != "" {
+		return ParseUnifiedDiff(diff)
+	}
+	if edits, ok := input["edits"].([]interface{}); ok {
+		return parseEditEnvelope(edits)
+	}
+	return nil, fmt.Errorf("either patch or edits must be provided")
+}
+
+// patchFileOperations describes an apply_patch call as one FileOperation
+// per touched file so the GUI can show the full change set
+func patchFileOperations(toolUse ToolUse, timestamp time.Time) []FileOperation {
+	patches, err := parsePatchInput(toolUse.Input)
+	if err != nil {
+		return nil
+	}
+
+	ops := make([]FileOperation, 0, len(patches))
+	for i, fp := range patches {
+		diff := renderFileDiff(fp)
+		ops = append(ops, FileOperation{
+			ID:        fmt.Sprintf("%s_%d", toolUse.ID, i),
+			Type:      "patch",
+			FilePath:  fp.Path(),
+			Changes:   diff,
+			Timestamp: timestamp,
+			Size:      len(diff),
+		})
+	}
+	return ops
+}
@@ -0,0 +1,275 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	diff := `diff --git a/one.txt b/one.txt
+--- a/one.txt	2024-01-01 00:00:00
++++ b/one.txt	2024-01-01 00:00:00
+@@ -1,3 +1,3 @@
+ alpha
+-beta
++BETA
+ gamma
+--- /dev/null
++++ b/two.txt
+@@ -0,0 +1,2 @@
++new
++file
+`
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("Expected 2 file patches, got %d", len(patches))
+	}
+
+	if patches[0].Path() != "one.txt" {
+		t.Errorf("Expected path 'one.txt', got '%s'", patches[0].Path())
+	}
+
+	if len(patches[0].Hunks) != 1 || len(patches[0].Hunks[0].Lines) != 4 {
+		t.Errorf("Expected 1 hunk with 4 lines, got %+v", patches[0].Hunks)
+	}
+
+	if !patches[1].IsCreate() || patches[1].Path() != "two.txt" {
+		t.Errorf("Expected creation of two.txt, got %+v", patches[1])
+	}
+}
+
+func TestParseUnifiedDiffTruncated(t *testing.T) {
+	diff := "--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n a\n-b\n"
+	if _, err := ParseUnifiedDiff(diff); err == nil {
+		t.Error("Expected error for truncated hunk")
+	}
+}
+
+func TestApplyPatchMultiFile(t *testing.T) {
+	tempDir := t.TempDir()
+	one := filepath.Join(tempDir, "one.txt")
+	two := filepath.Join(tempDir, "two.txt")
+	gone := filepath.Join(tempDir, "gone.txt")
+	writeTestFile(t, one, "alpha\nbeta\ngamma\n")
+	writeTestFile(t, gone, "bye\n")
+
+	diff := "--- " + one + "\n+++ " + one + "\n@@ -1,3 +1,3 @@\n alpha\n-beta\n+BETA\n gamma\n" +
+		"--- /dev/null\n+++ " + two + "\n@@ -0,0 +1,2 @@\n+new\n+file\n" +
+		"--- " + gone + "\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n"
+
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	result, err := ApplyPatch(patches, false)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	if !result.Applied {
+		t.Fatalf("Expected patch to be applied:\n%s", FormatPatchResult(result))
+	}
+
+	if got := readTestFile(t, one); got != "alpha\nBETA\ngamma\n" {
+		t.Errorf("Unexpected content of one.txt: %q", got)
+	}
+
+	if got := readTestFile(t, two); got != "new\nfile\n" {
+		t.Errorf("Unexpected content of two.txt: %q", got)
+	}
+
+	if _, err := os.Stat(gone); !os.IsNotExist(err) {
+		t.Error("Expected gone.txt to be deleted")
+	}
+}
+
+func TestApplyPatchOffsetAndFuzz(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "file.txt")
+	// Two extra lines at the top shift the hunk, and the last context line differs
+	writeTestFile(t, path, "x\ny\na\nb\nc\nd\nCHANGED\n")
+
+	diff := "--- " + path + "\n+++ " + path + "\n@@ -1,5 +1,5 @@\n a\n b\n-c\n+C\n d\n e\n"
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	result, err := ApplyPatch(patches, false)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	if !result.Applied {
+		t.Fatalf("Expected patch to be applied:\n%s", FormatPatchResult(result))
+	}
+
+	hunk := result.Files[0].Hunks[0]
+	if hunk.Offset != 2 {
+		t.Errorf("Expected offset 2, got %d", hunk.Offset)
+	}
+
+	if hunk.Fuzz != 1 {
+		t.Errorf("Expected fuzz 1, got %d", hunk.Fuzz)
+	}
+
+	if got := readTestFile(t, path); got != "x\ny\na\nb\nC\nd\nCHANGED\n" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+}
+
+func TestApplyPatchAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	good := filepath.Join(tempDir, "good.txt")
+	bad := filepath.Join(tempDir, "bad.txt")
+	writeTestFile(t, good, "one\ntwo\n")
+	writeTestFile(t, bad, "something else entirely\n")
+
+	diff := "--- " + good + "\n+++ " + good + "\n@@ -1,2 +1,2 @@\n one\n-two\n+TWO\n" +
+		"--- " + bad + "\n+++ " + bad + "\n@@ -1,2 +1,2 @@\n foo\n-bar\n+BAR\n"
+
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	result, err := ApplyPatch(patches, false)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	if result.Applied {
+		t.Error("Expected patch to be rejected")
+	}
+
+	if result.Files[0].Hunks[0].Applied != true || result.Files[1].Hunks[0].Applied != false {
+		t.Errorf("Unexpected per-hunk results: %+v", result.Files)
+	}
+
+	if got := readTestFile(t, good); got != "one\ntwo\n" {
+		t.Errorf("Expected good.txt to be untouched, got %q", got)
+	}
+
+	if !strings.Contains(FormatPatchResult(result), "NOT applied") {
+		t.Error("Expected report to say the patch was not applied")
+	}
+}
+
+func TestApplyPatchEditEnvelope(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "file.go")
+	writeTestFile(t, path, "func a() {}\nfunc b() {}\n")
+
+	patches, err := parsePatchInput(map[string]interface{}{
+		"edits": []interface{}{
+			map[string]interface{}{"file_path": path, "find": "func a()", "replace": "func A()"},
+			map[string]interface{}{"file_path": path, "find": "func b()", "replace": "func B()"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("parsePatchInput failed: %v", err)
+	}
+
+	if len(patches) != 1 || len(patches[0].Edits) != 2 {
+		t.Fatalf("Expected edits grouped into 1 file patch, got %+v", patches)
+	}
+
+	// Dry run must not write anything
+	result, err := ApplyPatch(patches, true)
+	if err != nil {
+		t.Fatalf("ApplyPatch dry run failed: %v", err)
+	}
+
+	if result.Applied || readTestFile(t, path) != "func a() {}\nfunc b() {}\n" {
+		t.Error("Dry run should not modify files")
+	}
+
+	result, err = ApplyPatch(patches, false)
+	if err != nil || !result.Applied {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	if got := readTestFile(t, path); got != "func A() {}\nfunc B() {}\n" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+}
+
+func TestApplyPatchRepeatedPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	writeTestFile(t, path, "a\nb\nc\nd\ne\nf\n")
+
+	diff := "--- " + path + "\n+++ " + path + "\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n" +
+		"--- " + path + "\n+++ " + path + "\n@@ -5,2 +5,2 @@\n e\n-f\n+F\n"
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	result, err := ApplyPatch(patches, false)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if !result.Applied {
+		t.Fatalf("Expected patch to be applied:\n%s", FormatPatchResult(result))
+	}
+	if len(result.Files) != 1 || len(result.Files[0].Hunks) != 2 {
+		t.Fatalf("Expected one file with 2 hunks, got %+v", result.Files)
+	}
+	if result.Files[0].Hunks[1].Index != 2 {
+		t.Errorf("Expected second hunk to be numbered 2, got %d", result.Files[0].Hunks[1].Index)
+	}
+	if got := readTestFile(t, path); got != "A\nb\nc\nd\ne\nF\n" {
+		t.Errorf("Expected both sections to be applied, got %q", got)
+	}
+}
+
+func TestApplyPatchZeroContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	writeTestFile(t, path, "a\nb\nc\n")
+
+	// As produced by diff -U0: insert X after line 2, replace c
+	diff := "--- " + path + "\n+++ " + path + "\n@@ -2,0 +3 @@\n+X\n@@ -3 +4 @@\n-c\n+C\n"
+	patches, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	result, err := ApplyPatch(patches, false)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if !result.Applied {
+		t.Fatalf("Expected patch to be applied:\n%s", FormatPatchResult(result))
+	}
+	if got := readTestFile(t, path); got != "a\nb\nX\nC\n" {
+		t.Errorf("Expected 'a\\nb\\nX\\nC\\n', got %q", got)
+	}
+	for _, h := range result.Files[0].Hunks {
+		if h.Offset != 0 {
+			t.Errorf("Expected hunk %d at its stated position, got offset %d", h.Index, h.Offset)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReconcileContextEntry asks Claude to merge two versions of a memory or
+// knowledge entry from branches being merged. It has the signature of a
+// context.Reconciler, for the ask-model merge strategy.
+func (c *ClaudeClient) ReconcileContextEntry(kind, key, ours, theirs string) (string, error) {
+	systemPrompt := `You merge notes that an AI coding assistant kept on two Git branches which are now being merged.
+Combine both versions of the entry into one that keeps every fact that is still true, drops duplicates,
+and where the versions contradict each other prefers the more specific or more recent-sounding one.
+Reply with the merged entry only, without commentary or code fences.`
+
+	userPrompt := fmt.Sprintf(`Entry: %s %q
+
+Version on the branch being merged into:
+%s
+
+Version on the branch being merged:
+%s`, kind, key, ours, theirs)
+
+	response, err := c.makeAPICall(systemPrompt, userPrompt)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Content) == 0 {
+		return "", fmt.Errorf("empty response")
+	}
+
+	merged := strings.TrimSpace(response.Content[0].Text)
+	if merged == "" {
+		return "", fmt.Errorf("empty response")
+	}
+	return merged, nil
+}
@@ -0,0 +1,37 @@
+package ai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReconcileContextEntry(t *testing.T) {
+	var request ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"content": [{"type": "text", "text": "  use sqlite, with WAL mode\n"}]}`))
+	}))
+	defer server.Close()
+
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model"}
+	merged, err := client.ReconcileContextEntry("knowledge", "database", "use sqlite", "enable WAL mode")
+	if err != nil {
+		t.Fatalf("ReconcileContextEntry failed: %v", err)
+	}
+	if merged != "use sqlite, with WAL mode" {
+		t.Errorf("Expected the trimmed merged value, got %q", merged)
+	}
+
+	if len(request.Messages) != 1 {
+		t.Fatalf("Expected one message, got %d", len(request.Messages))
+	}
+	prompt, _ := request.Messages[0].Content.(string)
+	for _, want := range []string{"database", "use sqlite", "enable WAL mode"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected the prompt to contain %q, got %q", want, prompt)
+		}
+	}
+}
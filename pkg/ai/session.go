@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"sync"
+
+	"stackagent/pkg/shell"
+)
+
+// EventSink receives the events of one agent run: function calls, shell
+// commands, file operations and consults
+type EventSink func(eventType string, data interface{})
+
+// RunEvents are where one agent run reports; either sink may be nil
+type RunEvents struct {
+	Events EventSink // Streaming events, as SetStreamingCallback gets them
+	Debug  EventSink // Function call debugging, as SetDebugCallback gets it
+}
+
+// tokenCalibration is the estimate scale a client shares with its sessions
+// and runs, so every response calibrates all of them
+type tokenCalibration struct {
+	mutex sync.Mutex
+	scale float64 // 0 until the first response
+}
+
+// NewSession returns a client for one conversation. It shares the client's
+// connection, persistent context and token calibration, and has its own
+// shell handles, so conversations don't see each other's commands. Runs of
+// different sessions may overlap; runs of one session must not.
+func (c *ClaudeClient) NewSession() *ClaudeClient {
+	session := c.derive()
+	session.shellManager = shell.NewShellManager()
+	return session
+}
+
+// ShellManager returns the client's shell manager, whose handles hold the
+// output of the commands the client ran
+func (c *ClaudeClient) ShellManager() *shell.ShellManager {
+	return c.shellManager
+}
+
+// Run runs one agent turn over messages within budget. Its events go to
+// the run's own sinks, not the client's callbacks, so concurrent runs never
+// see each other's events. What the turn's consults cost is added to the
+// client's consult cost.
+func (c *ClaudeClient) Run(messages []ConversationMessage, budget ContextBudget, events RunEvents) (string, TokenCost, OperationSummary, error) {
+	run := c.derive()
+	run.streamingCallback = events.Events
+	run.debugCallback = events.Debug
+
+	response, cost, summary, err := run.ChatWithBudget(messages, budget)
+	c.consultMutex.Lock()
+	addTokenCost(&c.consultCost, run.ConsultCost())
+	c.consultMutex.Unlock()
+	return response, cost, summary, err
+}
+
+// derive copies the client's settings into a new client without callbacks
+// that uses the same shell handles and token calibration
+func (c *ClaudeClient) derive() *ClaudeClient {
+	return &ClaudeClient{
+		apiKey:         c.apiKey,
+		baseURL:        c.baseURL,
+		httpClient:     c.httpClient,
+		model:          c.model,
+		debugFile:      c.debugFile,
+		debugEnabled:   c.debugEnabled,
+		shellManager:   c.shellManager,
+		contextManager: c.contextManager,
+		snapshots:      c.snapshots,
+		readOnly:       c.readOnly,
+		basePrompt:     c.basePrompt,
+		budget:         c.budget,
+		calibration:    c.sharedCalibration(),
+		settings:       c.settings,
+	}
+}
+
+// sharedCalibration returns the client's token calibration, creating it
+// on first use
+func (c *ClaudeClient) sharedCalibration() *tokenCalibration {
+	c.usageMutex.Lock()
+	defer c.usageMutex.Unlock()
+	if c.calibration == nil {
+		c.calibration = &tokenCalibration{}
+	}
+	return c.calibration
+}
@@ -0,0 +1,154 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Settings are what a client's agent runs with. Sessions share their
+// client's settings, so ApplySettings reaches every conversation from its
+// next turn.
+type Settings struct {
+	Model         string   `json:"model"`
+	MaxTokens     int      `json:"maxTokens"`               // Per response
+	MaxRounds     int      `json:"maxRounds"`               // Tool rounds per turn
+	Tools         []string `json:"tools,omitempty"`         // Enabled tools; all if empty
+	DisabledTools []string `json:"disabledTools,omitempty"` // Applied after Tools
+}
+
+// DefaultSettings are the settings of a new client
+func DefaultSettings() Settings {
+	return Settings{
+		Model:     "claude-sonnet-4-20250514", // Latest Claude Sonnet 4
+		MaxTokens: 4000,
+		MaxRounds: 10,
+	}
+}
+
+// Limits Validate checks settings against
+const (
+	MaxResponseTokens = 128_000
+	MaxToolRounds     = 100
+)
+
+// ToolNames returns the names of all tools the agent may be given, sorted
+func ToolNames() []string {
+	tools := append((&ClaudeClient{}).getAvailableTools(), memoryTools()...)
+	seen := make(map[string]bool, len(tools))
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		if !seen[tool.Name] {
+			seen[tool.Name] = true
+			names = append(names, tool.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate reports the first problem with settings
+func (s Settings) Validate() error {
+	if s.Model == "" || strings.ContainsAny(s.Model, " \t\n") {
+		return fmt.Errorf("invalid model %q", s.Model)
+	}
+	if s.MaxTokens < 1 || s.MaxTokens > MaxResponseTokens {
+		return fmt.Errorf("max tokens must be between 1 and %d, got %d", MaxResponseTokens, s.MaxTokens)
+	}
+	if s.MaxRounds < 1 || s.MaxRounds > MaxToolRounds {
+		return fmt.Errorf("max rounds must be between 1 and %d, got %d", MaxToolRounds, s.MaxRounds)
+	}
+
+	known := ToolNames()
+	enabled := 0
+	for _, name := range append(append([]string{}, s.Tools...), s.DisabledTools...) {
+		if !containsString(known, name) {
+			return fmt.Errorf("unknown tool %q (known: %s)", name, strings.Join(known, ", "))
+		}
+	}
+	for _, name := range known {
+		if s.allows(name) {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		return fmt.Errorf("no tools left enabled")
+	}
+	return nil
+}
+
+// allows reports whether the settings enable a tool
+func (s Settings) allows(name string) bool {
+	if len(s.Tools) > 0 && !containsString(s.Tools, name) {
+		return false
+	}
+	return !containsString(s.DisabledTools, name)
+}
+
+// withDefaults fills in unset limits, for clients made without settings
+func (s Settings) withDefaults() Settings {
+	defaults := DefaultSettings()
+	if s.MaxTokens == 0 {
+		s.MaxTokens = defaults.MaxTokens
+	}
+	if s.MaxRounds == 0 {
+		s.MaxRounds = defaults.MaxRounds
+	}
+	return s
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedSettings are the settings a client shares with its sessions and
+// runs
+type sharedSettings struct {
+	mutex    sync.Mutex
+	settings Settings
+}
+
+// ApplySettings validates settings and makes them the client's and its
+// sessions'. Turns already running keep the settings they started with.
+func (c *ClaudeClient) ApplySettings(settings Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	settings.Tools = append([]string(nil), settings.Tools...)
+	settings.DisabledTools = append([]string(nil), settings.DisabledTools...)
+	if c.settings == nil {
+		c.settings = &sharedSettings{}
+	}
+	c.settings.mutex.Lock()
+	c.settings.settings = settings
+	c.settings.mutex.Unlock()
+	return nil
+}
+
+// Settings returns the settings the client's next turn runs with
+func (c *ClaudeClient) Settings() Settings {
+	if c.settings == nil {
+		return Settings{Model: c.model}.withDefaults()
+	}
+	c.settings.mutex.Lock()
+	defer c.settings.mutex.Unlock()
+	return c.settings.settings
+}
+
+// enabledToolsOf keeps the tools of tools the settings enable
+func (c *ClaudeClient) enabledToolsOf(tools []Tool) []Tool {
+	settings := c.Settings()
+	enabled := tools[:0]
+	for _, tool := range tools {
+		if settings.allows(tool.Name) {
+			enabled = append(enabled, tool)
+		}
+	}
+	return enabled
+}
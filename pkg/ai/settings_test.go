@@ -0,0 +1,86 @@
+package ai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"stackagent/pkg/shell"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	if err := DefaultSettings().Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+
+	tests := map[string]func(s *Settings){
+		"no model":         func(s *Settings) { s.Model = "" },
+		"model with space": func(s *Settings) { s.Model = "claude sonnet" },
+		"no tokens":        func(s *Settings) { s.MaxTokens = 0 },
+		"too many rounds":  func(s *Settings) { s.MaxRounds = MaxToolRounds + 1 },
+		"unknown tool":     func(s *Settings) { s.Tools = []string{"read_file", "rm_rf"} },
+		"unknown disabled": func(s *Settings) { s.DisabledTools = []string{"nope"} },
+		"nothing enabled":  func(s *Settings) { s.Tools, s.DisabledTools = []string{"read_file"}, []string{"read_file"} },
+	}
+	for name, change := range tests {
+		settings := DefaultSettings()
+		change(&settings)
+		if err := settings.Validate(); err == nil {
+			t.Errorf("Expected %s to be invalid", name)
+		}
+	}
+}
+
+func TestApplySettingsReachesSessions(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		mutex.Lock()
+		requests = append(requests, request)
+		mutex.Unlock()
+		w.Write([]byte(`{"content": [{"type": "text", "text": "ok"}], "usage": {"input_tokens": 10, "output_tokens": 1}}`))
+	}))
+	defer server.Close()
+
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), shellManager: shell.NewShellManager(), settings: &sharedSettings{settings: DefaultSettings()}}
+	session := client.NewSession()
+	settings := Settings{Model: "test-model", MaxTokens: 512, MaxRounds: 3, DisabledTools: []string{"write_file", "run_with_capture"}}
+	if err := client.ApplySettings(settings); err != nil {
+		t.Fatalf("ApplySettings failed: %v", err)
+	}
+	if _, _, _, err := session.Run([]ConversationMessage{{Role: "user", Content: "hi"}}, ContextBudget{}, RunEvents{}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	request := requests[len(requests)-1]
+	if request.Model != "test-model" || request.MaxTokens != 512 {
+		t.Errorf("Expected the new model and limit, got %s and %d", request.Model, request.MaxTokens)
+	}
+	for _, tool := range request.Tools {
+		if tool.Name == "write_file" || tool.Name == "run_with_capture" {
+			t.Errorf("Expected %s to be disabled", tool.Name)
+		}
+	}
+	if len(request.Tools) == 0 {
+		t.Error("Expected the other tools to stay")
+	}
+
+	_, err := session.ExecuteFunction(ToolUse{ID: "1", Name: "write_file", Input: map[string]interface{}{"file_path": t.TempDir() + "/x", "content": "x"}})
+	if err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected a disabled tool to be refused, got %v", err)
+	}
+
+	if err := client.ApplySettings(Settings{Model: "test-model"}); err == nil {
+		t.Error("Expected invalid settings to be refused")
+	}
+	if client.Settings().MaxTokens != 512 {
+		t.Error("Expected refused settings to leave the old ones")
+	}
+}
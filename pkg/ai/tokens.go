@@ -0,0 +1,236 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokens a message costs beyond its content (role and separators)
+const messageOverheadTokens = 4
+
+// EstimateTokens estimates how many tokens text takes, locally. Estimates
+// are calibrated against the usage the API reports for earlier requests.
+func (c *ClaudeClient) EstimateTokens(text string) int {
+	return c.scaleTokens(estimateTextTokens(text))
+}
+
+// estimateTextTokens estimates Claude's token count of text. Words,
+// numbers, punctuation and whitespace are counted separately, which tracks
+// source code and JSON much better than a fixed characters-per-token ratio:
+//
+//	words          one token per camelCase part of up to 8 letters
+//	numbers        one token per 3 digits
+//	punctuation    one token per 2 characters
+//	whitespace     a single space is part of the next word; other runs
+//	               are one token plus one per 4 further characters
+//	other runes    one token per 2 bytes of UTF-8, rounded down
+func estimateTextTokens(text string) int {
+	tokens := 0
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case isWordRune(r):
+			part := 1
+			for j < len(runes) && isWordRune(runes[j]) {
+				if unicode.IsUpper(runes[j]) && unicode.IsLower(runes[j-1]) {
+					tokens += (part + 7) / 8
+					part = 0
+				}
+				part++
+				j++
+			}
+			tokens += (part + 7) / 8
+		case r >= '0' && r <= '9':
+			for j < len(runes) && runes[j] >= '0' && runes[j] <= '9' {
+				j++
+			}
+			tokens += (j - i + 2) / 3
+		case unicode.IsSpace(r):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+			if j-i > 1 || r != ' ' {
+				tokens += 1 + (j-i-1)/4
+			}
+		case r < utf8.RuneSelf:
+			for j < len(runes) && runes[j] < utf8.RuneSelf && isPunct(runes[j]) {
+				j++
+			}
+			tokens += (j - i + 1) / 2
+		default:
+			tokens += utf8.RuneLen(r) / 2
+		}
+		i = j
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsLetter(r) || r == '_')
+}
+
+func isPunct(r rune) bool {
+	return !isWordRune(r) && !unicode.IsSpace(r) && (r < '0' || r > '9')
+}
+
+// estimateRequestTokens estimates the input tokens of a request, before
+// calibration: every string in its messages, system prompt and tools
+func estimateRequestTokens(request ClaudeRequest) int {
+	tokens := estimateValueTokens(request.System) + estimateValueTokens(request.Tools)
+	for _, message := range request.Messages {
+		tokens += messageOverheadTokens + estimateValueTokens(message.Content)
+	}
+	return tokens
+}
+
+// estimateValueTokens estimates the tokens of the strings in v's JSON form
+func estimateValueTokens(v interface{}) int {
+	if s, ok := v.(string); ok {
+		return estimateTextTokens(s)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return 0
+	}
+	return estimateDecodedTokens(decoded)
+}
+
+func estimateDecodedTokens(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return estimateTextTokens(v)
+	case []interface{}:
+		tokens := 0
+		for _, item := range v {
+			tokens += estimateDecodedTokens(item)
+		}
+		return tokens
+	case map[string]interface{}:
+		tokens := 0
+		for key, item := range v {
+			tokens += estimateTextTokens(key) + estimateDecodedTokens(item)
+		}
+		return tokens
+	case nil:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// scaleTokens applies the calibration to a raw estimate
+func (c *ClaudeClient) scaleTokens(raw int) int {
+	calibration := c.sharedCalibration()
+	calibration.mutex.Lock()
+	scale := calibration.scale
+	calibration.mutex.Unlock()
+	if scale == 0 {
+		return raw
+	}
+	return int(float64(raw)*scale + 0.5)
+}
+
+// observeUsage calibrates estimates with the input tokens the API reported
+// for a request estimated at raw tokens. The ratio is smoothed and bounded,
+// so one odd request can't skew it.
+func (c *ClaudeClient) observeUsage(raw int, response *ClaudeResponse) {
+	actual := response.Usage.InputTokens + response.Usage.CacheCreationInputTokens + response.Usage.CacheReadInputTokens
+	if raw <= 0 || actual <= 0 {
+		return
+	}
+	ratio := float64(actual) / float64(raw)
+	if ratio < 0.5 {
+		ratio = 0.5
+	} else if ratio > 2 {
+		ratio = 2
+	}
+
+	calibration := c.sharedCalibration()
+	calibration.mutex.Lock()
+	defer calibration.mutex.Unlock()
+	if calibration.scale == 0 {
+		calibration.scale = ratio
+	} else {
+		calibration.scale = 0.7*calibration.scale + 0.3*ratio
+	}
+}
+
+// CountTokens asks the API's token counting endpoint how many input tokens
+// a request with messages, the system prompt and the tools takes
+func (c *ClaudeClient) CountTokens(messages []ConversationMessage) (int, error) {
+	request := c.conversationRequest(messages)
+	body, err := json.Marshal(map[string]interface{}{
+		"model":    request.Model,
+		"messages": request.Messages,
+		"system":   request.System,
+		"tools":    request.Tools,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.baseURL, "/")+"/count_tokens", bytes.NewBuffer(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("token count failed with status %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(responseBody, &count); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return count.InputTokens, nil
+}
+
+// conversationTokens counts the input tokens of a request with messages,
+// with the count endpoint if it answers and the estimator otherwise
+func (c *ClaudeClient) conversationTokens(messages []ConversationMessage) int {
+	if tokens, err := c.CountTokens(messages); err == nil {
+		return tokens
+	}
+	return c.scaleTokens(estimateRequestTokens(c.conversationRequest(messages)))
+}
+
+// conversationRequest builds the first request of a turn over messages
+func (c *ClaudeClient) conversationRequest(messages []ConversationMessage) ClaudeRequest {
+	claudeMessages := make([]ClaudeMessage, 0, len(messages))
+	for _, msg := range messages {
+		claudeMessages = append(claudeMessages, ClaudeMessage{Role: msg.Role, Content: msg.Content})
+	}
+	return ClaudeRequest{
+		Model:    c.Settings().Model,
+		Messages: claudeMessages,
+		Tools:    c.getAvailableTools(),
+		System:   c.systemPromptBlocks(),
+	}
+}
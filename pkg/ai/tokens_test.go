@@ -0,0 +1,91 @@
+package ai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEstimateTextTokens(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{"", 0},
+		{"This is a test message", 5},
+		{"ChatWithToolsAndContext", 5},
+		{"internationalization", 3},
+		{"12345678", 3},
+		{"    return nil", 3},
+		{"if err != nil {", 5},
+		{"日本語", 3},
+	}
+	for _, tt := range tests {
+		if got := estimateTextTokens(tt.text); got != tt.expected {
+			t.Errorf("Expected %d tokens for %q, got %d", tt.expected, tt.text, got)
+		}
+	}
+}
+
+func TestEstimateTokensCalibrates(t *testing.T) {
+	client := &ClaudeClient{}
+	if tokens := client.EstimateTokens("This is a test message"); tokens != 5 {
+		t.Fatalf("Expected 5 tokens before calibration, got %d", tokens)
+	}
+
+	response := &ClaudeResponse{}
+	response.Usage.InputTokens = 50
+	response.Usage.CacheReadInputTokens = 100
+	client.observeUsage(100, response)
+	if tokens := client.EstimateTokens("This is a test message"); tokens != 8 {
+		t.Errorf("Expected estimates scaled by 1.5, got %d", tokens)
+	}
+
+	// One wildly different request moves the scale only within bounds
+	response.Usage.InputTokens, response.Usage.CacheReadInputTokens = 100000, 0
+	client.observeUsage(100, response)
+	if scale := client.calibration.scale; scale > 1.7*1.5 || scale < 1.5 {
+		t.Errorf("Expected a smoothed, bounded scale, got %f", scale)
+	}
+
+	// Sessions share the calibration
+	if tokens := client.NewSession().EstimateTokens("This is a test message"); tokens != client.EstimateTokens("This is a test message") {
+		t.Errorf("Expected a session to estimate like its client, got %d", tokens)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	var path string
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"input_tokens": 1234}`))
+	}))
+	defer server.Close()
+
+	client := &ClaudeClient{baseURL: server.URL + "/v1/messages", httpClient: server.Client(), model: "test-model"}
+	tokens, err := client.CountTokens([]ConversationMessage{{Role: "user", Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("CountTokens failed: %v", err)
+	}
+	if tokens != 1234 || path != "/v1/messages/count_tokens" {
+		t.Errorf("Expected 1234 tokens from /v1/messages/count_tokens, got %d from %s", tokens, path)
+	}
+	if request["tools"] == nil || request["system"] == nil {
+		t.Errorf("Expected the tools and system prompt to be counted, got %v", request)
+	}
+}
+
+func TestConversationTokensFallsBackToEstimate(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := &ClaudeClient{baseURL: server.URL, httpClient: server.Client(), model: "test-model"}
+	messages := []ConversationMessage{{Role: "user", Content: "This is a test message"}}
+	expected := estimateRequestTokens(client.conversationRequest(messages))
+	if tokens := client.conversationTokens(messages); tokens != expected || tokens < 5 {
+		t.Errorf("Expected the estimate %d, got %d", expected, tokens)
+	}
+}
@@ -0,0 +1,68 @@
+package ai
+
+import (
+	"fmt"
+
+	stackctx "stackagent/pkg/context"
+	"stackagent/pkg/shell"
+)
+
+// SetContextManager connects the client to persistent context so commands
+// and touched files are recorded across sessions
+func (c *ClaudeClient) SetContextManager(cm *stackctx.ContextManager) {
+	c.contextManager = cm
+}
+
+// GetContextManager returns the persistent context, or nil if none is set
+func (c *ClaudeClient) GetContextManager() *stackctx.ContextManager {
+	return c.contextManager
+}
+
+// recordCommand adds a run_with_capture invocation to the command history
+// once it completes, so the history holds real exit codes and durations.
+// It blocks until then; callers run it in a goroutine.
+func (c *ClaudeClient) recordCommand(handle *shell.OutputHandle) {
+	cm := c.contextManager
+	if cm == nil {
+		return
+	}
+
+	if err := cm.SetActiveHandles(c.shellManager.ListHandles()); err != nil {
+		c.debugLog("CONTEXT ERROR", fmt.Sprintf("failed to record active handles: %v", err))
+	}
+
+	stats, err := c.shellManager.Wait(handle.ID)
+	if err != nil {
+		return
+	}
+	if err := cm.AddCommandRecord(handle.Command, handle.ID, stats.ExitCode, stats.Duration); err != nil {
+		c.debugLog("CONTEXT ERROR", fmt.Sprintf("failed to record command: %v", err))
+	}
+}
+
+// trackActiveFiles records the files a successful tool call touched
+func (c *ClaudeClient) trackActiveFiles(toolUse ToolUse) {
+	if c.contextManager == nil || c.readOnly {
+		return
+	}
+
+	var paths []string
+	switch toolUse.Name {
+	case "read_file", "write_file", "edit_file", "search_in_file":
+		if filePath, ok := toolUse.Input["file_path"].(string); ok {
+			paths = append(paths, filePath)
+		}
+	case "apply_patch":
+		if patches, err := parsePatchInput(toolUse.Input); err == nil {
+			for _, fp := range patches {
+				paths = append(paths, fp.Path())
+			}
+		}
+	}
+
+	for _, p := range paths {
+		if err := c.contextManager.TrackActiveFile(p); err != nil {
+			c.debugLog("CONTEXT ERROR", fmt.Sprintf("failed to track %s: %v", p, err))
+		}
+	}
+}
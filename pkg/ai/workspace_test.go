@@ -0,0 +1,98 @@
+package ai
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	stackctx "stackagent/pkg/context"
+	"stackagent/pkg/shell"
+)
+
+func newContextTestClient(t *testing.T) (*ClaudeClient, *stackctx.ContextManager) {
+	t.Helper()
+	cm := stackctx.NewContextManager(t.TempDir())
+	client := &ClaudeClient{shellManager: shell.NewShellManager()}
+	client.SetContextManager(cm)
+	return client, cm
+}
+
+// waitForCommandRecords polls until n commands have been recorded
+func waitForCommandRecords(t *testing.T, cm *stackctx.ContextManager, n int) []stackctx.CommandRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		history := cm.GetWorkspace().CommandHistory
+		if len(history) >= n || time.Now().After(deadline) {
+			return history
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunWithCaptureRecordsCommand(t *testing.T) {
+	client, cm := newContextTestClient(t)
+
+	_, err := client.ExecuteFunction(ToolUse{
+		ID:    "call_1",
+		Name:  "run_with_capture",
+		Input: map[string]interface{}{"command": "echo recorded"},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteFunction failed: %v", err)
+	}
+
+	history := waitForCommandRecords(t, cm, 1)
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 command record, got %d", len(history))
+	}
+	if history[0].Command != "echo recorded" {
+		t.Errorf("Expected 'echo recorded', got '%s'", history[0].Command)
+	}
+	if ws := cm.GetWorkspace(); len(ws.ActiveHandles) != 1 {
+		t.Errorf("Expected 1 active handle, got %d", len(ws.ActiveHandles))
+	}
+}
+
+func TestRecordCommandWaitsForCompletion(t *testing.T) {
+	client, cm := newContextTestClient(t)
+
+	handle, err := client.shellManager.RunWithCapture("sleep 0.3; exit 3")
+	if err != nil {
+		t.Fatalf("RunWithCapture failed: %v", err)
+	}
+	client.recordCommand(handle)
+
+	history := cm.GetWorkspace().CommandHistory
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 command record, got %d", len(history))
+	}
+	if history[0].ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", history[0].ExitCode)
+	}
+	if d, _ := time.ParseDuration(history[0].Duration); d < 300*time.Millisecond {
+		t.Errorf("Expected the full run time to be recorded, got %s", history[0].Duration)
+	}
+}
+
+func TestTrackActiveFiles(t *testing.T) {
+	client, cm := newContextTestClient(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	writeTestFile(t, path, "hello\n")
+
+	client.trackActiveFiles(ToolUse{Name: "read_file", Input: map[string]interface{}{"file_path": path}})
+	client.trackActiveFiles(ToolUse{Name: "apply_patch", Input: map[string]interface{}{
+		"patch": "--- a/other.txt\n+++ b/other.txt\n@@ -1 +1 @@\n-a\n+b\n",
+	}})
+	client.trackActiveFiles(ToolUse{Name: "list_directory", Input: map[string]interface{}{"directory_path": dir}})
+
+	files := cm.GetWorkspace().ActiveFiles
+	if len(files) != 2 || files[0] != "other.txt" || files[1] != path {
+		t.Errorf("Expected [other.txt %s], got %v", path, files)
+	}
+
+	// Without a context manager tracking is a no-op
+	plain := &ClaudeClient{shellManager: shell.NewShellManager()}
+	plain.trackActiveFiles(ToolUse{Name: "read_file", Input: map[string]interface{}{"file_path": path}})
+}
@@ -0,0 +1,399 @@
+package codeintel
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"stackagent/pkg/search"
+)
+
+// Symbol describes a top-level Go declaration
+type Symbol struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"` // "func", "method", "type", "const", "var"
+	Receiver  string `json:"receiver,omitempty"`
+	Package   string `json:"package"`
+	Signature string `json:"signature"`
+	Doc       string `json:"doc,omitempty"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	EndLine   int    `json:"endLine"`
+	Exported  bool   `json:"exported"`
+}
+
+// QualifiedName returns Receiver.Name for methods and Name otherwise
+func (s Symbol) QualifiedName() string {
+	if s.Receiver != "" {
+		return s.Receiver + "." + s.Name
+	}
+	return s.Name
+}
+
+// Reference is a use of an identifier in the source
+type Reference struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text"` // The source line, trimmed
+}
+
+// FunctionSource is the full source of a function or method
+type FunctionSource struct {
+	Symbol
+	Source string `json:"source"`
+}
+
+// parsedFile is a parsed Go file together with its raw source
+type parsedFile struct {
+	path string
+	file *ast.File
+	src  []byte
+}
+
+// parseTree parses every .go file under root (respecting .gitignore).
+// With recursive false only root itself is parsed.
+func parseTree(root string, recursive bool) (*token.FileSet, []parsedFile, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	fset := token.NewFileSet()
+	var files []parsedFile
+	err = search.Walk(root, search.WalkOptions{}, func(p, rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if !recursive || d.Name() == "vendor" || d.Name() == "testdata" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".go") {
+			return nil
+		}
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, p, src, parser.ParseComments)
+		if err != nil && f == nil {
+			return nil // Skip files that don't parse at all
+		}
+		files = append(files, parsedFile{path: p, file: f, src: src})
+		return nil
+	})
+	return fset, files, err
+}
+
+// PackageSymbols lists the top-level declarations of the package in dir
+func PackageSymbols(dir string, includeUnexported bool) ([]Symbol, error) {
+	fset, files, err := parseTree(dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []Symbol
+	for _, pf := range files {
+		for _, sym := range fileSymbols(fset, pf) {
+			if sym.Exported || includeUnexported {
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].File != symbols[j].File {
+			return symbols[i].File < symbols[j].File
+		}
+		return symbols[i].Line < symbols[j].Line
+	})
+	return symbols, nil
+}
+
+// FindDefinitions finds declarations named name ("Name" or "Type.Method")
+// anywhere under root
+func FindDefinitions(root, name string) ([]Symbol, error) {
+	fset, files, err := parseTree(root, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Symbol
+	for _, pf := range files {
+		for _, sym := range fileSymbols(fset, pf) {
+			if symbolMatches(sym, name) {
+				found = append(found, sym)
+			}
+		}
+	}
+	return found, nil
+}
+
+// FindReferences finds identifiers named name under root. For "Type.Method"
+// only selector expressions ending in Method are reported. This is a
+// syntactic search: same-named identifiers in unrelated scopes also match.
+func FindReferences(root, name string, maxResults int) ([]Reference, bool, error) {
+	fset, files, err := parseTree(root, true)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ident := name
+	selectorOnly := false
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		ident = name[i+1:]
+		selectorOnly = true
+	}
+
+	var refs []Reference
+	truncated := false
+	for _, pf := range files {
+		lines := strings.Split(string(pf.src), "\n")
+		ast.Inspect(pf.file, func(n ast.Node) bool {
+			if truncated {
+				return false
+			}
+			var id *ast.Ident
+			switch node := n.(type) {
+			case *ast.SelectorExpr:
+				if node.Sel.Name == ident {
+					id = node.Sel
+				}
+			case *ast.Ident:
+				if !selectorOnly && node.Name == ident {
+					id = node
+				}
+			}
+			if id == nil {
+				return true
+			}
+
+			pos := fset.Position(id.Pos())
+			// Selector identifiers are visited twice (as SelectorExpr and Ident)
+			if len(refs) > 0 {
+				last := refs[len(refs)-1]
+				if last.File == pos.Filename && last.Line == pos.Line && last.Column == pos.Column {
+					return true
+				}
+			}
+			text := ""
+			if pos.Line-1 < len(lines) {
+				text = strings.TrimSpace(lines[pos.Line-1])
+			}
+			refs = append(refs, Reference{File: pos.Filename, Line: pos.Line, Column: pos.Column, Text: text})
+			if maxResults > 0 && len(refs) >= maxResults {
+				truncated = true
+			}
+			return true
+		})
+	}
+	return refs, truncated, nil
+}
+
+// FindFunctionSource returns the source of functions or methods named name
+func FindFunctionSource(root, name string) ([]FunctionSource, error) {
+	fset, files, err := parseTree(root, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []FunctionSource
+	for _, pf := range files {
+		for _, decl := range pf.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			sym := funcSymbol(fset, pf, fn)
+			if !symbolMatches(sym, name) {
+				continue
+			}
+			start := fset.Position(fn.Pos()).Offset
+			if fn.Doc != nil {
+				start = fset.Position(fn.Doc.Pos()).Offset
+			}
+			end := fset.Position(fn.End()).Offset
+			found = append(found, FunctionSource{Symbol: sym, Source: string(pf.src[start:end])})
+		}
+	}
+	return found, nil
+}
+
+func symbolMatches(sym Symbol, name string) bool {
+	if strings.Contains(name, ".") {
+		return sym.QualifiedName() == name
+	}
+	return sym.Name == name
+}
+
+// fileSymbols returns the top-level declarations of a file
+func fileSymbols(fset *token.FileSet, pf parsedFile) []Symbol {
+	var symbols []Symbol
+	pkg := pf.file.Name.Name
+
+	for _, decl := range pf.file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			symbols = append(symbols, funcSymbol(fset, pf, d))
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					sym := newSymbol(fset, pf, s.Name.Name, "type", s.Pos(), s.End())
+					sym.Signature = typeSignature(fset, s)
+					sym.Doc = docText(s.Doc, d.Doc, len(d.Specs))
+					symbols = append(symbols, sym)
+
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, n := range s.Names {
+						if n.Name == "_" {
+							continue
+						}
+						sym := newSymbol(fset, pf, n.Name, kind, s.Pos(), s.End())
+						sym.Signature = kind + " " + n.Name
+						if s.Type != nil {
+							sym.Signature += " " + nodeString(fset, s.Type)
+						}
+						sym.Doc = docText(s.Doc, d.Doc, len(d.Specs))
+						symbols = append(symbols, sym)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range symbols {
+		symbols[i].Package = pkg
+	}
+	return symbols
+}
+
+func newSymbol(fset *token.FileSet, pf parsedFile, name, kind string, pos, end token.Pos) Symbol {
+	return Symbol{
+		Name:     name,
+		Kind:     kind,
+		File:     pf.path,
+		Line:     fset.Position(pos).Line,
+		EndLine:  fset.Position(end).Line,
+		Exported: ast.IsExported(name),
+	}
+}
+
+func funcSymbol(fset *token.FileSet, pf parsedFile, fn *ast.FuncDecl) Symbol {
+	sym := newSymbol(fset, pf, fn.Name.Name, "func", fn.Pos(), fn.End())
+	sym.Package = pf.file.Name.Name
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		sym.Kind = "method"
+		sym.Receiver = receiverTypeName(fn.Recv.List[0].Type)
+	}
+
+	// Print the declaration without its body
+	sig := *fn
+	sig.Body = nil
+	sig.Doc = nil
+	sym.Signature = nodeString(fset, &sig)
+	sym.Doc = docText(fn.Doc, nil, 0)
+	return sym
+}
+
+// receiverTypeName returns "T" for receivers of type T, *T, T[K] or *T[K]
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// typeSignature renders a compact one-line type description
+func typeSignature(fset *token.FileSet, s *ast.TypeSpec) string {
+	prefix := "type " + s.Name.Name
+	if s.Assign.IsValid() {
+		prefix += " ="
+	}
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		return fmt.Sprintf("%s struct { %d field(s) }", prefix, t.Fields.NumFields())
+	case *ast.InterfaceType:
+		return fmt.Sprintf("%s interface { %d method(s) }", prefix, t.Methods.NumFields())
+	default:
+		return prefix + " " + nodeString(fset, s.Type)
+	}
+}
+
+// docText returns the first line of the spec's doc comment, falling back
+// to the declaration's comment for single-spec declarations
+func docText(spec, decl *ast.CommentGroup, specs int) string {
+	doc := spec
+	if doc == nil && specs <= 1 {
+		doc = decl
+	}
+	if doc == nil {
+		return ""
+	}
+	text := strings.TrimSpace(doc.Text())
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
+
+func nodeString(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// FormatOutline renders symbols as a compact outline grouped by file, with
+// methods indented so they stand out from package-level declarations
+func FormatOutline(symbols []Symbol) string {
+	if len(symbols) == 0 {
+		return "No symbols found"
+	}
+
+	var b strings.Builder
+	lastFile := ""
+	for _, sym := range symbols {
+		if sym.File != lastFile {
+			fmt.Fprintf(&b, "\n%s (package %s)\n", sym.File, sym.Package)
+			lastFile = sym.File
+		}
+		indent := "  "
+		if sym.Kind == "method" {
+			indent = "    "
+		}
+		fmt.Fprintf(&b, "%s%d: %s", indent, sym.Line, sym.Signature)
+		if sym.Doc != "" {
+			fmt.Fprintf(&b, "  // %s", sym.Doc)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,155 @@
+package codeintel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `package shapes
+
+import "math"
+
+// Pi is a handy constant
+const Pi = math.Pi
+
+// Shape is anything with an area
+type Shape interface {
+	Area() float64
+}
+
+// Circle is a round shape
+type Circle struct {
+	Radius float64
+}
+
+// Area returns the area of the circle
+func (c *Circle) Area() float64 {
+	return Pi * c.Radius * c.Radius
+}
+
+func newCircle(r float64) *Circle {
+	return &Circle{Radius: r}
+}
+`
+
+const testCaller = `package main
+
+import "example/shapes"
+
+func main() {
+	c := shapes.Circle{Radius: 2}
+	_ = c.Area()
+}
+`
+
+func writeTestPackage(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "shapes"), 0755); err != nil {
+		t.Fatalf("Failed to create package dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "shapes", "shapes.go"), []byte(testSource), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(testCaller), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	return root
+}
+
+func TestPackageSymbols(t *testing.T) {
+	root := writeTestPackage(t)
+
+	symbols, err := PackageSymbols(filepath.Join(root, "shapes"), false)
+	if err != nil {
+		t.Fatalf("PackageSymbols failed: %v", err)
+	}
+
+	var names []string
+	for _, sym := range symbols {
+		names = append(names, sym.QualifiedName())
+	}
+
+	expected := "Pi,Shape,Circle,Circle.Area"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected exported symbols %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	outline := FormatOutline(symbols)
+	if !strings.Contains(outline, "func (c *Circle) Area() float64") {
+		t.Errorf("Expected method signature in outline, got:\n%s", outline)
+	}
+
+	if !strings.Contains(outline, "type Circle struct { 1 field(s) }") {
+		t.Errorf("Expected compact struct summary in outline, got:\n%s", outline)
+	}
+
+	all, err := PackageSymbols(filepath.Join(root, "shapes"), true)
+	if err != nil {
+		t.Fatalf("PackageSymbols failed: %v", err)
+	}
+
+	if len(all) != len(symbols)+1 {
+		t.Errorf("Expected unexported newCircle to be included, got %d symbols", len(all))
+	}
+}
+
+func TestFindDefinitions(t *testing.T) {
+	root := writeTestPackage(t)
+
+	defs, err := FindDefinitions(root, "Circle.Area")
+	if err != nil {
+		t.Fatalf("FindDefinitions failed: %v", err)
+	}
+
+	if len(defs) != 1 {
+		t.Fatalf("Expected 1 definition, got %d", len(defs))
+	}
+
+	if defs[0].Line != 19 || defs[0].Doc != "Area returns the area of the circle" {
+		t.Errorf("Unexpected definition: %+v", defs[0])
+	}
+}
+
+func TestFindReferences(t *testing.T) {
+	root := writeTestPackage(t)
+
+	refs, truncated, err := FindReferences(root, "Circle", 0)
+	if err != nil {
+		t.Fatalf("FindReferences failed: %v", err)
+	}
+
+	// Declaration, receiver, composite literal in newCircle, return type, and shapes.Circle in main
+	if len(refs) != 5 || truncated {
+		t.Errorf("Expected 5 references, got %d: %+v", len(refs), refs)
+	}
+
+	refs, _, err = FindReferences(root, "Circle.Area", 0)
+	if err != nil {
+		t.Fatalf("FindReferences failed: %v", err)
+	}
+
+	if len(refs) != 1 || !strings.HasSuffix(refs[0].File, "main.go") {
+		t.Errorf("Expected one selector reference in main.go, got %+v", refs)
+	}
+}
+
+func TestFindFunctionSource(t *testing.T) {
+	root := writeTestPackage(t)
+
+	funcs, err := FindFunctionSource(root, "newCircle")
+	if err != nil {
+		t.Fatalf("FindFunctionSource failed: %v", err)
+	}
+
+	if len(funcs) != 1 {
+		t.Fatalf("Expected 1 function, got %d", len(funcs))
+	}
+
+	expected := "func newCircle(r float64) *Circle {\n\treturn &Circle{Radius: r}\n}"
+	if funcs[0].Source != expected {
+		t.Errorf("Expected %q, got %q", expected, funcs[0].Source)
+	}
+}
@@ -0,0 +1,488 @@
+// Package config builds the server's configuration from layers, each
+// overriding the one before: built-in defaults, the user's
+// ~/.config/stackagent/config.yaml, the project's .stackagent/config.yaml,
+// STACKAGENT_* environment variables and command line flags.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"stackagent/pkg/ai"
+)
+
+// Config is the server's configuration
+type Config struct {
+	Agent   AgentConfig
+	Server  ServerConfig
+	Context ContextConfig
+
+	sources map[string]string // Where each setting's value came from, by key
+}
+
+// AgentConfig is how the agent runs
+type AgentConfig struct {
+	Model         string
+	MaxTokens     int
+	MaxRounds     int
+	Tools         []string // Enabled tools; all if empty
+	DisabledTools []string
+	DebugLog      string // API calls are logged here if set
+}
+
+// ServerConfig is where and how the server listens
+type ServerConfig struct {
+	Bind       string // Address to bind, or "none" for the Unix socket only
+	Port       int
+	GUIPath    string
+	Socket     string // Unix socket path, optional
+	SocketMode os.FileMode
+	TLSCert    string
+	TLSKey     string
+	SelfSigned bool   // Generate a self-signed certificate
+	AuthConfig string // Auth config JSON; local connections only if empty
+}
+
+// ContextConfig is how persistent context is stored and recalled
+type ContextConfig struct {
+	Format          string // json or markdown; as stored if empty
+	EmbeddingsURL   string // OpenAI-compatible endpoint; offline embedder if empty
+	EmbeddingsModel string
+	EmbeddingsKey   string
+}
+
+// setting is one configurable value and the names it goes by in each layer
+type setting struct {
+	key        string // Dotted path in config files
+	env        string
+	flag       string
+	usage      string
+	reloadable bool // Takes effect on SIGHUP without a restart
+	secret     bool // Masked by Show
+	list       bool // Comma-separated in env vars and flags
+	get        func(c *Config) string
+	set        func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{key: "agent.model", env: "STACKAGENT_MODEL", flag: "model", usage: "Claude model", reloadable: true,
+		get: func(c *Config) string { return c.Agent.Model },
+		set: func(c *Config, v string) error { c.Agent.Model = v; return nil }},
+	{key: "agent.maxTokens", env: "STACKAGENT_MAX_TOKENS", flag: "max-tokens", usage: "maximum tokens per response", reloadable: true,
+		get: func(c *Config) string { return strconv.Itoa(c.Agent.MaxTokens) },
+		set: func(c *Config, v string) error { return setInt(&c.Agent.MaxTokens, v) }},
+	{key: "agent.maxRounds", env: "STACKAGENT_MAX_ROUNDS", flag: "max-rounds", usage: "maximum tool rounds per turn", reloadable: true,
+		get: func(c *Config) string { return strconv.Itoa(c.Agent.MaxRounds) },
+		set: func(c *Config, v string) error { return setInt(&c.Agent.MaxRounds, v) }},
+	{key: "agent.tools.enabled", env: "STACKAGENT_TOOLS", flag: "tools", usage: "comma-separated tools to enable (default all)", reloadable: true, list: true,
+		get: func(c *Config) string { return strings.Join(c.Agent.Tools, ",") },
+		set: func(c *Config, v string) error { c.Agent.Tools = splitList(v); return nil }},
+	{key: "agent.tools.disabled", env: "STACKAGENT_DISABLED_TOOLS", flag: "disable-tools", usage: "comma-separated tools to disable", reloadable: true, list: true,
+		get: func(c *Config) string { return strings.Join(c.Agent.DisabledTools, ",") },
+		set: func(c *Config, v string) error { c.Agent.DisabledTools = splitList(v); return nil }},
+	{key: "agent.debugLog", env: "STACKAGENT_DEBUG_LOG", flag: "debug-log", usage: "file to log API calls to",
+		get: func(c *Config) string { return c.Agent.DebugLog },
+		set: func(c *Config, v string) error { c.Agent.DebugLog = v; return nil }},
+
+	{key: "server.bind", env: "STACKAGENT_BIND", flag: "bind", usage: `address to listen on, or "none" for the Unix socket only`,
+		get: func(c *Config) string { return c.Server.Bind },
+		set: func(c *Config, v string) error { c.Server.Bind = v; return nil }},
+	{key: "server.port", env: "STACKAGENT_PORT", flag: "port", usage: "port to listen on",
+		get: func(c *Config) string { return strconv.Itoa(c.Server.Port) },
+		set: func(c *Config, v string) error { return setInt(&c.Server.Port, v) }},
+	{key: "server.guiPath", env: "STACKAGENT_GUI_PATH", flag: "gui", usage: "directory of the built GUI",
+		get: func(c *Config) string { return c.Server.GUIPath },
+		set: func(c *Config, v string) error { c.Server.GUIPath = v; return nil }},
+	{key: "server.socket", env: "STACKAGENT_SOCKET", flag: "socket", usage: "Unix socket to listen on",
+		get: func(c *Config) string { return c.Server.Socket },
+		set: func(c *Config, v string) error { c.Server.Socket = v; return nil }},
+	{key: "server.socketMode", env: "STACKAGENT_SOCKET_MODE", flag: "socket-mode", usage: "Unix socket permissions, in octal",
+		get: func(c *Config) string { return fmt.Sprintf("%04o", uint32(c.Server.SocketMode)) },
+		set: func(c *Config, v string) error {
+			mode, err := strconv.ParseUint(v, 8, 32)
+			if err != nil {
+				return fmt.Errorf("expected an octal mode such as 0600, got %q", v)
+			}
+			c.Server.SocketMode = os.FileMode(mode)
+			return nil
+		}},
+	{key: "server.tls.cert", env: "STACKAGENT_TLS_CERT", flag: "tls-cert", usage: "TLS certificate file",
+		get: func(c *Config) string { return c.Server.TLSCert },
+		set: func(c *Config, v string) error { c.Server.TLSCert = v; return nil }},
+	{key: "server.tls.key", env: "STACKAGENT_TLS_KEY", flag: "tls-key", usage: "TLS key file",
+		get: func(c *Config) string { return c.Server.TLSKey },
+		set: func(c *Config, v string) error { c.Server.TLSKey = v; return nil }},
+	{key: "server.tls.selfSigned", env: "STACKAGENT_TLS_SELF_SIGNED", flag: "tls-self-signed", usage: "serve TLS with a generated self-signed certificate",
+		get: func(c *Config) string { return strconv.FormatBool(c.Server.SelfSigned) },
+		set: func(c *Config, v string) error { return setBool(&c.Server.SelfSigned, v) }},
+	{key: "server.authConfig", env: "STACKAGENT_AUTH_CONFIG", flag: "auth-config", usage: "auth config JSON file",
+		get: func(c *Config) string { return c.Server.AuthConfig },
+		set: func(c *Config, v string) error { c.Server.AuthConfig = v; return nil }},
+
+	{key: "context.format", env: "STACKAGENT_CONTEXT_FORMAT", flag: "context-format", usage: `context storage format, "json" or "markdown"`,
+		get: func(c *Config) string { return c.Context.Format },
+		set: func(c *Config, v string) error { c.Context.Format = v; return nil }},
+	{key: "context.embeddings.url", env: "STACKAGENT_EMBEDDINGS_URL", flag: "embeddings-url", usage: "OpenAI-compatible embeddings endpoint",
+		get: func(c *Config) string { return c.Context.EmbeddingsURL },
+		set: func(c *Config, v string) error { c.Context.EmbeddingsURL = v; return nil }},
+	{key: "context.embeddings.model", env: "STACKAGENT_EMBEDDINGS_MODEL", flag: "embeddings-model", usage: "embeddings model",
+		get: func(c *Config) string { return c.Context.EmbeddingsModel },
+		set: func(c *Config, v string) error { c.Context.EmbeddingsModel = v; return nil }},
+	{key: "context.embeddings.apiKey", env: "STACKAGENT_EMBEDDINGS_API_KEY", flag: "embeddings-api-key", usage: "embeddings API key", secret: true,
+		get: func(c *Config) string { return c.Context.EmbeddingsKey },
+		set: func(c *Config, v string) error { c.Context.EmbeddingsKey = v; return nil }},
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	agent := ai.DefaultSettings()
+	c := &Config{
+		Agent: AgentConfig{
+			Model:     agent.Model,
+			MaxTokens: agent.MaxTokens,
+			MaxRounds: agent.MaxRounds,
+		},
+		Server: ServerConfig{
+			Bind:       "127.0.0.1",
+			Port:       8080,
+			GUIPath:    filepath.Join("web", "gui", "dist"),
+			SocketMode: 0600,
+		},
+		Context: ContextConfig{
+			EmbeddingsModel: "text-embedding-3-small",
+		},
+		sources: make(map[string]string),
+	}
+	for _, s := range settings {
+		c.sources[s.key] = "default"
+	}
+	return c
+}
+
+// Dir is the user's StackAgent config directory: $XDG_CONFIG_HOME/stackagent
+// or ~/.config/stackagent
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "stackagent"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "stackagent"), nil
+}
+
+// Options say where Load finds each layer
+type Options struct {
+	UserFile    string   // Default <Dir>/config.yaml
+	ProjectDir  string   // Holds .stackagent/config.yaml; default the working directory
+	Environ     []string // Default os.Environ()
+	Args        []string // Command line flags
+	ProgramName string   // For flag usage
+}
+
+// Load builds the configuration from all layers and validates it. Missing
+// config files are skipped.
+func Load(options Options) (*Config, error) {
+	values, configFile, err := parseFlags(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.UserFile == "" {
+		dir, err := Dir()
+		if err != nil {
+			return nil, err
+		}
+		options.UserFile = filepath.Join(dir, "config.yaml")
+	}
+	if options.ProjectDir == "" {
+		options.ProjectDir = "."
+	}
+	if options.Environ == nil {
+		options.Environ = os.Environ()
+	}
+
+	c := Default()
+	files := []string{options.UserFile, filepath.Join(options.ProjectDir, ".stackagent", "config.yaml")}
+	if configFile != "" {
+		files = append(files, configFile)
+	}
+	for i, path := range files {
+		if err := c.loadFile(path, i == len(files)-1 && configFile != ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(options.Environ); err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if value, ok := values[s.flag]; ok {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %w", s.flag, err)
+			}
+			c.sources[s.key] = "flag -" + s.flag
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseFlags parses the command line into values by flag name and the
+// -config file
+func parseFlags(options Options) (map[string]string, string, error) {
+	name := options.ProgramName
+	if name == "" {
+		name = "stackagent-server"
+	}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configFile := flags.String("config", "", "config file applied after the user and project ones")
+	for _, s := range settings {
+		flags.String(s.flag, "", s.usage)
+	}
+	if err := flags.Parse(options.Args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, "", fmt.Errorf("usage of %s:\n%s", name, Usage())
+		}
+		return nil, "", err
+	}
+	if flags.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	values := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values, *configFile, nil
+}
+
+// Usage describes the flags and environment variables
+func Usage() string {
+	var b strings.Builder
+	b.WriteString("  -config file\n        config file applied after the user and project ones\n")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%s value\n        %s (%s, %s)\n", s.flag, s.usage, s.env, s.key)
+	}
+	return b.String()
+}
+
+// loadFile applies a config file; only a file asked for must exist
+func (c *Config) loadFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	values, err := parseYAML(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	known := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return values[keys[i]].line < values[keys[j]].line })
+	for _, key := range keys {
+		value := values[key]
+		s, ok := known[key]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown setting %s", path, value.line, key)
+		}
+		if err := s.set(c, value.String()); err != nil {
+			return fmt.Errorf("%s:%d: invalid %s: %w", path, value.line, key, err)
+		}
+		c.sources[key] = path
+	}
+	return nil
+}
+
+// loadEnv applies STACKAGENT_* variables, and PORT as the port
+func (c *Config) loadEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			env[name] = value
+		}
+	}
+	for _, s := range settings {
+		value, ok := env[s.env]
+		if !ok && s.env == "STACKAGENT_PORT" {
+			value, ok = env["PORT"]
+		}
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", s.env, err)
+		}
+		c.sources[s.key] = "env " + s.env
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration
+func (c *Config) Validate() error {
+	var problems []string
+	if err := c.AgentSettings().Validate(); err != nil {
+		problems = append(problems, "agent: "+err.Error())
+	}
+
+	if c.Server.Bind != "none" {
+		if c.Server.Bind == "" || (strings.ContainsAny(c.Server.Bind, " /:") && net.ParseIP(c.Server.Bind) == nil) {
+			problems = append(problems, fmt.Sprintf("server.bind: invalid address %q", c.Server.Bind))
+		}
+		if c.Server.Port < 1 || c.Server.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("server.port: %d is not a port", c.Server.Port))
+		}
+	} else if c.Server.Socket == "" {
+		problems = append(problems, "server.bind is none but no server.socket is set, so there is nothing to listen on")
+	}
+	if c.Server.SocketMode&^os.ModePerm != 0 || c.Server.SocketMode&0600 != 0600 {
+		problems = append(problems, fmt.Sprintf("server.socketMode: %04o must be permissions that let the owner read and write", uint32(c.Server.SocketMode)))
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		problems = append(problems, "server.tls: cert and key must be set together")
+	}
+	if c.Server.SelfSigned && c.Server.TLSCert != "" {
+		problems = append(problems, "server.tls: selfSigned and a cert can't both be set")
+	}
+
+	switch c.Context.Format {
+	case "", "json", "markdown":
+	default:
+		problems = append(problems, fmt.Sprintf("context.format: %q is not json or markdown", c.Context.Format))
+	}
+	if c.Context.EmbeddingsURL != "" {
+		u, err := url.Parse(c.Context.EmbeddingsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("context.embeddings.url: %q is not an http(s) URL", c.Context.EmbeddingsURL))
+		}
+		if c.Context.EmbeddingsModel == "" {
+			problems = append(problems, "context.embeddings.model: required with an embeddings URL")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// AgentSettings are the agent settings of the configuration
+func (c *Config) AgentSettings() ai.Settings {
+	return ai.Settings{
+		Model:         c.Agent.Model,
+		MaxTokens:     c.Agent.MaxTokens,
+		MaxRounds:     c.Agent.MaxRounds,
+		Tools:         c.Agent.Tools,
+		DisabledTools: c.Agent.DisabledTools,
+	}
+}
+
+// Source says where a setting's value came from: "default", a file path,
+// "env NAME" or "flag -name"
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Reload takes the reloadable settings of next and returns the keys it
+// changed and the keys that changed but need a restart
+func (c *Config) Reload(next *Config) (changed, restart []string) {
+	for _, s := range settings {
+		value := s.get(next)
+		if value == s.get(c) {
+			continue
+		}
+		if !s.reloadable {
+			restart = append(restart, s.key)
+			continue
+		}
+		s.set(c, value)
+		c.sources[s.key] = next.sources[s.key]
+		changed = append(changed, s.key)
+	}
+	return changed, restart
+}
+
+// Show writes the configuration as YAML, each value commented with where
+// it came from. Secrets are masked.
+func (c *Config) Show(w io.Writer) {
+	var section []string
+	for _, s := range settings {
+		parts := strings.Split(s.key, ".")
+		parents := parts[:len(parts)-1]
+		common := 0
+		for common < len(section) && common < len(parents) && section[common] == parents[common] {
+			common++
+		}
+		for i := common; i < len(parents); i++ {
+			fmt.Fprintf(w, "%s%s:\n", strings.Repeat("  ", i), parents[i])
+		}
+		section = parents
+
+		value := s.get(c)
+		rendered := quoteScalar(value)
+		switch {
+		case s.secret && value != "":
+			rendered = `"********"`
+		case s.list:
+			items := splitList(value)
+			for i, item := range items {
+				items[i] = quoteScalar(item)
+			}
+			rendered = "[" + strings.Join(items, ", ") + "]"
+		}
+		fmt.Fprintf(w, "%s%s: %s # %s\n", strings.Repeat("  ", len(parents)), parts[len(parts)-1], rendered, c.sources[s.key])
+	}
+}
+
+func setInt(target *int, value string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("expected a number, got %q", value)
+	}
+	*target = n
+	return nil
+}
+
+func setBool(target *bool, value string) error {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		*target = true
+	case "false", "no", "off", "0", "":
+		*target = false
+	default:
+		return fmt.Errorf("expected true or false, got %q", value)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
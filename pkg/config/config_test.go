@@ -0,0 +1,269 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file, creating its directory
+func writeConfig(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testOptions are Options with user and project files under a temp dir and
+// an empty environment
+func testOptions(t *testing.T) Options {
+	dir := t.TempDir()
+	return Options{
+		UserFile:   filepath.Join(dir, "user", "config.yaml"),
+		ProjectDir: filepath.Join(dir, "project"),
+		Environ:    []string{},
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(testOptions(t))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.Server.Bind != "127.0.0.1" || c.Server.Port != 8080 || c.Agent.MaxRounds != 10 {
+		t.Errorf("Unexpected defaults %+v", c)
+	}
+	if c.Source("agent.model") != "default" {
+		t.Errorf("Expected the model from the defaults, got %s", c.Source("agent.model"))
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	options := testOptions(t)
+	writeConfig(t, options.UserFile, `
+# Everyone's preferences
+agent:
+  model: claude-3-5-haiku-20241022
+  maxTokens: 2000
+  tools:
+    disabled: [write_file]
+server:
+  port: 9000
+context:
+  embeddings:
+    url: "http://localhost:11434/v1"   # Local Ollama
+`)
+	project := filepath.Join(options.ProjectDir, ".stackagent", "config.yaml")
+	writeConfig(t, project, `
+agent:
+  maxTokens: 8000
+  tools:
+    disabled:
+      - write_file
+      - apply_patch
+server:
+  tls:
+    selfSigned: yes
+`)
+	options.Environ = []string{"STACKAGENT_MAX_TOKENS=6000", "PORT=9100", "STACKAGENT_MODEL="}
+	options.Args = []string{"-max-rounds", "20", "-model=claude-3-opus-20240229"}
+
+	c, err := Load(options)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		key, value, source string
+	}{
+		{"agent.model", "claude-3-opus-20240229", "flag -model"},
+		{"agent.maxTokens", "6000", "env STACKAGENT_MAX_TOKENS"},
+		{"agent.maxRounds", "20", "flag -max-rounds"},
+		{"agent.tools.disabled", "write_file,apply_patch", project},
+		{"server.port", "9100", "env STACKAGENT_PORT"},
+		{"server.tls.selfSigned", "true", project},
+		{"context.embeddings.url", "http://localhost:11434/v1", options.UserFile},
+		{"server.bind", "127.0.0.1", "default"},
+	}
+	for _, tt := range tests {
+		var value string
+		for _, s := range settings {
+			if s.key == tt.key {
+				value = s.get(c)
+			}
+		}
+		if value != tt.value || c.Source(tt.key) != tt.source {
+			t.Errorf("Expected %s = %s from %s, got %s from %s", tt.key, tt.value, tt.source, value, c.Source(tt.key))
+		}
+	}
+	if c.Source("server.port") != "env STACKAGENT_PORT" {
+		t.Errorf("Expected PORT to count as STACKAGENT_PORT")
+	}
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	options := testOptions(t)
+	extra := filepath.Join(t.TempDir(), "ci.yaml")
+	writeConfig(t, extra, "agent:\n  maxRounds: 3\n")
+	options.Args = []string{"-config", extra}
+
+	c, err := Load(options)
+	if err != nil || c.Agent.MaxRounds != 3 {
+		t.Fatalf("Expected the -config file applied, got %v", err)
+	}
+
+	options.Args = []string{"-config", extra + ".missing"}
+	if _, err := Load(options); err == nil {
+		t.Error("Expected a missing -config file to fail")
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	tests := map[string]struct {
+		file, env, args string
+		expected        string
+	}{
+		"unknown key":     {file: "agent:\n  modle: x\n", expected: "unknown setting agent.modle"},
+		"not a number":    {file: "agent:\n  maxTokens: many\n", expected: "config.yaml:2: invalid agent.maxTokens"},
+		"tabs":            {file: "agent:\n\tmodel: x\n", expected: "tabs"},
+		"duplicate":       {file: "server:\n  port: 1\n  port: 2\n", expected: "set twice"},
+		"unknown tool":    {env: "STACKAGENT_TOOLS=read_file,format_disk", expected: `unknown tool "format_disk"`},
+		"bad port":        {args: "-port 70000", expected: "is not a port"},
+		"nothing to bind": {args: "-bind none", expected: "nothing to listen on"},
+		"socket mode":     {env: "STACKAGENT_SOCKET_MODE=0044", expected: "server.socketMode"},
+		"half tls":        {args: "-tls-cert cert.pem", expected: "cert and key must be set together"},
+		"format":          {file: "context:\n  format: xml\n", expected: "context.format"},
+		"bad flag":        {args: "-colour", expected: "not defined"},
+	}
+	for name, tt := range tests {
+		options := testOptions(t)
+		if tt.file != "" {
+			writeConfig(t, options.UserFile, tt.file)
+		}
+		if tt.env != "" {
+			options.Environ = []string{tt.env}
+		}
+		if tt.args != "" {
+			options.Args = strings.Fields(tt.args)
+		}
+		if _, err := Load(options); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", name, tt.expected, err)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := Default()
+	c.Agent.MaxRounds = 0
+	c.Server.Port = 0
+	c.Context.Format = "xml"
+	err := c.Validate()
+	if err == nil || strings.Count(err.Error(), "\n") != 3 {
+		t.Errorf("Expected three problems, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	options := testOptions(t)
+	writeConfig(t, options.UserFile, "agent:\n  model: claude-3-5-haiku-20241022\n")
+	running, err := Load(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, options.UserFile, "agent:\n  model: claude-3-opus-20240229\n  tools:\n    disabled: [run_with_capture]\nserver:\n  port: 9999\n")
+	next, err := Load(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, restart := running.Reload(next)
+	if !reflect.DeepEqual(changed, []string{"agent.model", "agent.tools.disabled"}) || !reflect.DeepEqual(restart, []string{"server.port"}) {
+		t.Errorf("Unexpected reload %v, %v", changed, restart)
+	}
+	if running.Agent.Model != "claude-3-opus-20240229" || running.Server.Port != 8080 {
+		t.Errorf("Expected only the reloadable settings taken, got %+v", running)
+	}
+	if settings := running.AgentSettings(); !reflect.DeepEqual(settings.DisabledTools, []string{"run_with_capture"}) {
+		t.Errorf("Unexpected agent settings %+v", settings)
+	}
+}
+
+func TestShowRoundTrips(t *testing.T) {
+	options := testOptions(t)
+	options.Environ = []string{"STACKAGENT_EMBEDDINGS_URL=https://api.example.com/v1", "STACKAGENT_EMBEDDINGS_API_KEY=sk-secret"}
+	options.Args = []string{"-disable-tools", "write_file,edit_file", "-gui", "/opt/stack agent/gui"}
+	c, err := Load(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	c.Show(&b)
+	shown := b.String()
+	if strings.Contains(shown, "sk-secret") {
+		t.Error("Expected the API key to be masked")
+	}
+	if !strings.Contains(shown, "  disabled: [write_file, edit_file] # flag -disable-tools") {
+		t.Errorf("Expected values commented with their source, got:\n%s", shown)
+	}
+
+	// What Show writes is a valid config file with the same values
+	shown = strings.Replace(shown, `"********"`, "sk-secret", 1)
+	path := filepath.Join(t.TempDir(), "shown.yaml")
+	writeConfig(t, path, shown)
+	reloaded, err := Load(Options{UserFile: path, ProjectDir: t.TempDir(), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Failed to load what Show wrote: %v\n%s", err, shown)
+	}
+	if changed, restart := reloaded.Reload(c); len(changed)+len(restart) != 0 {
+		t.Errorf("Expected the same configuration back, got differences in %v %v", changed, restart)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	values, err := parseYAML([]byte(`---
+a:
+  b: "quoted # not a comment" # a comment
+  c: 'it''s'
+  list:
+  - one
+  - "two, three"
+  empty:
+  flow: [x, 'y', "z"]
+d: plain value: with colon
+e: ~
+`))
+	if err != nil {
+		t.Fatalf("parseYAML failed: %v", err)
+	}
+	expected := map[string]string{
+		"a.b":     "quoted # not a comment",
+		"a.c":     "it's",
+		"a.list":  "one,two, three",
+		"a.empty": "",
+		"a.flow":  "x,y,z",
+		"d":       "plain value: with colon",
+		"e":       "",
+	}
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d keys, got %v", len(expected), values)
+	}
+	for key, value := range expected {
+		if values[key].String() != value {
+			t.Errorf("Expected %s = %q, got %q", key, value, values[key].String())
+		}
+	}
+	if !values["a.list"].isList || len(values["a.list"].list) != 2 {
+		t.Errorf("Expected a two item list, got %+v", values["a.list"])
+	}
+
+	for _, invalid := range []string{"- orphan\n", "a: [1, [2]]\n", "a: &anchor x\n", "a:\n  - x\n  b: y\n", "just text\n", "a: \"open\n"} {
+		if _, err := parseYAML([]byte(invalid)); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
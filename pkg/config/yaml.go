@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlValue is a scalar or a list of scalars of a config file
+type yamlValue struct {
+	scalar string
+	list   []string
+	isList bool
+	line   int
+}
+
+// String renders a list comma-separated, as env vars and flags give lists
+func (v yamlValue) String() string {
+	if v.isList {
+		return strings.Join(v.list, ",")
+	}
+	return v.scalar
+}
+
+// parseYAML parses the YAML config files use into dotted keys, e.g.
+// "agent.tools.disabled". It supports nested mappings, plain and quoted
+// scalars, and block and flow lists of scalars; anchors, multi-line
+// strings and lists of mappings are rejected.
+func parseYAML(data []byte) (map[string]yamlValue, error) {
+	type frame struct {
+		indent int
+		path   string
+	}
+	values := make(map[string]yamlValue)
+	stack := []frame{{indent: -1}}
+	pending, pendingIndent := "", 0 // The last key without a value
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		line := i + 1
+		raw = strings.TrimRight(raw, " \r")
+		text := strings.TrimSpace(stripComment(raw))
+		if text == "" || (i == 0 && text == "---") {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if strings.HasPrefix(strings.TrimLeft(raw, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't indent YAML", line)
+		}
+
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			value, ok := values[pending]
+			if pending == "" || indent < pendingIndent || (!value.isList && value.scalar != "") {
+				return nil, fmt.Errorf("line %d: list item without a key", line)
+			}
+			item, err := parseScalar(strings.TrimSpace(text[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if !ok {
+				value.line = line
+			}
+			value.isList = true
+			value.list = append(value.list, item)
+			values[pending] = value
+			continue
+		}
+
+		if pending != "" && indent > pendingIndent {
+			// The key without a value is a mapping
+			if values[pending].isList {
+				return nil, fmt.Errorf("line %d: lists of mappings are not supported", line)
+			}
+			delete(values, pending)
+			stack = append(stack, frame{indent: pendingIndent, path: pending})
+		}
+		pending = ""
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		key, rest, ok := splitKey(text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\", got %q", line, text)
+		}
+		path := key
+		if parent := stack[len(stack)-1].path; parent != "" {
+			path = parent + "." + key
+		}
+		if _, exists := values[path]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", line, path)
+		}
+
+		switch {
+		case rest == "":
+			pending, pendingIndent = path, indent
+			values[path] = yamlValue{line: line}
+		case strings.HasPrefix(rest, "["):
+			list, err := parseFlowList(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			values[path] = yamlValue{list: list, isList: true, line: line}
+		default:
+			scalar, err := parseScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			values[path] = yamlValue{scalar: scalar, line: line}
+		}
+	}
+	return values, nil
+}
+
+// splitKey splits "key: value" at the colon
+func splitKey(text string) (string, string, bool) {
+	colon := strings.Index(text, ":")
+	for colon >= 0 && colon+1 < len(text) && text[colon+1] != ' ' {
+		next := strings.Index(text[colon+1:], ":")
+		if next < 0 {
+			return "", "", false
+		}
+		colon += next + 1
+	}
+	if colon <= 0 {
+		return "", "", false
+	}
+	key := strings.TrimSpace(text[:colon])
+	if strings.ContainsAny(key, " \"'{}[]&*!|>%@`") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[colon+1:]), true
+}
+
+// stripComment cuts a comment off a line, minding quotes
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseScalar unquotes a scalar; ~ and null are empty
+func parseScalar(text string) (string, error) {
+	switch {
+	case text == "" || text == "~" || text == "null":
+		return "", nil
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.ContainsAny(text[:1], "&*!|>{@`%"):
+		return "", fmt.Errorf("unsupported YAML %q", text)
+	}
+	return text, nil
+}
+
+// parseFlowList parses [a, "b", 'c']
+func parseFlowList(text string) ([]string, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unterminated list %s", text)
+	}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	list := []string{}
+	if inner == "" {
+		return list, nil
+	}
+
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c == '[' || c == ']':
+				return nil, fmt.Errorf("nested lists are not supported")
+			case c != ',':
+				continue
+			}
+		}
+		item, err := parseScalar(strings.TrimSpace(inner[start:i]))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		start = i + 1
+	}
+	return list, nil
+}
+
+// quoteScalar renders a value so parseScalar reads it back
+func quoteScalar(value string) string {
+	if value == "" || value != strings.TrimSpace(value) || strings.ContainsAny(value, ":#,[]{}\"'\\\n\t") ||
+		strings.ContainsAny(value[:1], "&*!|>@`%-?~") || value == "null" {
+		return strconv.Quote(value)
+	}
+	return value
+}
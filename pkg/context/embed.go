@@ -0,0 +1,202 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Embedder turns text into vectors for semantic recall. Vectors from
+// different embedders are not comparable, so Name must identify both the
+// model and anything that changes its output.
+type Embedder interface {
+	Name() string
+	Embed(texts []string) ([][]float32, error)
+}
+
+// Default size of HashEmbedder vectors
+const defaultHashDimensions = 512
+
+// HashEmbedder is a pure-Go embedder that hashes word, word-pair and
+// character trigram features into a fixed-size vector. It needs no network
+// or model files, and catches related wording ("refresh token rotation" vs
+// "rotating refresh tokens") that exact keyword search misses.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+// NewHashEmbedder creates a local embedder (0 means defaultHashDimensions)
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = defaultHashDimensions
+	}
+	return &HashEmbedder{Dimensions: dimensions}
+}
+
+func (e *HashEmbedder) Name() string {
+	return fmt.Sprintf("hash-ngram-v1-%d", e.Dimensions)
+}
+
+func (e *HashEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+// Feature weights for HashEmbedder
+const (
+	wordWeight    = 1.0
+	bigramWeight  = 0.7
+	trigramWeight = 0.4
+)
+
+func (e *HashEmbedder) embed(text string) []float32 {
+	vec := make([]float32, e.Dimensions)
+	words := tokenize(text)
+	for i, word := range words {
+		e.add(vec, "w:"+word, wordWeight)
+		if i > 0 {
+			e.add(vec, "b:"+words[i-1]+" "+word, bigramWeight)
+		}
+		// Trigrams with boundary markers match across inflections and typos
+		padded := []rune("^" + word + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			e.add(vec, "t:"+string(padded[j:j+3]), trigramWeight)
+		}
+	}
+	normalize(vec)
+	return vec
+}
+
+// add hashes a feature to a bucket, with a hash-derived sign so unrelated
+// features that share a bucket tend to cancel out rather than add up
+func (e *HashEmbedder) add(vec []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	bucket := sum % uint64(len(vec))
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vec[bucket] += weight
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint (OpenAI,
+// Ollama, LM Studio, vLLM, ...)
+type OpenAIEmbedder struct {
+	BaseURL    string // e.g. https://api.openai.com/v1 or http://localhost:11434/v1
+	APIKey     string // Optional for local servers
+	Model      string
+	httpClient *http.Client
+}
+
+// NewOpenAIEmbedder creates an embedder for an OpenAI-compatible endpoint
+func NewOpenAIEmbedder(baseURL, apiKey, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Model:   model,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return "openai-" + e.Model
+}
+
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model": e.Model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embeddings request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", e.BaseURL+"/embeddings", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embeddings endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embeddings response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings endpoint returned status %d: %s", resp.StatusCode, string(data))
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse embeddings response: %w", err)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings endpoint returned %d vectors for %d inputs", len(parsed.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings endpoint returned invalid index %d", d.Index)
+		}
+		normalize(d.Embedding)
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// normalize scales vec to unit length so cosine similarity is a dot product
+func normalize(vec []float32) {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+// cosine returns the cosine similarity of two unit vectors
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
@@ -0,0 +1,79 @@
+package context
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHashEmbedderSimilarity(t *testing.T) {
+	e := NewHashEmbedder(0)
+	vectors, err := e.Embed([]string{
+		"rotating refresh tokens on every use",
+		"refresh token rotation policy",
+		"postgres connection pool size",
+	})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(vectors[0]) != defaultHashDimensions {
+		t.Fatalf("Expected %d dimensions, got %d", defaultHashDimensions, len(vectors[0]))
+	}
+
+	related := cosine(vectors[0], vectors[1])
+	unrelated := cosine(vectors[0], vectors[2])
+	if related <= unrelated {
+		t.Errorf("Expected related texts to be more similar (%f) than unrelated ones (%f)", related, unrelated)
+	}
+	if self := cosine(vectors[0], vectors[0]); self < 0.999 || self > 1.001 {
+		t.Errorf("Expected unit vectors, got self-similarity %f", self)
+	}
+
+	again, _ := e.Embed([]string{"rotating refresh tokens on every use"})
+	if cosine(vectors[0], again[0]) < 0.999 {
+		t.Error("Expected embeddings to be deterministic")
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("Expected /v1/embeddings, got %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", auth)
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "test-model" || len(req.Input) != 2 {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		// Returned out of order to check indices are honoured
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,2]},{"index":0,"embedding":[3,0]}]}`))
+	}))
+	defer server.Close()
+
+	e := NewOpenAIEmbedder(server.URL+"/v1/", "secret", "test-model")
+	vectors, err := e.Embed([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Expected normalized vectors in input order, got %v", vectors)
+	}
+	if e.Name() != "openai-test-model" {
+		t.Errorf("Expected name openai-test-model, got %s", e.Name())
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such model", http.StatusNotFound)
+	}))
+	defer failing.Close()
+	if _, err := NewOpenAIEmbedder(failing.URL, "", "x").Embed([]string{"a"}); err == nil {
+		t.Error("Expected error for a failing endpoint")
+	}
+}
@@ -2,9 +2,12 @@ package context
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,6 +15,24 @@ import (
 // GitContextManager handles Git-specific context operations
 type GitContextManager struct {
 	*ContextManager
+	inherit      BranchInheritance
+	mergeOptions MergeOptions // Used when the post-merge hook merges contexts
+}
+
+// SetMergeOptions selects how the post-merge hook merges the merged
+// branch's context. By default conflicting entries keep both values.
+func (gcm *GitContextManager) SetMergeOptions(opts MergeOptions) {
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
+	
+	gcm.mergeOptions = opts
+}
+
+// BranchInheritance selects what a new branch context copies from the
+// context of the branch it was created from
+type BranchInheritance struct {
+	Knowledge bool
+	Memory    bool
 }
 
 // NewGitContextManager creates a context manager with Git integration
@@ -22,15 +43,23 @@ func NewGitContextManager(basePath string) *GitContextManager {
 	
 	return &GitContextManager{
 		ContextManager: NewContextManager(basePath),
+		inherit:        BranchInheritance{Knowledge: true},
 	}
 }
 
+// SetBranchInheritance selects what new branch contexts inherit from their
+// parent branch. By default they inherit knowledge but not memory.
+func (gcm *GitContextManager) SetBranchInheritance(inherit BranchInheritance) {
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
+	
+	gcm.inherit = inherit
+}
+
 // OnGitCheckout handles context restoration when switching Git branches
 func (gcm *GitContextManager) OnGitCheckout(from, to string) error {
 	// Save current context with branch info
 	if from != "" {
-		gcm.context.Metadata.GitBranch = from
-		gcm.context.Metadata.GitCommit = gcm.getCurrentCommit()
 		if err := gcm.SaveContextFor(from); err != nil {
 			return fmt.Errorf("failed to save context for branch %s: %w", from, err)
 		}
@@ -44,22 +73,192 @@ func (gcm *GitContextManager) OnGitCheckout(from, to string) error {
 		}
 		fmt.Printf("🔄 Restored AI context from branch: %s\n", to)
 	} else {
+		// Start from what the branch this one was created from knew
+		parent := gcm.findParentBranch(from, to)
+		var inherited *Context
+		if parent != "" {
+			ctx, err := gcm.readBranchContext(parent)
+			if err != nil {
+				return fmt.Errorf("failed to read context of parent branch %s: %w", parent, err)
+			}
+			inherited = ctx
+		}
+		
+		gcm.mutex.Lock()
+		
 		// Create new context for this branch
 		gcm.context.Metadata.GitBranch = to
 		gcm.context.Metadata.GitCommit = gcm.getCurrentCommit()
+		gcm.context.Metadata.ParentBranch = parent
 		gcm.context.Memory = make(map[string]string) // Fresh memory for new branch
+		gcm.context.MemoryMeta = make(map[string]EntryMeta)
+		
+		switch {
+		case !gcm.inherit.Knowledge:
+			gcm.context.Knowledge = make(map[string]string)
+			gcm.context.KnowledgeMeta = make(map[string]EntryMeta)
+		case inherited != nil:
+			gcm.context.Knowledge = inherited.Knowledge
+			gcm.context.KnowledgeMeta = inherited.KnowledgeMeta
+		}
+		// Without a parent the knowledge of the branch we came from is kept
+		if inherited != nil && gcm.inherit.Memory {
+			gcm.context.Memory = inherited.Memory
+			gcm.context.MemoryMeta = inherited.MemoryMeta
+		}
 		
 		// But keep some workspace state
 		gcm.context.Workspace.ActiveFiles = []string{}
 		gcm.context.Workspace.CommandHistory = []CommandRecord{}
 		gcm.context.Workspace.ActiveHandles = []uint64{}
+		gcm.revision++
 		
-		fmt.Printf("🆕 Created new context for branch: %s\n", to)
+		gcm.mutex.Unlock()
+		if parent != "" {
+			fmt.Printf("🆕 Created new context for branch: %s (inherited from %s)\n", to, parent)
+		} else {
+			fmt.Printf("🆕 Created new context for branch: %s\n", to)
+		}
 	}
 	
 	return nil
 }
 
+// findParentBranch picks the branch with saved context that branch was most
+// recently created from: the one whose merge-base with HEAD is closest to
+// HEAD. Ties go to the branch that moved least since the merge-base (usually
+// the one branched from rather than a sibling), then to a branch the other
+// was itself created from, then to from. Returns "" if no branch with
+// context shares history with HEAD.
+func (gcm *GitContextManager) findParentBranch(from, branch string) string {
+	type candidate struct {
+		name          string
+		parent        string
+		behind, ahead int
+	}
+	better := func(c, best *candidate) bool {
+		switch {
+		case c.behind != best.behind:
+			return c.behind < best.behind
+		case c.ahead != best.ahead:
+			return c.ahead < best.ahead
+		case best.parent == c.name || c.parent == best.name:
+			return best.parent == c.name
+		}
+		return c.name == from
+	}
+	
+	var best *candidate
+	for _, name := range gcm.ListBranches() {
+		if name == branch {
+			continue
+		}
+		base, err := gitOutput("merge-base", "HEAD", "refs/heads/"+name)
+		if err != nil {
+			continue
+		}
+		behind, err1 := gitCount(base + "..HEAD")
+		ahead, err2 := gitCount(base + "..refs/heads/" + name)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		
+		var meta ContextMetadata
+		loadJSONFile(filepath.Join(gcm.getBranchContextPath(name), "metadata.json"), &meta)
+		c := &candidate{name: name, parent: meta.ParentBranch, behind: behind, ahead: ahead}
+		if best == nil || better(c, best) {
+			best = c
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.name
+}
+
+// readBranchContext reads the saved context of a branch
+func (gcm *GitContextManager) readBranchContext(branch string) (*Context, error) {
+	branchPath := gcm.getBranchContextPath(branch)
+	release, err := openContextDir(branchPath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	
+	ctx := &Context{}
+	if err := readContext(branchPath, ctx); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// gitOutput runs git in the current directory and returns its trimmed output
+func gitOutput(args ...string) (string, error) {
+	output, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitCount counts the commits in a revision range
+func gitCount(revisions string) (int, error) {
+	output, err := gitOutput("rev-list", "--count", revisions)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(output)
+}
+
+// AttachWorkspace loads the persisted context for the current directory and,
+// if the Git branch changed while nothing was running, switches to the
+// context of the branch that is now checked out
+func (gcm *GitContextManager) AttachWorkspace() error {
+	if err := gcm.LoadContext(); err != nil {
+		return err
+	}
+	
+	if gcm.isGitRepository() {
+		current := gcm.getCurrentBranch()
+		if current == "HEAD" {
+			// Detached HEAD: keep the context of the branch we left
+			return gcm.recordWorkingDir()
+		}
+		gcm.mutex.RLock()
+		previous := gcm.context.Metadata.GitBranch
+		gcm.mutex.RUnlock()
+		
+		if previous != "" && current != "" && previous != current {
+			if err := gcm.OnGitCheckout(previous, current); err != nil {
+				return err
+			}
+		}
+		
+		gcm.mutex.Lock()
+		gcm.context.Metadata.GitBranch = current
+		gcm.context.Metadata.GitCommit = gcm.getCurrentCommit()
+		gcm.mutex.Unlock()
+	}
+	
+	return gcm.recordWorkingDir()
+}
+
+// recordWorkingDir records the current directory in the workspace state and
+// saves the context
+func (gcm *GitContextManager) recordWorkingDir() error {
+	ws := gcm.GetWorkspace()
+	if wd, err := os.Getwd(); err == nil {
+		ws.WorkingDir = wd
+	}
+	return gcm.UpdateWorkspace(ws)
+}
+
+// CurrentBranch returns the checked out Git branch, "HEAD" when detached,
+// or "" outside a repository
+func (gcm *GitContextManager) CurrentBranch() string {
+	return gcm.getCurrentBranch()
+}
+
 // ContextExistsAt checks if context exists for a specific branch
 func (gcm *GitContextManager) ContextExistsAt(branch string) bool {
 	branchPath := gcm.getBranchContextPath(branch)
@@ -73,33 +272,35 @@ func (gcm *GitContextManager) ContextExistsAt(branch string) bool {
 func (gcm *GitContextManager) LoadContextFrom(branch string) error {
 	branchPath := gcm.getBranchContextPath(branch)
 	
-	// Clear existing context before loading new branch context
-	gcm.context.Memory = make(map[string]string)
-	gcm.context.Knowledge = make(map[string]string)
-	gcm.context.Workspace = WorkspaceState{
-		ActiveFiles:    []string{},
-		CommandHistory: []CommandRecord{},
-		ActiveHandles:  []uint64{},
-		LastActivity:   time.Now(),
-	}
-	
-	// Temporarily change basePath to load from branch-specific directory
-	originalBasePath := gcm.basePath
-	gcm.basePath = branchPath
-	
-	err := gcm.LoadContext()
-	
-	// Restore original basePath
-	gcm.basePath = originalBasePath
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
 	
+	release, err := openContextDir(branchPath, false)
 	if err != nil {
 		return err
 	}
+	defer release()
+	
+	// Read into a fresh context so a failed load leaves the current one intact
+	loaded := &Context{
+		Workspace: WorkspaceState{
+			ActiveFiles:    []string{},
+			CommandHistory: []CommandRecord{},
+			ActiveHandles:  []uint64{},
+			LastActivity:   time.Now(),
+		},
+		Metadata: gcm.context.Metadata,
+	}
+	if err := readContext(branchPath, loaded); err != nil {
+		return err
+	}
 	
 	// Update metadata
-	gcm.context.Metadata.GitBranch = branch
-	gcm.context.Metadata.GitCommit = gcm.getCurrentCommit()
+	loaded.Metadata.GitBranch = branch
+	loaded.Metadata.GitCommit = gcm.getCurrentCommit()
 	
+	gcm.context = loaded
+	gcm.revision++
 	return nil
 }
 
@@ -112,29 +313,43 @@ func (gcm *GitContextManager) SaveContextFor(branch string) error {
 		return fmt.Errorf("failed to create branch context directory: %w", err)
 	}
 	
-	// Temporarily change basePath to save to branch-specific directory
-	originalBasePath := gcm.basePath
-	gcm.basePath = branchPath
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
 	
 	// Update Git metadata
 	gcm.context.Metadata.GitBranch = branch
 	gcm.context.Metadata.GitCommit = gcm.getCurrentCommit()
 	
-	err := gcm.SaveContext()
-	
-	// Restore original basePath
-	gcm.basePath = originalBasePath
-	
-	return err
+	return gcm.saveToUnsafe(branchPath)
 }
 
 // getBranchContextPath returns the path for branch-specific context
 func (gcm *GitContextManager) getBranchContextPath(branch string) string {
-	// Clean branch name for filesystem
-	cleanBranch := strings.ReplaceAll(branch, "/", "_")
-	cleanBranch = strings.ReplaceAll(cleanBranch, "\\", "_")
-	
-	return filepath.Join(gcm.basePath, "branches", cleanBranch)
+	return filepath.Join(gcm.basePath, "branches", encodeBranchName(branch))
+}
+
+// encodeBranchName maps a branch name to a directory name that
+// decodeBranchName maps back. Letters, digits, '-', '_' and '.' are kept
+// (except a leading '.'); every other byte, including '/' and '%', is
+// percent-encoded.
+func encodeBranchName(branch string) string {
+	var b strings.Builder
+	for i := 0; i < len(branch); i++ {
+		c := branch[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeBranchName reverses encodeBranchName
+func decodeBranchName(name string) (string, error) {
+	return url.PathUnescape(name)
 }
 
 // getCurrentBranch returns the current Git branch
@@ -165,45 +380,6 @@ func (gcm *GitContextManager) isGitRepository() bool {
 	return err == nil
 }
 
-// InitializeGitHooks sets up Git hooks for automatic context switching
-func (gcm *GitContextManager) InitializeGitHooks() error {
-	if !gcm.isGitRepository() {
-		return fmt.Errorf("not a Git repository")
-	}
-	
-	// Create post-checkout hook
-	hookPath := ".git/hooks/post-checkout"
-	hookContent := `#!/bin/bash
-# StackAgent Context Management Hook
-# This hook automatically switches AI context when changing branches
-
-PREVIOUS_HEAD=$1
-NEW_HEAD=$2
-BRANCH_SWITCH=$3
-
-if [ "$BRANCH_SWITCH" = "1" ]; then
-    # Get branch names
-    PREVIOUS_BRANCH=$(git name-rev --name-only $PREVIOUS_HEAD 2>/dev/null || echo "")
-    NEW_BRANCH=$(git name-rev --name-only $NEW_HEAD 2>/dev/null || echo "")
-    
-    # Only try to restore context if stackagent-cli is available
-    if command -v stackagent-cli &> /dev/null; then
-        echo "🔄 StackAgent: Switching context from $PREVIOUS_BRANCH to $NEW_BRANCH"
-        # Note: This would call stackagent-cli with context restore command
-        # For now, just notify the user
-        echo "💡 Run 'stackagent-cli context restore' to restore AI context"
-    fi
-fi
-`
-	
-	if err := os.WriteFile(hookPath, []byte(hookContent), 0755); err != nil {
-		return fmt.Errorf("failed to create post-checkout hook: %w", err)
-	}
-	
-	fmt.Printf("✅ Git hooks initialized for context switching\n")
-	return nil
-}
-
 // ListBranches returns all branches that have context
 func (gcm *GitContextManager) ListBranches() []string {
 	branchesPath := filepath.Join(gcm.basePath, "branches")
@@ -216,11 +392,15 @@ func (gcm *GitContextManager) ListBranches() []string {
 	for _, entry := range entries {
 		if entry.IsDir() {
 			// Convert filesystem-safe name back to branch name
-			branch := strings.ReplaceAll(entry.Name(), "_", "/")
+			branch, err := decodeBranchName(entry.Name())
+			if err != nil {
+				continue // Not a branch context
+			}
 			branches = append(branches, branch)
 		}
 	}
 	
+	sort.Strings(branches)
 	return branches
 }
 
@@ -245,23 +425,23 @@ func (gcm *GitContextManager) GetBranchStats() map[string]ContextStats {
 
 // CleanupBranches removes context for branches that no longer exist
 func (gcm *GitContextManager) CleanupBranches() error {
-	// Get all Git branches
-	cmd := exec.Command("git", "branch", "-a")
-	output, err := cmd.Output()
+	// Get all local and remote-tracking Git branches, including the
+	// current one
+	output, err := gitOutput("for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes")
 	if err != nil {
 		return fmt.Errorf("failed to get Git branches: %w", err)
 	}
 	
 	// Parse branch names
 	gitBranches := make(map[string]bool)
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "*") {
-			// Clean up branch name
-			branch := strings.TrimPrefix(line, "remotes/origin/")
-			branch = strings.TrimPrefix(branch, "* ")
+	for _, ref := range strings.Split(output, "\n") {
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
 			gitBranches[branch] = true
+		} else if remote, ok := strings.CutPrefix(ref, "refs/remotes/"); ok {
+			// Drop the remote name: origin/feature/x is feature/x
+			if _, branch, ok := strings.Cut(remote, "/"); ok && branch != "HEAD" {
+				gitBranches[branch] = true
+			}
 		}
 	}
 	
@@ -300,17 +480,16 @@ func (gcm *GitContextManager) SyncWithGit() error {
 	currentBranch := gcm.getCurrentBranch()
 	currentCommit := gcm.getCurrentCommit()
 	
-	// Update metadata
-	gcm.context.Metadata.GitBranch = currentBranch
-	gcm.context.Metadata.GitCommit = currentCommit
-	
-	// Save context for current branch
+	// Save context for current branch (this records the branch and commit)
 	if err := gcm.SaveContextFor(currentBranch); err != nil {
 		return fmt.Errorf("failed to save context for current branch: %w", err)
 	}
 	
+	if len(currentCommit) > 8 {
+		currentCommit = currentCommit[:8]
+	}
 	fmt.Printf("🔄 Context synchronized with Git (branch: %s, commit: %s)\n", 
-		currentBranch, currentCommit[:8])
+		currentBranch, currentCommit)
 	
 	return nil
 } 
\ No newline at end of file
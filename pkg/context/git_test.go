@@ -3,7 +3,10 @@ package context
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,14 +41,14 @@ func TestGetBranchContextPath(t *testing.T) {
 	
 	// Test branch with slash
 	path = gcm.getBranchContextPath("feature/user-auth")
-	expected = filepath.Join(tempDir, "branches", "feature_user-auth")
+	expected = filepath.Join(tempDir, "branches", "feature%2Fuser-auth")
 	if path != expected {
 		t.Errorf("Expected path %s, got %s", expected, path)
 	}
 	
 	// Test branch with backslash
 	path = gcm.getBranchContextPath("hotfix\\bug-fix")
-	expected = filepath.Join(tempDir, "branches", "hotfix_bug-fix")
+	expected = filepath.Join(tempDir, "branches", "hotfix%5Cbug-fix")
 	if path != expected {
 		t.Errorf("Expected path %s, got %s", expected, path)
 	}
@@ -68,7 +71,7 @@ func TestSaveContextFor(t *testing.T) {
 	}
 	
 	// Check that branch directory was created
-	branchPath := filepath.Join(tempDir, "branches", "feature_test")
+	branchPath := filepath.Join(tempDir, "branches", "feature%2Ftest")
 	if _, err := os.Stat(branchPath); os.IsNotExist(err) {
 		t.Errorf("Expected branch directory %s to exist", branchPath)
 	}
@@ -464,4 +467,182 @@ func TestEmptyBranchSwitch(t *testing.T) {
 	if len(gcm.context.Memory) != 0 {
 		t.Errorf("Expected empty memory for new branch, got %d entries", len(gcm.context.Memory))
 	}
-} 
\ No newline at end of file
+} 
+func TestBranchNameEncoding(t *testing.T) {
+	names := []string{"main", "feature/my_thing", "feature_my/thing", "a%2Fb", ".hidden", "fix\\path", "ünï/code"}
+	seen := make(map[string]string)
+	for _, name := range names {
+		encoded := encodeBranchName(name)
+		if strings.ContainsAny(encoded, "/\\") || strings.HasPrefix(encoded, ".") {
+			t.Errorf("Encoding of %q is not a safe directory name: %q", name, encoded)
+		}
+		if other, ok := seen[encoded]; ok {
+			t.Errorf("%q and %q both encode to %q", name, other, encoded)
+		}
+		seen[encoded] = name
+		
+		decoded, err := decodeBranchName(encoded)
+		if err != nil || decoded != name {
+			t.Errorf("Expected %q to round-trip, got %q (%v)", name, decoded, err)
+		}
+	}
+}
+
+func TestListBranchesKeepsUnderscores(t *testing.T) {
+	tempDir := t.TempDir()
+	gcm := NewGitContextManager(tempDir)
+	
+	for _, branch := range []string{"feature/my_thing", "feature_my/thing"} {
+		if err := gcm.SaveContextFor(branch); err != nil {
+			t.Fatalf("SaveContextFor failed: %v", err)
+		}
+	}
+	
+	branches := gcm.ListBranches()
+	expected := []string{"feature/my_thing", "feature_my/thing"}
+	if !reflect.DeepEqual(branches, expected) {
+		t.Errorf("Expected %v, got %v", expected, branches)
+	}
+}
+
+func TestMigrateBranchDirectoryNames(t *testing.T) {
+	tempDir := t.TempDir()
+	write := func(path, content string) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(tempDir, "metadata.json"), `{"version": "2.0", "storage": "json"}`)
+	// The metadata knows the real name; the old directory name lost it
+	write(filepath.Join(tempDir, "branches", "feature_my_thing", "metadata.json"),
+		`{"version": "2.0", "git_branch": "feature/my_thing"}`)
+	write(filepath.Join(tempDir, "branches", "hotfix_x", "metadata.json"), `{"version": "2.0"}`)
+	
+	gcm := NewGitContextManager(tempDir)
+	if err := gcm.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	
+	expected := []string{"feature/my_thing", "hotfix/x"}
+	if branches := gcm.ListBranches(); !reflect.DeepEqual(branches, expected) {
+		t.Errorf("Expected %v, got %v", expected, branches)
+	}
+	for _, branch := range expected {
+		if !gcm.ContextExistsAt(branch) {
+			t.Errorf("Expected context for %s after migration", branch)
+		}
+	}
+}
+
+// initGitRepo creates a Git repository with one commit on main and makes it
+// the working directory for the rest of the test
+func initGitRepo(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	
+	repo := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(env, "test")
+	}
+	for _, env := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(env, "test@example.com")
+	}
+	runGit(t, "init", "-q", "-b", "main")
+	commitFile(t, "README", "hello")
+}
+
+func runGit(t *testing.T, args ...string) {
+	t.Helper()
+	if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+}
+
+func commitFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", name)
+	runGit(t, "commit", "-q", "-m", "update "+name)
+}
+
+func TestOnGitCheckoutInheritsFromParent(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+	
+	// A sibling that branched off main before main moved on
+	runGit(t, "branch", "older")
+	gcm.SetKnowledge("style", "older style")
+	if err := gcm.SaveContextFor("older"); err != nil {
+		t.Fatalf("SaveContextFor failed: %v", err)
+	}
+	
+	commitFile(t, "main.go", "package main")
+	gcm.SetKnowledge("style", "wrap errors with %w")
+	gcm.SetProtected("decision", "use sqlite")
+	if err := gcm.SaveContextFor("main"); err != nil {
+		t.Fatalf("SaveContextFor failed: %v", err)
+	}
+	
+	// Switch away to the sibling, then create the feature branch from main
+	if err := gcm.LoadContextFrom("older"); err != nil {
+		t.Fatalf("LoadContextFrom failed: %v", err)
+	}
+	runGit(t, "checkout", "-q", "-b", "feature/x", "main")
+	if err := gcm.OnGitCheckout("older", "feature/x"); err != nil {
+		t.Fatalf("OnGitCheckout failed: %v", err)
+	}
+	
+	if value, _ := gcm.GetKnowledge("style"); value != "wrap errors with %w" {
+		t.Errorf("Expected knowledge inherited from main, got %q", value)
+	}
+	if _, ok := gcm.GetProtected("decision"); ok {
+		t.Error("Expected memory not to be inherited by default")
+	}
+	if parent := gcm.GetContext().Metadata.ParentBranch; parent != "main" {
+		t.Errorf("Expected parent branch main, got %q", parent)
+	}
+	
+	// With memory inheritance on, memory comes along too
+	gcm.SetBranchInheritance(BranchInheritance{Knowledge: true, Memory: true})
+	runGit(t, "checkout", "-q", "-b", "feature/y", "main")
+	if err := gcm.OnGitCheckout("feature/x", "feature/y"); err != nil {
+		t.Fatalf("OnGitCheckout failed: %v", err)
+	}
+	if value, _ := gcm.GetProtected("decision"); value != "use sqlite" {
+		t.Errorf("Expected memory inherited from main, got %q", value)
+	}
+}
+
+func TestCleanupBranchesKeepsLiveBranches(t *testing.T) {
+	initGitRepo(t)
+	runGit(t, "branch", "feature/my_thing")
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+	
+	for _, branch := range []string{"main", "feature/my_thing", "gone"} {
+		if err := gcm.SaveContextFor(branch); err != nil {
+			t.Fatalf("SaveContextFor failed: %v", err)
+		}
+	}
+	if err := gcm.CleanupBranches(); err != nil {
+		t.Fatalf("CleanupBranches failed: %v", err)
+	}
+	
+	expected := []string{"feature/my_thing", "main"}
+	if branches := gcm.ListBranches(); !reflect.DeepEqual(branches, expected) {
+		t.Errorf("Expected %v to remain, got %v", expected, branches)
+	}
+}
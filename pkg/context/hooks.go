@@ -0,0 +1,192 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// hookMarker identifies hooks written by InitializeGitHooks
+	hookMarker = "# StackAgent context hook"
+
+	// An existing hook is kept under this suffix and run before ours
+	chainedHookSuffix = ".pre-stackagent"
+)
+
+// gitHooks are the hooks InitializeGitHooks installs
+var gitHooks = []string{"post-checkout", "post-commit", "post-merge", "post-rewrite"}
+
+// hookScript runs the hook it replaced, then `stackagent context hook`.
+// STACKAGENT overrides the command. The hook's exit status is that of the
+// replaced hook; a failing context update only prints a warning.
+const hookScript = `#!/bin/sh
+` + hookMarker + `: keeps the AI context in step with Git.
+# Installed by 'stackagent context install-hooks'. A hook that was here
+# before is kept as %[1]s` + chainedHookSuffix + ` and runs first.
+
+status=0
+previous="$(dirname "$0")/%[1]s` + chainedHookSuffix + `"
+if [ -x "$previous" ]; then
+    "$previous" "$@" || status=$?
+fi
+
+stackagent="${STACKAGENT:-stackagent}"
+if command -v "$stackagent" >/dev/null 2>&1; then
+    "$stackagent" context hook %[1]s "$@" || echo "StackAgent: failed to update context" >&2
+fi
+exit $status
+`
+
+// InitializeGitHooks installs post-checkout, post-commit, post-merge and
+// post-rewrite hooks that keep the context in step with Git. Existing hooks are kept and
+// chained; installing again only refreshes our hooks. The hooks directory
+// comes from Git, so worktrees (where .git is a file) and core.hooksPath
+// work.
+func (gcm *GitContextManager) InitializeGitHooks() error {
+	if !gcm.isGitRepository() {
+		return fmt.Errorf("not a Git repository")
+	}
+
+	hooksDir, err := gitOutput("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return fmt.Errorf("failed to find Git hooks directory: %w", err)
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %w", err)
+	}
+
+	for _, name := range gitHooks {
+		if err := installHook(hooksDir, name); err != nil {
+			return fmt.Errorf("failed to install %s hook: %w", name, err)
+		}
+	}
+
+	fmt.Printf("✅ Git hooks initialized for context switching\n")
+	return nil
+}
+
+// installHook writes our hook, moving an existing foreign hook aside so
+// ours runs it first
+func installHook(hooksDir, name string) error {
+	path := filepath.Join(hooksDir, name)
+	existing, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case !strings.Contains(string(existing), hookMarker):
+		chained := path + chainedHookSuffix
+		if _, err := os.Stat(chained); err == nil {
+			return fmt.Errorf("both %s and %s exist; merge them by hand", path, chained)
+		}
+		if err := os.Rename(path, chained); err != nil {
+			return err
+		}
+		fmt.Printf("🔗 Kept existing %s hook; it now runs before StackAgent's\n", name)
+	}
+
+	if err := writeFileAtomic(path, []byte(fmt.Sprintf(hookScript, name))); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0755)
+}
+
+// RunGitHook updates the context for a Git hook, called by the installed
+// hooks with the hook's arguments:
+//
+//	post-checkout <old> <new> <flag>  switches to the new branch's context
+//	post-commit                       snapshots the context at the commit
+//	post-merge <squash>               merges the merged branch's context,
+//	                                  records and snapshots the merge commit
+//	post-rewrite <amend|rebase>       records and snapshots the rewritten commit
+func (gcm *GitContextManager) RunGitHook(hook string, args []string) error {
+	switch hook {
+	case "post-checkout":
+		if len(args) < 3 || args[2] != "1" {
+			return nil // A file checkout, not a branch switch
+		}
+		// Loads the context and switches it if the branch changed
+		return gcm.AttachWorkspace()
+
+	case "post-commit":
+		if err := gcm.LoadContext(); err != nil {
+			return err
+		}
+		_, err := gcm.TakeSnapshot()
+		return err
+
+	case "post-merge", "post-rewrite":
+		if err := gcm.LoadContext(); err != nil {
+			return err
+		}
+		branch := gcm.getCurrentBranch()
+		if branch == "" || branch == "HEAD" {
+			return nil
+		}
+		if hook == "post-merge" {
+			if err := gcm.mergeMergedBranch(branch); err != nil {
+				return err
+			}
+		}
+		if err := gcm.SyncWithGit(); err != nil {
+			return err
+		}
+		if err := gcm.SaveContext(); err != nil {
+			return err
+		}
+		_, err := gcm.TakeSnapshot()
+		return err
+
+	default:
+		return fmt.Errorf("unknown hook %q", hook)
+	}
+}
+
+// mergeMergedBranch merges the context of the branch the last merge brought
+// into branch, if it has one
+func (gcm *GitContextManager) mergeMergedBranch(branch string) error {
+	from := mergedBranch()
+	if from == "" || from == branch {
+		return nil
+	}
+	if !gcm.ContextExistsAt(from) {
+		// A remote-tracking branch (origin/feature) has its context under
+		// the branch name
+		if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/remotes/"+from); err != nil {
+			return nil
+		}
+		_, local, _ := strings.Cut(from, "/")
+		if local == branch || !gcm.ContextExistsAt(local) {
+			return nil
+		}
+		from = local
+	}
+
+	gcm.mutex.RLock()
+	opts := gcm.mergeOptions
+	gcm.mutex.RUnlock()
+
+	report, err := gcm.MergeContext(from, branch, opts)
+	if err != nil {
+		return fmt.Errorf("failed to merge context of %s: %w", from, err)
+	}
+	fmt.Printf("🔀 %s\n", report)
+	return nil
+}
+
+// mergedBranch returns the branch named by the last reflog entry if it was
+// a merge ("merge feature/x: Fast-forward"), or ""
+func mergedBranch() string {
+	subject, err := gitOutput("reflog", "-1", "--format=%gs")
+	if err != nil {
+		return ""
+	}
+	rest, ok := strings.CutPrefix(subject, "merge ")
+	if !ok {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, ":")
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,139 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitializeGitHooksChainsExistingHooks(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+
+	existing := "#!/bin/sh\necho existing\n"
+	hookPath := filepath.Join(".git", "hooks", "post-checkout")
+	os.MkdirAll(filepath.Dir(hookPath), 0755)
+	if err := os.WriteFile(hookPath, []byte(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Installing twice must not chain our hook to itself
+	for i := 0; i < 2; i++ {
+		if err := gcm.InitializeGitHooks(); err != nil {
+			t.Fatalf("InitializeGitHooks failed: %v", err)
+		}
+	}
+
+	chained, err := os.ReadFile(hookPath + chainedHookSuffix)
+	if err != nil || string(chained) != existing {
+		t.Errorf("Expected the existing hook to be kept, got %q (%v)", chained, err)
+	}
+	for _, name := range gitHooks {
+		path := filepath.Join(".git", "hooks", name)
+		data, err := os.ReadFile(path)
+		if err != nil || !strings.Contains(string(data), hookMarker) {
+			t.Errorf("Expected %s to be installed, got %q (%v)", name, data, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode()&0111 == 0 {
+			t.Errorf("Expected %s to be executable", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(".git", "hooks", "post-merge"+chainedHookSuffix)); !os.IsNotExist(err) {
+		t.Error("Expected no chained hook where none existed")
+	}
+}
+
+func TestInitializeGitHooksInWorktree(t *testing.T) {
+	initGitRepo(t)
+	main, _ := os.Getwd()
+	worktree := filepath.Join(t.TempDir(), "wt")
+	runGit(t, "worktree", "add", "-q", "-b", "wt", worktree)
+	if err := os.Chdir(worktree); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(".git"); err != nil || info.IsDir() {
+		t.Fatalf("Expected .git to be a file in a worktree")
+	}
+
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+	if err := gcm.InitializeGitHooks(); err != nil {
+		t.Fatalf("InitializeGitHooks failed: %v", err)
+	}
+	// Hooks are shared by all worktrees
+	if _, err := os.Stat(filepath.Join(main, ".git", "hooks", "post-checkout")); err != nil {
+		t.Errorf("Expected hook in the main repository: %v", err)
+	}
+}
+
+func TestRunGitHookPostCheckout(t *testing.T) {
+	initGitRepo(t)
+	contextDir := filepath.Join(t.TempDir(), "context")
+
+	gcm := NewGitContextManager(contextDir)
+	if err := gcm.AttachWorkspace(); err != nil {
+		t.Fatalf("AttachWorkspace failed: %v", err)
+	}
+	gcm.SetKnowledge("style", "wrap errors")
+	gcm.SetProtected("decision", "use sqlite")
+
+	// What the hook runs after `git checkout -b feature`
+	runGit(t, "checkout", "-q", "-b", "feature")
+	hook := NewGitContextManager(contextDir)
+	if err := hook.RunGitHook("post-checkout", []string{"a", "b", "1"}); err != nil {
+		t.Fatalf("RunGitHook failed: %v", err)
+	}
+
+	if branch := hook.GetContext().Metadata.GitBranch; branch != "feature" {
+		t.Errorf("Expected context for feature, got %q", branch)
+	}
+	if _, ok := hook.GetProtected("decision"); ok {
+		t.Error("Expected fresh memory on the new branch")
+	}
+	if value, _ := hook.GetKnowledge("style"); value != "wrap errors" {
+		t.Errorf("Expected knowledge inherited from main, got %q", value)
+	}
+	if !hook.ContextExistsAt("main") {
+		t.Error("Expected the context of main to be saved")
+	}
+
+	// The switch is persisted for the next process
+	next := NewGitContextManager(contextDir)
+	if err := next.LoadContext(); err != nil {
+		t.Fatal(err)
+	}
+	if branch := next.GetContext().Metadata.GitBranch; branch != "feature" {
+		t.Errorf("Expected saved context for feature, got %q", branch)
+	}
+
+	// File checkouts leave the context alone
+	if err := hook.RunGitHook("post-checkout", []string{"a", "b", "0"}); err != nil {
+		t.Errorf("RunGitHook for a file checkout failed: %v", err)
+	}
+}
+
+func TestRunGitHookPostMerge(t *testing.T) {
+	initGitRepo(t)
+	contextDir := filepath.Join(t.TempDir(), "context")
+	gcm := NewGitContextManager(contextDir)
+	if err := gcm.AttachWorkspace(); err != nil {
+		t.Fatalf("AttachWorkspace failed: %v", err)
+	}
+
+	commitFile(t, "merged.go", "package main")
+	hook := NewGitContextManager(contextDir)
+	if err := hook.RunGitHook("post-merge", []string{"0"}); err != nil {
+		t.Fatalf("RunGitHook failed: %v", err)
+	}
+
+	head, _ := gitOutput("rev-parse", "HEAD")
+	if commit := hook.GetContext().Metadata.GitCommit; commit != head {
+		t.Errorf("Expected commit %s, got %s", head, commit)
+	}
+	if !hook.ContextExistsAt("main") {
+		t.Error("Expected the branch context to be saved")
+	}
+	if err := hook.RunGitHook("pre-commit", nil); err == nil {
+		t.Error("Expected an error for an unknown hook")
+	}
+}
@@ -13,7 +13,22 @@ import (
 type ContextManager struct {
 	basePath string
 	context  *Context
+	format   string // Storage format; empty means whatever is on disk
 	mutex    sync.RWMutex
+	
+	revision   uint64       // Bumped whenever the context changes
+	index      *searchIndex // Search index, rebuilt when revision moves on
+	indexMutex sync.Mutex
+	
+	saveDelay time.Duration // Debounce for saves after changes; 0 saves immediately
+	saveTimer *time.Timer
+	dirty     bool  // Changes not yet written
+	saveErr   error // Error from the last debounced save
+	synced    syncState
+	
+	embedder    Embedder     // Used by Recall; nil means the local HashEmbedder
+	vectors     *vectorStore // Cached entry vectors for embedder
+	vectorMutex sync.Mutex
 }
 
 // Context represents the AI's persistent state
@@ -22,6 +37,9 @@ type Context struct {
 	Workspace WorkspaceState    `json:"workspace"` // Active files, commands, current state
 	Knowledge map[string]string `json:"knowledge"` // Learned patterns and insights
 	Metadata  ContextMetadata   `json:"metadata"`  // Session info, timestamps
+	
+	MemoryMeta    map[string]EntryMeta `json:"memory_meta,omitempty"`    // Created/updated/tags per memory key
+	KnowledgeMeta map[string]EntryMeta `json:"knowledge_meta,omitempty"` // Created/updated/tags per knowledge key
 }
 
 // WorkspaceState tracks the current working state
@@ -47,12 +65,14 @@ type CommandRecord struct {
 
 // ContextMetadata stores session and versioning information
 type ContextMetadata struct {
-	Created     time.Time `json:"created"`
-	LastUpdated time.Time `json:"last_updated"`
-	Version     string    `json:"version"`
-	SessionID   string    `json:"session_id"`
-	GitBranch   string    `json:"git_branch,omitempty"`
-	GitCommit   string    `json:"git_commit,omitempty"`
+	Created      time.Time `json:"created"`
+	LastUpdated  time.Time `json:"last_updated"`
+	Version      string    `json:"version"`
+	SessionID    string    `json:"session_id"`
+	GitBranch    string    `json:"git_branch,omitempty"`
+	GitCommit    string    `json:"git_commit,omitempty"`
+	ParentBranch string    `json:"parent_branch,omitempty"` // Branch whose context this one started from
+	Storage      string    `json:"storage,omitempty"`       // FormatJSON or FormatMarkdown
 }
 
 // NewContextManager creates a new context manager
@@ -72,9 +92,11 @@ func NewContextManager(basePath string) *ContextManager {
 				LastActivity:   time.Now(),
 			},
 			Knowledge: make(map[string]string),
+			MemoryMeta:    make(map[string]EntryMeta),
+			KnowledgeMeta: make(map[string]EntryMeta),
 			Metadata: ContextMetadata{
 				Created:   time.Now(),
-				Version:   "1.0",
+				Version:   schemaVersionString(SchemaVersion),
 				SessionID: generateSessionID(),
 			},
 		},
@@ -92,10 +114,11 @@ func (cm *ContextManager) SetProtected(key, value string) error {
 	defer cm.mutex.Unlock()
 	
 	cm.context.Memory[key] = value
+	cm.context.MemoryMeta = touchEntry(cm.context.MemoryMeta, key)
 	cm.context.Metadata.LastUpdated = time.Now()
 	
 	// Immediately save to disk
-	return cm.saveContextUnsafe()
+	return cm.commitUnsafe()
 }
 
 // GetProtected retrieves a protected memory value
@@ -125,9 +148,10 @@ func (cm *ContextManager) DeleteProtected(key string) error {
 	defer cm.mutex.Unlock()
 	
 	delete(cm.context.Memory, key)
+	delete(cm.context.MemoryMeta, key)
 	cm.context.Metadata.LastUpdated = time.Now()
 	
-	return cm.saveContextUnsafe()
+	return cm.commitUnsafe()
 }
 
 // UpdateWorkspace updates the workspace state
@@ -139,9 +163,12 @@ func (cm *ContextManager) UpdateWorkspace(ws WorkspaceState) error {
 	cm.context.Workspace.LastActivity = time.Now()
 	cm.context.Metadata.LastUpdated = time.Now()
 	
-	return cm.saveContextUnsafe()
+	return cm.commitUnsafe()
 }
 
+// Maximum number of records kept in WorkspaceState.CommandHistory
+const maxCommandHistory = 100
+
 // AddCommandRecord adds a command to the history
 func (cm *ContextManager) AddCommandRecord(cmd string, handleID uint64, exitCode int, duration time.Duration) error {
 	cm.mutex.Lock()
@@ -155,16 +182,48 @@ func (cm *ContextManager) AddCommandRecord(cmd string, handleID uint64, exitCode
 		Duration:  duration.String(),
 	}
 	
-	// Keep only last 100 commands
+	// Keep only last maxCommandHistory commands
 	cm.context.Workspace.CommandHistory = append(cm.context.Workspace.CommandHistory, record)
-	if len(cm.context.Workspace.CommandHistory) > 100 {
+	if len(cm.context.Workspace.CommandHistory) > maxCommandHistory {
 		cm.context.Workspace.CommandHistory = cm.context.Workspace.CommandHistory[1:]
 	}
 	
 	cm.context.Workspace.LastActivity = time.Now()
 	cm.context.Metadata.LastUpdated = time.Now()
 	
-	return cm.saveContextUnsafe()
+	return cm.commitUnsafe()
+}
+
+// Maximum number of files kept in WorkspaceState.ActiveFiles
+const maxActiveFiles = 50
+
+// TrackActiveFile moves path to the front of the active file list
+func (cm *ContextManager) TrackActiveFile(path string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	files := []string{path}
+	for _, f := range cm.context.Workspace.ActiveFiles {
+		if f != path && len(files) < maxActiveFiles {
+			files = append(files, f)
+		}
+	}
+	cm.context.Workspace.ActiveFiles = files
+	cm.context.Workspace.LastActivity = time.Now()
+	cm.context.Metadata.LastUpdated = time.Now()
+	
+	return cm.commitUnsafe()
+}
+
+// SetActiveHandles records the output handles currently in use
+func (cm *ContextManager) SetActiveHandles(handles []uint64) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	cm.context.Workspace.ActiveHandles = append([]uint64{}, handles...)
+	cm.context.Metadata.LastUpdated = time.Now()
+	
+	return cm.commitUnsafe()
 }
 
 // SetKnowledge stores learned patterns or insights
@@ -173,9 +232,55 @@ func (cm *ContextManager) SetKnowledge(key, value string) error {
 	defer cm.mutex.Unlock()
 	
 	cm.context.Knowledge[key] = value
+	cm.context.KnowledgeMeta = touchEntry(cm.context.KnowledgeMeta, key)
 	cm.context.Metadata.LastUpdated = time.Now()
 	
-	return cm.saveContextUnsafe()
+	return cm.commitUnsafe()
+}
+
+// SetEntryTags sets the tags of a memory ("memory") or knowledge
+// ("knowledge") entry
+func (cm *ContextManager) SetEntryTags(kind, key string, tags []string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	var values map[string]string
+	var meta *map[string]EntryMeta
+	switch kind {
+	case "memory":
+		values, meta = cm.context.Memory, &cm.context.MemoryMeta
+	case "knowledge":
+		values, meta = cm.context.Knowledge, &cm.context.KnowledgeMeta
+	default:
+		return fmt.Errorf("unknown entry kind: %s", kind)
+	}
+	if _, exists := values[key]; !exists {
+		return fmt.Errorf("%s entry %s not found", kind, key)
+	}
+	
+	*meta = touchEntry(*meta, key)
+	entry := (*meta)[key]
+	entry.Tags = append([]string(nil), tags...)
+	(*meta)[key] = entry
+	cm.context.Metadata.LastUpdated = time.Now()
+	
+	return cm.commitUnsafe()
+}
+
+// GetEntryMeta returns the metadata of a memory or knowledge entry
+func (cm *ContextManager) GetEntryMeta(kind, key string) (EntryMeta, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	
+	var meta map[string]EntryMeta
+	switch kind {
+	case "memory":
+		meta = cm.context.MemoryMeta
+	case "knowledge":
+		meta = cm.context.KnowledgeMeta
+	}
+	entry, exists := meta[key]
+	return entry, exists
 }
 
 // GetKnowledge retrieves stored knowledge
@@ -187,6 +292,66 @@ func (cm *ContextManager) GetKnowledge(key string) (string, bool) {
 	return value, exists
 }
 
+// ListKnowledge returns all knowledge keys
+func (cm *ContextManager) ListKnowledge() []string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	
+	keys := make([]string, 0, len(cm.context.Knowledge))
+	for key := range cm.context.Knowledge {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// GetMemory returns a copy of all protected memory
+func (cm *ContextManager) GetMemory() map[string]string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	
+	return copyStringMap(cm.context.Memory)
+}
+
+// GetAllKnowledge returns a copy of all stored knowledge
+func (cm *ContextManager) GetAllKnowledge() map[string]string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	
+	return copyStringMap(cm.context.Knowledge)
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// SetCurrentTask records what we're working on
+func (cm *ContextManager) SetCurrentTask(task string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	cm.context.Workspace.CurrentTask = task
+	cm.context.Workspace.LastActivity = time.Now()
+	cm.context.Metadata.LastUpdated = time.Now()
+	
+	return cm.commitUnsafe()
+}
+
+// SetProjectContext records the project description
+func (cm *ContextManager) SetProjectContext(description string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	cm.context.Workspace.ProjectContext = description
+	cm.context.Workspace.LastActivity = time.Now()
+	cm.context.Metadata.LastUpdated = time.Now()
+	
+	return cm.commitUnsafe()
+}
+
 // GetWorkspace returns the current workspace state
 func (cm *ContextManager) GetWorkspace() WorkspaceState {
 	cm.mutex.RLock()
@@ -203,21 +368,31 @@ func (cm *ContextManager) GetContext() Context {
 	return *cm.context
 }
 
-// SaveContext saves the current context to disk
+// SaveContext saves the current context to disk, including any changes
+// still waiting for a debounced save
 func (cm *ContextManager) SaveContext() error {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 	
+	cm.stopSaveTimerUnsafe()
 	return cm.saveContextUnsafe()
 }
 
 // saveContextUnsafe saves context without locking (internal use)
 func (cm *ContextManager) saveContextUnsafe() error {
+	return cm.saveToUnsafe(cm.basePath)
+}
+
+// saveToUnsafe writes the context under basePath while holding the on-disk
+// lock, first merging in changes another process made since our last sync
+func (cm *ContextManager) saveToUnsafe(basePath string) error {
+	cm.revision++
+	
 	// Create directory structure
 	dirs := []string{
-		filepath.Join(cm.basePath, "memory"),
-		filepath.Join(cm.basePath, "workspace"),
-		filepath.Join(cm.basePath, "knowledge"),
+		filepath.Join(basePath, "memory"),
+		filepath.Join(basePath, "workspace"),
+		filepath.Join(basePath, "knowledge"),
 	}
 	
 	for _, dir := range dirs {
@@ -226,26 +401,70 @@ func (cm *ContextManager) saveContextUnsafe() error {
 		}
 	}
 	
+	lock, err := acquireLock(basePath)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	
+	// Upgrade an older context first so its backup holds the old files, and
+	// never overwrite one written by a newer version
+	if err := migrateContext(basePath, false); err != nil {
+		return err
+	}
+	
+	if basePath == cm.basePath {
+		if err := cm.mergeExternalUnsafe(); err != nil {
+			return err
+		}
+	}
+	
+	// The context on disk may be in another format; its files are only
+	// removed once the new ones and the metadata pointing at them are written
+	format := cm.storageFormatUnsafe()
+	storage, err := storageFor(format)
+	if err != nil {
+		return err
+	}
+	previous := detectFormat(basePath)
+	
 	// Save memory
-	if err := cm.saveJSONFile(filepath.Join(cm.basePath, "memory/current.json"), cm.context.Memory); err != nil {
+	if err := storage.Save(basePath, entrySet{dir: "memory", values: cm.context.Memory, meta: cm.context.MemoryMeta}); err != nil {
 		return fmt.Errorf("failed to save memory: %w", err)
 	}
 	
 	// Save workspace
-	if err := cm.saveJSONFile(filepath.Join(cm.basePath, "workspace/state.json"), cm.context.Workspace); err != nil {
+	if err := saveJSONFile(filepath.Join(basePath, "workspace/state.json"), cm.context.Workspace); err != nil {
 		return fmt.Errorf("failed to save workspace: %w", err)
 	}
 	
 	// Save knowledge
-	if err := cm.saveJSONFile(filepath.Join(cm.basePath, "knowledge/patterns.json"), cm.context.Knowledge); err != nil {
+	if err := storage.Save(basePath, entrySet{dir: "knowledge", values: cm.context.Knowledge, meta: cm.context.KnowledgeMeta}); err != nil {
 		return fmt.Errorf("failed to save knowledge: %w", err)
 	}
 	
 	// Save metadata
-	if err := cm.saveJSONFile(filepath.Join(cm.basePath, "metadata.json"), cm.context.Metadata); err != nil {
+	cm.context.Metadata.Storage = format
+	cm.context.Metadata.Version = schemaVersionString(SchemaVersion)
+	if err := saveJSONFile(filepath.Join(basePath, "metadata.json"), cm.context.Metadata); err != nil {
 		return fmt.Errorf("failed to save metadata: %w", err)
 	}
 	
+	// Finish converting from the previous format
+	if previous != format {
+		if old, err := storageFor(previous); err == nil {
+			for _, dir := range []string{"memory", "knowledge"} {
+				if err := old.Clear(basePath, dir); err != nil {
+					return fmt.Errorf("failed to remove %s %s files: %w", previous, dir, err)
+				}
+			}
+		}
+	}
+	
+	if basePath == cm.basePath {
+		cm.dirty = false
+		cm.markSyncedUnsafe()
+	}
 	return nil
 }
 
@@ -254,8 +473,36 @@ func (cm *ContextManager) LoadContext() error {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 	
+	release, err := openContextDir(cm.basePath, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	
+	if err := readContext(cm.basePath, cm.context); err != nil {
+		return err
+	}
+	cm.revision++
+	cm.markSyncedUnsafe()
+	return nil
+}
+
+// readContext reads the context stored under basePath into ctx. Memory and
+// knowledge are replaced; fields missing on disk keep their values.
+func readContext(basePath string, ctx *Context) error {
+	if err := checkSchema(basePath); err != nil {
+		return err
+	}
+	
+	// Memory and knowledge are read in whatever format they were written
+	storage, err := storageFor(detectFormat(basePath))
+	if err != nil {
+		return err
+	}
+	
 	// Load memory
-	if err := cm.loadJSONFile(filepath.Join(cm.basePath, "memory/current.json"), &cm.context.Memory); err != nil {
+	memory := entrySet{dir: "memory"}
+	if err := storage.Load(basePath, &memory); err != nil {
 		// If file doesn't exist, that's okay - start with empty memory
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to load memory: %w", err)
@@ -263,41 +510,103 @@ func (cm *ContextManager) LoadContext() error {
 	}
 	
 	// Load workspace
-	if err := cm.loadJSONFile(filepath.Join(cm.basePath, "workspace/state.json"), &cm.context.Workspace); err != nil {
+	if err := loadJSONFile(filepath.Join(basePath, "workspace/state.json"), &ctx.Workspace); err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to load workspace: %w", err)
 		}
 	}
 	
 	// Load knowledge
-	if err := cm.loadJSONFile(filepath.Join(cm.basePath, "knowledge/patterns.json"), &cm.context.Knowledge); err != nil {
+	knowledge := entrySet{dir: "knowledge"}
+	if err := storage.Load(basePath, &knowledge); err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to load knowledge: %w", err)
 		}
 	}
 	
 	// Load metadata
-	if err := cm.loadJSONFile(filepath.Join(cm.basePath, "metadata.json"), &cm.context.Metadata); err != nil {
+	if err := loadJSONFile(filepath.Join(basePath, "metadata.json"), &ctx.Metadata); err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to load metadata: %w", err)
 		}
 	}
 	
+	ctx.Memory, ctx.MemoryMeta = memory.values, memory.meta
+	ctx.Knowledge, ctx.KnowledgeMeta = knowledge.values, knowledge.meta
+	if ctx.Memory == nil {
+		ctx.Memory = make(map[string]string)
+	}
+	if ctx.Knowledge == nil {
+		ctx.Knowledge = make(map[string]string)
+	}
+	if ctx.MemoryMeta == nil {
+		ctx.MemoryMeta = make(map[string]EntryMeta)
+	}
+	if ctx.KnowledgeMeta == nil {
+		ctx.KnowledgeMeta = make(map[string]EntryMeta)
+	}
 	return nil
 }
 
+// SetStorageFormat selects how memory and knowledge are stored (FormatJSON
+// or FormatMarkdown) and rewrites the context on disk in that format
+func (cm *ContextManager) SetStorageFormat(format string) error {
+	if _, err := storageFor(format); err != nil {
+		return err
+	}
+	
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	
+	cm.format = format
+	cm.stopSaveTimerUnsafe()
+	return cm.saveContextUnsafe()
+}
+
+// StorageFormat returns the format memory and knowledge are stored in
+func (cm *ContextManager) StorageFormat() string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	
+	return cm.storageFormatUnsafe()
+}
+
+// storageFormatUnsafe returns the explicitly selected format, or the format
+// of the context already on disk
+func (cm *ContextManager) storageFormatUnsafe() string {
+	if cm.format != "" {
+		return cm.format
+	}
+	return detectFormat(cm.basePath)
+}
+
+// touchEntry updates an entry's timestamps, creating meta if needed
+func touchEntry(meta map[string]EntryMeta, key string) map[string]EntryMeta {
+	if meta == nil {
+		meta = make(map[string]EntryMeta)
+	}
+	now := time.Now()
+	entry, exists := meta[key]
+	if !exists || entry.Created.IsZero() {
+		entry.Created = now
+	}
+	entry.Updated = now
+	meta[key] = entry
+	return meta
+}
+
 // saveJSONFile saves data to a JSON file
-func (cm *ContextManager) saveJSONFile(path string, data interface{}) error {
+func saveJSONFile(path string, data interface{}) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
 	}
 	
-	return os.WriteFile(path, jsonData, 0644)
+	return writeFileIfChanged(path, jsonData)
 }
 
 // loadJSONFile loads data from a JSON file
-func (cm *ContextManager) loadJSONFile(path string, data interface{}) error {
+func loadJSONFile(path string, data interface{}) error {
 	fileData, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -326,6 +635,8 @@ func (cm *ContextManager) GetStats() ContextStats {
 		SessionID:        cm.context.Metadata.SessionID,
 		CreatedAt:        cm.context.Metadata.Created,
 		LastUpdated:      cm.context.Metadata.LastUpdated,
+		GitBranch:        cm.context.Metadata.GitBranch,
+		GitCommit:        cm.context.Metadata.GitCommit,
 	}
 }
 
@@ -340,4 +651,6 @@ type ContextStats struct {
 	SessionID        string    `json:"session_id"`
 	CreatedAt        time.Time `json:"created_at"`
 	LastUpdated      time.Time `json:"last_updated"`
+	GitBranch        string    `json:"git_branch,omitempty"`
+	GitCommit        string    `json:"git_commit,omitempty"`
 } 
\ No newline at end of file
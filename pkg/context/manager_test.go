@@ -36,8 +36,8 @@ func TestNewContextManager(t *testing.T) {
 		t.Error("SessionID should be generated")
 	}
 	
-	if cm.context.Metadata.Version != "1.0" {
-		t.Errorf("Expected version 1.0, got %s", cm.context.Metadata.Version)
+	if cm.context.Metadata.Version != "3.0" {
+		t.Errorf("Expected version 3.0, got %s", cm.context.Metadata.Version)
 	}
 }
 
@@ -149,6 +149,91 @@ func TestCommandHistory(t *testing.T) {
 	}
 }
 
+func TestTrackActiveFile(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	
+	for _, f := range []string{"a.go", "b.go", "a.go"} {
+		if err := cm.TrackActiveFile(f); err != nil {
+			t.Fatalf("TrackActiveFile failed: %v", err)
+		}
+	}
+	
+	files := cm.GetWorkspace().ActiveFiles
+	if len(files) != 2 || files[0] != "a.go" || files[1] != "b.go" {
+		t.Errorf("Expected [a.go b.go], got %v", files)
+	}
+	
+	for i := 0; i < maxActiveFiles+10; i++ {
+		cm.TrackActiveFile(fmt.Sprintf("file%d.go", i))
+	}
+	if n := len(cm.GetWorkspace().ActiveFiles); n != maxActiveFiles {
+		t.Errorf("Expected %d active files, got %d", maxActiveFiles, n)
+	}
+	
+	// Active files survive a reload
+	cm2 := NewContextManager(tempDir)
+	if err := cm2.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if stats := cm2.GetStats(); stats.ActiveFiles != maxActiveFiles {
+		t.Errorf("Expected %d active files after reload, got %d", maxActiveFiles, stats.ActiveFiles)
+	}
+}
+
+func TestSetActiveHandles(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	
+	if err := cm.SetActiveHandles([]uint64{1, 2, 3}); err != nil {
+		t.Fatalf("SetActiveHandles failed: %v", err)
+	}
+	if stats := cm.GetStats(); stats.ActiveHandles != 3 {
+		t.Errorf("Expected 3 active handles, got %d", stats.ActiveHandles)
+	}
+}
+
+func TestTaskAndProjectContext(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	
+	if err := cm.SetCurrentTask("refactor parser"); err != nil {
+		t.Fatalf("SetCurrentTask failed: %v", err)
+	}
+	if err := cm.SetProjectContext("CLI tool"); err != nil {
+		t.Fatalf("SetProjectContext failed: %v", err)
+	}
+	
+	cm2 := NewContextManager(tempDir)
+	if err := cm2.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	ws := cm2.GetWorkspace()
+	if ws.CurrentTask != "refactor parser" || ws.ProjectContext != "CLI tool" {
+		t.Errorf("Expected task and project to persist, got %q / %q", ws.CurrentTask, ws.ProjectContext)
+	}
+}
+
+func TestMemoryCopies(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetProtected("a", "1")
+	cm.SetKnowledge("k", "v")
+	
+	memory := cm.GetMemory()
+	memory["a"] = "changed"
+	if v, _ := cm.GetProtected("a"); v != "1" {
+		t.Errorf("Expected GetMemory to return a copy, got %q", v)
+	}
+	
+	if keys := cm.ListKnowledge(); len(keys) != 1 || keys[0] != "k" {
+		t.Errorf("Expected [k], got %v", keys)
+	}
+	if knowledge := cm.GetAllKnowledge(); knowledge["k"] != "v" {
+		t.Errorf("Expected knowledge copy to contain k, got %v", knowledge)
+	}
+}
+
 func TestWorkspaceUpdate(t *testing.T) {
 	tempDir := t.TempDir()
 	cm := NewContextManager(tempDir)
@@ -0,0 +1,279 @@
+package context
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markdownStorage writes each entry to its own Markdown file with a small
+// YAML front-matter block, so entries can be reviewed and edited by hand:
+//
+//	---
+//	key: auth/refresh-tokens
+//	created: 2024-05-01T10:00:00Z
+//	updated: 2024-05-02T09:30:00Z
+//	tags: [auth, jwt]
+//	---
+//
+//	Refresh tokens rotate on every use.
+type markdownStorage struct{}
+
+func (markdownStorage) Save(basePath string, set entrySet) error {
+	dir := filepath.Join(basePath, set.dir)
+
+	keys := make([]string, 0, len(set.values))
+	for key := range set.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	written := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		name := uniqueFileName(entryFileName(key), written)
+		written[name] = true
+		content := renderMarkdownEntry(key, set.values[key], set.meta[key])
+		if err := writeFileIfChanged(filepath.Join(dir, name), []byte(content)); err != nil {
+			return err
+		}
+	}
+
+	// Remove files of entries that no longer exist
+	existing, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if !written[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (markdownStorage) Load(basePath string, set *entrySet) error {
+	paths, err := filepath.Glob(filepath.Join(basePath, set.dir, "*.md"))
+	if err != nil {
+		return err
+	}
+
+	set.values = make(map[string]string, len(paths))
+	set.meta = make(map[string]EntryMeta, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, value, meta, err := parseMarkdownEntry(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if key == "" {
+			// Hand-written files without front-matter are keyed by file name
+			key = strings.TrimSuffix(filepath.Base(path), ".md")
+		}
+		set.values[key] = value
+		set.meta[key] = meta
+	}
+	return nil
+}
+
+func (markdownStorage) Clear(basePath string, dir string) error {
+	paths, err := filepath.Glob(filepath.Join(basePath, dir, "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryFileName turns a key into a readable, filesystem-safe file name.
+// Keys that had to be changed get a short hash of the key appended, so
+// "a/b" and "a:b" keep their own files whatever other keys exist.
+func entryFileName(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	name := strings.Trim(b.String(), "-.")
+	if name == key {
+		return name + ".md"
+	}
+	if name == "" {
+		name = "entry"
+	}
+	sum := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%s-%s.md", name, hex.EncodeToString(sum[:4]))
+}
+
+// uniqueFileName appends -2, -3, ... until name is not in used. With hashed
+// names this only matters on a hash collision.
+func uniqueFileName(name string, used map[string]bool) string {
+	if !used[name] {
+		return name
+	}
+	base := strings.TrimSuffix(name, ".md")
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d.md", base, i)
+		if !used[candidate] {
+			return candidate
+		}
+	}
+}
+
+// renderMarkdownEntry renders an entry as front-matter plus body. The body
+// always ends with exactly one extra newline, which parsing strips again.
+func renderMarkdownEntry(key, value string, meta EntryMeta) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "key: %s\n", yamlString(key))
+	if !meta.Created.IsZero() {
+		fmt.Fprintf(&b, "created: %s\n", meta.Created.UTC().Format(time.RFC3339))
+	}
+	if !meta.Updated.IsZero() {
+		fmt.Fprintf(&b, "updated: %s\n", meta.Updated.UTC().Format(time.RFC3339))
+	}
+	if len(meta.Tags) > 0 {
+		tags := make([]string, len(meta.Tags))
+		for i, tag := range meta.Tags {
+			tags[i] = yamlString(tag)
+		}
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(value)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// parseMarkdownEntry splits a Markdown entry into its front-matter fields
+// and body. Files without front-matter are returned whole as the body.
+func parseMarkdownEntry(content string) (string, string, EntryMeta, error) {
+	var meta EntryMeta
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(content, "---\n") {
+		return "", strings.TrimSuffix(content, "\n"), meta, nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\n") == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", "", meta, fmt.Errorf("unterminated front-matter")
+	}
+	header := strings.Join(lines[1:end], "")
+	body := strings.Join(lines[end+1:], "")
+	body = strings.TrimPrefix(body, "\n") // Blank line after the front-matter
+	body = strings.TrimSuffix(body, "\n")
+
+	key := ""
+	var listField string // Field being filled by a block-style "- item" list
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") && listField == "tags" {
+			meta.Tags = append(meta.Tags, yamlUnquote(strings.TrimSpace(trimmed[2:])))
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return "", "", meta, fmt.Errorf("invalid front-matter line: %q", line)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		listField = ""
+
+		switch name {
+		case "key":
+			key = yamlUnquote(value)
+		case "created", "updated":
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, yamlUnquote(value))
+			if err != nil {
+				return "", "", meta, fmt.Errorf("invalid %s time: %w", name, err)
+			}
+			if name == "created" {
+				meta.Created = t
+			} else {
+				meta.Updated = t
+			}
+		case "tags":
+			if value == "" {
+				listField = "tags"
+				continue
+			}
+			meta.Tags = parseYAMLList(value)
+		}
+		// Unknown fields are ignored so people can add their own notes
+	}
+	return key, body, meta, nil
+}
+
+// parseYAMLList parses a flow-style list like [a, "b c"] or a bare a, b
+func parseYAMLList(value string) []string {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "[")
+	value = strings.TrimSuffix(value, "]")
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = yamlUnquote(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// yamlString quotes s if it could be misread as YAML syntax
+func yamlString(s string) string {
+	if s == "" || strings.ContainsAny(s, ":#[]{},\"'\n") || strings.TrimSpace(s) != s {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// yamlUnquote reverses yamlString, also accepting single quotes
+func yamlUnquote(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if unquoted, err := strconv.Unquote(s); err == nil {
+				return unquoted
+			}
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+		}
+	}
+	return s
+}
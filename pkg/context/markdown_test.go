@@ -0,0 +1,156 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdownEntryRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	meta := EntryMeta{Created: created, Updated: created.Add(time.Hour), Tags: []string{"auth", "needs: quoting"}}
+	values := []string{
+		"Refresh tokens rotate on every use.",
+		"line one\n\nline three\n",
+		"\nstarts with a blank line",
+		"---\nlooks like front-matter\n---",
+		"",
+	}
+
+	for _, value := range values {
+		content := renderMarkdownEntry("auth/refresh: tokens", value, meta)
+		key, got, gotMeta, err := parseMarkdownEntry(content)
+		if err != nil {
+			t.Fatalf("parseMarkdownEntry failed for %q: %v", value, err)
+		}
+		if key != "auth/refresh: tokens" {
+			t.Errorf("Expected key to round-trip, got %q", key)
+		}
+		if got != value {
+			t.Errorf("Expected value %q, got %q", value, got)
+		}
+		if !reflect.DeepEqual(gotMeta, meta) {
+			t.Errorf("Expected meta %+v, got %+v", meta, gotMeta)
+		}
+	}
+}
+
+func TestParseHandWrittenMarkdown(t *testing.T) {
+	// No front-matter: the whole file is the value
+	key, value, _, err := parseMarkdownEntry("# Notes\n\nUse make test.\n")
+	if err != nil {
+		t.Fatalf("parseMarkdownEntry failed: %v", err)
+	}
+	if key != "" || value != "# Notes\n\nUse make test." {
+		t.Errorf("Expected empty key and whole body, got %q / %q", key, value)
+	}
+
+	// Block-style tag list, single quotes, CRLF line endings and unknown fields
+	content := "---\r\nkey: 'build'\r\nowner: sam\r\ntags:\r\n  - ci\r\n  - make\r\n---\r\nmake all\r\n"
+	key, value, meta, err := parseMarkdownEntry(content)
+	if err != nil {
+		t.Fatalf("parseMarkdownEntry failed: %v", err)
+	}
+	if key != "build" || value != "make all" {
+		t.Errorf("Expected build / make all, got %q / %q", key, value)
+	}
+	if !reflect.DeepEqual(meta.Tags, []string{"ci", "make"}) {
+		t.Errorf("Expected tags [ci make], got %v", meta.Tags)
+	}
+
+	if _, _, _, err := parseMarkdownEntry("---\nkey: x\n"); err == nil {
+		t.Error("Expected error for unterminated front-matter")
+	}
+}
+
+func TestMarkdownStorageFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	if err := cm.SetStorageFormat(FormatMarkdown); err != nil {
+		t.Fatalf("SetStorageFormat failed: %v", err)
+	}
+
+	cm.SetProtected("auth/design", "use JWT")
+	cm.SetProtected("auth:design", "collides after sanitising")
+	cm.SetKnowledge("codebase-map", "pkg/ai talks to the API")
+
+	slashName := filepath.Join("memory", entryFileName("auth/design"))
+	colonName := filepath.Join("memory", entryFileName("auth:design"))
+	if slashName == colonName {
+		t.Fatalf("Expected colliding keys to get distinct names, both got %s", slashName)
+	}
+	for _, name := range []string{slashName, colonName, "knowledge/codebase-map.md"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("Expected %s to exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "memory", "current.json")); !os.IsNotExist(err) {
+		t.Error("Expected no JSON memory file in Markdown mode")
+	}
+
+	// Deleted entries lose their file and the other key keeps its name
+	cm.DeleteProtected("auth:design")
+	if _, err := os.Stat(filepath.Join(tempDir, colonName)); !os.IsNotExist(err) {
+		t.Error("Expected file of deleted entry to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, slashName)); err != nil {
+		t.Errorf("Expected %s to keep its file name: %v", slashName, err)
+	}
+
+	// Hand edits and new hand-written files are picked up on load
+	os.WriteFile(filepath.Join(tempDir, "knowledge", "testing.md"), []byte("Run go test ./...\n"), 0644)
+	cm2 := NewContextManager(tempDir)
+	if err := cm2.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if v, _ := cm2.GetKnowledge("testing"); v != "Run go test ./..." {
+		t.Errorf("Expected hand-written knowledge, got %q", v)
+	}
+	if v, _ := cm2.GetProtected("auth/design"); v != "use JWT" {
+		t.Errorf("Expected memory to load, got %q", v)
+	}
+	if cm2.StorageFormat() != FormatMarkdown {
+		t.Errorf("Expected format to be detected as markdown, got %s", cm2.StorageFormat())
+	}
+}
+
+func TestEntryFileName(t *testing.T) {
+	if got := entryFileName("codebase-map"); got != "codebase-map.md" {
+		t.Errorf("Expected safe keys to be used as is, got %s", got)
+	}
+	got := entryFileName("auth/design")
+	if !strings.HasPrefix(got, "auth-design-") || len(got) != len("auth-design-12345678.md") {
+		t.Errorf("Expected sanitised name with a hash suffix, got %s", got)
+	}
+	if got != entryFileName("auth/design") {
+		t.Error("Expected file names to be stable")
+	}
+}
+
+func TestMarkdownSaveSkipsUnchangedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetStorageFormat(FormatMarkdown)
+	cm.SetKnowledge("stable", "unchanged value")
+
+	path := filepath.Join(tempDir, "knowledge", "stable.md")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	// Unrelated changes save the context again
+	cm.TrackActiveFile("main.go")
+	cm.SetKnowledge("other", "new value")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Error("Expected unchanged entry file not to be rewritten")
+	}
+}
@@ -0,0 +1,284 @@
+package context
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MergeStrategy decides an entry both branches have with different values
+type MergeStrategy string
+
+const (
+	MergePreferOurs   MergeStrategy = "prefer-ours"   // Keep the target branch's value
+	MergePreferTheirs MergeStrategy = "prefer-theirs" // Take the merged branch's value
+	MergeKeepBoth     MergeStrategy = "keep-both"     // Keep ours; add theirs as key@branch
+	MergeAskModel     MergeStrategy = "ask-model"     // Let Reconcile write the merged value
+)
+
+// Reconciler combines two values of an entry into one, typically by asking
+// the model
+type Reconciler func(kind, key, ours, theirs string) (string, error)
+
+// MergeOptions controls MergeContext
+type MergeOptions struct {
+	Strategy   MergeStrategy            // For conflicts; keep-both if empty
+	Keys       map[string]MergeStrategy // Per-key strategies, overriding Strategy
+	SkipMemory bool                     // Merge knowledge only
+	Reconcile  Reconciler               // Required by ask-model
+}
+
+// MergeChange is one entry MergeContext added or decided
+type MergeChange struct {
+	Kind     string        `json:"kind"` // KindMemory or KindKnowledge
+	Key      string        `json:"key"`
+	Action   string        `json:"action"` // added, kept-ours, took-theirs, kept-both, reconciled
+	Strategy MergeStrategy `json:"strategy,omitempty"`
+	Ours     string        `json:"ours,omitempty"`
+	Theirs   string        `json:"theirs,omitempty"`
+	Result   string        `json:"result"`
+	Note     string        `json:"note,omitempty"`
+}
+
+// MergeReport describes what MergeContext changed
+type MergeReport struct {
+	From    string        `json:"from"`
+	Into    string        `json:"into"`
+	Changes []MergeChange `json:"changes"`
+}
+
+// String summarises the report, one line per change
+func (r *MergeReport) String() string {
+	if len(r.Changes) == 0 {
+		return fmt.Sprintf("Context of %s is already in %s", r.From, r.Into)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Merged context of %s into %s (%d changes):", r.From, r.Into, len(r.Changes))
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "\n  %-11s [%s] %s", c.Action, c.Kind, c.Key)
+		if c.Action == "kept-both" {
+			fmt.Fprintf(&b, " (theirs as %s)", keepBothKey(c.Key, r.From))
+		}
+		if c.Note != "" {
+			fmt.Fprintf(&b, " - %s", c.Note)
+		}
+	}
+	return b.String()
+}
+
+// strategyFor returns the strategy for a conflicting key
+func (o MergeOptions) strategyFor(key string) MergeStrategy {
+	if s, ok := o.Keys[key]; ok {
+		return s
+	}
+	if o.Strategy == "" {
+		return MergeKeepBoth
+	}
+	return o.Strategy
+}
+
+// ValidMergeStrategy reports whether s is a known strategy
+func ValidMergeStrategy(s MergeStrategy) bool {
+	switch s {
+	case MergePreferOurs, MergePreferTheirs, MergeKeepBoth, MergeAskModel:
+		return true
+	}
+	return false
+}
+
+// keepBothKey is where keep-both stores the merged branch's value
+func keepBothKey(key, from string) string {
+	return key + "@" + from
+}
+
+// MergeContext merges the context of branch from into branch into: entries
+// only from has are added, and entries both have with different values are
+// decided by the options' strategies. Entries only into has are kept. into
+// may be the checked out branch, whose live context is updated, or any
+// branch with saved context.
+func (gcm *GitContextManager) MergeContext(from, into string, opts MergeOptions) (*MergeReport, error) {
+	if from == into {
+		return nil, fmt.Errorf("cannot merge the context of %s into itself", from)
+	}
+	for key, s := range opts.Keys {
+		if !ValidMergeStrategy(s) {
+			return nil, fmt.Errorf("unknown merge strategy %q for %s", s, key)
+		}
+	}
+	if opts.Strategy != "" && !ValidMergeStrategy(opts.Strategy) {
+		return nil, fmt.Errorf("unknown merge strategy %q", opts.Strategy)
+	}
+
+	live := gcm.snapshot().Metadata.GitBranch
+	source, err := gcm.branchOrLiveContext(from, live)
+	if err != nil {
+		return nil, err
+	}
+
+	// The target is the live context or the branch's saved context
+	target := gcm.ContextManager
+	if into != live {
+		if !gcm.ContextExistsAt(into) {
+			return nil, fmt.Errorf("no saved context for branch %s", into)
+		}
+		target = NewContextManager(gcm.getBranchContextPath(into))
+		if err := target.LoadContext(); err != nil {
+			return nil, fmt.Errorf("failed to load context of %s: %w", into, err)
+		}
+	}
+	current := target.snapshot()
+
+	// Decide every entry first: reconciling may call the model, which must
+	// not happen with the context locked
+	report := &MergeReport{From: from, Into: into}
+	kinds := []string{KindKnowledge}
+	if !opts.SkipMemory {
+		kinds = append(kinds, KindMemory)
+	}
+	for _, kind := range kinds {
+		ours, theirs := current.Knowledge, source.Knowledge
+		if kind == KindMemory {
+			ours, theirs = current.Memory, source.Memory
+		}
+		changes, err := planMerge(kind, from, ours, theirs, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Changes = append(report.Changes, changes...)
+	}
+
+	if err := target.applyMerge(report, source); err != nil {
+		return nil, err
+	}
+	if target != gcm.ContextManager {
+		if err := target.SaveContext(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// branchOrLiveContext returns the checked out branch's live context or
+// another branch's saved one
+func (gcm *GitContextManager) branchOrLiveContext(branch, live string) (*Context, error) {
+	if branch == live {
+		return gcm.snapshot(), nil
+	}
+	if !gcm.ContextExistsAt(branch) {
+		return nil, fmt.Errorf("no saved context for branch %s", branch)
+	}
+	ctx, err := gcm.readBranchContext(branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context of %s: %w", branch, err)
+	}
+	return ctx, nil
+}
+
+// planMerge decides each entry of theirs against ours, in key order
+func planMerge(kind, from string, ours, theirs map[string]string, opts MergeOptions) ([]MergeChange, error) {
+	keys := make([]string, 0, len(theirs))
+	for key := range theirs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []MergeChange
+	for _, key := range keys {
+		theirValue := theirs[key]
+		ourValue, inOurs := ours[key]
+		switch {
+		case !inOurs:
+			changes = append(changes, MergeChange{Kind: kind, Key: key, Action: "added", Theirs: theirValue, Result: theirValue})
+			continue
+		case ourValue == theirValue:
+			continue
+		}
+
+		change := MergeChange{Kind: kind, Key: key, Strategy: opts.strategyFor(key), Ours: ourValue, Theirs: theirValue}
+		switch change.Strategy {
+		case MergePreferOurs:
+			change.Action, change.Result = "kept-ours", ourValue
+		case MergePreferTheirs:
+			change.Action, change.Result = "took-theirs", theirValue
+		case MergeKeepBoth:
+			change.Action, change.Result = "kept-both", ourValue
+		case MergeAskModel:
+			if opts.Reconcile == nil {
+				change.Action, change.Result = "kept-both", ourValue
+				change.Note = "no model to reconcile with"
+				break
+			}
+			merged, err := opts.Reconcile(kind, key, ourValue, theirValue)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reconcile %s %s: %w", kind, key, err)
+			}
+			change.Action, change.Result = "reconciled", merged
+		}
+		if ours := ours[keepBothKey(key, from)]; change.Action == "kept-both" && ours == theirValue {
+			continue // Kept both in an earlier merge
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// applyMerge writes the decided entries into the context in one save.
+// Added entries keep their metadata from the source context.
+func (cm *ContextManager) applyMerge(report *MergeReport, source *Context) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	for _, c := range report.Changes {
+		values, meta := cm.context.Knowledge, &cm.context.KnowledgeMeta
+		sourceMeta := source.KnowledgeMeta
+		if c.Kind == KindMemory {
+			values, meta = cm.context.Memory, &cm.context.MemoryMeta
+			sourceMeta = source.MemoryMeta
+		}
+
+		switch c.Action {
+		case "added":
+			values[c.Key] = c.Result
+			if m, ok := sourceMeta[c.Key]; ok {
+				(*meta)[c.Key] = m
+			} else {
+				*meta = touchEntry(*meta, c.Key)
+			}
+		case "took-theirs", "reconciled":
+			values[c.Key] = c.Result
+			*meta = touchEntry(*meta, c.Key)
+		case "kept-both":
+			other := keepBothKey(c.Key, report.From)
+			values[other] = c.Theirs
+			*meta = touchEntry(*meta, other)
+		}
+	}
+	if len(report.Changes) == 0 {
+		return nil
+	}
+	cm.context.Metadata.LastUpdated = time.Now()
+	return cm.commitUnsafe()
+}
+
+// snapshot returns a copy of the context whose entry maps can be read
+// without holding the lock
+func (cm *ContextManager) snapshot() *Context {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	ctx := *cm.context
+	ctx.Memory = copyStringMap(ctx.Memory)
+	ctx.Knowledge = copyStringMap(ctx.Knowledge)
+	ctx.MemoryMeta = copyEntryMeta(ctx.MemoryMeta)
+	ctx.KnowledgeMeta = copyEntryMeta(ctx.KnowledgeMeta)
+	return &ctx
+}
+
+func copyEntryMeta(m map[string]EntryMeta) map[string]EntryMeta {
+	c := make(map[string]EntryMeta, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,192 @@
+package context
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupMergeBranches saves a feature context and leaves main as the live
+// context, both sharing and disagreeing on some entries
+func setupMergeBranches(t *testing.T) *GitContextManager {
+	t.Helper()
+	gcm := NewGitContextManager(t.TempDir())
+
+	gcm.SetKnowledge("style", "wrap errors")
+	gcm.SetKnowledge("database", "use sqlite")
+	gcm.SetKnowledge("cache", "none")
+	gcm.SetProtected("task", "feature work")
+	if err := gcm.SaveContextFor("feature"); err != nil {
+		t.Fatalf("SaveContextFor failed: %v", err)
+	}
+
+	if err := gcm.OnGitCheckout("feature", "main"); err != nil {
+		t.Fatalf("OnGitCheckout failed: %v", err)
+	}
+	gcm.SetKnowledge("style", "wrap errors")
+	gcm.SetKnowledge("database", "use postgres")
+	gcm.SetKnowledge("cache", "redis")
+	return gcm
+}
+
+func TestMergeContextStrategies(t *testing.T) {
+	gcm := setupMergeBranches(t)
+
+	var asked []string
+	report, err := gcm.MergeContext("feature", "main", MergeOptions{
+		Strategy: MergePreferOurs,
+		Keys:     map[string]MergeStrategy{"database": MergeAskModel},
+		Reconcile: func(kind, key, ours, theirs string) (string, error) {
+			asked = append(asked, key)
+			return ours + " or " + theirs, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("MergeContext failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"style":    "wrap errors",
+		"database": "use postgres or use sqlite",
+		"cache":    "redis",
+	}
+	for key, value := range expected {
+		if got, _ := gcm.GetKnowledge(key); got != value {
+			t.Errorf("Expected knowledge %s = %q, got %q", key, value, got)
+		}
+	}
+	if value, _ := gcm.GetProtected("task"); value != "feature work" {
+		t.Errorf("Expected memory only in feature to be added, got %q", value)
+	}
+	if len(asked) != 1 || asked[0] != "database" {
+		t.Errorf("Expected the model to be asked about database only, got %v", asked)
+	}
+
+	actions := make(map[string]string)
+	for _, c := range report.Changes {
+		actions[c.Key] = c.Action
+	}
+	if actions["database"] != "reconciled" || actions["cache"] != "kept-ours" || actions["task"] != "added" {
+		t.Errorf("Unexpected report actions: %v", actions)
+	}
+	if _, ok := actions["style"]; ok {
+		t.Error("Expected identical entries to be left out of the report")
+	}
+	if !strings.Contains(report.String(), "reconciled") {
+		t.Errorf("Expected the summary to list changes, got %q", report.String())
+	}
+}
+
+func TestMergeContextKeepBoth(t *testing.T) {
+	gcm := setupMergeBranches(t)
+
+	report, err := gcm.MergeContext("feature", "main", MergeOptions{SkipMemory: true})
+	if err != nil {
+		t.Fatalf("MergeContext failed: %v", err)
+	}
+	if value, _ := gcm.GetKnowledge("cache"); value != "redis" {
+		t.Errorf("Expected ours to stay, got %q", value)
+	}
+	if value, _ := gcm.GetKnowledge("cache@feature"); value != "none" {
+		t.Errorf("Expected theirs to be kept alongside, got %q", value)
+	}
+	if _, ok := gcm.GetProtected("task"); ok {
+		t.Error("Expected memory to be skipped")
+	}
+	if len(report.Changes) != 2 {
+		t.Errorf("Expected 2 changes, got %d", len(report.Changes))
+	}
+
+	// Merging again finds nothing new
+	report, err = gcm.MergeContext("feature", "main", MergeOptions{SkipMemory: true})
+	if err != nil {
+		t.Fatalf("Second MergeContext failed: %v", err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("Expected no changes on a repeated merge, got %+v", report.Changes)
+	}
+}
+
+func TestMergeContextIntoSavedBranch(t *testing.T) {
+	gcm := setupMergeBranches(t)
+	for _, branch := range []string{"release", "main"} {
+		if err := gcm.SaveContextFor(branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gcm.LoadContextFrom("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither side is the live context, which is main
+	if _, err := gcm.MergeContext("feature", "release", MergeOptions{Strategy: MergePreferTheirs}); err != nil {
+		t.Fatalf("MergeContext failed: %v", err)
+	}
+	release, err := gcm.readBranchContext("release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Knowledge["database"] != "use sqlite" || release.Memory["task"] != "feature work" {
+		t.Errorf("Expected feature's entries in release, got %v / %v", release.Knowledge, release.Memory)
+	}
+	if value, _ := gcm.GetKnowledge("database"); value != "use postgres" {
+		t.Errorf("Expected the live context to be untouched, got %q", value)
+	}
+}
+
+func TestMergeContextErrors(t *testing.T) {
+	gcm := setupMergeBranches(t)
+	tests := []struct {
+		from, into string
+		opts       MergeOptions
+	}{
+		{"feature", "feature", MergeOptions{}},
+		{"missing", "main", MergeOptions{}},
+		{"feature", "missing", MergeOptions{}},
+		{"feature", "main", MergeOptions{Strategy: "newest"}},
+		{"feature", "main", MergeOptions{Keys: map[string]MergeStrategy{"cache": "newest"}}},
+		{"feature", "main", MergeOptions{Strategy: MergeAskModel, Reconcile: func(kind, key, ours, theirs string) (string, error) {
+			return "", fmt.Errorf("model unavailable")
+		}}},
+	}
+	for _, tt := range tests {
+		if _, err := gcm.MergeContext(tt.from, tt.into, tt.opts); err == nil {
+			t.Errorf("Expected an error merging %s into %s with %+v", tt.from, tt.into, tt.opts)
+		}
+	}
+	if value, _ := gcm.GetKnowledge("cache"); value != "redis" {
+		t.Errorf("Expected failed merges to change nothing, got %q", value)
+	}
+}
+
+func TestRunGitHookPostMergeMergesContext(t *testing.T) {
+	initGitRepo(t)
+	contextDir := filepath.Join(t.TempDir(), "context")
+	gcm := NewGitContextManager(contextDir)
+	if err := gcm.AttachWorkspace(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Knowledge gathered on a feature branch
+	runGit(t, "checkout", "-q", "-b", "feature/x")
+	if err := gcm.RunGitHook("post-checkout", []string{"a", "b", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	gcm.SetKnowledge("api", "v2 endpoints")
+	commitFile(t, "api.go", "package api")
+
+	runGit(t, "checkout", "-q", "main")
+	if err := NewGitContextManager(contextDir).RunGitHook("post-checkout", []string{"a", "b", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "merge", "-q", "feature/x")
+
+	hook := NewGitContextManager(contextDir)
+	if err := hook.RunGitHook("post-merge", []string{"0"}); err != nil {
+		t.Fatalf("RunGitHook failed: %v", err)
+	}
+	if value, _ := hook.GetKnowledge("api"); value != "v2 endpoints" {
+		t.Errorf("Expected feature/x's knowledge after the merge, got %q", value)
+	}
+}
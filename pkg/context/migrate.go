@@ -0,0 +1,299 @@
+package context
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the on-disk context layout this build reads and writes.
+// Bump it together with a new entry in migrations.
+//
+//	1: memory/current.json, knowledge/patterns.json, workspace/state.json
+//	   and metadata.json, flat under the base path and under each
+//	   branches/<name>
+//	2: adds per-entry metadata (meta.json or Markdown front-matter) and the
+//	   storage format in metadata.json
+//	3: branch directories use encodeBranchName instead of mapping '/' to '_'
+const SchemaVersion = 3
+
+// SchemaError reports a context written by a newer StackAgent
+type SchemaError struct {
+	Path      string
+	Found     int
+	Supported int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("context at %s uses schema version %d but this StackAgent supports up to %d; upgrade StackAgent to use it",
+		e.Path, e.Found, e.Supported)
+}
+
+// migration upgrades one context directory from version from to from+1
+type migration struct {
+	description string
+	apply       func(dir string, meta *ContextMetadata) error
+}
+
+// migrations is keyed by the version they upgrade from
+var migrations = map[int]migration{
+	1: {description: "add entry metadata and storage format", apply: migrateV1ToV2},
+	2: {description: "rename branch directories to reversible names", apply: migrateV2ToV3},
+}
+
+// schemaVersionString formats a version the way it is stored in metadata
+func schemaVersionString(version int) string {
+	return fmt.Sprintf("%d.0", version)
+}
+
+// parseSchemaVersion reads the major version from a metadata version such
+// as "1.0"; contexts from before versioning count as version 1
+func parseSchemaVersion(v string) (int, error) {
+	if v == "" {
+		return 1, nil
+	}
+	major, _, _ := strings.Cut(v, ".")
+	version, err := strconv.Atoi(major)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid context schema version %q", v)
+	}
+	return version, nil
+}
+
+// openContextDir takes the on-disk lock for the context under dir and
+// brings it up to SchemaVersion, including its branch contexts when
+// branches is set. The returned function releases the lock. A directory
+// that doesn't exist yet has nothing to lock or migrate.
+func openContextDir(dir string, branches bool) (func(), error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	lock, err := acquireLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateContext(dir, branches); err != nil {
+		lock.release()
+		return nil, err
+	}
+	return lock.release, nil
+}
+
+// migrateContext brings the context under dir up to SchemaVersion, backing
+// it up first, and refuses contexts from newer versions. With branches set
+// every branches/<name> context below dir is upgraded too. Must be called
+// with the lock on dir held.
+func migrateContext(dir string, branches bool) error {
+	backup, err := migrateDir(dir, "")
+	if err != nil || !branches {
+		return err
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "branches"))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		branchDir := filepath.Join(dir, "branches", e.Name())
+		// The base path's backup, if any, already holds this branch's old files
+		lock, err := acquireLock(branchDir)
+		if err != nil {
+			return err
+		}
+		_, err = migrateDir(branchDir, backup)
+		lock.release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDir upgrades the single context under dir and returns where its
+// old files were backed up, or "" if it was current. A backup is made
+// unless one is passed in. A directory without metadata.json is new and
+// left alone.
+func migrateDir(dir, backup string) (string, error) {
+	var meta ContextMetadata
+	if err := loadJSONFile(filepath.Join(dir, "metadata.json"), &meta); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read context metadata: %w", err)
+	}
+	version, err := parseSchemaVersion(meta.Version)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", dir, err)
+	}
+	if version > SchemaVersion {
+		return "", &SchemaError{Path: dir, Found: version, Supported: SchemaVersion}
+	}
+	if version == SchemaVersion {
+		return "", nil
+	}
+
+	if backup == "" {
+		if backup, err = backupContext(dir, version); err != nil {
+			return "", fmt.Errorf("failed to back up context before migrating: %w", err)
+		}
+	}
+	for v := version; v < SchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return "", fmt.Errorf("no migration from context schema version %d", v)
+		}
+		if err := m.apply(dir, &meta); err != nil {
+			return "", fmt.Errorf("failed to migrate context at %s to version %d (%s); a backup is at %s: %w",
+				dir, v+1, m.description, backup, err)
+		}
+		meta.Version = schemaVersionString(v + 1)
+		if err := saveJSONFile(filepath.Join(dir, "metadata.json"), meta); err != nil {
+			return "", fmt.Errorf("failed to record context version: %w", err)
+		}
+	}
+	fmt.Printf("📦 Migrated context at %s from version %d to %d (backup: %s)\n", dir, version, SchemaVersion, backup)
+	return backup, nil
+}
+
+// checkSchema returns a SchemaError if the context under dir is newer than
+// this build
+func checkSchema(dir string) error {
+	var meta ContextMetadata
+	if err := loadJSONFile(filepath.Join(dir, "metadata.json"), &meta); err != nil {
+		return nil
+	}
+	if version, err := parseSchemaVersion(meta.Version); err == nil && version > SchemaVersion {
+		return &SchemaError{Path: dir, Found: version, Supported: SchemaVersion}
+	}
+	return nil
+}
+
+// backupContext copies the context files under dir (including branch
+// contexts, excluding earlier backups and derived data) to
+// dir/backups/v<version>-<timestamp> and returns that path
+func backupContext(dir string, version int) (string, error) {
+	backup := filepath.Join(dir, "backups", fmt.Sprintf("v%d-%s", version, time.Now().UTC().Format("20060102T150405Z")))
+	// Snapshots are immutable and never migrated
+	skip := map[string]bool{"backups": true, "vectors": true, "snapshots": true, lockFileName: true}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if skip[filepath.Base(path)] || strings.Contains(filepath.Base(path), ".tmp-") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(backup, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// migrateV1ToV2 records that the entries are stored as JSON and creates
+// entry metadata, dating each entry from its file's modification time
+func migrateV1ToV2(dir string, meta *ContextMetadata) error {
+	meta.Storage = FormatJSON
+	storage := jsonStorage{}
+
+	for _, sub := range []string{"memory", "knowledge"} {
+		path := filepath.Join(dir, sub, storage.fileName(sub))
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var values map[string]string
+		if err := loadJSONFile(path, &values); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		entries := make(map[string]EntryMeta, len(values))
+		for key := range values {
+			entries[key] = EntryMeta{Created: info.ModTime(), Updated: info.ModTime()}
+		}
+		if err := saveJSONFile(filepath.Join(dir, sub, "meta.json"), entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateV2ToV3 renames branches/<name> directories from the old lossy
+// names, where '/' became '_', to encodeBranchName. The branch name is taken
+// from the context's own metadata, which knows it exactly; only contexts
+// without one fall back to reading '_' as '/'.
+func migrateV2ToV3(dir string, meta *ContextMetadata) error {
+	branchesDir := filepath.Join(dir, "branches")
+	entries, err := os.ReadDir(branchesDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		var branchMeta ContextMetadata
+		loadJSONFile(filepath.Join(branchesDir, e.Name(), "metadata.json"), &branchMeta)
+		branch := branchMeta.GitBranch
+		if branch == "" {
+			branch = strings.ReplaceAll(e.Name(), "_", "/")
+		}
+
+		name := encodeBranchName(branch)
+		if name == e.Name() {
+			continue
+		}
+		target := filepath.Join(branchesDir, name)
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("cannot rename context of branch %s: %s already exists", branch, target)
+		}
+		if err := os.Rename(filepath.Join(branchesDir, e.Name()), target); err != nil {
+			return fmt.Errorf("failed to rename context of branch %s: %w", branch, err)
+		}
+	}
+	return nil
+}
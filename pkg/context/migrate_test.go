@@ -0,0 +1,134 @@
+package context
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeV1Context writes a context the way version 1 laid it out
+func writeV1Context(t *testing.T, dir, memoryValue string) {
+	t.Helper()
+	files := map[string]string{
+		"metadata.json":           `{"version": "1.0", "session_id": "old"}`,
+		"memory/current.json":     `{"decision": "` + memoryValue + `"}`,
+		"knowledge/patterns.json": `{"style": "wrap errors"}`,
+		"workspace/state.json":    `{"working_dir": "/src"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateV1Context(t *testing.T) {
+	tempDir := t.TempDir()
+	writeV1Context(t, tempDir, "use sqlite")
+	writeV1Context(t, filepath.Join(tempDir, "branches", "feature"), "use postgres")
+
+	cm := NewContextManager(tempDir)
+	if err := cm.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if value, _ := cm.GetProtected("decision"); value != "use sqlite" {
+		t.Errorf("Expected migrated memory, got %q", value)
+	}
+	if meta, ok := cm.GetEntryMeta("knowledge", "style"); !ok || meta.Created.IsZero() {
+		t.Errorf("Expected migrated entry metadata, got %+v", meta)
+	}
+
+	for _, dir := range []string{tempDir, filepath.Join(tempDir, "branches", "feature")} {
+		var meta ContextMetadata
+		if err := loadJSONFile(filepath.Join(dir, "metadata.json"), &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Version != schemaVersionString(SchemaVersion) || meta.Storage != FormatJSON {
+			t.Errorf("Expected %s to be at version %d, got %q (%q)", dir, SchemaVersion, meta.Version, meta.Storage)
+		}
+	}
+
+	// One backup of the old files, covering the branch contexts too
+	backups, _ := filepath.Glob(filepath.Join(tempDir, "backups", "v1-*"))
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup, got %v", backups)
+	}
+	for _, name := range []string{"metadata.json", "memory/current.json", "branches/feature/memory/current.json"} {
+		if _, err := os.Stat(filepath.Join(backups[0], name)); err != nil {
+			t.Errorf("Expected %s in the backup: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "branches", "feature", "backups")); !os.IsNotExist(err) {
+		t.Error("Expected no separate backup for a branch covered by the base path's backup")
+	}
+
+	// Loading again doesn't migrate or back up again
+	if err := NewContextManager(tempDir).LoadContext(); err != nil {
+		t.Fatalf("Second LoadContext failed: %v", err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(tempDir, "backups", "*")); len(backups) != 1 {
+		t.Errorf("Expected no new backup, got %v", backups)
+	}
+}
+
+func TestMigrateBranchOnLoad(t *testing.T) {
+	tempDir := t.TempDir()
+	gcm := NewGitContextManager(tempDir)
+	gcm.SetProtected("root", "current")
+
+	// A branch context from an older StackAgent arrives later, e.g. by a pull
+	branchDir := gcm.getBranchContextPath("feature")
+	writeV1Context(t, branchDir, "use postgres")
+
+	if err := gcm.LoadContextFrom("feature"); err != nil {
+		t.Fatalf("LoadContextFrom failed: %v", err)
+	}
+	if value, _ := gcm.GetProtected("decision"); value != "use postgres" {
+		t.Errorf("Expected branch memory, got %q", value)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(branchDir, "backups", "v1-*")); len(backups) != 1 {
+		t.Errorf("Expected the branch to be backed up, got %v", backups)
+	}
+}
+
+func TestRefuseNewerSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	newer := `{"version": "99.0"}`
+	if err := os.WriteFile(filepath.Join(tempDir, "metadata.json"), []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewContextManager(tempDir)
+	var schemaErr *SchemaError
+	if err := cm.LoadContext(); !errors.As(err, &schemaErr) || schemaErr.Found != 99 {
+		t.Fatalf("Expected a SchemaError for version 99, got %v", err)
+	}
+
+	// Saving must not overwrite it either
+	if err := cm.SetProtected("key", "value"); !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected save to refuse a newer context, got %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(tempDir, "metadata.json"))
+	if string(data) != newer {
+		t.Errorf("Expected metadata to be untouched, got %s", data)
+	}
+}
+
+func TestParseSchemaVersion(t *testing.T) {
+	tests := map[string]int{"": 1, "1.0": 1, "2.0": 2, "3": 3}
+	for input, expected := range tests {
+		if got, err := parseSchemaVersion(input); err != nil || got != expected {
+			t.Errorf("parseSchemaVersion(%q): expected %d, got %d (%v)", input, expected, got, err)
+		}
+	}
+	for _, input := range []string{"abc", "0.9", "-1"} {
+		if _, err := parseSchemaVersion(input); err == nil {
+			t.Errorf("parseSchemaVersion(%q): expected an error", input)
+		}
+	}
+}
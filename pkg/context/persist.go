@@ -0,0 +1,337 @@
+package context
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	lockFileName = ".lock"
+
+	// A lock older than this is considered abandoned. Saves take
+	// milliseconds, so a live holder never gets close.
+	staleLockAge = 30 * time.Second
+)
+
+// How long a save waits for another process to release the lock
+var lockTimeout = 5 * time.Second
+
+// lockInfo is written into the lock file to identify its holder
+type lockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// fileLock is an exclusive lock on a context directory, shared with other
+// processes through a lock file created with O_EXCL
+type fileLock struct {
+	path string
+}
+
+// acquireLock takes the lock for dir, waiting up to lockTimeout and
+// breaking locks whose holder is gone
+func acquireLock(dir string) (*fileLock, error) {
+	path := filepath.Join(dir, lockFileName)
+	hostname, _ := os.Hostname()
+	info, _ := json.Marshal(lockInfo{PID: os.Getpid(), Hostname: hostname, Created: time.Now()})
+
+	deadline := time.Now().Add(lockTimeout)
+	wait := 5 * time.Millisecond
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, werr := f.Write(info)
+			cerr := f.Close()
+			if werr != nil || cerr != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %s", path)
+			}
+			return &fileLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, stale := inspectLock(path, hostname)
+		if stale {
+			// Another process may break the same lock; O_EXCL decides who wins
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("context at %s is locked by process %d on %s", dir, holder.PID, holder.Hostname)
+		}
+		time.Sleep(wait)
+		if wait < 100*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+// inspectLock reads a lock file and reports whether it was abandoned: its
+// holder on this host is no longer running, or it is older than staleLockAge
+func inspectLock(path, hostname string) (lockInfo, bool) {
+	var holder lockInfo
+	stat, err := os.Stat(path)
+	if err != nil {
+		return holder, false // Released in the meantime; just retry
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &holder) != nil {
+		// Empty or partial: the holder may still be writing it
+		return holder, time.Since(stat.ModTime()) > staleLockAge
+	}
+	if holder.Hostname == hostname && holder.PID != os.Getpid() && !processAlive(holder.PID) {
+		return holder, true
+	}
+	return holder, time.Since(holder.Created) > staleLockAge
+}
+
+// processAlive reports whether a process with this PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func (l *fileLock) release() {
+	os.Remove(l.path)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers and crashes never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(data)
+	serr := tmp.Sync()
+	cerr := tmp.Close()
+	if err := errors.Join(werr, serr, cerr); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// SetSaveDelay batches saves: after a change the context is written once
+// delay has passed, instead of on every change. 0 (the default) saves
+// immediately. Flush or SaveContext write pending changes right away.
+func (cm *ContextManager) SetSaveDelay(delay time.Duration) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.saveDelay = delay
+}
+
+// Flush writes changes waiting for a debounced save
+func (cm *ContextManager) Flush() error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.stopSaveTimerUnsafe()
+	if !cm.dirty {
+		err := cm.saveErr
+		cm.saveErr = nil
+		return err
+	}
+	return cm.saveContextUnsafe()
+}
+
+// commitUnsafe records a change to the context and saves it, now or after
+// the save delay. An error from an earlier debounced save is returned by
+// the next change.
+func (cm *ContextManager) commitUnsafe() error {
+	if cm.saveDelay <= 0 {
+		return cm.saveContextUnsafe()
+	}
+
+	cm.revision++
+	cm.dirty = true
+	if cm.saveTimer == nil {
+		cm.saveTimer = time.AfterFunc(cm.saveDelay, cm.flushPending)
+	}
+	err := cm.saveErr
+	cm.saveErr = nil
+	return err
+}
+
+// flushPending is the debounced save
+func (cm *ContextManager) flushPending() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.saveTimer = nil
+	if !cm.dirty {
+		return
+	}
+	if err := cm.saveContextUnsafe(); err != nil {
+		cm.saveErr = err
+		fmt.Printf("⚠️  Failed to save context: %v\n", err)
+	}
+}
+
+func (cm *ContextManager) stopSaveTimerUnsafe() {
+	if cm.saveTimer != nil {
+		cm.saveTimer.Stop()
+		cm.saveTimer = nil
+	}
+}
+
+// syncState is what the context on disk looked like when we last loaded or
+// saved it, used to tell our changes from another process's
+type syncState struct {
+	stamp     time.Time // Newest mtime of the context files
+	memory    map[string]string
+	knowledge map[string]string
+}
+
+// markSyncedUnsafe records the on-disk state after a load or save
+func (cm *ContextManager) markSyncedUnsafe() {
+	cm.synced = syncState{
+		stamp:     diskStamp(cm.basePath),
+		memory:    copyStringMap(cm.context.Memory),
+		knowledge: copyStringMap(cm.context.Knowledge),
+	}
+}
+
+// diskStamp returns the newest modification time of the context files
+// under basePath
+func diskStamp(basePath string) time.Time {
+	var newest time.Time
+	check := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	check(filepath.Join(basePath, "metadata.json"))
+	check(filepath.Join(basePath, "workspace", "state.json"))
+	for _, dir := range []string{"memory", "knowledge"} {
+		// The directory mtime changes when entry files are added or removed
+		check(filepath.Join(basePath, dir))
+		entries, _ := os.ReadDir(filepath.Join(basePath, dir))
+		for _, e := range entries {
+			if !e.IsDir() {
+				check(filepath.Join(basePath, dir, e.Name()))
+			}
+		}
+	}
+	return newest
+}
+
+// mergeExternalUnsafe folds in changes another process (or a hand edit)
+// made to the context on disk since we last loaded or saved it. Must be
+// called with the on-disk lock held.
+func (cm *ContextManager) mergeExternalUnsafe() error {
+	if cm.synced.stamp.IsZero() {
+		// Nothing loaded from or written to this directory yet
+		if _, err := os.Stat(filepath.Join(cm.basePath, "metadata.json")); err != nil {
+			return nil
+		}
+	} else if !diskStamp(cm.basePath).After(cm.synced.stamp) {
+		return nil
+	}
+
+	disk := &Context{}
+	if err := readContext(cm.basePath, disk); err != nil {
+		return fmt.Errorf("failed to read context changed on disk: %w", err)
+	}
+
+	mergeEntries(cm.context.Memory, cm.context.MemoryMeta, disk.Memory, disk.MemoryMeta, cm.synced.memory)
+	mergeEntries(cm.context.Knowledge, cm.context.KnowledgeMeta, disk.Knowledge, disk.KnowledgeMeta, cm.synced.knowledge)
+	cm.context.Workspace.CommandHistory = mergeCommandHistory(cm.context.Workspace.CommandHistory, disk.Workspace.CommandHistory)
+	cm.revision++
+	return nil
+}
+
+// mergeEntries merges theirs into ours against base, the values at our last
+// sync: a side that didn't change an entry takes the other side's change
+// (including deletion), and when both changed it the newer update wins
+func mergeEntries(ours map[string]string, oursMeta map[string]EntryMeta, theirs map[string]string, theirsMeta map[string]EntryMeta, base map[string]string) {
+	for key, theirValue := range theirs {
+		baseValue, inBase := base[key]
+		ourValue, inOurs := ours[key]
+		theirsChanged := !inBase || theirValue != baseValue
+
+		switch {
+		case !inOurs:
+			// We deleted it (or never had it): keep their version only if
+			// they changed it since
+			if !theirsChanged {
+				continue
+			}
+		case ourValue == theirValue:
+			continue
+		default:
+			oursChanged := !inBase || ourValue != baseValue
+			if oursChanged && (!theirsChanged || !theirsMeta[key].Updated.After(oursMeta[key].Updated)) {
+				continue
+			}
+		}
+		ours[key] = theirValue
+		if meta, ok := theirsMeta[key]; ok {
+			oursMeta[key] = meta
+		}
+	}
+
+	for key, ourValue := range ours {
+		if _, inTheirs := theirs[key]; inTheirs {
+			continue
+		}
+		// They deleted it; so do we unless we changed it since
+		if baseValue, inBase := base[key]; inBase && ourValue == baseValue {
+			delete(ours, key)
+			delete(oursMeta, key)
+		}
+	}
+}
+
+// mergeCommandHistory unions two command histories in time order, keeping
+// the most recent maxCommandHistory records
+func mergeCommandHistory(ours, theirs []CommandRecord) []CommandRecord {
+	type recordKey struct {
+		command string
+		handle  uint64
+		at      int64
+	}
+	seen := make(map[recordKey]bool, len(ours)+len(theirs))
+	merged := make([]CommandRecord, 0, len(ours)+len(theirs))
+	for _, list := range [][]CommandRecord{ours, theirs} {
+		for _, r := range list {
+			k := recordKey{r.Command, r.HandleID, r.Timestamp.UnixNano()}
+			if !seen[k] {
+				seen[k] = true
+				merged = append(merged, r)
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	if len(merged) > maxCommandHistory {
+		merged = merged[len(merged)-maxCommandHistory:]
+	}
+	return merged
+}
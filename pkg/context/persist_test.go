@@ -0,0 +1,192 @@
+package context
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSaveLeavesNoTemporaryFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetProtected("a", "1")
+	cm.SetKnowledge("b", "2")
+
+	filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+		if strings.Contains(filepath.Base(path), ".tmp-") || filepath.Base(path) == lockFileName {
+			t.Errorf("Unexpected leftover file %s", path)
+		}
+		return nil
+	})
+}
+
+func TestLockBreaksStaleLocks(t *testing.T) {
+	tempDir := t.TempDir()
+	hostname, _ := os.Hostname()
+
+	// A lock left by a process that no longer exists
+	dead, _ := json.Marshal(lockInfo{PID: 999999999, Hostname: hostname, Created: time.Now()})
+	os.WriteFile(filepath.Join(tempDir, lockFileName), dead, 0644)
+
+	cm := NewContextManager(tempDir)
+	if err := cm.SetProtected("a", "1"); err != nil {
+		t.Fatalf("Expected stale lock to be broken, got %v", err)
+	}
+
+	// A lock from another host that is too old
+	old, _ := json.Marshal(lockInfo{PID: 1, Hostname: "elsewhere", Created: time.Now().Add(-time.Hour)})
+	os.WriteFile(filepath.Join(tempDir, lockFileName), old, 0644)
+	if err := cm.SetProtected("b", "2"); err != nil {
+		t.Fatalf("Expected expired lock to be broken, got %v", err)
+	}
+}
+
+func TestLockWaitsForLiveHolder(t *testing.T) {
+	defer func(d time.Duration) { lockTimeout = d }(lockTimeout)
+	lockTimeout = 100 * time.Millisecond
+
+	tempDir := t.TempDir()
+	held, err := acquireLock(tempDir)
+	if err != nil {
+		t.Fatalf("acquireLock failed: %v", err)
+	}
+
+	cm := NewContextManager(tempDir)
+	if err := cm.SetProtected("a", "1"); err == nil || !strings.Contains(err.Error(), "locked by process") {
+		t.Errorf("Expected a lock timeout, got %v", err)
+	}
+
+	held.release()
+	if err := cm.SetProtected("a", "1"); err != nil {
+		t.Errorf("Expected save to succeed once the lock is released, got %v", err)
+	}
+}
+
+func TestDebouncedSaves(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetSaveDelay(time.Hour)
+
+	cm.SetProtected("a", "1")
+	cm.SetProtected("b", "2")
+	if _, err := os.Stat(filepath.Join(tempDir, "memory", "current.json")); !os.IsNotExist(err) {
+		t.Fatal("Expected changes to wait for the save delay")
+	}
+
+	if err := cm.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	reloaded := NewContextManager(tempDir)
+	reloaded.LoadContext()
+	if len(reloaded.GetMemory()) != 2 {
+		t.Errorf("Expected both changes after Flush, got %v", reloaded.GetMemory())
+	}
+
+	// The timer writes pending changes on its own
+	cm.SetSaveDelay(20 * time.Millisecond)
+	cm.SetProtected("c", "3")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		reloaded.LoadContext()
+		if _, ok := reloaded.GetProtected("c"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected debounced save to be written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSaveMergesExternalChanges(t *testing.T) {
+	tempDir := t.TempDir()
+	first := NewContextManager(tempDir)
+	first.SetProtected("shared", "v1")
+	first.SetProtected("doomed", "x")
+
+	second := NewContextManager(tempDir)
+	if err := second.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+
+	// Make sure the second writer's files get a newer mtime
+	time.Sleep(20 * time.Millisecond)
+	second.SetProtected("theirs", "from second")
+	second.SetProtected("shared", "v2")
+	second.DeleteProtected("doomed")
+	second.AddCommandRecord("make test", 7, 0, time.Second)
+
+	time.Sleep(20 * time.Millisecond)
+	first.SetProtected("ours", "from first")
+	first.AddCommandRecord("make build", 3, 0, time.Second)
+
+	check := NewContextManager(tempDir)
+	check.LoadContext()
+	memory := check.GetMemory()
+	want := map[string]string{"shared": "v2", "theirs": "from second", "ours": "from first"}
+	if len(memory) != len(want) {
+		t.Errorf("Expected %v, got %v", want, memory)
+	}
+	for k, v := range want {
+		if memory[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, memory[k])
+		}
+	}
+	if history := check.GetWorkspace().CommandHistory; len(history) != 2 {
+		t.Errorf("Expected both command histories to be merged, got %d records", len(history))
+	}
+}
+
+func TestMergeEntries(t *testing.T) {
+	older := time.Now().Add(-time.Minute)
+	newer := time.Now()
+	base := map[string]string{"k": "base", "gone": "g"}
+	ours := map[string]string{"k": "ours", "gone": "g"}
+	oursMeta := map[string]EntryMeta{"k": {Updated: older}}
+	theirs := map[string]string{"k": "theirs"}
+	theirsMeta := map[string]EntryMeta{"k": {Updated: newer}}
+
+	mergeEntries(ours, oursMeta, theirs, theirsMeta, base)
+	if ours["k"] != "theirs" {
+		t.Errorf("Expected the newer change to win, got %q", ours["k"])
+	}
+	if _, ok := ours["gone"]; ok {
+		t.Error("Expected an external deletion of an unchanged entry to apply")
+	}
+
+	// A hand edit without a new timestamp wins over an unchanged entry
+	ours = map[string]string{"k": "base"}
+	mergeEntries(ours, map[string]EntryMeta{}, map[string]string{"k": "hand edit"}, map[string]EntryMeta{}, map[string]string{"k": "base"})
+	if ours["k"] != "hand edit" {
+		t.Errorf("Expected hand edit to be kept, got %q", ours["k"])
+	}
+}
+
+func TestSaveContextForDoesNotRace(t *testing.T) {
+	gcm := NewGitContextManager(t.TempDir())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			gcm.SaveContextFor("feature")
+		}()
+		go func() {
+			defer wg.Done()
+			gcm.SetProtected("k", "v")
+		}()
+	}
+	wg.Wait()
+
+	// Ordinary saves still land in the base directory
+	if _, err := os.Stat(filepath.Join(gcm.basePath, "memory", "current.json")); err != nil {
+		t.Errorf("Expected base context to be saved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(gcm.basePath, "branches", "feature", "branches")); !os.IsNotExist(err) {
+		t.Error("Expected no nested branch directories")
+	}
+}
@@ -0,0 +1,228 @@
+package context
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// Entries less similar than this to the query are not recalled
+	minRecallScore = 0.1
+
+	// Longer entries are cut before embedding to stay within model limits
+	maxEmbedChars = 8000
+)
+
+// vectorStore caches entry embeddings for one embedder, keyed by
+// "kind:key", so unchanged entries aren't embedded again
+type vectorStore struct {
+	Embedder string                  `json:"embedder"`
+	Entries  map[string]storedVector `json:"entries"`
+	path     string                  // File the store was loaded from
+}
+
+type storedVector struct {
+	Hash   string    `json:"hash"` // Of the embedded text
+	Vector []float32 `json:"vector"`
+}
+
+// SetEmbedder selects the embedder used by Recall. nil restores the local
+// HashEmbedder.
+func (cm *ContextManager) SetEmbedder(e Embedder) {
+	cm.vectorMutex.Lock()
+	defer cm.vectorMutex.Unlock()
+
+	cm.embedder = e
+	cm.vectors = nil
+}
+
+// Embedder returns the embedder used by Recall
+func (cm *ContextManager) Embedder() Embedder {
+	cm.vectorMutex.Lock()
+	defer cm.vectorMutex.Unlock()
+
+	return cm.embedderUnsafe()
+}
+
+func (cm *ContextManager) embedderUnsafe() Embedder {
+	if cm.embedder == nil {
+		cm.embedder = NewHashEmbedder(0)
+	}
+	return cm.embedder
+}
+
+// Recall ranks memory and knowledge entries by semantic similarity to query.
+// Entry vectors are stored under the context's vectors/ directory and only
+// recomputed when an entry changes.
+func (cm *ContextManager) Recall(query string, opts SearchOptions) ([]ContextSearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	cm.mutex.RLock()
+	basePath := cm.basePath
+	var docs []searchDoc
+	for key, value := range cm.context.Memory {
+		docs = append(docs, searchDoc{kind: KindMemory, key: key, text: value, tags: cm.context.MemoryMeta[key].Tags})
+	}
+	for key, value := range cm.context.Knowledge {
+		docs = append(docs, searchDoc{kind: KindKnowledge, key: key, text: value, tags: cm.context.KnowledgeMeta[key].Tags})
+	}
+	cm.mutex.RUnlock()
+
+	// Embedding may call out to a server, so it runs outside cm.mutex
+	cm.vectorMutex.Lock()
+	defer cm.vectorMutex.Unlock()
+
+	embedder := cm.embedderUnsafe()
+	vectors, err := cm.entryVectors(basePath, embedder, docs)
+	if err != nil {
+		return nil, err
+	}
+	queryVectors, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(queryVectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for the query", len(queryVectors))
+	}
+	queryVector := queryVectors[0]
+
+	terms := tokenize(query)
+	var results []ContextSearchResult
+	for i, doc := range docs {
+		if !doc.matches(opts) {
+			continue
+		}
+		score := cosine(queryVector, vectors[i])
+		if score < minRecallScore {
+			continue
+		}
+		results = append(results, ContextSearchResult{
+			Kind:    doc.kind,
+			Key:     doc.key,
+			Snippet: snippet(doc.text, terms),
+			Score:   score,
+			Tags:    doc.tags,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Kind != results[j].Kind {
+			return results[i].Kind < results[j].Kind
+		}
+		return results[i].Key < results[j].Key
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// entryVectors returns a vector per doc, embedding only new or changed
+// entries and persisting the store when anything changed. Must be called
+// with vectorMutex held.
+func (cm *ContextManager) entryVectors(basePath string, embedder Embedder, docs []searchDoc) ([][]float32, error) {
+	path := filepath.Join(basePath, "vectors", vectorFileName(embedder.Name()))
+	if cm.vectors == nil || cm.vectors.Embedder != embedder.Name() || cm.vectors.path != path {
+		store := &vectorStore{}
+		if err := loadJSONFile(path, store); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load vectors: %w", err)
+		}
+		if store.Embedder != embedder.Name() || store.Entries == nil {
+			store = &vectorStore{Embedder: embedder.Name(), Entries: make(map[string]storedVector)}
+		}
+		store.path = path
+		cm.vectors = store
+	}
+	store := cm.vectors
+
+	ids := make([]string, len(docs))
+	hashes := make([]string, len(docs))
+	var missing []int
+	var texts []string
+	for i, doc := range docs {
+		ids[i] = doc.kind + ":" + doc.key
+		text := embedText(doc)
+		sum := sha1.Sum([]byte(text))
+		hashes[i] = hex.EncodeToString(sum[:])
+		if stored, ok := store.Entries[ids[i]]; !ok || stored.Hash != hashes[i] {
+			missing = append(missing, i)
+			texts = append(texts, text)
+		}
+	}
+
+	changed := false
+	if len(missing) > 0 {
+		embedded, err := embedder.Embed(texts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed context entries: %w", err)
+		}
+		if len(embedded) != len(texts) {
+			return nil, fmt.Errorf("embedder returned %d vectors for %d entries", len(embedded), len(texts))
+		}
+		for j, i := range missing {
+			store.Entries[ids[i]] = storedVector{Hash: hashes[i], Vector: embedded[j]}
+		}
+		changed = true
+	}
+
+	// Forget vectors of deleted entries
+	live := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		live[id] = true
+	}
+	for id := range store.Entries {
+		if !live[id] {
+			delete(store.Entries, id)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create vectors directory: %w", err)
+		}
+		if err := saveJSONFile(path, store); err != nil {
+			return nil, fmt.Errorf("failed to save vectors: %w", err)
+		}
+	}
+
+	vectors := make([][]float32, len(docs))
+	for i, id := range ids {
+		vectors[i] = store.Entries[id].Vector
+	}
+	return vectors, nil
+}
+
+// embedText is what gets embedded for an entry: its key, tags and value
+func embedText(doc searchDoc) string {
+	text := doc.key + "\n" + strings.Join(doc.tags, " ") + "\n" + doc.text
+	if len(text) > maxEmbedChars {
+		text = strings.ToValidUTF8(text[:maxEmbedChars], "")
+	}
+	return text
+}
+
+// vectorFileName maps an embedder name to a file name
+func vectorFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, name) + ".json"
+}
@@ -0,0 +1,89 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingEmbedder records how many texts it was asked to embed
+type countingEmbedder struct {
+	*HashEmbedder
+	embedded int
+}
+
+func (e *countingEmbedder) Embed(texts []string) ([][]float32, error) {
+	e.embedded += len(texts)
+	return e.HashEmbedder.Embed(texts)
+}
+
+func TestRecall(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetKnowledge("jwt-research/rotation", "Refresh tokens are rotated on every use; reuse revokes the family")
+	cm.SetKnowledge("db/pool", "Postgres pool is capped at 20 connections")
+	cm.SetProtected("todo", "write tests for token rotation")
+
+	results, err := cm.Recall("rotating refresh tokens", SearchOptions{})
+	if err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+	if len(results) == 0 || results[0].Key != "jwt-research/rotation" {
+		t.Fatalf("Expected the rotation entry first, got %+v", results)
+	}
+	for _, r := range results {
+		if r.Key == "db/pool" && r.Score >= results[0].Score {
+			t.Error("Expected the unrelated entry to rank lower")
+		}
+	}
+
+	scoped, err := cm.Recall("rotating refresh tokens", SearchOptions{Kinds: []string{KindMemory}})
+	if err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+	if len(scoped) != 1 || scoped[0].Key != "todo" {
+		t.Errorf("Expected only the memory entry, got %+v", scoped)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "vectors", vectorFileName(NewHashEmbedder(0).Name()))); err != nil {
+		t.Errorf("Expected vectors to be stored: %v", err)
+	}
+}
+
+func TestRecallReusesStoredVectors(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetKnowledge("a", "alpha notes")
+	cm.SetKnowledge("b", "beta notes")
+
+	embedder := &countingEmbedder{HashEmbedder: NewHashEmbedder(64)}
+	cm.SetEmbedder(embedder)
+	if _, err := cm.Recall("notes", SearchOptions{}); err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+	if embedder.embedded != 3 {
+		t.Errorf("Expected 2 entries and the query to be embedded, got %d", embedder.embedded)
+	}
+
+	// Only the changed entry is embedded again
+	cm.SetKnowledge("b", "beta notes, revised")
+	embedder.embedded = 0
+	cm.Recall("notes", SearchOptions{})
+	if embedder.embedded != 2 {
+		t.Errorf("Expected the changed entry and the query to be embedded, got %d", embedder.embedded)
+	}
+
+	// A new manager picks the vectors up from disk
+	cm2 := NewContextManager(tempDir)
+	cm2.LoadContext()
+	embedder2 := &countingEmbedder{HashEmbedder: NewHashEmbedder(64)}
+	cm2.SetEmbedder(embedder2)
+	cm2.Recall("notes", SearchOptions{})
+	if embedder2.embedded != 1 {
+		t.Errorf("Expected only the query to be embedded, got %d", embedder2.embedded)
+	}
+
+	if _, err := cm.Recall("  ", SearchOptions{}); err == nil {
+		t.Error("Expected error for an empty query")
+	}
+}
@@ -0,0 +1,320 @@
+package context
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// Keys and tags count this many times as often as body text
+	keyBoost = 3
+
+	defaultSearchLimit = 10
+	snippetLength      = 200
+)
+
+// Kinds of context searched by SearchContext
+const (
+	KindMemory    = "memory"
+	KindKnowledge = "knowledge"
+	KindCommand   = "command"
+)
+
+// SearchOptions narrows SearchContext
+type SearchOptions struct {
+	Kinds []string // Any of KindMemory, KindKnowledge, KindCommand; empty means all
+	Scope string   // Only entries whose key starts with Scope or that are tagged Scope
+	Limit int      // 0 means defaultSearchLimit
+}
+
+// ContextSearchResult is a ranked match from SearchContext
+type ContextSearchResult struct {
+	Kind    string   `json:"kind"`
+	Key     string   `json:"key"` // Entry key, or the command for command history
+	Snippet string   `json:"snippet"`
+	Score   float64  `json:"score"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// searchDoc is one indexed entry
+type searchDoc struct {
+	kind string
+	key  string
+	text string
+	tags []string
+}
+
+// posting records how often a term occurs in a document
+type posting struct {
+	doc int
+	tf  int
+}
+
+// searchIndex is an in-memory inverted index over the context
+type searchIndex struct {
+	revision uint64
+	docs     []searchDoc
+	docLen   []int
+	avgLen   float64
+	postings map[string][]posting
+}
+
+// SearchContext ranks memory, knowledge and command history against query
+// using BM25
+func (cm *ContextManager) SearchContext(query string) []ContextSearchResult {
+	return cm.SearchContextWith(query, SearchOptions{})
+}
+
+// SearchContextWith is SearchContext with filters, e.g. a scope such as
+// "jwt-research" to search only one topic
+func (cm *ContextManager) SearchContextWith(query string, opts SearchOptions) []ContextSearchResult {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	idx := cm.searchIndex()
+	scores := make(map[int]float64)
+	n := float64(len(idx.docs))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.tf)
+			norm := 1 - bm25B + bm25B*float64(idx.docLen[p.doc])/idx.avgLen
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	results := make([]ContextSearchResult, 0, len(scores))
+	for docID, score := range scores {
+		doc := idx.docs[docID]
+		if !doc.matches(opts) {
+			continue
+		}
+		results = append(results, ContextSearchResult{
+			Kind:    doc.kind,
+			Key:     doc.key,
+			Snippet: snippet(doc.text, terms),
+			Score:   score,
+			Tags:    doc.tags,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Kind != results[j].Kind {
+			return results[i].Kind < results[j].Kind
+		}
+		return results[i].Key < results[j].Key
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matches reports whether the document passes the kind and scope filters
+func (d searchDoc) matches(opts SearchOptions) bool {
+	if len(opts.Kinds) > 0 {
+		found := false
+		for _, kind := range opts.Kinds {
+			if kind == d.kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if opts.Scope == "" || strings.HasPrefix(d.key, opts.Scope) {
+		return true
+	}
+	for _, tag := range d.tags {
+		if strings.EqualFold(tag, opts.Scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndex returns the index for the current context, rebuilding it if
+// the context changed since it was built
+func (cm *ContextManager) searchIndex() *searchIndex {
+	cm.indexMutex.Lock()
+	defer cm.indexMutex.Unlock()
+
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if cm.index != nil && cm.index.revision == cm.revision {
+		return cm.index
+	}
+	cm.index = buildSearchIndex(cm.context, cm.revision)
+	return cm.index
+}
+
+// buildSearchIndex indexes memory, knowledge and command history
+func buildSearchIndex(ctx *Context, revision uint64) *searchIndex {
+	var docs []searchDoc
+	for key, value := range ctx.Memory {
+		docs = append(docs, searchDoc{kind: KindMemory, key: key, text: value, tags: ctx.MemoryMeta[key].Tags})
+	}
+	for key, value := range ctx.Knowledge {
+		docs = append(docs, searchDoc{kind: KindKnowledge, key: key, text: value, tags: ctx.KnowledgeMeta[key].Tags})
+	}
+	seen := make(map[string]bool)
+	for i := len(ctx.Workspace.CommandHistory) - 1; i >= 0; i-- {
+		record := ctx.Workspace.CommandHistory[i]
+		// Only the most recent run of a repeated command is indexed
+		if seen[record.Command] {
+			continue
+		}
+		seen[record.Command] = true
+		docs = append(docs, searchDoc{kind: KindCommand, key: record.Command, text: record.Summary})
+	}
+	// Deterministic document order keeps tie-breaking stable
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].kind != docs[j].kind {
+			return docs[i].kind < docs[j].kind
+		}
+		return docs[i].key < docs[j].key
+	})
+
+	idx := &searchIndex{
+		revision: revision,
+		docs:     docs,
+		docLen:   make([]int, len(docs)),
+		postings: make(map[string][]posting),
+	}
+	total := 0
+	for i, doc := range docs {
+		counts := make(map[string]int)
+		length := 0
+		for _, term := range tokenize(doc.text) {
+			counts[term]++
+			length++
+		}
+		boosted := tokenize(doc.key + " " + strings.Join(doc.tags, " "))
+		for _, term := range boosted {
+			counts[term] += keyBoost
+			length += keyBoost
+		}
+		for term, tf := range counts {
+			idx.postings[term] = append(idx.postings[term], posting{doc: i, tf: tf})
+		}
+		idx.docLen[i] = length
+		total += length
+	}
+	if len(docs) > 0 {
+		idx.avgLen = float64(total) / float64(len(docs))
+	}
+	if idx.avgLen == 0 {
+		idx.avgLen = 1
+	}
+	return idx
+}
+
+// stopWords are too common to help ranking
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "was": true,
+	"we": true, "with": true,
+}
+
+// tokenize splits text into lowercase terms, dropping stop words and
+// folding simple plurals so "tokens" matches "token"
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := fields[:0]
+	for _, field := range fields {
+		if stopWords[field] {
+			continue
+		}
+		terms = append(terms, stem(field))
+	}
+	return terms
+}
+
+// stem folds plural "s" and "ies" endings
+func stem(term string) string {
+	switch {
+	case len(term) > 4 && strings.HasSuffix(term, "ies"):
+		return term[:len(term)-3] + "y"
+	case len(term) > 3 && strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") && !strings.HasSuffix(term, "us"):
+		return term[:len(term)-1]
+	}
+	return term
+}
+
+// snippet returns the part of text around the first query term
+func snippet(text string, terms []string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= snippetLength {
+		return text
+	}
+
+	lower := strings.ToLower(text)
+	pos := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+	start := 0
+	if pos > snippetLength/4 {
+		start = pos - snippetLength/4
+	}
+	end := start + snippetLength
+	if end > len(text) {
+		end = len(text)
+		start = end - snippetLength
+	}
+	// Don't cut UTF-8 sequences in half
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+
+	result := text[start:end]
+	if start > 0 {
+		result = "..." + result
+	}
+	if end < len(text) {
+		result += "..."
+	}
+	return result
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,112 @@
+package context
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchContextRanksByRelevance(t *testing.T) {
+	cm := NewContextManager(t.TempDir())
+	cm.SetKnowledge("jwt-research/refresh", "Refresh tokens rotate on every use and are stored hashed")
+	cm.SetKnowledge("jwt-research/signing", "Access tokens are signed with RS256")
+	cm.SetProtected("build", "Run make all before committing")
+	cm.AddCommandRecord("go test ./pkg/auth/...", 1, 0, time.Second)
+
+	results := cm.SearchContext("refresh tokens")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d: %+v", len(results), results)
+	}
+	if results[0].Key != "jwt-research/refresh" {
+		t.Errorf("Expected refresh entry first, got %s", results[0].Key)
+	}
+	if results[0].Kind != KindKnowledge {
+		t.Errorf("Expected kind knowledge, got %s", results[0].Kind)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("Expected descending scores, got %f then %f", results[0].Score, results[1].Score)
+	}
+
+	commands := cm.SearchContext("auth")
+	if len(commands) != 1 || commands[0].Kind != KindCommand {
+		t.Errorf("Expected the auth test command, got %+v", commands)
+	}
+
+	if results := cm.SearchContext("the and"); results != nil {
+		t.Errorf("Expected no results for stop words, got %+v", results)
+	}
+}
+
+func TestSearchContextWithScopeAndKind(t *testing.T) {
+	cm := NewContextManager(t.TempDir())
+	cm.SetKnowledge("jwt-research/refresh", "refresh tokens rotate")
+	cm.SetKnowledge("oauth/refresh", "refresh tokens come from the provider")
+	cm.SetProtected("notes", "refresh tokens still need tests")
+	cm.SetEntryTags("memory", "notes", []string{"jwt-research"})
+
+	scoped := cm.SearchContextWith("refresh tokens", SearchOptions{Scope: "jwt-research"})
+	if len(scoped) != 2 {
+		t.Fatalf("Expected 2 scoped results, got %+v", scoped)
+	}
+	for _, r := range scoped {
+		if r.Key == "oauth/refresh" {
+			t.Error("Expected entries outside the scope to be excluded")
+		}
+	}
+
+	memory := cm.SearchContextWith("refresh", SearchOptions{Kinds: []string{KindMemory}})
+	if len(memory) != 1 || memory[0].Key != "notes" {
+		t.Errorf("Expected only the memory entry, got %+v", memory)
+	}
+
+	limited := cm.SearchContextWith("refresh", SearchOptions{Limit: 1})
+	if len(limited) != 1 {
+		t.Errorf("Expected 1 result with limit, got %d", len(limited))
+	}
+}
+
+func TestSearchIndexTracksChanges(t *testing.T) {
+	cm := NewContextManager(t.TempDir())
+	cm.SetProtected("cache", "redis holds sessions")
+	if len(cm.SearchContext("redis")) != 1 {
+		t.Fatal("Expected entry to be found")
+	}
+
+	cm.DeleteProtected("cache")
+	if results := cm.SearchContext("redis"); len(results) != 0 {
+		t.Errorf("Expected deleted entry to drop out of the index, got %+v", results)
+	}
+
+	// A fresh manager loading the same directory sees the saved entries
+	dir := t.TempDir()
+	first := NewContextManager(dir)
+	first.SetKnowledge("db", "postgres migrations live in db/migrations")
+	second := NewContextManager(dir)
+	if err := second.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if len(second.SearchContext("migrations")) != 1 {
+		t.Error("Expected loaded entry to be searchable")
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := strings.Join(tokenize("The Refresh-Tokens, policies & JWT_v2"), " ")
+	if got != "refresh token policy jwt v2" {
+		t.Errorf("Expected 'refresh token policy jwt v2', got %q", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("filler ", 100) + "the refresh token rotates" + strings.Repeat(" filler", 100)
+	s := snippet(long, []string{"refresh"})
+	if !strings.Contains(s, "refresh token") {
+		t.Errorf("Expected snippet around the match, got %q", s)
+	}
+	if !strings.HasPrefix(s, "...") || !strings.HasSuffix(s, "...") {
+		t.Errorf("Expected ellipses on both sides, got %q", s)
+	}
+	if snippet("short text", []string{"short"}) != "short text" {
+		t.Error("Expected short text to be returned whole")
+	}
+}
@@ -0,0 +1,249 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshots are looked for this far back when resolving a commit without
+// one of its own
+const maxSnapshotSearch = 10000
+
+// Snapshot is the memory and knowledge as they were at a commit. Snapshots
+// are written once, to snapshots/<commit>.json, and never changed.
+type Snapshot struct {
+	Commit        string               `json:"commit"`
+	Branch        string               `json:"branch,omitempty"`
+	Created       time.Time            `json:"created"`
+	Memory        map[string]string    `json:"memory"`
+	Knowledge     map[string]string    `json:"knowledge"`
+	MemoryMeta    map[string]EntryMeta `json:"memory_meta,omitempty"`
+	KnowledgeMeta map[string]EntryMeta `json:"knowledge_meta,omitempty"`
+}
+
+// SnapshotInfo describes a snapshot on the history of a ref
+type SnapshotInfo struct {
+	Commit           string    `json:"commit"`
+	Time             time.Time `json:"time"` // Commit time
+	Subject          string    `json:"subject"`
+	Tags             []string  `json:"tags,omitempty"`
+	MemoryEntries    int       `json:"memory_entries"`
+	KnowledgeEntries int       `json:"knowledge_entries"`
+}
+
+// SnapshotChange is an entry that differs between two snapshots
+type SnapshotChange struct {
+	Kind   string `json:"kind"` // KindMemory or KindKnowledge
+	Key    string `json:"key"`
+	Change string `json:"change"` // added, removed or changed
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// SnapshotDiff lists what changed between two snapshots
+type SnapshotDiff struct {
+	From    string           `json:"from"` // Commits of the compared snapshots
+	To      string           `json:"to"`
+	Changes []SnapshotChange `json:"changes"`
+}
+
+// TakeSnapshot pins the current memory and knowledge to the checked out
+// commit and returns it. A commit that already has a snapshot keeps it.
+func (gcm *GitContextManager) TakeSnapshot() (string, error) {
+	commit := gcm.getCurrentCommit()
+	if commit == "" {
+		return "", fmt.Errorf("no commit checked out")
+	}
+
+	ctx := gcm.snapshot()
+	snapshot := Snapshot{
+		Commit:        commit,
+		Branch:        ctx.Metadata.GitBranch,
+		Created:       time.Now(),
+		Memory:        ctx.Memory,
+		Knowledge:     ctx.Knowledge,
+		MemoryMeta:    ctx.MemoryMeta,
+		KnowledgeMeta: ctx.KnowledgeMeta,
+	}
+	if err := writeSnapshot(gcm.snapshotPath(commit), snapshot); err != nil {
+		return "", fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return commit, nil
+}
+
+// writeSnapshot writes a snapshot file unless it already exists. The file
+// is linked into place, so a concurrent writer can't replace it either.
+func writeSnapshot(path string, snapshot Snapshot) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp-" + strconv.Itoa(os.Getpid())
+	if err := saveJSONFile(tmp, snapshot); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, path); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ContextAt returns the context as of ref (a commit, tag or branch): the
+// snapshot of that commit or, if it has none, of its closest ancestor
+// that has one. The snapshot is a copy; changing it changes nothing.
+func (gcm *GitContextManager) ContextAt(ref string) (*Snapshot, error) {
+	commit, err := resolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot, err := gcm.loadSnapshot(commit); err == nil {
+		return snapshot, nil
+	}
+
+	history, err := gitOutput("rev-list", "--max-count="+strconv.Itoa(maxSnapshotSearch), commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", ref, err)
+	}
+	for _, c := range strings.Fields(history) {
+		if snapshot, err := gcm.loadSnapshot(c); err == nil {
+			return snapshot, nil
+		}
+	}
+	return nil, fmt.Errorf("no context snapshot at or before %s", ref)
+}
+
+// ContextManager returns a context manager under dir holding the
+// snapshot's memory and knowledge, to read the context as it was. Only the
+// directory is created; nothing is written unless the manager is saved.
+func (s *Snapshot) ContextManager(dir string) *ContextManager {
+	cm := NewContextManager(dir)
+	cm.context.Memory = copyStringMap(s.Memory)
+	cm.context.Knowledge = copyStringMap(s.Knowledge)
+	cm.context.MemoryMeta = copyEntryMeta(s.MemoryMeta)
+	cm.context.KnowledgeMeta = copyEntryMeta(s.KnowledgeMeta)
+	cm.context.Metadata.GitBranch = s.Branch
+	cm.context.Metadata.GitCommit = s.Commit
+	return cm
+}
+
+// ListSnapshots lists the snapshots along the history of ref (HEAD if
+// empty), newest first, at most limit of them (all if limit <= 0)
+func (gcm *GitContextManager) ListSnapshots(ref string, limit int) ([]SnapshotInfo, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := resolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	log, err := gitOutput("log", "--format=%H%x1f%ct%x1f%D%x1f%s", commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", ref, err)
+	}
+
+	var infos []SnapshotInfo
+	for _, line := range strings.Split(log, "\n") {
+		fields := strings.SplitN(line, "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		snapshot, err := gcm.loadSnapshot(fields[0])
+		if err != nil {
+			continue
+		}
+
+		info := SnapshotInfo{
+			Commit:           fields[0],
+			Subject:          fields[3],
+			MemoryEntries:    len(snapshot.Memory),
+			KnowledgeEntries: len(snapshot.Knowledge),
+		}
+		if seconds, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			info.Time = time.Unix(seconds, 0)
+		}
+		for _, decoration := range strings.Split(fields[2], ", ") {
+			if tag, ok := strings.CutPrefix(decoration, "tag: "); ok {
+				info.Tags = append(info.Tags, tag)
+			}
+		}
+		infos = append(infos, info)
+		if limit > 0 && len(infos) == limit {
+			break
+		}
+	}
+	return infos, nil
+}
+
+// DiffSnapshots compares the context as of two refs
+func (gcm *GitContextManager) DiffSnapshots(from, to string) (*SnapshotDiff, error) {
+	before, err := gcm.ContextAt(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := gcm.ContextAt(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SnapshotDiff{From: before.Commit, To: after.Commit}
+	diff.Changes = append(diff.Changes, diffEntries(KindKnowledge, before.Knowledge, after.Knowledge)...)
+	diff.Changes = append(diff.Changes, diffEntries(KindMemory, before.Memory, after.Memory)...)
+	return diff, nil
+}
+
+// diffEntries lists the entries added, removed or changed, in key order
+func diffEntries(kind string, before, after map[string]string) []SnapshotChange {
+	var changes []SnapshotChange
+	for key, value := range after {
+		old, existed := before[key]
+		switch {
+		case !existed:
+			changes = append(changes, SnapshotChange{Kind: kind, Key: key, Change: "added", After: value})
+		case old != value:
+			changes = append(changes, SnapshotChange{Kind: kind, Key: key, Change: "changed", Before: old, After: value})
+		}
+	}
+	for key, value := range before {
+		if _, exists := after[key]; !exists {
+			changes = append(changes, SnapshotChange{Kind: kind, Key: key, Change: "removed", Before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func (gcm *GitContextManager) snapshotPath(commit string) string {
+	return filepath.Join(gcm.basePath, "snapshots", commit+".json")
+}
+
+func (gcm *GitContextManager) loadSnapshot(commit string) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := loadJSONFile(gcm.snapshotPath(commit), &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Memory == nil {
+		snapshot.Memory = make(map[string]string)
+	}
+	if snapshot.Knowledge == nil {
+		snapshot.Knowledge = make(map[string]string)
+	}
+	return &snapshot, nil
+}
+
+// resolveCommit returns the full hash of the commit a ref points at
+func resolveCommit(ref string) (string, error) {
+	commit, err := gitOutput("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil || commit == "" {
+		return "", fmt.Errorf("unknown commit %q", ref)
+	}
+	return commit, nil
+}
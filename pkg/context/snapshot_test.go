@@ -0,0 +1,152 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotsAlongHistory(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+
+	gcm.SetKnowledge("database", "use sqlite")
+	first, err := gcm.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+	runGit(t, "tag", "v1.0")
+
+	// A commit without a snapshot, then one with
+	commitFile(t, "a.go", "package a")
+	commitFile(t, "b.go", "package b")
+	gcm.SetKnowledge("database", "use postgres")
+	gcm.SetProtected("task", "migrate data")
+	second, err := gcm.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+
+	// The tag resolves to the first snapshot, read-only
+	past, err := gcm.ContextAt("v1.0")
+	if err != nil {
+		t.Fatalf("ContextAt failed: %v", err)
+	}
+	if past.Commit != first || past.Knowledge["database"] != "use sqlite" {
+		t.Errorf("Expected the v1.0 snapshot, got %s %v", past.Commit, past.Knowledge)
+	}
+	past.Knowledge["database"] = "changed"
+	if again, _ := gcm.ContextAt("v1.0"); again.Knowledge["database"] != "use sqlite" {
+		t.Error("Expected snapshots not to change through a loaded copy")
+	}
+
+	// A commit without a snapshot resolves to its closest ancestor's
+	between, err := gcm.ContextAt("HEAD~1")
+	if err != nil || between.Commit != first {
+		t.Errorf("Expected HEAD~1 to resolve to the first snapshot, got %v (%v)", between, err)
+	}
+
+	infos, err := gcm.ListSnapshots("", 0)
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Commit != second || infos[1].Commit != first {
+		t.Fatalf("Expected both snapshots newest first, got %+v", infos)
+	}
+	if !reflect.DeepEqual(infos[1].Tags, []string{"v1.0"}) || infos[0].MemoryEntries != 1 {
+		t.Errorf("Unexpected snapshot info: %+v", infos)
+	}
+	if infos, _ := gcm.ListSnapshots("HEAD", 1); len(infos) != 1 {
+		t.Errorf("Expected limit to apply, got %d", len(infos))
+	}
+
+	diff, err := gcm.DiffSnapshots("v1.0", "HEAD")
+	if err != nil {
+		t.Fatalf("DiffSnapshots failed: %v", err)
+	}
+	expected := []SnapshotChange{
+		{Kind: KindKnowledge, Key: "database", Change: "changed", Before: "use sqlite", After: "use postgres"},
+		{Kind: KindMemory, Key: "task", Change: "added", After: "migrate data"},
+	}
+	if !reflect.DeepEqual(diff.Changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diff.Changes)
+	}
+}
+
+func TestSnapshotsAreImmutable(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+
+	gcm.SetKnowledge("style", "original")
+	commit, err := gcm.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm.SetKnowledge("style", "changed")
+	if _, err := gcm.TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := gcm.ContextAt(commit)
+	if err != nil || snapshot.Knowledge["style"] != "original" {
+		t.Errorf("Expected the first snapshot to be kept, got %v (%v)", snapshot, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(gcm.snapshotPath(commit)), "*.tmp-*")); len(leftovers) > 0 {
+		t.Errorf("Expected no temporary files, got %v", leftovers)
+	}
+}
+
+func TestContextAtErrors(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+
+	if _, err := gcm.ContextAt("no-such-ref"); err == nil {
+		t.Error("Expected an error for an unknown ref")
+	}
+	if _, err := gcm.ContextAt("HEAD"); err == nil {
+		t.Error("Expected an error when there are no snapshots")
+	}
+}
+
+func TestRunGitHookPostCommitTakesSnapshot(t *testing.T) {
+	initGitRepo(t)
+	contextDir := filepath.Join(t.TempDir(), "context")
+	gcm := NewGitContextManager(contextDir)
+	gcm.SetKnowledge("style", "wrap errors")
+
+	commitFile(t, "c.go", "package c")
+	if err := NewGitContextManager(contextDir).RunGitHook("post-commit", nil); err != nil {
+		t.Fatalf("RunGitHook failed: %v", err)
+	}
+	head, _ := gitOutput("rev-parse", "HEAD")
+	if _, err := os.Stat(gcm.snapshotPath(head)); err != nil {
+		t.Errorf("Expected a snapshot of %s: %v", head, err)
+	}
+}
+
+func TestSnapshotContextManager(t *testing.T) {
+	initGitRepo(t)
+	gcm := NewGitContextManager(filepath.Join(t.TempDir(), "context"))
+	gcm.SetKnowledge("database", "use sqlite")
+	if _, err := gcm.TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	gcm.SetKnowledge("database", "use postgres")
+
+	snapshot, err := gcm.ContextAt("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "past")
+	past := snapshot.ContextManager(dir)
+	if value, _ := past.GetKnowledge("database"); value != "use sqlite" {
+		t.Errorf("Expected the snapshot's knowledge, got %q", value)
+	}
+	if stats := past.GetStats(); stats.GitCommit != snapshot.Commit {
+		t.Errorf("Expected commit %s, got %s", snapshot.Commit, stats.GitCommit)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected nothing written, got %v", entries)
+	}
+}
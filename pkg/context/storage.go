@@ -0,0 +1,120 @@
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage formats for memory and knowledge entries. Workspace state and
+// metadata are always stored as JSON.
+const (
+	FormatJSON     = "json"     // memory/current.json, knowledge/patterns.json
+	FormatMarkdown = "markdown" // One Markdown file with front-matter per entry
+)
+
+// EntryMeta holds bookkeeping for a memory or knowledge entry
+type EntryMeta struct {
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Tags    []string  `json:"tags,omitempty"`
+}
+
+// entrySet is a kind of entry (memory or knowledge) with its metadata
+type entrySet struct {
+	dir    string // Subdirectory of the context base path
+	values map[string]string
+	meta   map[string]EntryMeta
+}
+
+// Storage reads and writes memory and knowledge entries under a base path
+type Storage interface {
+	Save(basePath string, set entrySet) error
+	Load(basePath string, set *entrySet) error
+	// Clear removes the files this format wrote, used when converting
+	Clear(basePath string, dir string) error
+}
+
+// storageFor returns the backend for a format
+func storageFor(format string) (Storage, error) {
+	switch format {
+	case FormatJSON, "":
+		return jsonStorage{}, nil
+	case FormatMarkdown:
+		return markdownStorage{}, nil
+	default:
+		return nil, fmt.Errorf("unknown context storage format: %s", format)
+	}
+}
+
+// detectFormat works out which format the context under basePath uses:
+// the format recorded in metadata.json, else Markdown if there are Markdown
+// entries and no JSON ones, else JSON
+func detectFormat(basePath string) string {
+	var meta ContextMetadata
+	if err := loadJSONFile(filepath.Join(basePath, "metadata.json"), &meta); err == nil && meta.Storage != "" {
+		return meta.Storage
+	}
+
+	if _, err := os.Stat(filepath.Join(basePath, "memory", "current.json")); err == nil {
+		return FormatJSON
+	}
+	for _, dir := range []string{"memory", "knowledge"} {
+		if matches, _ := filepath.Glob(filepath.Join(basePath, dir, "*.md")); len(matches) > 0 {
+			return FormatMarkdown
+		}
+	}
+	return FormatJSON
+}
+
+// jsonStorage keeps each kind of entry in a single JSON map, with entry
+// metadata alongside in meta.json
+type jsonStorage struct{}
+
+func (jsonStorage) fileName(dir string) string {
+	if dir == "memory" {
+		return "current.json"
+	}
+	return "patterns.json"
+}
+
+func (s jsonStorage) Save(basePath string, set entrySet) error {
+	dir := filepath.Join(basePath, set.dir)
+	if err := saveJSONFile(filepath.Join(dir, s.fileName(set.dir)), set.values); err != nil {
+		return err
+	}
+	return saveJSONFile(filepath.Join(dir, "meta.json"), set.meta)
+}
+
+func (s jsonStorage) Load(basePath string, set *entrySet) error {
+	dir := filepath.Join(basePath, set.dir)
+	if err := loadJSONFile(filepath.Join(dir, s.fileName(set.dir)), &set.values); err != nil {
+		return err
+	}
+	// Entry metadata is optional (older contexts don't have it)
+	if err := loadJSONFile(filepath.Join(dir, "meta.json"), &set.meta); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s jsonStorage) Clear(basePath string, dir string) error {
+	for _, name := range []string{s.fileName(dir), "meta.json"} {
+		if err := os.Remove(filepath.Join(basePath, dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileIfChanged atomically writes data to path unless the file already
+// holds exactly that, so unchanged entries keep their mtime and stay out of
+// diffs
+func writeFileIfChanged(path string, data []byte) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	return writeFileAtomic(path, data)
+}
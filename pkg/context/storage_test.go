@@ -0,0 +1,120 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStorageFormatRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetProtected("decision", "use sqlite\nfor now")
+	cm.SetKnowledge("style", "errors wrap with %w")
+	if err := cm.SetEntryTags("knowledge", "style", []string{"go", "errors"}); err != nil {
+		t.Fatalf("SetEntryTags failed: %v", err)
+	}
+	if cm.StorageFormat() != FormatJSON {
+		t.Fatalf("Expected JSON by default, got %s", cm.StorageFormat())
+	}
+	original := cm.GetContext()
+
+	// JSON -> Markdown -> JSON keeps every entry and its metadata
+	for _, format := range []string{FormatMarkdown, FormatJSON} {
+		if err := cm.SetStorageFormat(format); err != nil {
+			t.Fatalf("SetStorageFormat(%s) failed: %v", format, err)
+		}
+
+		loaded := NewContextManager(tempDir)
+		if err := loaded.LoadContext(); err != nil {
+			t.Fatalf("LoadContext failed: %v", err)
+		}
+		ctx := loaded.GetContext()
+		if loaded.StorageFormat() != format {
+			t.Errorf("Expected format %s, got %s", format, loaded.StorageFormat())
+		}
+		if !reflect.DeepEqual(ctx.Memory, original.Memory) || !reflect.DeepEqual(ctx.Knowledge, original.Knowledge) {
+			t.Errorf("Expected entries to round-trip through %s, got %v / %v", format, ctx.Memory, ctx.Knowledge)
+		}
+		meta := ctx.KnowledgeMeta["style"]
+		want := original.KnowledgeMeta["style"]
+		// Markdown stores times with second precision
+		if !reflect.DeepEqual(meta.Tags, want.Tags) || meta.Created.Sub(want.Created).Abs() >= time.Second {
+			t.Errorf("Expected metadata to round-trip through %s, got %+v want %+v", format, meta, want)
+		}
+	}
+
+	// Converting back to JSON removes the Markdown files
+	if matches, _ := filepath.Glob(filepath.Join(tempDir, "memory", "*.md")); len(matches) != 0 {
+		t.Errorf("Expected Markdown files to be removed, got %v", matches)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tempDir := t.TempDir()
+	if got := detectFormat(tempDir); got != FormatJSON {
+		t.Errorf("Expected JSON for an empty directory, got %s", got)
+	}
+
+	// Markdown files without metadata.json, e.g. a hand-made context
+	os.MkdirAll(filepath.Join(tempDir, "knowledge"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "knowledge", "codebase-map.md"), []byte("pkg/ai: API client\n"), 0644)
+	if got := detectFormat(tempDir); got != FormatMarkdown {
+		t.Errorf("Expected markdown, got %s", got)
+	}
+
+	if _, err := storageFor("yaml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	cm := NewContextManager(tempDir)
+	if err := cm.SetStorageFormat("yaml"); err == nil {
+		t.Error("Expected SetStorageFormat to reject unknown format")
+	}
+}
+
+func TestEntryMetaTimestamps(t *testing.T) {
+	cm := NewContextManager(t.TempDir())
+	cm.SetProtected("k", "v1")
+	first, ok := cm.GetEntryMeta("memory", "k")
+	if !ok || first.Created.IsZero() {
+		t.Fatalf("Expected entry metadata, got %+v", first)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	cm.SetProtected("k", "v2")
+	second, _ := cm.GetEntryMeta("memory", "k")
+	if !second.Created.Equal(first.Created) || !second.Updated.After(first.Updated) {
+		t.Errorf("Expected created to stay and updated to advance, got %+v then %+v", first, second)
+	}
+
+	if err := cm.SetEntryTags("memory", "missing", nil); err == nil {
+		t.Error("Expected error tagging a missing entry")
+	}
+}
+
+func TestFormatConversionKeepsOldFilesUntilWritten(t *testing.T) {
+	tempDir := t.TempDir()
+	cm := NewContextManager(tempDir)
+	cm.SetProtected("build", "make all")
+
+	// Block the Markdown file so the conversion fails part way
+	if err := os.MkdirAll(filepath.Join(tempDir, "memory", "build.md"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := cm.SetStorageFormat(FormatMarkdown); err == nil {
+		t.Fatal("Expected conversion to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "memory", "current.json")); err != nil {
+		t.Fatalf("Expected JSON memory to survive a failed conversion: %v", err)
+	}
+	reloaded := NewContextManager(tempDir)
+	if err := reloaded.LoadContext(); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if v, _ := reloaded.GetProtected("build"); v != "make all" {
+		t.Errorf("Expected memory to load after a failed conversion, got %q", v)
+	}
+}
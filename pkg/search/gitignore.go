@@ -0,0 +1,197 @@
+package search
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single compiled .gitignore pattern
+type ignoreRule struct {
+	re      *regexp.Regexp
+	base    string // Directory of the .gitignore, relative to the root ("" for the root)
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreMatcher evaluates .gitignore rules. Paths are slash-separated and
+// relative to the root the matcher was created for.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+// NewIgnoreMatcher creates an empty matcher
+func NewIgnoreMatcher() *IgnoreMatcher {
+	return &IgnoreMatcher{}
+}
+
+// LoadFile adds the rules of a .gitignore file located in directory base
+func (im *IgnoreMatcher) LoadFile(base, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	im.AddPatterns(base, lines)
+	return nil
+}
+
+// AddPatterns adds gitignore-style patterns that apply below directory base
+func (im *IgnoreMatcher) AddPatterns(base string, patterns []string) {
+	base = strings.Trim(base, "/")
+	if base == "." {
+		base = ""
+	}
+
+	for _, p := range patterns {
+		p = strings.TrimRight(p, " \t\r")
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(p, "!") {
+			rule.negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\`) {
+			p = p[1:] // Escaped leading '#' or '!'
+		}
+
+		if strings.HasSuffix(p, "/") {
+			rule.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if p == "" {
+			continue
+		}
+
+		// A slash anywhere but the end anchors the pattern to base
+		anchored := strings.Contains(p, "/")
+		p = strings.TrimPrefix(p, "/")
+
+		expr := globToRegexp(p)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "^(?:.*/)?" + expr + "$"
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			continue // Ignore malformed patterns like git does
+		}
+		rule.re = re
+		im.rules = append(im.rules, rule)
+	}
+}
+
+// Match reports whether relPath is ignored. The last matching rule wins,
+// so negated patterns can re-include paths.
+func (im *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+
+	ignored := false
+	for _, rule := range im.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		sub := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			sub = relPath[len(rule.base)+1:]
+		}
+
+		if rule.re.MatchString(sub) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp converts a gitignore glob into a regular expression body
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				// "**/" matches zero or more directories, a trailing "**" everything
+				if i+2 < len(glob) && glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// GlobMatcher matches paths against a list of globs (with ** support).
+// A glob without a slash matches the base name at any depth.
+type GlobMatcher struct {
+	im *IgnoreMatcher
+}
+
+// NewGlobMatcher compiles comma-separated globs; it returns nil for an empty list
+func NewGlobMatcher(globs string) *GlobMatcher {
+	var patterns []string
+	for _, g := range strings.Split(globs, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			patterns = append(patterns, g)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	im := NewIgnoreMatcher()
+	im.AddPatterns("", patterns)
+	return &GlobMatcher{im: im}
+}
+
+// Match reports whether relPath matches any of the globs
+func (gm *GlobMatcher) Match(relPath string, isDir bool) bool {
+	return gm.im.Match(relPath, isDir)
+}
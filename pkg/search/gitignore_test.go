@@ -0,0 +1,62 @@
+package search
+
+import "testing"
+
+func TestIgnoreMatcher(t *testing.T) {
+	im := NewIgnoreMatcher()
+	im.AddPatterns("", []string{
+		"# comment",
+		"node_modules/",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"docs/**/*.tmp",
+	})
+	im.AddPatterns("web", []string{"dist"})
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"node_modules", true, true},
+		{"web/gui/node_modules", true, true},
+		{"node_modules", false, false}, // Directory-only pattern
+		{"server.log", false, true},
+		{"logs/server.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"cmd/build", true, false}, // Anchored to the root
+		{"docs/a/b/c.tmp", false, true},
+		{"docs/c.tmp", false, true},
+		{"web/dist", true, true},
+		{"dist", true, false}, // Only applies below web/
+		{"main.go", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := im.Match(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("Match(%q, %v) = %v, expected %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+}
+
+func TestGlobMatcher(t *testing.T) {
+	gm := NewGlobMatcher("*.go, cmd/**")
+
+	if !gm.Match("pkg/ai/claude.go", false) {
+		t.Error("Expected *.go to match at any depth")
+	}
+
+	if !gm.Match("cmd/server/main.ts", false) {
+		t.Error("Expected cmd/** to match below cmd")
+	}
+
+	if gm.Match("web/gui/src/App.tsx", false) {
+		t.Error("Expected App.tsx not to match")
+	}
+
+	if NewGlobMatcher(" , ") != nil {
+		t.Error("Expected nil matcher for an empty glob list")
+	}
+}
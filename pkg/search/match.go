@@ -0,0 +1,95 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher decides whether a line matches a search pattern. It is shared by
+// shell output search and code search so both behave the same way.
+type Matcher struct {
+	pattern    string
+	re         *regexp.Regexp
+	ignoreCase bool
+}
+
+// Match represents a search match with surrounding context lines
+type Match struct {
+	LineNumber   int      `json:"lineNumber"` // 1-indexed
+	Line         string   `json:"line"`
+	Context      []string `json:"context,omitempty"`      // Lines before/after for context, including the match
+	ContextStart int      `json:"contextStart,omitempty"` // Line number of Context[0]
+}
+
+// NewMatcher creates a matcher for a literal substring or a regular expression
+func NewMatcher(pattern string, regex, ignoreCase bool) (*Matcher, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty search pattern")
+	}
+
+	m := &Matcher{pattern: pattern, ignoreCase: ignoreCase}
+	if regex {
+		expr := pattern
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		m.re = re
+	} else if ignoreCase {
+		m.pattern = strings.ToLower(pattern)
+	}
+	return m, nil
+}
+
+// MatchLine reports whether the line matches
+func (m *Matcher) MatchLine(line string) bool {
+	if m.re != nil {
+		return m.re.MatchString(line)
+	}
+	if m.ignoreCase {
+		return strings.Contains(strings.ToLower(line), m.pattern)
+	}
+	return strings.Contains(line, m.pattern)
+}
+
+// FindMatches returns up to maxMatches matches (0 means unlimited) with
+// contextLines lines of context on each side
+func FindMatches(lines []string, m *Matcher, contextLines, maxMatches int) []Match {
+	var matches []Match
+	for i, line := range lines {
+		if !m.MatchLine(line) {
+			continue
+		}
+		match := Match{
+			LineNumber: i + 1,
+			Line:       line,
+		}
+		if contextLines > 0 {
+			match.Context, match.ContextStart = contextAround(lines, i, contextLines)
+		}
+		matches = append(matches, match)
+		if maxMatches > 0 && len(matches) >= maxMatches {
+			break
+		}
+	}
+	return matches
+}
+
+// contextAround returns the lines around center and the line number of the first one
+func contextAround(lines []string, center, contextSize int) ([]string, int) {
+	start := center - contextSize
+	if start < 0 {
+		start = 0
+	}
+
+	end := center + contextSize + 1
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	return lines[start:end], start + 1
+}
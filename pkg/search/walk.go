@@ -0,0 +1,238 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Files larger than this are skipped by code search
+	maxSearchFileSize = 2 * 1024 * 1024
+	// Default cap on the number of matches returned by SearchTree
+	defaultMaxResults = 100
+)
+
+// WalkOptions controls which entries Walk visits
+type WalkOptions struct {
+	NoIgnore   bool // Don't apply .gitignore rules
+	ShowHidden bool // Visit dot files and directories
+}
+
+// WalkFunc is called for every entry that survives filtering. relPath is
+// slash-separated and relative to the walk root.
+type WalkFunc func(path, relPath string, d fs.DirEntry) error
+
+// Walk traverses root like filepath.WalkDir, skipping .git, hidden entries
+// and anything ignored by .gitignore files (including those of parent
+// directories up to the repository root)
+func Walk(root string, opts WalkOptions, fn WalkFunc) error {
+	ignore := NewIgnoreMatcher()
+	prefix := ""
+	if !opts.NoIgnore {
+		prefix = loadParentIgnores(root, ignore)
+	}
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil // Unreadable entries are skipped rather than aborting the walk
+		}
+
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			if d.IsDir() && !opts.NoIgnore {
+				ignore.LoadFile(prefix, filepath.Join(p, ".gitignore"))
+			}
+			return nil
+		}
+
+		name := d.Name()
+		if d.IsDir() && name == ".git" {
+			return filepath.SkipDir
+		}
+		if !opts.ShowHidden && strings.HasPrefix(name, ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !opts.NoIgnore && ignore.Match(joinRel(prefix, rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() && !opts.NoIgnore {
+			ignore.LoadFile(joinRel(prefix, rel), filepath.Join(p, ".gitignore"))
+		}
+		return fn(p, rel, d)
+	})
+}
+
+// loadParentIgnores loads .gitignore files from the enclosing repository
+// root down to (but excluding) root. It returns root's path relative to the
+// repository root, which prefixes every path given to the matcher.
+func loadParentIgnores(root string, ignore *IgnoreMatcher) string {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return ""
+	}
+
+	// Find the repository root
+	repo := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			repo = dir
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if repo == "" || repo == abs {
+		return ""
+	}
+
+	rel, err := filepath.Rel(repo, abs)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+
+	dir := repo
+	base := ""
+	for _, part := range strings.Split(rel, "/") {
+		ignore.LoadFile(base, filepath.Join(dir, ".gitignore"))
+		dir = filepath.Join(dir, part)
+		base = joinRel(base, part)
+	}
+	return rel
+}
+
+func joinRel(prefix, rel string) string {
+	if prefix == "" {
+		return rel
+	}
+	return prefix + "/" + rel
+}
+
+// TreeOptions configures SearchTree
+type TreeOptions struct {
+	Pattern      string
+	Regex        bool
+	IgnoreCase   bool
+	Include      string // Comma-separated globs a file must match
+	Exclude      string // Comma-separated globs to skip
+	ContextLines int
+	MaxResults   int // 0 means defaultMaxResults
+	WalkOptions
+}
+
+// FileMatch is a match within a specific file
+type FileMatch struct {
+	Path string `json:"path"`
+	Match
+}
+
+// TreeResult holds the structured results of SearchTree
+type TreeResult struct {
+	Root          string      `json:"root"`
+	Pattern       string      `json:"pattern"`
+	Matches       []FileMatch `json:"matches"`
+	FilesSearched int         `json:"filesSearched"`
+	FilesMatched  int         `json:"filesMatched"`
+	Truncated     bool        `json:"truncated"`
+}
+
+// SearchTree searches every text file under root for the pattern
+func SearchTree(root string, opts TreeOptions) (*TreeResult, error) {
+	matcher, err := NewMatcher(opts.Pattern, opts.Regex, opts.IgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+
+	maxResults := opts.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultMaxResults
+	}
+	include := NewGlobMatcher(opts.Include)
+	exclude := NewGlobMatcher(opts.Exclude)
+
+	result := &TreeResult{Root: root, Pattern: opts.Pattern}
+	errStop := io.EOF // Sentinel to end the walk early
+
+	err = Walk(root, opts.WalkOptions, func(p, rel string, d fs.DirEntry) error {
+		if exclude != nil && exclude.Match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if include != nil && !include.Match(rel, false) {
+			return nil
+		}
+
+		lines, ok := readTextLines(p)
+		if !ok {
+			return nil
+		}
+		result.FilesSearched++
+
+		matches := FindMatches(lines, matcher, opts.ContextLines, maxResults-len(result.Matches))
+		if len(matches) > 0 {
+			result.FilesMatched++
+		}
+		for _, m := range matches {
+			result.Matches = append(result.Matches, FileMatch{Path: rel, Match: m})
+		}
+		if len(result.Matches) >= maxResults {
+			result.Truncated = true
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readTextLines reads a file's lines, reporting false for binary or huge files
+func readTextLines(p string) ([]string, bool) {
+	info, err := os.Stat(p)
+	if err != nil || info.Size() > maxSearchFileSize {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	sniff := data
+	if len(sniff) > 8000 {
+		sniff = sniff[:8000]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return nil, false
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, true
+}
@@ -0,0 +1,105 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func TestSearchTreeRespectsGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":                "node_modules/\n*.gen.go\n",
+		"main.go":                   "package main\n\nfunc main() {\n\tTODO()\n}\n",
+		"util.gen.go":               "// TODO generated\n",
+		"node_modules/lib/index.js": "// TODO vendored\n",
+		"pkg/lib/lib.go":            "package lib\n// todo lowercase\n",
+		"pkg/lib/.gitignore":        "skip.go\n",
+		"pkg/lib/skip.go":           "// TODO skipped by nested gitignore\n",
+		".hidden/notes.txt":         "TODO hidden\n",
+		"bin/blob":                  "TODO\x00binary",
+	})
+
+	result, err := SearchTree(root, TreeOptions{Pattern: "TODO", ContextLines: 1})
+	if err != nil {
+		t.Fatalf("SearchTree failed: %v", err)
+	}
+
+	if len(result.Matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d: %+v", len(result.Matches), result.Matches)
+	}
+
+	match := result.Matches[0]
+	if match.Path != "main.go" || match.LineNumber != 4 {
+		t.Errorf("Expected main.go:4, got %s:%d", match.Path, match.LineNumber)
+	}
+
+	if len(match.Context) != 3 || match.ContextStart != 3 {
+		t.Errorf("Expected 3 context lines starting at 3, got %d starting at %d", len(match.Context), match.ContextStart)
+	}
+
+	// Case-insensitive regex restricted to pkg/
+	result, err = SearchTree(root, TreeOptions{Pattern: `to+do`, Regex: true, IgnoreCase: true, Include: "pkg/**"})
+	if err != nil {
+		t.Fatalf("SearchTree failed: %v", err)
+	}
+
+	if len(result.Matches) != 1 || result.Matches[0].Path != "pkg/lib/lib.go" {
+		t.Errorf("Expected a single match in pkg/lib/lib.go, got %+v", result.Matches)
+	}
+
+	// Ignore rules can be switched off
+	result, err = SearchTree(root, TreeOptions{Pattern: "TODO", WalkOptions: WalkOptions{NoIgnore: true}})
+	if err != nil {
+		t.Fatalf("SearchTree failed: %v", err)
+	}
+
+	if len(result.Matches) != 4 {
+		t.Errorf("Expected 4 matches without ignore rules, got %d", len(result.Matches))
+	}
+}
+
+func TestSearchTreeMaxResults(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt": "x\nx\nx\n",
+		"b.txt": "x\nx\n",
+	})
+
+	result, err := SearchTree(root, TreeOptions{Pattern: "x", MaxResults: 4})
+	if err != nil {
+		t.Fatalf("SearchTree failed: %v", err)
+	}
+
+	if len(result.Matches) != 4 || !result.Truncated {
+		t.Errorf("Expected 4 truncated matches, got %d (truncated=%v)", len(result.Matches), result.Truncated)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	if _, err := NewMatcher("(", true, false); err == nil {
+		t.Error("Expected error for invalid regex")
+	}
+
+	m, err := NewMatcher("Hello", false, true)
+	if err != nil {
+		t.Fatalf("NewMatcher failed: %v", err)
+	}
+
+	if !m.MatchLine("say hello") {
+		t.Error("Expected case-insensitive match")
+	}
+}
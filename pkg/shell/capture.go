@@ -8,6 +8,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"stackagent/pkg/search"
 )
 
 // OutputHandle represents a handle to command output that can be queried
@@ -20,6 +22,7 @@ type OutputHandle struct {
 	StartTime time.Time
 	EndTime   *time.Time
 	mutex     sync.RWMutex
+	done      chan struct{} // Closed when the command completes
 }
 
 // Stats provides statistics about the output
@@ -31,11 +34,7 @@ type Stats struct {
 }
 
 // Match represents a search match in the output
-type Match struct {
-	LineNumber int
-	Line       string
-	Context    []string // Lines before/after for context
-}
+type Match = search.Match
 
 // ShellManager manages shell sessions and output handles
 type ShellManager struct {
@@ -54,15 +53,16 @@ func NewShellManager() *ShellManager {
 
 // RunWithCapture executes a command and returns a handle for querying output
 func (sm *ShellManager) RunWithCapture(cmd string) (*OutputHandle, error) {
-	// Generate unique uint64 ID
-	id := sm.nextID
-	atomic.AddUint64(&sm.nextID, 1)
+	// Generate unique uint64 ID; reading and incrementing in one atomic
+	// step keeps concurrent runs from sharing an ID
+	id := atomic.AddUint64(&sm.nextID, 1) - 1
 	
 	handle := &OutputHandle{
 		ID:        id,
 		Command:   cmd,
 		Buffer:    []string{},
 		StartTime: time.Now(),
+		done:      make(chan struct{}),
 	}
 	
 	// Store handle immediately
@@ -132,13 +132,37 @@ func (sm *ShellManager) RunWithCapture(cmd string) (*OutputHandle, error) {
 			handle.ExitCode = 0
 		}
 		handle.mutex.Unlock()
+		close(handle.done)
 	}()
 	
 	return handle, nil
 }
 
+// Wait blocks until the command behind a handle completes and returns its
+// final stats
+func (sm *ShellManager) Wait(handleID uint64) (*Stats, error) {
+	handle, exists := sm.GetHandle(handleID)
+	if !exists {
+		return nil, fmt.Errorf("handle %d not found", handleID)
+	}
+	if handle.done != nil {
+		<-handle.done
+	}
+	return sm.GetStats(handleID)
+}
+
 // SearchOutput searches for a pattern in the output and returns matches
 func (sm *ShellManager) SearchOutput(handleID uint64, pattern string) ([]Match, error) {
+	matcher, err := search.NewMatcher(pattern, false, false)
+	if err != nil {
+		return nil, err
+	}
+	
+	return sm.SearchOutputWith(handleID, matcher, 2) // 2 lines context
+}
+
+// SearchOutputWith searches the output using a prepared matcher
+func (sm *ShellManager) SearchOutputWith(handleID uint64, matcher *search.Matcher, contextLines int) ([]Match, error) {
 	sm.mutex.RLock()
 	handle, exists := sm.handles[handleID]
 	sm.mutex.RUnlock()
@@ -150,19 +174,7 @@ func (sm *ShellManager) SearchOutput(handleID uint64, pattern string) ([]Match,
 	handle.mutex.RLock()
 	defer handle.mutex.RUnlock()
 	
-	var matches []Match
-	for i, line := range handle.Buffer {
-		if strings.Contains(line, pattern) {
-			match := Match{
-				LineNumber: i + 1, // 1-indexed
-				Line:       line,
-				Context:    getContext(handle.Buffer, i, 2), // 2 lines context
-			}
-			matches = append(matches, match)
-		}
-	}
-	
-	return matches, nil
+	return search.FindMatches(handle.Buffer, matcher, contextLines, 0), nil
 }
 
 // ReadLines returns specific lines from the output
@@ -277,18 +289,3 @@ func (sm *ShellManager) ListHandles() []uint64 {
 	
 	return handles
 }
-
-// Helper function to get context lines around a match
-func getContext(lines []string, center, contextSize int) []string {
-	start := center - contextSize
-	if start < 0 {
-		start = 0
-	}
-	
-	end := center + contextSize + 1
-	if end > len(lines) {
-		end = len(lines)
-	}
-	
-	return lines[start:end]
-} 
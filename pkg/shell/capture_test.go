@@ -32,8 +32,8 @@ func TestRunWithCapture(t *testing.T) {
 	}
 	
 	// Check handle properties
-	if handle.ID != 2 {
-		t.Errorf("Expected handle ID 2, got %d", handle.ID)
+	if handle.ID != 1 {
+		t.Errorf("Expected handle ID 1, got %d", handle.ID)
 	}
 	
 	if handle.Command != "echo Hello World" {
@@ -73,8 +73,8 @@ func TestSequentialIDs(t *testing.T) {
 	}
 	
 	// Check sequential IDs
-	if handle1.ID != 2 || handle2.ID != 3 || handle3.ID != 4 {
-		t.Errorf("Expected sequential IDs [2,3,4], got [%d,%d,%d]", 
+	if handle1.ID != 1 || handle2.ID != 2 || handle3.ID != 3 {
+		t.Errorf("Expected sequential IDs [1,2,3], got [%d,%d,%d]", 
 			handle1.ID, handle2.ID, handle3.ID)
 	}
 	
@@ -319,4 +319,23 @@ func TestConcurrency(t *testing.T) {
 	if len(handles) != 10 {
 		t.Errorf("Expected 10 handles from concurrent test, got %d", len(handles))
 	}
-} 
\ No newline at end of file
+} 
+func TestWait(t *testing.T) {
+	sm := NewShellManager()
+	handle, err := sm.RunWithCapture("sleep 0.1; echo done; exit 2")
+	if err != nil {
+		t.Fatalf("RunWithCapture failed: %v", err)
+	}
+
+	stats, err := sm.Wait(handle.ID)
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if !stats.Complete || stats.ExitCode != 2 {
+		t.Errorf("Expected completed command with exit code 2, got %+v", stats)
+	}
+
+	if _, err := sm.Wait(9999); err == nil {
+		t.Error("Expected error for unknown handle")
+	}
+}
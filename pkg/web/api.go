@@ -0,0 +1,679 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stackagent/pkg/ai"
+)
+
+// APIVersion prefixes the paths of the REST API, e.g. /api/v1/sessions
+const APIVersion = "v1"
+
+// Version is the server's version, as /api/health reports it
+const Version = "1.0.0"
+
+// Limits of REST API requests
+const (
+	maxAPIBody    = 1 << 20          // Bytes of a request body
+	maxJobWait    = 10 * time.Minute // Longest ?wait= of a job request
+	defaultTail   = 100              // Lines of output without ?tail= or ?start=
+	maxOutputTail = 10000
+)
+
+// errNoAgent answers messages while the server has no Claude client
+var errNoAgent = errors.New("the Claude client is not initialized; set the ANTHROPIC_API_KEY environment variable")
+
+// apiCall is an authenticated REST API request: who made it and the path
+// segments its route's wildcards matched
+type apiCall struct {
+	Identity Identity
+	Args     []string
+}
+
+// apiRoute maps a method and path to a handler. "*" matches any one path
+// segment. Routes that change sessions need the user role.
+type apiRoute struct {
+	method  string
+	pattern string
+	write   bool
+	handle  func(ws *WebSocketServer, w http.ResponseWriter, r *http.Request, call apiCall)
+}
+
+var apiRoutes = []apiRoute{
+	{"GET", "sessions", false, (*WebSocketServer).apiListSessions},
+	{"POST", "sessions", true, (*WebSocketServer).apiCreateSession},
+	{"GET", "sessions/*", false, (*WebSocketServer).apiGetSession},
+	{"DELETE", "sessions/*", true, (*WebSocketServer).apiDeleteSession},
+	{"POST", "sessions/*/messages", true, (*WebSocketServer).apiPostMessage},
+	{"GET", "sessions/*/handles", false, (*WebSocketServer).apiListHandles},
+	{"GET", "sessions/*/handles/*", false, (*WebSocketServer).apiGetHandle},
+	{"GET", "sessions/*/handles/*/output", false, (*WebSocketServer).apiHandleOutput},
+	{"GET", "jobs/*", false, (*WebSocketServer).apiGetJob},
+	{"GET", "context", false, (*WebSocketServer).apiContextStats},
+	{"GET", "context/memory", false, (*WebSocketServer).apiContextMemory},
+	{"GET", "costs", false, (*WebSocketServer).apiCosts},
+}
+
+// match reports whether path segments match the route's pattern and
+// returns the segments its wildcards matched
+func (route apiRoute) match(segments []string) ([]string, bool) {
+	pattern := strings.Split(route.pattern, "/")
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	var args []string
+	for i, part := range pattern {
+		switch {
+		case part == "*" && segments[i] != "":
+			args = append(args, segments[i])
+		case part != segments[i]:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// HandleAPI serves the REST API under /api/: /api/health, unauthenticated,
+// and the versioned API under /api/v1/, which authenticates requests as
+// WebSocket connections are. Bodies are JSON; errors are {"error": "..."}.
+func (ws *WebSocketServer) HandleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	if path == "health" || path == APIVersion+"/health" {
+		ws.handleHealth(w, r)
+		return
+	}
+	segments := strings.Split(path, "/")
+	if segments[0] != APIVersion {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no API at %s; the current version is under /api/%s/", r.URL.Path, APIVersion))
+		return
+	}
+
+	identity, status, err := ws.authenticate(r)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	var allowed []string
+	for _, route := range apiRoutes {
+		args, ok := route.match(segments[1:])
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		if route.write && !identity.Allows(RoleUser) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s role required", RoleUser))
+			return
+		}
+		route.handle(ws, w, r, apiCall{Identity: identity, Args: args})
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no API at %s", r.URL.Path))
+}
+
+// handleHealth reports that the server is up
+func (ws *WebSocketServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "ok",
+		"timestamp":     time.Now().UTC(),
+		"clients":       ws.GetClientCount(),
+		"version":       Version,
+		"apiVersion":    APIVersion,
+		"uptimeSeconds": int(time.Since(ws.started).Seconds()),
+	})
+}
+
+// writeJSON writes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+// writeError writes {"error": err}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// apiSessionInfo summarises a session in API responses
+type apiSessionInfo struct {
+	SessionInfo
+	Running bool `json:"running"` // A turn is queued or running
+}
+
+// apiSessionDetail is a session with its conversation
+type apiSessionDetail struct {
+	apiSessionInfo
+	Messages      []ConversationMessage `json:"messages"`
+	Budget        ai.ContextBudget      `json:"budget"`
+	ContextTokens int                   `json:"contextTokens"`
+}
+
+// apiMessage is the body of a posted message. Async messages return a job
+// to poll instead of waiting for the answer.
+type apiMessage struct {
+	Message string `json:"message"`
+	Async   bool   `json:"async"`
+}
+
+// apiNewSession answers a session created by its first message
+type apiNewSession struct {
+	SessionID     string `json:"sessionId"`
+	ResumeToken   string `json:"resumeToken"`
+	ObserverToken string `json:"observerToken"`
+	Job           Job    `json:"job"`
+}
+
+// apiHandle describes a shell handle of a session
+type apiHandle struct {
+	ID        uint64    `json:"id"`
+	Command   string    `json:"command"`
+	StartTime time.Time `json:"startTime"`
+	LineCount int       `json:"lineCount"`
+	Complete  bool      `json:"complete"`
+	ExitCode  int       `json:"exitCode"`
+	Duration  float64   `json:"duration"` // Seconds, so far if still running
+}
+
+// apiHandleOutput is lines of a handle's output
+type apiHandleOutput struct {
+	apiHandle
+	Output string `json:"output"`
+}
+
+// apiCostTotals adds up what the caller's sessions cost
+type apiCostTotals struct {
+	TotalCost    float64       `json:"totalCost"`
+	ConsultCost  float64       `json:"consultCost"` // Spent by consulted forks, not in TotalCost
+	RequestCount int           `json:"requestCount"`
+	Sessions     []SessionInfo `json:"sessions"`
+}
+
+// sessionInfos summarises the stored sessions and the loaded ones, most
+// recently updated first. Loaded sessions may be ahead of their stored copy.
+func (ws *WebSocketServer) sessionInfos() ([]SessionInfo, error) {
+	var infos []SessionInfo
+	if ws.sessions != nil {
+		var err error
+		if infos, err = ws.sessions.List(); err != nil {
+			return nil, err
+		}
+	}
+
+	ws.mutex.RLock()
+	loaded := make(map[string]*ConversationContext, len(ws.conversations))
+	for sessionID, conversation := range ws.conversations {
+		loaded[sessionID] = conversation
+	}
+	ws.mutex.RUnlock()
+
+	for i, info := range infos {
+		if conversation, ok := loaded[info.SessionID]; ok {
+			infos[i] = conversation.info()
+			delete(loaded, info.SessionID)
+		}
+	}
+	for _, conversation := range loaded {
+		if info := conversation.info(); info.MessageCount > 0 {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].UpdatedAt.After(infos[j].UpdatedAt) })
+	return infos, nil
+}
+
+// running reports whether a loaded session has a turn queued or running
+func (ws *WebSocketServer) running(sessionID string) bool {
+	conversation := ws.GetConversationContext(sessionID)
+	return conversation != nil && conversation.Busy()
+}
+
+// apiSession loads a session the caller owns, or writes why it can't. Pass
+// the session to releaseSession when done with it.
+func (ws *WebSocketServer) apiSession(w http.ResponseWriter, call apiCall) (*ConversationContext, bool) {
+	sessionID := call.Args[0]
+	conversation, err := ws.loadSession(sessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session %s", sessionID))
+		return nil, false
+	}
+	conversation.mutex.RLock()
+	owner := conversation.Owner
+	conversation.mutex.RUnlock()
+	if client := (clientInfo{Identity: call.Identity}); !client.owns(owner) {
+		ws.releaseSession(sessionID)
+		writeError(w, http.StatusForbidden, fmt.Errorf("session %s belongs to another user", sessionID))
+		return nil, false
+	}
+	return conversation, true
+}
+
+// releaseSession unloads a session nobody uses any more
+func (ws *WebSocketServer) releaseSession(sessionID string) {
+	ws.mutex.Lock()
+	ws.releaseSessionUnsafe(sessionID)
+	ws.mutex.Unlock()
+}
+
+// apiListSessions lists the caller's sessions; admins get everyone's
+func (ws *WebSocketServer) apiListSessions(w http.ResponseWriter, r *http.Request, call apiCall) {
+	infos, err := ws.sessionInfos()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to list sessions: %w", err))
+		return
+	}
+	client := clientInfo{Identity: call.Identity}
+	sessions := []apiSessionInfo{}
+	for _, info := range infos {
+		if client.owns(info.Owner) {
+			sessions = append(sessions, apiSessionInfo{SessionInfo: info, Running: ws.running(info.SessionID)})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// apiGetSession returns a session and its conversation
+func (ws *WebSocketServer) apiGetSession(w http.ResponseWriter, r *http.Request, call apiCall) {
+	conversation, ok := ws.apiSession(w, call)
+	if !ok {
+		return
+	}
+	defer ws.releaseSession(conversation.SessionID)
+
+	conversation.mutex.RLock()
+	contextTokens := conversation.ContextTokens
+	conversation.mutex.RUnlock()
+	writeJSON(w, http.StatusOK, apiSessionDetail{
+		apiSessionInfo: apiSessionInfo{SessionInfo: conversation.info(), Running: conversation.Busy()},
+		Messages:       conversation.GetMessages(),
+		Budget:         conversation.GetBudget(),
+		ContextTokens:  contextTokens,
+	})
+}
+
+// apiDeleteSession deletes a session. A session with a running turn or a
+// client attached is kept.
+func (ws *WebSocketServer) apiDeleteSession(w http.ResponseWriter, r *http.Request, call apiCall) {
+	conversation, ok := ws.apiSession(w, call)
+	if !ok {
+		return
+	}
+	sessionID := conversation.SessionID
+
+	ws.mutex.Lock()
+	var err error
+	status := http.StatusConflict
+	switch {
+	case conversation.Busy():
+		err = fmt.Errorf("session %s is running a turn", sessionID)
+	case ws.attachedUnsafe(sessionID):
+		err = fmt.Errorf("session %s is open elsewhere", sessionID)
+	default:
+		delete(ws.conversations, sessionID)
+		delete(ws.parked, sessionID)
+		if ws.sessions != nil {
+			err = ws.sessions.Delete(sessionID)
+			status = http.StatusInternalServerError
+		}
+	}
+	if err != nil {
+		ws.releaseSessionUnsafe(sessionID)
+	}
+	ws.mutex.Unlock()
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readMessage decodes a posted message, or writes why it can't
+func readMessage(w http.ResponseWriter, r *http.Request) (apiMessage, bool) {
+	var message apiMessage
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&message); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("expected a JSON body")
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid message: %w", err))
+		return message, false
+	}
+	if strings.TrimSpace(message.Message) == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("message is empty"))
+		return message, false
+	}
+	return message, true
+}
+
+// apiCreateSession starts a session for the caller with its first message,
+// answered as apiPostMessage answers. Sessions without messages aren't kept,
+// so there is no creating an empty one.
+func (ws *WebSocketServer) apiCreateSession(w http.ResponseWriter, r *http.Request, call apiCall) {
+	message, ok := readMessage(w, r)
+	if !ok {
+		return
+	}
+	if ws.claude == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoAgent)
+		return
+	}
+
+	sessionID := generateSessionID()
+	token, hash := newResumeToken()
+	observerToken, observerHash := newResumeToken()
+	conversation := newConversationContext(sessionID)
+	conversation.ResumeTokenHash = hash
+	conversation.ObserverTokenHash = observerHash
+	conversation.Owner = call.Identity.User
+	ws.mutex.Lock()
+	ws.conversations[sessionID] = conversation
+	ws.mutex.Unlock()
+	log.Printf("API started session %s for %s", sessionID, call.Identity.User)
+
+	job := ws.postMessage(conversation, call.Identity, message.Message)
+	created := func(job Job) interface{} {
+		return apiNewSession{SessionID: sessionID, ResumeToken: token, ObserverToken: observerToken, Job: job}
+	}
+	ws.answerMessage(w, r, job, message.Async, http.StatusCreated, created)
+}
+
+// apiPostMessage posts a message to a session. Synchronous posts answer
+// with the finished job; async ones with 202 and the job to poll at
+// /api/v1/jobs/{id}. Messages queue behind the session's earlier turns,
+// and clients attached to the session see the turn as if one of them sent it.
+func (ws *WebSocketServer) apiPostMessage(w http.ResponseWriter, r *http.Request, call apiCall) {
+	message, ok := readMessage(w, r)
+	if !ok {
+		return
+	}
+	if ws.claude == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoAgent)
+		return
+	}
+	conversation, ok := ws.apiSession(w, call)
+	if !ok {
+		return
+	}
+	job := ws.postMessage(conversation, call.Identity, message.Message)
+	ws.releaseSession(conversation.SessionID)
+	ws.answerMessage(w, r, job, message.Async, http.StatusOK, func(job Job) interface{} { return job })
+}
+
+// postMessage queues a message as a turn of the session and returns its job
+func (ws *WebSocketServer) postMessage(conversation *ConversationContext, identity Identity, message string) *Job {
+	sessionID := conversation.SessionID
+	job := ws.jobs.add(sessionID, identity.User)
+	ws.sendToSession(sessionID, WebSocketEvent{
+		Type: string(EventUserMessage),
+		Data: map[string]interface{}{
+			"id":        job.ID,
+			"message":   message,
+			"timestamp": time.Now(),
+		},
+		Timestamp: time.Now(),
+		SessionID: sessionID,
+	})
+
+	agent := ws.sessionAgent(conversation)
+	ahead := ws.enqueueTurn(conversation, func() {
+		ws.jobs.start(job)
+		ws.jobs.finish(job, ws.runTurn(conversation, agent, message))
+	})
+	if ahead > 0 {
+		ws.sendToSession(sessionID, WebSocketEvent{
+			Type: string(EventTurnQueued),
+			Data: map[string]interface{}{
+				"id":       job.ID,
+				"position": ahead,
+			},
+			Timestamp: time.Now(),
+			SessionID: sessionID,
+		})
+	}
+	return job
+}
+
+// answerMessage writes the answer to a posted message: 202 and the job
+// right away if async, otherwise once the turn has finished, with status on
+// success and 502 if the agent failed. body wraps the job.
+func (ws *WebSocketServer) answerMessage(w http.ResponseWriter, r *http.Request, job *Job, async bool, status int, body func(Job) interface{}) {
+	if async {
+		snapshot, _ := ws.jobs.get(job.ID)
+		w.Header().Set("Location", "/api/"+APIVersion+"/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, body(snapshot))
+		return
+	}
+
+	// Turns take longer than the server's write timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		// The caller left; the turn goes on and its job stays readable
+		return
+	}
+	snapshot, _ := ws.jobs.get(job.ID)
+	if snapshot.Status == JobFailed {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, body(snapshot))
+}
+
+// apiGetJob returns a posted message's job. ?wait=30s waits up to that
+// long for it to finish.
+func (ws *WebSocketServer) apiGetJob(w http.ResponseWriter, r *http.Request, call apiCall) {
+	jobID := call.Args[0]
+	job, ok := ws.jobs.get(jobID)
+	if client := (clientInfo{Identity: call.Identity}); !ok || !client.owns(job.owner) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", jobID))
+		return
+	}
+
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil || timeout < 0 || timeout > maxJobWait {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("wait must be a duration up to %s, got %q", maxJobWait, wait))
+			return
+		}
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-job.done:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+		job, _ = ws.jobs.get(jobID)
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// apiListHandles lists the shell handles of a session's commands. Handles
+// live in memory: they are gone after a restart, and an hour after the
+// session was last used.
+func (ws *WebSocketServer) apiListHandles(w http.ResponseWriter, r *http.Request, call apiCall) {
+	conversation, ok := ws.apiSession(w, call)
+	if !ok {
+		return
+	}
+	defer ws.releaseSession(conversation.SessionID)
+
+	handles := []apiHandle{}
+	if agent := conversationAgent(conversation); agent != nil {
+		ids := agent.ShellManager().ListHandles()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			if handle, err := describeHandle(agent, id); err == nil {
+				handles = append(handles, handle)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"handles": handles})
+}
+
+// apiGetHandle describes a shell handle of a session
+func (ws *WebSocketServer) apiGetHandle(w http.ResponseWriter, r *http.Request, call apiCall) {
+	ws.withHandle(w, call, func(agent *ai.ClaudeClient, handle apiHandle) {
+		writeJSON(w, http.StatusOK, handle)
+	})
+}
+
+// apiHandleOutput returns a shell handle's output: the last ?tail= lines,
+// 100 by default, or lines ?start= to ?end=, counted from 1
+func (ws *WebSocketServer) apiHandleOutput(w http.ResponseWriter, r *http.Request, call apiCall) {
+	query := r.URL.Query()
+	ws.withHandle(w, call, func(agent *ai.ClaudeClient, handle apiHandle) {
+		var output string
+		var err error
+		if start := query.Get("start"); start != "" {
+			first, startErr := strconv.Atoi(start)
+			last, endErr := 0, error(nil)
+			if end := query.Get("end"); end != "" {
+				last, endErr = strconv.Atoi(end)
+			}
+			if startErr != nil || endErr != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("start and end must be line numbers"))
+				return
+			}
+			output, err = agent.ShellManager().ReadLines(handle.ID, first, last)
+		} else {
+			tail := defaultTail
+			if value := query.Get("tail"); value != "" {
+				tail, err = strconv.Atoi(value)
+				if err != nil || tail < 1 || tail > maxOutputTail {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("tail must be between 1 and %d lines", maxOutputTail))
+					return
+				}
+			}
+			output, err = agent.ShellManager().GetTail(handle.ID, tail)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, apiHandleOutput{apiHandle: handle, Output: output})
+	})
+}
+
+// withHandle calls f with the session's handle the call names, or writes
+// why there is none
+func (ws *WebSocketServer) withHandle(w http.ResponseWriter, call apiCall, f func(agent *ai.ClaudeClient, handle apiHandle)) {
+	id, err := strconv.ParseUint(call.Args[1], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid handle ID %q", call.Args[1]))
+		return
+	}
+	conversation, ok := ws.apiSession(w, call)
+	if !ok {
+		return
+	}
+	defer ws.releaseSession(conversation.SessionID)
+
+	agent := conversationAgent(conversation)
+	if agent == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("handle %d not found", id))
+		return
+	}
+	handle, err := describeHandle(agent, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	f(agent, handle)
+}
+
+// conversationAgent returns the session's agent, nil if it never ran a turn
+func conversationAgent(conversation *ConversationContext) *ai.ClaudeClient {
+	conversation.mutex.RLock()
+	defer conversation.mutex.RUnlock()
+	return conversation.agent
+}
+
+// describeHandle describes a handle of an agent's shell
+func describeHandle(agent *ai.ClaudeClient, id uint64) (apiHandle, error) {
+	shells := agent.ShellManager()
+	handle, ok := shells.GetHandle(id)
+	if !ok {
+		return apiHandle{}, fmt.Errorf("handle %d not found", id)
+	}
+	stats, err := shells.GetStats(id)
+	if err != nil {
+		return apiHandle{}, err
+	}
+	return apiHandle{
+		ID:        id,
+		Command:   handle.Command,
+		StartTime: handle.StartTime,
+		LineCount: stats.LineCount,
+		Complete:  stats.Complete,
+		ExitCode:  stats.ExitCode,
+		Duration:  stats.Duration.Seconds(),
+	}, nil
+}
+
+// apiContextStats returns the persistent context's statistics
+func (ws *WebSocketServer) apiContextStats(w http.ResponseWriter, r *http.Request, call apiCall) {
+	if ws.contextManager == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("persistent context is not enabled on this server"))
+		return
+	}
+	writeJSON(w, http.StatusOK, ws.contextManager.GetStats())
+}
+
+// apiContextMemory returns the persistent context's protected memory and
+// knowledge base
+func (ws *WebSocketServer) apiContextMemory(w http.ResponseWriter, r *http.Request, call apiCall) {
+	if ws.contextManager == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("persistent context is not enabled on this server"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"memory":    ws.contextManager.GetMemory(),
+		"knowledge": ws.contextManager.GetAllKnowledge(),
+	})
+}
+
+// apiCosts adds up what the caller's sessions cost; admins get everyone's
+func (ws *WebSocketServer) apiCosts(w http.ResponseWriter, r *http.Request, call apiCall) {
+	infos, err := ws.sessionInfos()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to list sessions: %w", err))
+		return
+	}
+	client := clientInfo{Identity: call.Identity}
+	totals := apiCostTotals{Sessions: []SessionInfo{}}
+	for _, info := range infos {
+		if !client.owns(info.Owner) {
+			continue
+		}
+		totals.TotalCost += info.TotalCost
+		totals.ConsultCost += info.ConsultCost
+		totals.RequestCount += info.RequestCount
+		totals.Sessions = append(totals.Sessions, info)
+	}
+	writeJSON(w, http.StatusOK, totals)
+}
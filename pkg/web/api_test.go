@@ -0,0 +1,201 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAPITestServer serves a test server's REST API
+func newAPITestServer(t *testing.T, ws *WebSocketServer) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(ws.HandleAPI))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// apiRequest makes a REST API request with a token, decodes the response
+// into out, if not nil, and returns its status
+func apiRequest(t *testing.T, server *httptest.Server, method, path, token string, body, out interface{}) *http.Response {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode %s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestAPIHealth(t *testing.T) {
+	ws := NewWebSocketServer()
+	server := newAPITestServer(t, ws)
+
+	for _, path := range []string{"/api/health", "/api/v1/health"} {
+		var health struct {
+			Status    string    `json:"status"`
+			Timestamp time.Time `json:"timestamp"`
+			Version   string    `json:"version"`
+		}
+		resp := apiRequest(t, server, "GET", path, "", nil, &health)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected JSON from %s, got %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if health.Status != "ok" || health.Version != Version || time.Since(health.Timestamp) > time.Minute {
+			t.Errorf("Unexpected health %+v", health)
+		}
+	}
+}
+
+func TestAPIRequiresAuthentication(t *testing.T) {
+	ws, _ := newTestServer(t)
+	server := newAPITestServer(t, ws)
+
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if resp := apiRequest(t, server, "GET", "/api/v1/sessions", "", nil, &failure); resp.StatusCode != http.StatusUnauthorized || failure.Error == "" {
+		t.Errorf("Expected 401 with an error, got %d %+v", resp.StatusCode, failure)
+	}
+	if resp := apiRequest(t, server, "GET", "/api/v2/sessions", aliceToken, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown version, got %d", resp.StatusCode)
+	}
+	resp := apiRequest(t, server, "PUT", "/api/v1/sessions", aliceToken, nil, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, POST" {
+		t.Errorf("Expected 405 allowing GET and POST, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if resp := apiRequest(t, server, "POST", "/api/v1/sessions", carolToken, apiMessage{Message: "hi"}, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a viewer refused, got %d", resp.StatusCode)
+	}
+	if resp := apiRequest(t, server, "GET", "/api/v1/context", aliceToken, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 without persistent context, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIMessagesAndHandles(t *testing.T) {
+	_, providerServer := newFakeProvider(t, 1)
+	ws, wsServer := newAgentTestServer(t, providerServer)
+	server := newAPITestServer(t, ws)
+
+	// A synchronous first message starts the session
+	var created apiNewSession
+	resp := apiRequest(t, server, "POST", "/api/v1/sessions", aliceToken, apiMessage{Message: "delta"}, &created)
+	if resp.StatusCode != http.StatusCreated || created.Job.Status != JobDone || created.Job.Response != "done delta" {
+		t.Fatalf("Expected the answered first message, got %d %+v", resp.StatusCode, created)
+	}
+	if created.ResumeToken == "" || created.Job.Cost == nil {
+		t.Errorf("Expected a resume token and the turn's cost, got %+v", created)
+	}
+	sessionPath := "/api/v1/sessions/" + created.SessionID
+
+	// The session was unloaded after its turn, but its handles stay
+	var handles struct {
+		Handles []apiHandle `json:"handles"`
+	}
+	apiRequest(t, server, "GET", sessionPath+"/handles", aliceToken, nil, &handles)
+	if len(handles.Handles) != 1 || handles.Handles[0].Command != "echo delta" {
+		t.Fatalf("Expected the turn's command, got %+v", handles)
+	}
+	var output apiHandleOutput
+	apiRequest(t, server, "GET", sessionPath+"/handles/1/output?tail=5", aliceToken, nil, &output)
+	if output.Output != "delta" || !output.Complete {
+		t.Errorf("Expected the command's output, got %+v", output)
+	}
+	if resp := apiRequest(t, server, "GET", sessionPath+"/handles/9/output", aliceToken, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing handle, got %d", resp.StatusCode)
+	}
+
+	// A client attached to the session sees messages posted over the API
+	conn, _ := dialTestServer(t, wsServer)
+	conn.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{"sessionId": created.SessionID, "resumeToken": created.ResumeToken}})
+	readEvent(t, conn, string(EventSessionResumed))
+
+	var job Job
+	resp = apiRequest(t, server, "POST", sessionPath+"/messages", aliceToken, apiMessage{Message: "again", Async: true}, &job)
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != "/api/v1/jobs/"+job.ID {
+		t.Fatalf("Expected 202 and the job's location, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if _, response := collectTurn(t, conn); response != "done delta" {
+		t.Errorf("Expected the attached client to get the answer, got %q", response)
+	}
+	apiRequest(t, server, "GET", "/api/v1/jobs/"+job.ID+"?wait=10s", aliceToken, nil, &job)
+	if job.Status != JobDone || job.Response != "done delta" || job.FinishedAt == nil {
+		t.Errorf("Expected the job done, got %+v", job)
+	}
+
+	// Others see neither the session nor its job
+	if resp := apiRequest(t, server, "GET", sessionPath, bobToken, nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected Bob refused Alice's session, got %d", resp.StatusCode)
+	}
+	if resp := apiRequest(t, server, "GET", "/api/v1/jobs/"+job.ID, bobToken, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected Bob not to find Alice's job, got %d", resp.StatusCode)
+	}
+
+	var detail apiSessionDetail
+	apiRequest(t, server, "GET", sessionPath, aliceToken, nil, &detail)
+	if len(detail.Messages) != 4 || detail.Owner != "alice" {
+		t.Errorf("Expected Alice's session with both turns, got %+v", detail)
+	}
+	var costs, bobCosts apiCostTotals
+	apiRequest(t, server, "GET", "/api/v1/costs", aliceToken, nil, &costs)
+	apiRequest(t, server, "GET", "/api/v1/costs", bobToken, nil, &bobCosts)
+	if len(costs.Sessions) != 1 || costs.RequestCount != detail.RequestCount || costs.TotalCost != detail.TotalCost || costs.TotalCost == 0 {
+		t.Errorf("Expected Alice's session's costs, got %+v", costs)
+	}
+	if len(bobCosts.Sessions) != 0 || bobCosts.TotalCost != 0 {
+		t.Errorf("Expected no costs for Bob, got %+v", bobCosts)
+	}
+
+	// Deleting waits for the attached client to leave
+	var failure struct {
+		Error string `json:"error"`
+	}
+	resp = apiRequest(t, server, "DELETE", sessionPath, aliceToken, nil, &failure)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(failure.Error, "open elsewhere") {
+		t.Errorf("Expected 409 while the session is open, got %d %+v", resp.StatusCode, failure)
+	}
+	conn.Close()
+	waitForClients(ws, 0)
+	if resp := apiRequest(t, server, "DELETE", sessionPath, aliceToken, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", resp.StatusCode)
+	}
+	var list struct {
+		Sessions []apiSessionInfo `json:"sessions"`
+	}
+	apiRequest(t, server, "GET", "/api/v1/sessions", aliceToken, nil, &list)
+	if len(list.Sessions) != 0 {
+		t.Errorf("Expected the session gone, got %+v", list)
+	}
+}
+
+func TestAPIRejectsInvalidMessages(t *testing.T) {
+	ws, _ := newTestServer(t)
+	server := newAPITestServer(t, ws)
+
+	for _, body := range []string{"", "{", `{"message": ""}`, `{"message": "hi", "sync": true}`} {
+		r, _ := http.NewRequest("POST", server.URL+"/api/v1/sessions", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+aliceToken)
+		resp, err := server.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", body, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// User roles
+const (
+	RoleAdmin  = "admin"  // Everything, including other users' sessions
+	RoleUser   = "user"   // Chats with the agent in sessions they own
+	RoleViewer = "viewer" // Watches sessions shared with them, read-only
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleUser: 2, RoleAdmin: 3}
+
+func validRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// Identity is who a connection or request authenticated as
+type Identity struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// Allows reports whether the identity has at least role
+func (i Identity) Allows(role string) bool {
+	return roleRank[i.Role] >= roleRank[role]
+}
+
+// localIdentity is everyone when no authentication is configured; only
+// loopback peers are let in then
+var localIdentity = Identity{User: "local", Role: RoleAdmin}
+
+// StaticToken is a token from the auth config. The token itself or, better,
+// its SHA-256 in hex is given.
+type StaticToken struct {
+	Token       string `json:"token,omitempty"`
+	TokenSHA256 string `json:"tokenSha256,omitempty"`
+	User        string `json:"user"`
+	Role        string `json:"role"`
+}
+
+// AuthConfig configures who may connect
+type AuthConfig struct {
+	Tokens         []StaticToken `json:"tokens,omitempty"`
+	JWT            *JWTConfig    `json:"jwt,omitempty"`
+	AllowedOrigins []string      `json:"allowedOrigins,omitempty"` // Browser origins besides the server's own; "*" for any
+}
+
+// LoadAuthConfig reads an auth config JSON file
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %w", err)
+	}
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Authenticator checks the bearer tokens and origins of connections and
+// API requests
+type Authenticator struct {
+	tokens  map[string]Identity // By token SHA-256
+	jwt     *jwtVerifier
+	origins map[string]bool
+}
+
+// NewAuthenticator validates a config. Without tokens or JWT settings
+// nobody can authenticate.
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		tokens:  make(map[string]Identity),
+		origins: make(map[string]bool),
+	}
+	for i, token := range config.Tokens {
+		hash := strings.ToLower(token.TokenSHA256)
+		if token.Token != "" {
+			hash = hashToken(token.Token)
+		}
+		if len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("token %d needs a token or a SHA-256 in hex", i+1)
+		}
+		if token.User == "" || !validRole(token.Role) {
+			return nil, fmt.Errorf("token %d needs a user and a role of admin, user or viewer", i+1)
+		}
+		a.tokens[hash] = Identity{User: token.User, Role: token.Role}
+	}
+	if config.JWT != nil {
+		verifier, err := newJWTVerifier(*config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+	for _, origin := range config.AllowedOrigins {
+		a.origins[strings.TrimSuffix(strings.ToLower(origin), "/")] = true
+	}
+	return a, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate identifies a request by its bearer token, from the
+// Authorization header or, for browsers' WebSockets, the access_token
+// query parameter
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	token := r.URL.Query().Get("access_token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return Identity{}, fmt.Errorf("unsupported authorization scheme %q", scheme)
+		}
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return Identity{}, fmt.Errorf("no access token")
+	}
+
+	hash := hashToken(token)
+	for known, identity := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(hash)) == 1 {
+			return identity, nil
+		}
+	}
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		return a.jwt.Verify(token)
+	}
+	return Identity{}, fmt.Errorf("invalid access token")
+}
+
+// CheckOrigin lets in requests without an Origin (non-browser clients),
+// from the server's own origin and from the allowed origins
+func (a *Authenticator) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r.Host) {
+		return true
+	}
+	return a.origins["*"] || a.origins[strings.TrimSuffix(strings.ToLower(origin), "/")]
+}
+
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
+}
+
+// isLoopback reports whether a request comes from this machine
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		// Unix socket peers
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SetAuthenticator turns on authentication; nil lets in loopback peers
+// only, as admin
+func (ws *WebSocketServer) SetAuthenticator(auth *Authenticator) {
+	ws.auth = auth
+}
+
+// SetLocalHostNames adds names local connections may address the server
+// by while authentication is off, e.g. the name it binds to. localhost and
+// loopback addresses always work.
+func (ws *WebSocketServer) SetLocalHostNames(names ...string) {
+	ws.localHosts = append([]string(nil), names...)
+}
+
+// localHost reports whether a request addresses the server as localhost, a
+// loopback address or one of names. A DNS rebinding page reaches a
+// loopback server under its own domain, which this refuses.
+func localHost(r *http.Request, names []string) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, name := range names {
+		if host != "" && strings.EqualFold(host, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate identifies a request and checks its origin
+func (ws *WebSocketServer) authenticate(r *http.Request) (Identity, int, error) {
+	if ws.auth == nil {
+		if !isLoopback(r) {
+			return Identity{}, http.StatusForbidden, fmt.Errorf("authentication is not configured, so only local connections are allowed")
+		}
+		// Browsers send a rebinding page's own domain as Host, and no
+		// Origin on its same-origin GETs, so neither may vouch for it
+		if !fromUnixSocket(r) && !localHost(r, ws.localHosts) {
+			return Identity{}, http.StatusForbidden, fmt.Errorf("host %s is not a local name", r.Host)
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !localOrigin(origin) {
+			return Identity{}, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin)
+		}
+		return localIdentity, 0, nil
+	}
+	if !ws.auth.CheckOrigin(r) {
+		return Identity{}, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", r.Header.Get("Origin"))
+	}
+	if fromUnixSocket(r) && !hasAccessToken(r) {
+		// The socket's file permissions already decided who may connect
+		return localIdentity, 0, nil
+	}
+	identity, err := ws.auth.Authenticate(r)
+	if err != nil {
+		return Identity{}, http.StatusUnauthorized, err
+	}
+	return identity, 0, nil
+}
+
+func hasAccessToken(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.URL.Query().Get("access_token") != ""
+}
+
+type unixConnKey struct{}
+
+// ConnContext is an http.Server ConnContext that marks connections from
+// Unix sockets. Their peers are let in by the socket's file permissions, so
+// they needn't present a token.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return context.WithValue(ctx, unixConnKey{}, true)
+	}
+	return ctx
+}
+
+func fromUnixSocket(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixConnKey{}).(bool)
+	return unix
+}
+
+// localOrigin reports whether a browser origin is on this machine, e.g. the
+// GUI's dev server
+func localOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RequireRole wraps an HTTP handler so only identities with at least role
+// reach it
+func (ws *WebSocketServer) RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, status, err := ws.authenticate(r)
+		if err == nil && !identity.Allows(role) {
+			status, err = http.StatusForbidden, fmt.Errorf("%s role required", role)
+		}
+		if err != nil {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity)))
+	})
+}
+
+type identityKey struct{}
+
+func withIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity RequireRole authenticated a request as
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
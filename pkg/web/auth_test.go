@@ -0,0 +1,189 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLoadAuthConfig(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "auth.json")
+	os.WriteFile(path, []byte(`{
+		"tokens": [
+			{"token": "secret", "user": "alice", "role": "user"},
+			{"tokenSha256": "`+hashToken("hashed")+`", "user": "root", "role": "admin"}
+		],
+		"jwt": {"jwksFile": "`+keys.jwksFile()+`"},
+		"allowedOrigins": ["https://gui.example.com/"]
+	}`), 0600)
+
+	config, err := LoadAuthConfig(path)
+	if err != nil {
+		t.Fatalf("LoadAuthConfig failed: %v", err)
+	}
+	auth, err := NewAuthenticator(*config)
+	if err != nil {
+		t.Fatalf("NewAuthenticator failed: %v", err)
+	}
+
+	tests := []struct {
+		header, query string
+		expected      Identity
+	}{
+		{"Bearer secret", "", Identity{User: "alice", Role: RoleUser}},
+		{"", "hashed", Identity{User: "root", Role: RoleAdmin}},
+		{"Bearer " + keys.sign(t, "EdDSA", "ed", map[string]interface{}{"sub": "dana", "exp": time.Now().Add(time.Hour).Unix()}), "", Identity{User: "dana", Role: RoleViewer}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws?access_token="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if identity, err := auth.Authenticate(r); err != nil || identity != tt.expected {
+			t.Errorf("Expected %+v, got %+v (%v)", tt.expected, identity, err)
+		}
+	}
+
+	for _, header := range []string{"", "Bearer wrong", "Basic c2VjcmV0"} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Authorization", header)
+		if identity, err := auth.Authenticate(r); err == nil {
+			t.Errorf("Expected %q to be refused, got %+v", header, identity)
+		}
+	}
+
+	for origin, allowed := range map[string]bool{
+		"":                         true,
+		"http://example.com":       true, // The server's own
+		"https://gui.example.com":  true,
+		"https://evil.example.com": false,
+	} {
+		r := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		r.Header.Set("Origin", origin)
+		if auth.CheckOrigin(r) != allowed {
+			t.Errorf("Expected origin %q allowed: %v", origin, allowed)
+		}
+	}
+}
+
+func TestNewAuthenticatorValidates(t *testing.T) {
+	for _, config := range []AuthConfig{
+		{Tokens: []StaticToken{{User: "alice", Role: RoleUser}}},
+		{Tokens: []StaticToken{{Token: "t", User: "alice", Role: "superuser"}}},
+		{Tokens: []StaticToken{{Token: "t", Role: RoleUser}}},
+		{JWT: &JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}},
+	} {
+		if _, err := NewAuthenticator(config); err == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestLocalModeAllowsLoopbackOnly(t *testing.T) {
+	ws := NewWebSocketServer()
+	ws.SetLocalHostNames("devbox")
+	handler := ws.RequireRole(RoleAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := IdentityFrom(r.Context())
+		w.Write([]byte(identity.User))
+	}))
+
+	for _, tt := range []struct {
+		remote, host, origin string
+		status               int
+	}{
+		{"127.0.0.1:5000", "localhost:8080", "", http.StatusOK},
+		{"[::1]:5000", "localhost:8080", "http://localhost:5173", http.StatusOK},
+		{"127.0.0.1:5000", "127.0.0.1:8080", "http://127.0.0.1:8080", http.StatusOK},
+		{"[::1]:5000", "[::1]:8080", "", http.StatusOK},
+		{"127.0.0.1:5000", "devbox:8080", "", http.StatusOK},
+		{"10.0.0.7:5000", "localhost:8080", "", http.StatusForbidden},
+		{"127.0.0.1:5000", "localhost:8080", "https://evil.example.com", http.StatusForbidden},
+		// DNS rebinding: the attacker's name resolves to 127.0.0.1, so
+		// the page is same-origin with the server
+		{"127.0.0.1:5000", "evil.example.com:8080", "http://evil.example.com:8080", http.StatusForbidden},
+		{"127.0.0.1:5000", "evil.example.com:8080", "", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "http://"+tt.host+"/api/health", nil)
+		r.RemoteAddr = tt.remote
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("Expected %d from %s to %s (origin %q), got %d", tt.status, tt.remote, tt.host, tt.origin, w.Code)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "local" {
+			t.Errorf("Expected the local identity, got %q", w.Body.String())
+		}
+	}
+}
+
+func TestWebSocketRequiresAuthentication(t *testing.T) {
+	_, server := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %v", err)
+	}
+	header := http.Header{"Origin": {"https://evil.example.com"}}
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?access_token="+aliceToken, header)
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 from another origin, got %v", err)
+	}
+}
+
+func TestSessionOwnership(t *testing.T) {
+	ws, server := newTestServer(t)
+
+	alice, started := dialTestServer(t, server)
+	sessionID := started["sessionId"].(string)
+	resume := map[string]interface{}{"sessionId": sessionID, "resumeToken": started["resumeToken"]}
+	conversation := ws.GetConversationContext(sessionID)
+	conversation.AddMessage("user", "Alice's work")
+	ws.saveSession(conversation)
+
+	// Bob can't take over Alice's session even with her token, nor see it
+	bob, _ := dialAs(t, server, bobToken)
+	bob.WriteJSON(WebSocketEvent{Type: "resume_session", Data: resume})
+	refused := readEvent(t, bob, string(EventSessionError)).Data.(map[string]interface{})
+	if !strings.Contains(refused["error"].(string), "another user") {
+		t.Errorf("Expected the session to be refused as Alice's, got %v", refused)
+	}
+	bob.WriteJSON(WebSocketEvent{Type: "list_sessions"})
+	if list := readEvent(t, bob, string(EventSessionsList)).Data.(map[string]interface{})["sessions"].([]interface{}); len(list) != 0 {
+		t.Errorf("Expected Bob to see none of Alice's sessions, got %v", list)
+	}
+	bob.WriteJSON(WebSocketEvent{Type: "delete_session", Data: resume})
+	readEvent(t, bob, string(EventSessionError))
+
+	// Carol, a viewer, watches with the observer token but can't chat
+	carol, _ := dialAs(t, server, carolToken)
+	carol.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{"sessionId": sessionID, "resumeToken": started["observerToken"]}})
+	if role := readEvent(t, carol, string(EventSessionResumed)).Data.(map[string]interface{})["role"]; role != RoleObserver {
+		t.Errorf("Expected Carol to observe, got %v", role)
+	}
+	carol.WriteJSON(WebSocketEvent{Type: "chat_message", Data: map[string]interface{}{"id": "1", "message": "hi"}})
+	readEvent(t, carol, string(EventSessionError))
+
+	// An admin joins any session without a token and sees all of them
+	root, _ := dialAs(t, server, rootToken)
+	root.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{"sessionId": sessionID}})
+	if role := readEvent(t, root, string(EventSessionResumed)).Data.(map[string]interface{})["role"]; role != RoleParticipant {
+		t.Errorf("Expected the admin to participate, got %v", role)
+	}
+	root.WriteJSON(WebSocketEvent{Type: "list_sessions"})
+	list := readEvent(t, root, string(EventSessionsList)).Data.(map[string]interface{})["sessions"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["owner"] != "alice" {
+		t.Errorf("Expected Alice's session listed for the admin, got %v", list)
+	}
+	alice.Close()
+}
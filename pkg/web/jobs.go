@@ -0,0 +1,111 @@
+package web
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stackagent/pkg/ai"
+)
+
+// Job statuses
+const (
+	JobQueued  = "queued"  // Waiting behind the session's earlier turns
+	JobRunning = "running" // The agent is working on it
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// jobRetention is how long finished jobs stay readable
+const jobRetention = time.Hour
+
+// Jobs are numbered for their IDs
+var jobCounter int64
+
+// Job is a message posted over the REST API and the turn that answers it
+type Job struct {
+	ID               string               `json:"id"`
+	SessionID        string               `json:"sessionId"`
+	Status           string               `json:"status"`
+	Response         string               `json:"response,omitempty"`
+	Cost             *ai.TokenCost        `json:"cost,omitempty"`
+	OperationSummary *ai.OperationSummary `json:"operationSummary,omitempty"`
+	Error            string               `json:"error,omitempty"`
+	CreatedAt        time.Time            `json:"createdAt"`
+	FinishedAt       *time.Time           `json:"finishedAt,omitempty"`
+
+	owner string        // User who posted the message
+	done  chan struct{} // Closed when the job finishes
+}
+
+// jobStore keeps the jobs of posted messages until jobRetention after they
+// finish
+type jobStore struct {
+	mutex sync.Mutex
+	jobs  map[string]*Job
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*Job)}
+}
+
+// add creates a queued job for a message to a session and drops the jobs
+// that finished more than jobRetention ago
+func (s *jobStore) add(sessionID, owner string) *Job {
+	n := atomic.AddInt64(&jobCounter, 1)
+	job := &Job{
+		ID:        fmt.Sprintf("job_%d_%d", time.Now().UnixNano(), n),
+		SessionID: sessionID,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+		owner:     owner,
+		done:      make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, old := range s.jobs {
+		if old.FinishedAt != nil && time.Since(*old.FinishedAt) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+	return job
+}
+
+// get returns a copy of a job, safe to encode while the job runs
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// start marks a job running
+func (s *jobStore) start(job *Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job.Status = JobRunning
+}
+
+// finish records the turn's result and wakes whoever waits for the job
+func (s *jobStore) finish(job *Job, result turnResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	if result.Err != nil {
+		job.Status = JobFailed
+		job.Error = result.Err.Error()
+	} else {
+		job.Status = JobDone
+		job.Response = result.Response
+		job.Cost = &result.Cost
+		job.OperationSummary = &result.OperationSummary
+	}
+	close(job.done)
+}
@@ -0,0 +1,300 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Clock skew tolerated when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// JWTConfig verifies OIDC-style bearer tokens against a local JWKS file, so
+// an identity provider's tokens work without the server reaching it
+type JWTConfig struct {
+	JWKSFile    string `json:"jwksFile"`
+	Issuer      string `json:"issuer,omitempty"`      // Required iss, if set
+	Audience    string `json:"audience,omitempty"`    // Required in aud, if set
+	UserClaim   string `json:"userClaim,omitempty"`   // Default "sub"
+	RoleClaim   string `json:"roleClaim,omitempty"`   // Default "role"; a string or a list
+	DefaultRole string `json:"defaultRole,omitempty"` // For tokens without a known role, default viewer
+}
+
+// jsonWebKey is a public key of a JWKS file
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtVerifier checks token signatures and claims
+type jwtVerifier struct {
+	config JWTConfig
+	keys   map[string]crypto.PublicKey // By kid
+	now    func() time.Time
+}
+
+// newJWTVerifier loads the JWKS file of config
+func newJWTVerifier(config JWTConfig) (*jwtVerifier, error) {
+	data, err := os.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS %s: %w", config.JWKSFile, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %w", jwk.Kid, config.JWKSFile, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", config.JWKSFile)
+	}
+
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.RoleClaim == "" {
+		config.RoleClaim = "role"
+	}
+	if config.DefaultRole == "" {
+		config.DefaultRole = RoleViewer
+	}
+	if !validRole(config.DefaultRole) {
+		return nil, fmt.Errorf("invalid default role %q", config.DefaultRole)
+	}
+	return &jwtVerifier{config: config, keys: keys, now: time.Now}, nil
+}
+
+// publicKey decodes an RSA, EC (P-256, P-384) or Ed25519 key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// Verify checks a token's signature, lifetime, issuer and audience and
+// returns the identity it carries
+func (v *jwtVerifier) Verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("malformed token signature")
+	}
+
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return Identity{}, fmt.Errorf("unknown signing key %q", header.Kid)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return Identity{}, err
+	}
+
+	user, _ := claims[v.config.UserClaim].(string)
+	if user == "" {
+		return Identity{}, fmt.Errorf("token has no %s claim", v.config.UserClaim)
+	}
+	return Identity{User: user, Role: v.role(claims[v.config.RoleClaim])}, nil
+}
+
+// checkClaims checks exp (required), nbf, iss and aud
+func (v *jwtVerifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return fmt.Errorf("token issuer %v is not %s", claims["iss"], v.config.Issuer)
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("token is not for %s", v.config.Audience)
+	}
+	return nil
+}
+
+// role picks the highest known role of a role claim
+func (v *jwtVerifier) role(claim interface{}) string {
+	var roles []string
+	switch claim := claim.(type) {
+	case string:
+		roles = []string{claim}
+	case []interface{}:
+		for _, role := range claim {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	best := ""
+	for _, role := range roles {
+		if validRole(role) && (best == "" || roleRank[role] > roleRank[best]) {
+			best = role
+		}
+	}
+	if best == "" {
+		return v.config.DefaultRole
+	}
+	return best
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks a JWS signature; the algorithm must suit the key
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var h hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, cryptoHash = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, cryptoHash = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, cryptoHash = sha512.New(), crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if h != nil {
+		h.Write([]byte(signed))
+	}
+
+	valid := false
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		valid = strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, cryptoHash, h.Sum(nil), signature) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(signature) == 2*size && cryptoHash.Size() == size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(key, h.Sum(nil), r, s)
+		}
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(key, []byte(signed), signature)
+	}
+	if !valid {
+		return fmt.Errorf("invalid token signature")
+	}
+	return nil
+}
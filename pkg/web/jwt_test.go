@@ -0,0 +1,162 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// testKeys are a JWKS file's keys and their private halves
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	jwksDir string
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(edPublic)},
+	}}
+	dir := t.TempDir()
+	data, _ := json.Marshal(jwks)
+	if err := os.WriteFile(filepath.Join(dir, "jwks.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed: edKey, jwksDir: dir}
+}
+
+func (k *testKeys) jwksFile() string {
+	return filepath.Join(k.jwksDir, "jwks.json")
+}
+
+// sign makes a token with alg and kid over claims
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "EdDSA":
+		signature = ed25519.Sign(k.ed, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":  "dana",
+		"iss":  "https://idp.example.com",
+		"aud":  []string{"stackagent", "other"},
+		"exp":  time.Now().Add(time.Hour).Unix(),
+		"role": []string{"viewer", "user"},
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier, err := newJWTVerifier(JWTConfig{JWKSFile: keys.jwksFile(), Issuer: "https://idp.example.com", Audience: "stackagent"})
+	if err != nil {
+		t.Fatalf("newJWTVerifier failed: %v", err)
+	}
+
+	for _, signer := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}, {"EdDSA", "ed"}} {
+		identity, err := verifier.Verify(keys.sign(t, signer.alg, signer.kid, validClaims()))
+		if err != nil {
+			t.Errorf("Expected a valid %s token, got %v", signer.alg, err)
+		}
+		if identity != (Identity{User: "dana", Role: RoleUser}) {
+			t.Errorf("Expected dana with her highest role, got %+v", identity)
+		}
+	}
+
+	claims := validClaims()
+	delete(claims, "role")
+	if identity, _ := verifier.Verify(keys.sign(t, "RS256", "rsa", claims)); identity.Role != RoleViewer {
+		t.Errorf("Expected the default role viewer, got %q", identity.Role)
+	}
+}
+
+func TestJWTVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier, err := newJWTVerifier(JWTConfig{JWKSFile: keys.jwksFile(), Issuer: "https://idp.example.com", Audience: "stackagent"})
+	if err != nil {
+		t.Fatalf("newJWTVerifier failed: %v", err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	valid := keys.sign(t, "RS256", "rsa", validClaims())
+	parts := strings.Split(valid, ".")
+	forged := parts[0] + "." + b64.EncodeToString([]byte(`{"sub":"root","role":"admin","exp":9999999999}`)) + "." + parts[2]
+	unsigned := b64.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + parts[1] + "."
+
+	tests := map[string]string{
+		"expired":        keys.sign(t, "RS256", "rsa", with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":      keys.sign(t, "RS256", "rsa", with("exp", nil)),
+		"not yet valid":  keys.sign(t, "RS256", "rsa", with("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":   keys.sign(t, "RS256", "rsa", with("iss", "https://evil.example.com")),
+		"wrong audience": keys.sign(t, "RS256", "rsa", with("aud", "other")),
+		"no subject":     keys.sign(t, "RS256", "rsa", with("sub", nil)),
+		"unknown key":    keys.sign(t, "RS256", "gone", validClaims()),
+		"wrong key type": keys.sign(t, "ES256", "rsa", validClaims()),
+		"forged claims":  forged,
+		"alg none":       unsigned,
+		"malformed":      "not-a-token",
+	}
+	for name, token := range tests {
+		if identity, err := verifier.Verify(token); err == nil {
+			t.Errorf("Expected %s to be rejected, got %+v", name, identity)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serve runs a server with ws's endpoint on listener until the test ends
+func serve(t *testing.T, ws *WebSocketServer, listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ws.HandleWebSocket)
+	server := &http.Server{Handler: mux, ConnContext: ConnContext}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Close()
+		waitForClients(ws, 0)
+	})
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	ws, _ := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "run", "stackagent.sock")
+
+	listener, err := ListenUnix(path, DefaultSocketMode)
+	if err != nil {
+		t.Fatalf("ListenUnix failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a socket only its owner can use, got %v (%v)", info.Mode(), err)
+	}
+	if _, err := ListenUnix(path, DefaultSocketMode); err == nil || !strings.Contains(err.Error(), "another server") {
+		t.Errorf("Expected a live socket to be kept, got %v", err)
+	}
+	serve(t, ws, listener)
+
+	// Peers of the socket need no token, though the server requires them
+	dialer := websocket.Dialer{NetDial: func(string, string) (net.Conn, error) { return net.Dial("unix", path) }}
+	conn, _, err := dialer.Dial("ws://localhost/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect over the socket: %v", err)
+	}
+	defer conn.Close()
+	started := readEvent(t, conn, string(EventSessionStarted)).Data.(map[string]interface{})
+	if started["user"] != localIdentity.User {
+		t.Errorf("Expected the local user, got %v", started["user"])
+	}
+}
+
+func TestListenUnixReplacesStaleSockets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stale.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ListenUnix(path, 0660)
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got %v", err)
+	}
+	listener.Close()
+
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("keep me"), 0644)
+	if _, err := ListenUnix(file, DefaultSocketMode); err == nil {
+		t.Error("Expected a regular file to be kept")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep me" {
+		t.Errorf("Expected the file untouched, got %q", data)
+	}
+}
+
+func TestSelfSignedTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := SelfSignedCertificate(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("SelfSignedCertificate failed: %v", err)
+	}
+	if info, _ := os.Stat(keyFile); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private key file, got %v", info.Mode())
+	}
+	before, _ := os.ReadFile(certFile)
+	if _, _, err := SelfSignedCertificate(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(certFile); string(after) != string(before) {
+		t.Error("Expected the certificate to be reused")
+	}
+	if _, _, err := SelfSignedCertificate(dir, []string{"localhost", "stackagent.lan"}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(certFile); string(after) == string(before) {
+		t.Error("Expected a new certificate for another host")
+	}
+
+	config, err := LoadTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadTLSConfig failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, _ := newTestServer(t)
+	serve(t, ws, tls.NewListener(listener, config))
+
+	roots := x509.NewCertPool()
+	pem, _ := os.ReadFile(certFile)
+	roots.AppendCertsFromPEM(pem)
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}, HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.DialContext(context.Background(), "wss://"+listener.Addr().String()+"/ws?access_token="+aliceToken, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect over TLS: %v", err)
+	}
+	defer conn.Close()
+	var event WebSocketEvent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil || event.Type != string(EventSessionStarted) {
+		data, _ := json.Marshal(event)
+		t.Errorf("Expected session_started over TLS, got %s (%v)", data, err)
+	}
+	if _, err := LoadTLSConfig(certFile, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Expected a missing key to fail")
+	}
+}
@@ -0,0 +1,185 @@
+package web
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Roles of a client attached to a session
+const (
+	RoleParticipant = "participant" // Chats with the agent and manages the session
+	RoleObserver    = "observer"    // Watches the session without changing it
+)
+
+// observerEvents are the events an observer may send; anything else would
+// change the session
+var observerEvents = map[string]bool{
+	"ping":                true,
+	"pong":                true,
+	"get_context":         true,
+	"resume_session":      true,
+	"list_sessions":       true,
+	"configure_streaming": true,
+}
+
+// Connections are numbered for their IDs
+var clientCounter int64
+
+// clientInfo is a connection and the session it is attached to. Several
+// connections may share a session: tabs, a pairing teammate, a CLI watcher.
+type clientInfo struct {
+	ID        string
+	Name      string
+	Identity  Identity // Who authenticated the connection
+	Role      string   // Participant or observer of the session
+	SessionID string
+	JoinedAt  time.Time
+}
+
+// newClientInfo describes a new connection of identity, named name or
+// after its user
+func newClientInfo(name string, identity Identity) *clientInfo {
+	n := atomic.AddInt64(&clientCounter, 1)
+	if name == "" {
+		name = identity.User
+	}
+	return &clientInfo{
+		ID:       fmt.Sprintf("client_%d_%d", time.Now().UnixNano(), n),
+		Name:     name,
+		Identity: identity,
+		Role:     RoleParticipant,
+	}
+}
+
+func (c *clientInfo) presence() map[string]interface{} {
+	return map[string]interface{}{
+		"clientId": c.ID,
+		"name":     c.Name,
+		"user":     c.Identity.User,
+		"role":     c.Role,
+		"joinedAt": c.JoinedAt,
+	}
+}
+
+// sessionRole decides how a client may attach to a session given a token
+// and the role it asked for. Admins attach to any session. Owners attach
+// with the resume token, and anyone with the observer token watches.
+// Viewers only ever watch.
+func (c *clientInfo) sessionRole(conversation *ConversationContext, token, requested string) (string, error) {
+	role := conversation.tokenRole(token)
+	conversation.mutex.RLock()
+	owner := conversation.Owner
+	conversation.mutex.RUnlock()
+
+	switch {
+	case c.Identity.Allows(RoleAdmin):
+		role = RoleParticipant
+	case role == RoleParticipant && owner != c.Identity.User:
+		return "", fmt.Errorf("session %s belongs to another user", conversation.SessionID)
+	case role == "":
+		return "", fmt.Errorf("invalid resume token for session %s", conversation.SessionID)
+	}
+	if requested == RoleObserver || !c.Identity.Allows(RoleUser) {
+		role = RoleObserver
+	}
+	return role, nil
+}
+
+// owns reports whether the client may manage a session: list, delete
+func (c *clientInfo) owns(owner string) bool {
+	return c.Identity.Allows(RoleAdmin) || owner == c.Identity.User
+}
+
+// tokenRole returns the role a token attaches a client to the session in:
+// participant for the resume token, observer for the observer token and ""
+// for anything else
+func (c *ConversationContext) tokenRole(token string) string {
+	if c.CheckResumeToken(token) {
+		return RoleParticipant
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.ObserverTokenHash != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(hashResumeToken(token)), []byte(c.ObserverTokenHash)) == 1 {
+		return RoleObserver
+	}
+	return ""
+}
+
+// clientOf returns a copy of the connection's client; the original
+// changes under ws.mutex when the client switches sessions
+func (ws *WebSocketServer) clientOf(conn *websocket.Conn) (clientInfo, bool) {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	if client, ok := ws.clients[conn]; ok {
+		return *client, true
+	}
+	return clientInfo{}, false
+}
+
+// attachUnsafe attaches a connection's client to a session in role, tells
+// the clients of the session it left and the one it joined, and returns the
+// session it left. The caller holds ws.mutex.
+func (ws *WebSocketServer) attachUnsafe(conn *websocket.Conn, sessionID, role string) string {
+	client := ws.clients[conn]
+	previous := client.SessionID
+	client.SessionID = sessionID
+	client.Role = role
+	client.JoinedAt = time.Now()
+
+	if previous != "" && previous != sessionID {
+		ws.broadcastPresenceUnsafe(previous, "left", client)
+	}
+	ws.broadcastPresenceUnsafe(sessionID, "joined", client)
+	return previous
+}
+
+// presenceUnsafe lists the clients attached to a session, earliest first.
+// The caller holds ws.mutex.
+func (ws *WebSocketServer) presenceUnsafe(sessionID string) []map[string]interface{} {
+	var attached []*clientInfo
+	for _, client := range ws.clients {
+		if client.SessionID == sessionID {
+			attached = append(attached, client)
+		}
+	}
+	sort.Slice(attached, func(i, j int) bool { return attached[i].JoinedAt.Before(attached[j].JoinedAt) })
+
+	presence := make([]map[string]interface{}, 0, len(attached))
+	for _, client := range attached {
+		presence = append(presence, client.presence())
+	}
+	return presence
+}
+
+// broadcastPresenceUnsafe tells the clients of a session that client
+// joined or left it. The caller holds ws.mutex.
+func (ws *WebSocketServer) broadcastPresenceUnsafe(sessionID, change string, client *clientInfo) {
+	ws.broadcastUnsafe(sessionID, WebSocketEvent{
+		Type: string(EventPresence),
+		Data: map[string]interface{}{
+			"change":  change,
+			"client":  client.presence(),
+			"clients": ws.presenceUnsafe(sessionID),
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// broadcastUnsafe sends an event to every client attached to a session and
+// returns how many got it. The caller holds ws.mutex.
+func (ws *WebSocketServer) broadcastUnsafe(sessionID string, event WebSocketEvent) int {
+	event.SessionID = sessionID
+	delivered := 0
+	for conn, client := range ws.clients {
+		if client.SessionID == sessionID && ws.SendToClient(conn, event) == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
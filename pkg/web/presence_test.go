@@ -0,0 +1,112 @@
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionFanOutAndPresence(t *testing.T) {
+	ws, server := newTestServer(t)
+
+	owner, started := dialTestServer(t, server)
+	sessionID := started["sessionId"].(string)
+	ws.GetConversationContext(sessionID).AddMessage("user", "Let's debug this together")
+
+	// Another user watches with the observer token
+	observer, _ := dialAs(t, server, bobToken)
+	observer.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{
+		"sessionId":   sessionID,
+		"resumeToken": started["observerToken"],
+		"name":        "teammate",
+	}})
+	resumed := readEvent(t, observer, string(EventSessionResumed)).Data.(map[string]interface{})
+	if resumed["role"] != RoleObserver || len(resumed["clients"].([]interface{})) != 2 {
+		t.Errorf("Expected to watch as observer with 2 clients, got %v", resumed)
+	}
+
+	joined := readEvent(t, owner, string(EventPresence)).Data.(map[string]interface{})
+	for joined["change"] != "joined" || joined["client"].(map[string]interface{})["name"] != "teammate" {
+		joined = readEvent(t, owner, string(EventPresence)).Data.(map[string]interface{})
+	}
+	if clients := joined["clients"].([]interface{}); len(clients) != 2 {
+		t.Errorf("Expected 2 clients present, got %v", clients)
+	}
+
+	// Both receive the session's events
+	ws.SendStreamingEvent(sessionID, EventShellCommandStarted, map[string]interface{}{"command": "go test ./..."})
+	readEvent(t, owner, string(EventShellCommandStarted))
+	readEvent(t, observer, string(EventShellCommandStarted))
+
+	// The observer can't change the session
+	observer.WriteJSON(WebSocketEvent{Type: "chat_message", Data: map[string]interface{}{"id": "1", "message": "rm -rf"}})
+	refused := readEvent(t, observer, string(EventSessionError)).Data.(map[string]interface{})
+	if refused["request"] != "chat_message" {
+		t.Errorf("Expected chat_message to be refused, got %v", refused)
+	}
+	if messages := ws.GetConversationContext(sessionID).GetMessages(); len(messages) != 1 {
+		t.Errorf("Expected the conversation unchanged, got %d messages", len(messages))
+	}
+
+	// The owner is told when the observer leaves
+	observer.Close()
+	left := readEvent(t, owner, string(EventPresence)).Data.(map[string]interface{})
+	if left["change"] != "left" || len(left["clients"].([]interface{})) != 1 {
+		t.Errorf("Expected the observer to leave, got %v", left)
+	}
+}
+
+func TestObserverTokenIsReadOnly(t *testing.T) {
+	conversation := newConversationContext("session_1")
+	token, hash := newResumeToken()
+	observerToken, observerHash := newResumeToken()
+	conversation.ResumeTokenHash, conversation.ObserverTokenHash = hash, observerHash
+
+	if role := conversation.tokenRole(token); role != RoleParticipant {
+		t.Errorf("Expected the resume token to attach as participant, got %q", role)
+	}
+	if role := conversation.tokenRole(observerToken); role != RoleObserver {
+		t.Errorf("Expected the observer token to attach as observer, got %q", role)
+	}
+	if conversation.CheckResumeToken(observerToken) {
+		t.Error("Expected the observer token not to resume as participant")
+	}
+	if role := conversation.tokenRole("guess"); role != "" {
+		t.Errorf("Expected other tokens to be refused, got %q", role)
+	}
+}
+
+func TestStalledClientDoesNotBlockOthers(t *testing.T) {
+	ws, server := newTestServer(t)
+
+	// This client stops reading while its session floods it
+	stalled, started := dialTestServer(t, server)
+	stalledSession := started["sessionId"].(string)
+	other, started := dialAs(t, server, bobToken)
+	otherSession := started["sessionId"].(string)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		payload := strings.Repeat("x", 64*1024)
+		for i := 0; i < 2*sendQueueSize; i++ {
+			ws.SendStreamingEvent(stalledSession, EventShellCommandStreaming, map[string]interface{}{"output": payload})
+		}
+		ws.SendStreamingEvent(otherSession, EventShellCommandStarted, map[string]interface{}{"command": "make"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected sending to a stalled client not to block")
+	}
+	readEvent(t, other, string(EventShellCommandStarted))
+
+	// Connecting still works, and the stalled client was dropped
+	dialAs(t, server, rootToken)
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := stalled.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
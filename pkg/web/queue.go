@@ -0,0 +1,110 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"stackagent/pkg/ai"
+)
+
+// workQueue runs a session's jobs one at a time in the order they came, so
+// turns of one conversation never overlap while other sessions run theirs
+type workQueue struct {
+	mutex   sync.Mutex
+	jobs    []func()
+	running bool
+}
+
+// enqueue adds a job and returns how many jobs are ahead of it, the
+// running one included
+func (q *workQueue) enqueue(job func()) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ahead := len(q.jobs)
+	q.jobs = append(q.jobs, job)
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+	return ahead
+}
+
+// drain runs the jobs; each stays queued until it has run
+func (q *workQueue) drain() {
+	for {
+		q.mutex.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			q.mutex.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.mutex.Unlock()
+
+		job()
+
+		q.mutex.Lock()
+		q.jobs = q.jobs[1:]
+		q.mutex.Unlock()
+	}
+}
+
+// sessionAgent returns the session's own client, created from the server's
+// on first use: its shell handles are the session's alone
+func (ws *WebSocketServer) sessionAgent(conversation *ConversationContext) *ai.ClaudeClient {
+	conversation.mutex.Lock()
+	defer conversation.mutex.Unlock()
+	if conversation.agent == nil {
+		conversation.agent = ws.claude.NewSession()
+	}
+	return conversation.agent
+}
+
+// enqueueTurn queues work on a session's conversation. The session counts
+// as busy, and stays loaded, until the job has run.
+func (ws *WebSocketServer) enqueueTurn(conversation *ConversationContext, job func()) int {
+	conversation.beginTurn()
+	return conversation.queue.enqueue(func() {
+		defer ws.finishTurn(conversation)
+		job()
+	})
+}
+
+// finishTurn ends an agent turn, saves the conversation and unloads it if
+// no client is attached any more
+func (ws *WebSocketServer) finishTurn(conversation *ConversationContext) {
+	conversation.endTurn()
+	ws.saveSession(conversation)
+	ws.mutex.Lock()
+	ws.releaseSessionUnsafe(conversation.SessionID)
+	ws.mutex.Unlock()
+}
+
+// agentRetention is how long the agent of an unloaded session is kept, so
+// the output of its commands stays readable after the session's turns
+const agentRetention = time.Hour
+
+// parkedAgent is the agent of an unloaded session
+type parkedAgent struct {
+	agent    *ai.ClaudeClient
+	parkedAt time.Time
+}
+
+// parkAgentUnsafe keeps the agent of a session being unloaded if it has
+// shell handles, for loadSession to give back, and drops agents parked
+// longer than agentRetention. The caller holds ws.mutex.
+func (ws *WebSocketServer) parkAgentUnsafe(conversation *ConversationContext) {
+	now := time.Now()
+	for sessionID, parked := range ws.parked {
+		if now.Sub(parked.parkedAt) > agentRetention {
+			delete(ws.parked, sessionID)
+		}
+	}
+	conversation.mutex.RLock()
+	agent := conversation.agent
+	conversation.mutex.RUnlock()
+	if agent != nil && len(agent.ShellManager().ListHandles()) > 0 {
+		ws.parked[conversation.SessionID] = parkedAgent{agent: agent, parkedAt: now}
+	}
+}
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stackagent/pkg/ai"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeProvider is a Messages API that answers a conversation's first round
+// with a run_with_capture of "echo <first user message>" and the next with
+// text. First rounds wait for each other, so turns of different sessions
+// overlap.
+type fakeProvider struct {
+	mutex       sync.Mutex
+	inFlight    map[string]int // Requests running per conversation
+	maxInFlight map[string]int
+	results     map[string][]string // Tool results per conversation
+	firstRounds sync.WaitGroup
+}
+
+func newFakeProvider(t *testing.T, overlapping int) (*fakeProvider, *httptest.Server) {
+	provider := &fakeProvider{
+		inFlight:    make(map[string]int),
+		maxInFlight: make(map[string]int),
+		results:     make(map[string][]string),
+	}
+	provider.firstRounds.Add(overlapping)
+	server := httptest.NewServer(provider)
+	t.Cleanup(server.Close)
+	return provider, server
+}
+
+func (p *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/count_tokens") {
+		http.NotFound(w, r)
+		return
+	}
+	var request struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	var name string
+	json.Unmarshal(request.Messages[0].Content, &name)
+	last := request.Messages[len(request.Messages)-1]
+	var results []ai.ToolResult
+	json.Unmarshal(last.Content, &results)
+
+	p.mutex.Lock()
+	p.inFlight[name]++
+	if p.inFlight[name] > p.maxInFlight[name] {
+		p.maxInFlight[name] = p.inFlight[name]
+	}
+	for _, result := range results {
+		p.results[name] = append(p.results[name], result.Content)
+	}
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.inFlight[name]--
+		p.mutex.Unlock()
+	}()
+
+	usage := `"usage": {"input_tokens": 100, "output_tokens": 10}`
+	if len(results) > 0 {
+		fmt.Fprintf(w, `{"content": [{"type": "text", "text": "done %s"}], %s}`, name, usage)
+		return
+	}
+	if len(request.Messages) == 1 {
+		p.firstRounds.Done()
+		waitGroupTimeout(&p.firstRounds, 5*time.Second)
+	}
+	fmt.Fprintf(w, `{"content": [{"type": "tool_use", "id": "call_%s", "name": "run_with_capture", "input": {"command": "echo %s"}}], %s}`, name, name, usage)
+}
+
+func waitGroupTimeout(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// newAgentTestServer is newTestServer with a Claude client on the fake
+// provider
+func newAgentTestServer(t *testing.T, provider *httptest.Server) (*WebSocketServer, *httptest.Server) {
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	ws, server := newTestServer(t)
+	if ws.claude == nil {
+		t.Fatal("Expected a Claude client")
+	}
+	ws.claude.SetBaseURL(provider.URL)
+	return ws, server
+}
+
+// collectTurn reads a client's events until its ai_response and returns
+// the commands of its shell events
+func collectTurn(t *testing.T, conn *websocket.Conn) ([]string, string) {
+	var commands []string
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		var event WebSocketEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("Expected an ai_response, got %v", err)
+		}
+		data, _ := event.Data.(map[string]interface{})
+		switch event.Type {
+		case string(EventShellCommandStarted), string(EventShellCommandCompleted):
+			commands = append(commands, fmt.Sprint(data["command"]))
+		case string(EventAIError):
+			t.Fatalf("Unexpected ai_error: %v", data["error"])
+		case "ai_response":
+			return commands, fmt.Sprint(data["message"])
+		}
+	}
+}
+
+func TestConcurrentSessionsKeepTheirEvents(t *testing.T) {
+	provider, providerServer := newFakeProvider(t, 2)
+	_, server := newAgentTestServer(t, providerServer)
+
+	names := []string{"alpha", "beta"}
+	conns := make([]*websocket.Conn, len(names))
+	for i, name := range names {
+		conns[i], _ = dialTestServer(t, server)
+		conns[i].WriteJSON(WebSocketEvent{Type: "chat_message", Data: map[string]interface{}{"id": name, "message": name}})
+	}
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(conn *websocket.Conn, name string) {
+			defer wg.Done()
+			commands, response := collectTurn(t, conn)
+			if response != "done "+name {
+				t.Errorf("Expected %q, got %q", "done "+name, response)
+			}
+			if len(commands) != 2 {
+				t.Errorf("Expected the shell events of %s's command, got %v", name, commands)
+			}
+			for _, command := range commands {
+				if command != "echo "+name {
+					t.Errorf("Session %s got another session's event: %s", name, command)
+				}
+			}
+		}(conns[i], name)
+	}
+	wg.Wait()
+
+	// Each session has its own shell handles, so both commands got the
+	// first handle ID
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	for _, name := range names {
+		if results := provider.results[name]; len(results) != 1 || !strings.Contains(results[0], "Handle ID: 1") {
+			t.Errorf("Expected %s's command on its own first handle, got %v", name, results)
+		}
+	}
+}
+
+func TestSessionTurnsRunInOrder(t *testing.T) {
+	provider, providerServer := newFakeProvider(t, 1)
+	ws, server := newAgentTestServer(t, providerServer)
+
+	conn, started := dialTestServer(t, server)
+	sessionID := started["sessionId"].(string)
+	conn.WriteJSON(WebSocketEvent{Type: "chat_message", Data: map[string]interface{}{"id": "1", "message": "gamma"}})
+	conn.WriteJSON(WebSocketEvent{Type: "chat_message", Data: map[string]interface{}{"id": "2", "message": "again"}})
+
+	queued := readEvent(t, conn, string(EventTurnQueued)).Data.(map[string]interface{})
+	if queued["id"] != "2" || queued["position"] != float64(1) {
+		t.Errorf("Expected the second message queued behind the first, got %v", queued)
+	}
+	collectTurn(t, conn)
+	collectTurn(t, conn)
+
+	messages := ws.GetConversationContext(sessionID).GetMessages()
+	roles := make([]string, 0, len(messages))
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	if strings.Join(roles, ",") != "user,assistant,user,assistant" {
+		t.Errorf("Expected the turns one after the other, got %v", roles)
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.maxInFlight["gamma"] != 1 {
+		t.Errorf("Expected one request at a time in a session, got %d", provider.maxInFlight["gamma"])
+	}
+}
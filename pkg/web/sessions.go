@@ -0,0 +1,260 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionDir is where conversations are kept, next to the context
+	DefaultSessionDir = ".stackagent/sessions"
+
+	// Events queued for a detached session beyond this drop the oldest
+	maxPendingEvents = 500
+)
+
+// Session IDs name files, so they are restricted to a safe alphabet
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// SessionStore keeps conversations on disk, one JSON file per session, so
+// they survive disconnects and server restarts
+type SessionStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// SessionInfo summarises a stored session for list_sessions
+type SessionInfo struct {
+	SessionID    string    `json:"sessionId"`
+	Owner        string    `json:"owner,omitempty"`
+	Title        string    `json:"title"` // The first user message, shortened
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	MessageCount int       `json:"messageCount"`
+	TotalCost    float64   `json:"totalCost"`
+	ConsultCost  float64   `json:"consultCost"` // Spent by consulted forks, not in TotalCost
+	RequestCount int       `json:"requestCount"`
+}
+
+// NewSessionStore creates a store under dir (DefaultSessionDir if empty)
+func NewSessionStore(dir string) *SessionStore {
+	if dir == "" {
+		dir = DefaultSessionDir
+	}
+	return &SessionStore{dir: dir}
+}
+
+// Save writes a conversation. Conversations without messages aren't worth
+// resuming and are not written.
+func (s *SessionStore) Save(conversation *ConversationContext) error {
+	if !validSessionID(conversation.SessionID) {
+		return fmt.Errorf("invalid session ID %q", conversation.SessionID)
+	}
+	data, empty, err := conversation.marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if empty {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, conversation.SessionID+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(conversation.SessionID)); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// Load reads a stored conversation
+func (s *SessionStore) Load(sessionID string) (*ConversationContext, error) {
+	if !validSessionID(sessionID) {
+		return nil, fmt.Errorf("invalid session ID %q", sessionID)
+	}
+	s.mutex.Lock()
+	data, err := os.ReadFile(s.path(sessionID))
+	s.mutex.Unlock()
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no session %s", sessionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	conversation := newConversationContext(sessionID)
+	if err := json.Unmarshal(data, conversation); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", sessionID, err)
+	}
+	if conversation.ActiveOperations == nil {
+		conversation.ActiveOperations = make(map[string]interface{})
+	}
+	return conversation, nil
+}
+
+// List summarises the stored sessions, most recently updated first
+func (s *SessionStore) List() ([]SessionInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SessionInfo
+	for _, path := range paths {
+		conversation, err := s.Load(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, conversation.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].UpdatedAt.After(infos[j].UpdatedAt) })
+	return infos, nil
+}
+
+// Delete removes a stored conversation. Deleting one that was never saved
+// is not an error.
+func (s *SessionStore) Delete(sessionID string) error {
+	if !validSessionID(sessionID) {
+		return fmt.Errorf("invalid session ID %q", sessionID)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.Remove(s.path(sessionID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionStore) path(sessionID string) string {
+	return filepath.Join(s.dir, sessionID+".json")
+}
+
+func validSessionID(sessionID string) bool {
+	return sessionIDPattern.MatchString(sessionID)
+}
+
+// newResumeToken returns a secret that resumes a session and the hash the
+// session keeps of it
+func newResumeToken() (string, string) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate resume token: %v", err))
+	}
+	token := hex.EncodeToString(secret)
+	return token, hashResumeToken(token)
+}
+
+func hashResumeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckResumeToken reports whether token resumes the session
+func (c *ConversationContext) CheckResumeToken(token string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.ResumeTokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashResumeToken(token)), []byte(c.ResumeTokenHash)) == 1
+}
+
+// marshal encodes the conversation for the store and reports whether it
+// has no messages
+func (c *ConversationContext) marshal() ([]byte, bool, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	return data, len(c.Messages) == 0, err
+}
+
+// info summarises the conversation
+func (c *ConversationContext) info() SessionInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	info := SessionInfo{
+		SessionID:    c.SessionID,
+		Owner:        c.Owner,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		MessageCount: len(c.Messages),
+		TotalCost:    c.TotalCost,
+		ConsultCost:  c.ConsultCost,
+		RequestCount: c.RequestCount,
+	}
+	for _, msg := range c.Messages {
+		if msg.Role == "user" {
+			info.Title = msg.Content
+			break
+		}
+	}
+	if title := []rune(info.Title); len(title) > 80 {
+		info.Title = string(title[:77]) + "..."
+	}
+	return info
+}
+
+// queueEvent keeps an event for delivery when a client resumes the session
+func (c *ConversationContext) queueEvent(event WebSocketEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.PendingEvents = append(c.PendingEvents, event)
+	if over := len(c.PendingEvents) - maxPendingEvents; over > 0 {
+		c.PendingEvents = append([]WebSocketEvent{}, c.PendingEvents[over:]...)
+	}
+}
+
+// takePendingEvents returns the queued events and clears the queue
+func (c *ConversationContext) takePendingEvents() []WebSocketEvent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	events := c.PendingEvents
+	c.PendingEvents = nil
+	return events
+}
+
+// beginTurn and endTurn count the agent turns in flight
+func (c *ConversationContext) beginTurn() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.turns++
+}
+
+func (c *ConversationContext) endTurn() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.turns--
+}
+
+// Busy reports whether an agent turn is running
+func (c *ConversationContext) Busy() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.turns > 0
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionStoreRoundTrip(t *testing.T) {
+	store := NewSessionStore(t.TempDir())
+
+	conversation := newConversationContext("session_1")
+	_, conversation.ResumeTokenHash = newResumeToken()
+	if err := store.Save(conversation); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := store.Load("session_1"); err == nil {
+		t.Error("Expected an empty conversation not to be saved")
+	}
+
+	conversation.AddMessage("user", "Why does the build fail?")
+	conversation.AddMessage("assistant", "A missing import")
+	conversation.queueEvent(WebSocketEvent{Type: "ai_response", SessionID: "session_1"})
+	if err := store.Save(conversation); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := store.Load("session_1")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded.Messages) != 2 || loaded.Messages[1].Content != "A missing import" {
+		t.Errorf("Expected the messages back, got %+v", loaded.Messages)
+	}
+	if loaded.ResumeTokenHash != conversation.ResumeTokenHash || len(loaded.PendingEvents) != 1 {
+		t.Error("Expected the token hash and pending events back")
+	}
+	if loaded.GetBudget().MaxTokens == 0 {
+		t.Error("Expected the budget back")
+	}
+}
+
+func TestSessionStoreListAndDelete(t *testing.T) {
+	store := NewSessionStore(t.TempDir())
+	for i, id := range []string{"older", "newer"} {
+		conversation := newConversationContext(id)
+		conversation.AddMessage("user", "Question from "+id)
+		conversation.UpdatedAt = time.Now().Add(time.Duration(i) * time.Minute)
+		if err := store.Save(conversation); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	infos, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(infos) != 2 || infos[0].SessionID != "newer" || infos[0].Title != "Question from newer" {
+		t.Errorf("Expected newest first with titles, got %+v", infos)
+	}
+
+	if err := store.Delete("newer"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete("newer"); err != nil {
+		t.Errorf("Expected deleting twice to succeed, got %v", err)
+	}
+	if infos, _ := store.List(); len(infos) != 1 {
+		t.Errorf("Expected 1 session left, got %d", len(infos))
+	}
+}
+
+func TestSessionStoreRejectsInvalidIDs(t *testing.T) {
+	dir := t.TempDir()
+	store := NewSessionStore(filepath.Join(dir, "sessions"))
+	os.WriteFile(filepath.Join(dir, "secret.json"), []byte("{}"), 0600)
+
+	for _, id := range []string{"", "../secret", "a/b", strings.Repeat("x", 200)} {
+		if _, err := store.Load(id); err == nil {
+			t.Errorf("Expected an error loading %q", id)
+		}
+		if err := store.Delete(id); err == nil {
+			t.Errorf("Expected an error deleting %q", id)
+		}
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	conversation := newConversationContext("session_1")
+	token, hash := newResumeToken()
+	conversation.ResumeTokenHash = hash
+
+	if !conversation.CheckResumeToken(token) {
+		t.Error("Expected the token to resume the session")
+	}
+	other, _ := newResumeToken()
+	if conversation.CheckResumeToken(other) || conversation.CheckResumeToken("") {
+		t.Error("Expected other tokens to be refused")
+	}
+	if strings.Contains(hash, token) {
+		t.Error("Expected only the hash to be kept")
+	}
+}
+
+// Tokens of the test server's users
+const (
+	aliceToken = "alice-token" // user
+	bobToken   = "bob-token"   // user
+	carolToken = "carol-token" // viewer
+	rootToken  = "root-token"  // admin
+)
+
+// newTestServer starts a server storing sessions in a temporary directory,
+// with the test users. Cleanup waits for the connections to detach, which
+// saves their sessions.
+func newTestServer(t *testing.T) (*WebSocketServer, *httptest.Server) {
+	auth, err := NewAuthenticator(AuthConfig{Tokens: []StaticToken{
+		{Token: aliceToken, User: "alice", Role: RoleUser},
+		{Token: bobToken, User: "bob", Role: RoleUser},
+		{Token: carolToken, User: "carol", Role: RoleViewer},
+		{Token: rootToken, User: "root", Role: RoleAdmin},
+	}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator failed: %v", err)
+	}
+	ws := NewWebSocketServer()
+	ws.SetSessionStore(NewSessionStore(t.TempDir()))
+	ws.SetAuthenticator(auth)
+	server := httptest.NewServer(http.HandlerFunc(ws.HandleWebSocket))
+	t.Cleanup(func() {
+		waitForClients(ws, 0)
+		server.Close()
+	})
+	return ws, server
+}
+
+// waitForClients waits up to 5 seconds for count clients to be connected
+func waitForClients(ws *WebSocketServer, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for ws.GetClientCount() != count && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dialTestServer connects alice to a test server and reads her
+// session_started event
+func dialTestServer(t *testing.T, server *httptest.Server) (*websocket.Conn, map[string]interface{}) {
+	return dialAs(t, server, aliceToken)
+}
+
+// dialAs connects with a token and reads the session_started event
+func dialAs(t *testing.T, server *httptest.Server, token string) (*websocket.Conn, map[string]interface{}) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?access_token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	started := readEvent(t, conn, string(EventSessionStarted))
+	return conn, started.Data.(map[string]interface{})
+}
+
+// readEvent reads events until one of type eventType arrives
+func readEvent(t *testing.T, conn *websocket.Conn, eventType string) WebSocketEvent {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event WebSocketEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("Expected a %s event, got %v", eventType, err)
+		}
+		if event.Type == eventType {
+			return event
+		}
+	}
+}
+
+func TestResumeSessionAfterDisconnect(t *testing.T) {
+	ws, server := newTestServer(t)
+
+	conn, started := dialTestServer(t, server)
+	sessionID := started["sessionId"].(string)
+	token := started["resumeToken"].(string)
+	ws.GetConversationContext(sessionID).AddMessage("user", "Remember this")
+	conn.Close()
+
+	waitForClients(ws, 0)
+	if ws.GetConversationContext(sessionID) != nil {
+		t.Error("Expected the idle session to be unloaded after the disconnect")
+	}
+
+	// An event of a turn still running is queued while no client is attached
+	if _, err := ws.loadSession(sessionID); err != nil {
+		t.Fatalf("Expected the session to be saved: %v", err)
+	}
+	ws.SendStreamingEvent(sessionID, "ai_response", map[string]interface{}{"message": "Done"})
+
+	// A wrong token is refused
+	conn, _ = dialTestServer(t, server)
+	conn.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{"sessionId": sessionID, "resumeToken": "wrong"}})
+	readEvent(t, conn, string(EventSessionError))
+
+	conn.WriteJSON(WebSocketEvent{Type: "resume_session", Data: map[string]interface{}{"sessionId": sessionID, "resumeToken": token}})
+	resumed := readEvent(t, conn, string(EventSessionResumed)).Data.(map[string]interface{})
+	messages := resumed["messages"].([]interface{})
+	if len(messages) != 1 || messages[0].(map[string]interface{})["content"] != "Remember this" {
+		t.Errorf("Expected the history to be replayed, got %v", messages)
+	}
+	replayed := readEvent(t, conn, "ai_response")
+	if replayed.SessionID != sessionID {
+		t.Errorf("Expected the queued event for %s, got %s", sessionID, replayed.SessionID)
+	}
+	if pending := ws.GetConversationContext(sessionID).takePendingEvents(); len(pending) != 0 {
+		t.Errorf("Expected the queue to be flushed, got %d events", len(pending))
+	}
+
+	// Listing shows the resumed session as current
+	conn.WriteJSON(WebSocketEvent{Type: "list_sessions"})
+	list := readEvent(t, conn, string(EventSessionsList)).Data.(map[string]interface{})["sessions"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["current"] != true {
+		t.Errorf("Expected the current session listed, got %v", list)
+	}
+
+	// Deleting it removes it from disk and starts a new session
+	conn.WriteJSON(WebSocketEvent{Type: "delete_session", Data: map[string]interface{}{"sessionId": sessionID, "resumeToken": token}})
+	readEvent(t, conn, string(EventSessionDeleted))
+	if next := readEvent(t, conn, string(EventSessionStarted)); next.SessionID == sessionID {
+		t.Error("Expected a new session after deleting the current one")
+	}
+	if _, err := ws.sessions.Load(sessionID); err == nil {
+		t.Error("Expected the session to be deleted from disk")
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"stackagent/pkg/ai"
+	stackctx "stackagent/pkg/context"
 )
 
 // WebSocketEvent represents a message sent over WebSocket
@@ -45,11 +46,32 @@ const (
 	EventFileOperationCompleted WebSocketEventType = "file_operation_completed"
 	EventAIStreaming            WebSocketEventType = "ai_streaming"
 	EventConfigureStreaming     WebSocketEventType = "configure_streaming"
+	
+	// Consulting a forked agent
+	EventConsultCompleted WebSocketEventType = "consult_completed"
+	EventConsultResponse  WebSocketEventType = "consult_response"
+	EventConsultError     WebSocketEventType = "consult_error"
+	
+	// Context budget
+	EventSetBudget        WebSocketEventType = "set_budget"
+	EventBudgetUpdated    WebSocketEventType = "budget_updated"
+	EventContextCompacted WebSocketEventType = "context_compacted"
+	
+	// Session persistence
+	EventSessionStarted WebSocketEventType = "session_started"
+	EventResumeSession  WebSocketEventType = "resume_session"
+	EventSessionResumed WebSocketEventType = "session_resumed"
+	EventListSessions   WebSocketEventType = "list_sessions"
+	EventSessionsList   WebSocketEventType = "sessions_list"
+	EventDeleteSession  WebSocketEventType = "delete_session"
+	EventSessionDeleted WebSocketEventType = "session_deleted"
+	EventSessionError   WebSocketEventType = "session_error"
+	EventPresence       WebSocketEventType = "presence"
+	
+	// Turns waiting behind a session's running turn
+	EventTurnQueued WebSocketEventType = "turn_queued"
 )
 
-// StreamingCallback represents a callback for streaming events
-type StreamingCallback func(eventType WebSocketEventType, data interface{}, sessionID string)
-
 // Use ConversationMessage from ai package
 type ConversationMessage = ai.ConversationMessage
 
@@ -61,6 +83,13 @@ type ConversationContext struct {
 	UpdatedAt    time.Time             `json:"updatedAt"`
 	TotalCost    float64               `json:"totalCost"`
 	RequestCount int                   `json:"requestCount"`
+	ConsultCost  float64               `json:"consultCost"` // Spent by consulted forks, not in TotalCost
+	Budget        ai.ContextBudget     `json:"budget"`
+	ContextTokens int                  `json:"contextTokens"` // Input tokens of the conversation when last measured
+	ResumeTokenHash string             `json:"resumeTokenHash,omitempty"` // SHA-256 of the token that resumes the session
+	ObserverTokenHash string           `json:"observerTokenHash,omitempty"` // SHA-256 of the token that attaches read-only
+	Owner         string               `json:"owner,omitempty"` // User who started the session
+	PendingEvents []WebSocketEvent     `json:"pendingEvents,omitempty"`   // Events for a client that hasn't resumed yet
 	CacheStats   struct {
 		CacheHits        int     `json:"cacheHits"`
 		CacheMisses      int     `json:"cacheMisses"`
@@ -72,9 +101,27 @@ type ConversationContext struct {
 	ActiveOperations map[string]interface{} `json:"activeOperations"`
 	StreamingEnabled bool                   `json:"streamingEnabled"`
 	
+	agent        *ai.ClaudeClient // The session's own client, with its shell handles
+	queue        workQueue        // Serialises the session's turns
+	turns        int // Agent turns queued or running
 	mutex        sync.RWMutex
 }
 
+// newConversationContext creates the conversation of a new session
+func newConversationContext(sessionID string) *ConversationContext {
+	return &ConversationContext{
+		SessionID: sessionID,
+		Messages:  []ConversationMessage{},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Budget:    ai.DefaultContextBudget(),
+		
+		// Initialize Phase 2 streaming support
+		ActiveOperations: make(map[string]interface{}),
+		StreamingEnabled: true,
+	}
+}
+
 // AddMessage adds a message to the conversation context
 func (c *ConversationContext) AddMessage(role, content string) {
 	c.mutex.Lock()
@@ -112,6 +159,51 @@ func (c *ConversationContext) AddCost(cost ai.TokenCost) {
 	c.UpdatedAt = time.Now()
 }
 
+// AddConsultCost adds what consulting a forked agent cost, kept apart from
+// the conversation's own cost
+func (c *ConversationContext) AddConsultCost(cost ai.TokenCost) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	c.ConsultCost += cost.TotalCost
+	c.UpdatedAt = time.Now()
+}
+
+// SetBudget sets the conversation's context budget
+func (c *ConversationContext) SetBudget(budget ai.ContextBudget) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Budget = budget
+}
+
+// GetBudget returns the conversation's context budget
+func (c *ConversationContext) GetBudget() ai.ContextBudget {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.Budget
+}
+
+// CompactMessages replaces the first count messages, the ones compaction
+// was given, with their compacted form. Messages added since are kept.
+func (c *ConversationContext) CompactMessages(count int, compacted []ConversationMessage, tokens int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	
+	if count > len(c.Messages) {
+		count = len(c.Messages)
+	}
+	c.Messages = append(append([]ConversationMessage{}, compacted...), c.Messages[count:]...)
+	c.ContextTokens = tokens
+	c.UpdatedAt = time.Now()
+}
+
+// SetContextTokens records the conversation's measured size
+func (c *ConversationContext) SetContextTokens(tokens int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ContextTokens = tokens
+}
+
 // GetMessages returns a copy of the conversation messages
 func (c *ConversationContext) GetMessages() []ConversationMessage {
 	c.mutex.RLock()
@@ -160,14 +252,19 @@ func (c *ConversationContext) ClearMessages() {
 // WebSocketServer manages WebSocket connections and conversations
 type WebSocketServer struct {
 	upgrader      websocket.Upgrader
-	clients       map[*websocket.Conn]string
+	clients       map[*websocket.Conn]*clientInfo
 	conversations map[string]*ConversationContext
 	claude        *ai.ClaudeClient
+	contextManager *stackctx.GitContextManager // Persistent workspace context, optional
+	sessions      *SessionStore // Where conversations persist, optional
+	auth          *Authenticator // Who may connect; nil for local connections only
+	localHosts    []string // Names local connections may use besides localhost
+	parked        map[string]parkedAgent // Agents of unloaded sessions, keeping their shell output
+	jobs          *jobStore // Messages posted over the REST API
+	started       time.Time
 	mutex         sync.RWMutex
-	
-	// New Phase 2 streaming support
-	streamingCallbacks map[string]StreamingCallback // sessionID -> callback
-	streamingMutex     sync.RWMutex
+	writers       map[*websocket.Conn]*connWriter // Each connection's own writer
+	writersMutex  sync.Mutex // Guards writers, never held while writing
 }
 
 // NewWebSocketServer creates a new WebSocket server
@@ -182,17 +279,18 @@ func NewWebSocketServer() *WebSocketServer {
 	return &WebSocketServer{
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
-				// Allow connections from any origin for development
-				// In production, you should restrict this
+				// authenticate checked the origin before the upgrade
 				return true
 			},
 		},
-		clients:       make(map[*websocket.Conn]string),
+		clients:       make(map[*websocket.Conn]*clientInfo),
+		writers:       make(map[*websocket.Conn]*connWriter),
 		conversations: make(map[string]*ConversationContext),
 		claude:        claude,
-		
-		// Initialize Phase 2 streaming support
-		streamingCallbacks: make(map[string]StreamingCallback),
+		sessions:      NewSessionStore(""),
+		parked:        make(map[string]parkedAgent),
+		jobs:          newJobStore(),
+		started:       time.Now(),
 	}
 }
 
@@ -203,61 +301,474 @@ func (ws *WebSocketServer) GetConversationContext(sessionID string) *Conversatio
 	return ws.conversations[sessionID]
 }
 
-// SendToClient sends a message to a specific client
+// Agent returns the server's Claude client, nil if it is unavailable
+func (ws *WebSocketServer) Agent() *ai.ClaudeClient {
+	return ws.claude
+}
+
+// SetSessionStore sets where conversations persist; nil keeps them in
+// memory only
+func (ws *WebSocketServer) SetSessionStore(store *SessionStore) {
+	ws.sessions = store
+}
+
+// SetContextManager connects persistent workspace context to the server and
+// its Claude client
+func (ws *WebSocketServer) SetContextManager(cm *stackctx.GitContextManager) {
+	ws.contextManager = cm
+	if ws.claude != nil {
+		ws.claude.SetContextManager(cm.ContextManager)
+		ws.claude.SetSnapshotSource(cm)
+	}
+}
+
+// contextUpdate builds the payload of context_updated events: the context
+// state plus, when persistent context is available, its memory and knowledge
+func (ws *WebSocketServer) contextUpdate(sessionID string, conversation *ConversationContext) map[string]interface{} {
+	update := map[string]interface{}{"contextState": ws.contextState(sessionID, conversation)}
+	if ws.contextManager != nil {
+		update["protectedMemory"] = ws.contextManager.GetMemory()
+		update["knowledgeBase"] = ws.contextManager.GetAllKnowledge()
+		update["workspaceState"] = workspaceStatePayload(ws.contextManager.GetWorkspace())
+	}
+	return update
+}
+
+// budgetPresets lists the budgets the GUI offers
+func budgetPresets() []ai.ContextBudget {
+	var presets []ai.ContextBudget
+	for _, name := range ai.BudgetPresetNames() {
+		budget, _ := ai.BudgetPreset(name)
+		presets = append(presets, budget)
+	}
+	return presets
+}
+
+// workspaceStatePayload converts the workspace state to the GUI's camelCase
+// WorkspaceState shape
+func workspaceStatePayload(state stackctx.WorkspaceState) map[string]interface{} {
+	commands := make([]map[string]interface{}, 0, len(state.CommandHistory))
+	for _, record := range state.CommandHistory {
+		commands = append(commands, map[string]interface{}{
+			"command":   record.Command,
+			"handleId":  record.HandleID,
+			"timestamp": record.Timestamp,
+			"exitCode":  record.ExitCode,
+			"duration":  record.Duration,
+			"summary":   record.Summary,
+		})
+	}
+	
+	return map[string]interface{}{
+		"activeFiles":    state.ActiveFiles,
+		"commandHistory": commands,
+		"workingDir":     state.WorkingDir,
+		"activeHandles":  state.ActiveHandles,
+		"currentTask":    state.CurrentTask,
+		"projectContext": state.ProjectContext,
+		"lastActivity":   state.LastActivity,
+	}
+}
+
+// contextState builds the contextState payload of context_updated events
+// from the persistent context and the session's conversation
+func (ws *WebSocketServer) contextState(sessionID string, conversation *ConversationContext) map[string]interface{} {
+	conversation.mutex.RLock()
+	defer conversation.mutex.RUnlock()
+	
+	state := map[string]interface{}{
+		"sessionId":        sessionID,
+		"messageCount":     len(conversation.Messages),
+		"memoryEntries":    0,
+		"knowledgeEntries": 0,
+		"commandHistory":   0,
+		"activeHandles":    0,
+		"activeFiles":      0,
+		"lastActivity":     conversation.UpdatedAt,
+		"createdAt":        conversation.CreatedAt,
+		"totalCost":        conversation.TotalCost,
+		"requestCount":     conversation.RequestCount,
+		"consultCost":      conversation.ConsultCost,
+		"budget":           conversation.Budget,
+		"contextTokens":    conversation.ContextTokens,
+		"budgetPresets":    budgetPresets(),
+		"cacheStats":       conversation.CacheStats,
+	}
+	
+	if ws.contextManager != nil {
+		stats := ws.contextManager.GetStats()
+		state["memoryEntries"] = stats.MemoryEntries
+		state["knowledgeEntries"] = stats.KnowledgeEntries
+		state["commandHistory"] = stats.CommandHistory
+		state["activeHandles"] = stats.ActiveHandles
+		state["activeFiles"] = stats.ActiveFiles
+		state["gitBranch"] = stats.GitBranch
+		state["gitCommit"] = stats.GitCommit
+		if stats.LastActivity.After(conversation.UpdatedAt) {
+			state["lastActivity"] = stats.LastActivity
+		}
+	}
+	return state
+}
+
+// SendToClient sends a message to a specific client. It only queues the
+// message for the connection's writer, so it never blocks on the client.
 func (ws *WebSocketServer) SendToClient(client *websocket.Conn, event WebSocketEvent) error {
 	message, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
+	writer := ws.writerOf(client)
+	if writer == nil {
+		return errClientGone
+	}
+	return writer.send(message)
+}
 
-	return client.WriteMessage(websocket.TextMessage, message)
+// sendToSession sends an event to every client attached to a session or,
+// while none is, queues it for the client that resumes the session. Agent
+// turns report through this, so they outlive the connection they began on.
+func (ws *WebSocketServer) sendToSession(sessionID string, event WebSocketEvent) {
+	event.SessionID = sessionID
+	ws.mutex.RLock()
+	var recipients []*websocket.Conn
+	for conn, client := range ws.clients {
+		if client.SessionID == sessionID {
+			recipients = append(recipients, conn)
+		}
+	}
+	conversation := ws.conversations[sessionID]
+	if len(recipients) == 0 {
+		// Queue under the lock, so a resume can't flush the queue between
+		// finding no clients and queueing
+		if conversation != nil {
+			conversation.queueEvent(event)
+		}
+		ws.mutex.RUnlock()
+		return
+	}
+	ws.mutex.RUnlock()
+	
+	// Write outside the lock. If every client left meanwhile, the event
+	// waits for the next resume.
+	delivered := 0
+	for _, conn := range recipients {
+		if ws.SendToClient(conn, event) == nil {
+			delivered++
+		}
+	}
+	if delivered == 0 && conversation != nil {
+		conversation.queueEvent(event)
+	}
 }
 
-// generateSessionID generates a unique session ID
-func generateSessionID() string {
-	return fmt.Sprintf("session_%d", time.Now().UnixNano())
+// sessionOf returns the session a connection is attached to
+func (ws *WebSocketServer) sessionOf(conn *websocket.Conn) string {
+	client, _ := ws.clientOf(conn)
+	return client.SessionID
 }
 
-// HandleWebSocket handles WebSocket connections
-func (ws *WebSocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+// attachedUnsafe reports whether any connection is attached to a session.
+// The caller holds ws.mutex.
+func (ws *WebSocketServer) attachedUnsafe(sessionID string) bool {
+	for _, client := range ws.clients {
+		if client.SessionID == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSession persists a conversation, logging failures
+func (ws *WebSocketServer) saveSession(conversation *ConversationContext) {
+	if ws.sessions == nil {
 		return
 	}
+	if err := ws.sessions.Save(conversation); err != nil {
+		log.Printf("Failed to save session %s: %v", conversation.SessionID, err)
+	}
+}
 
-	// Generate a unique session ID
+// releaseSessionUnsafe saves and unloads a session that no client is
+// attached to and no turn is running in. The caller holds ws.mutex.
+func (ws *WebSocketServer) releaseSessionUnsafe(sessionID string) {
+	conversation := ws.conversations[sessionID]
+	if ws.sessions == nil || conversation == nil || conversation.Busy() || ws.attachedUnsafe(sessionID) {
+		return
+	}
+	ws.saveSession(conversation)
+	ws.parkAgentUnsafe(conversation)
+	delete(ws.conversations, sessionID)
+}
+
+// startSession attaches a connection to a new session and tells the
+// client its ID, the token that resumes it and the token that lets others
+// watch it
+func (ws *WebSocketServer) startSession(conn *websocket.Conn) string {
 	sessionID := generateSessionID()
+	token, hash := newResumeToken()
+	observerToken, observerHash := newResumeToken()
+	conversation := newConversationContext(sessionID)
+	conversation.ResumeTokenHash = hash
+	conversation.ObserverTokenHash = observerHash
 	
-	// Register the connection with session ID
 	ws.mutex.Lock()
-	ws.clients[conn] = sessionID
-	// Create new conversation context for this session
-	ws.conversations[sessionID] = &ConversationContext{
+	client := ws.clients[conn]
+	conversation.Owner = client.Identity.User
+	ws.conversations[sessionID] = conversation
+	role := RoleParticipant
+	if !client.Identity.Allows(RoleUser) {
+		role = RoleObserver
+	}
+	ws.SendToClient(conn, WebSocketEvent{
+		Type: string(EventSessionStarted),
+		Data: map[string]interface{}{
+			"sessionId":     sessionID,
+			"resumeToken":   token,
+			"observerToken": observerToken,
+			"clientId":      client.ID,
+			"user":          client.Identity.User,
+			"role":          role,
+			"message":       "WebSocket connection established",
+		},
+		Timestamp: time.Now(),
 		SessionID: sessionID,
-		Messages:  []ConversationMessage{},
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		
-		// Initialize Phase 2 streaming support
-		ActiveOperations: make(map[string]interface{}),
-		StreamingEnabled: true,
+	})
+	if previous := ws.attachUnsafe(conn, sessionID, role); previous != "" {
+		ws.releaseSessionUnsafe(previous)
 	}
 	ws.mutex.Unlock()
+	return sessionID
+}
 
-	log.Printf("Client connected with session ID: %s", sessionID)
+// loadSession returns a loaded session or loads it from the store
+func (ws *WebSocketServer) loadSession(sessionID string) (*ConversationContext, error) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if conversation, ok := ws.conversations[sessionID]; ok {
+		return conversation, nil
+	}
+	if ws.sessions == nil {
+		return nil, fmt.Errorf("no session %s", sessionID)
+	}
+	conversation, err := ws.sessions.Load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if parked, ok := ws.parked[sessionID]; ok {
+		conversation.agent = parked.agent
+		delete(ws.parked, sessionID)
+	}
+	ws.conversations[sessionID] = conversation
+	return conversation, nil
+}
 
-	// Send session started event
-	sessionStartedEvent := WebSocketEvent{
-		Type: "session_started",
+// sessionError tells a client a session request failed
+func (ws *WebSocketServer) sessionError(client *websocket.Conn, request string, err error) {
+	ws.SendToClient(client, WebSocketEvent{
+		Type: string(EventSessionError),
+		Data: map[string]interface{}{
+			"request": request,
+			"error":   err.Error(),
+		},
+		Timestamp: time.Now(),
+		SessionID: ws.sessionOf(client),
+	})
+}
+
+// handleResumeSession attaches the connection to a session given its
+// resume token, or read-only given its observer token or role "observer".
+// Other clients may be attached too; all of them receive the session's
+// events. The client gets the history and the events queued while no
+// client was attached. A turn still running reports here from now.
+func (ws *WebSocketServer) handleResumeSession(client *websocket.Conn, event WebSocketEvent) {
+	data, _ := event.Data.(map[string]interface{})
+	sessionID, _ := data["sessionId"].(string)
+	token, _ := data["resumeToken"].(string)
+	requested, _ := data["role"].(string)
+	
+	conversation, err := ws.loadSession(sessionID)
+	role := ""
+	if err == nil {
+		info, _ := ws.clientOf(client)
+		role, err = info.sessionRole(conversation, token, requested)
+	}
+	if err != nil {
+		log.Printf("Resume failed: %v", err)
+		ws.mutex.Lock()
+		ws.releaseSessionUnsafe(sessionID)
+		ws.mutex.Unlock()
+		ws.sessionError(client, string(EventResumeSession), err)
+		return
+	}
+	
+	// Attach and flush under the lock, so events of a running turn can't
+	// overtake the queued ones
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.clients[client] == nil {
+		return
+	}
+	if name, _ := data["name"].(string); name != "" {
+		ws.clients[client].Name = name
+	}
+	if previous := ws.attachUnsafe(client, sessionID, role); previous != sessionID {
+		ws.releaseSessionUnsafe(previous)
+	}
+	
+	ws.SendToClient(client, WebSocketEvent{
+		Type: string(EventSessionResumed),
 		Data: map[string]interface{}{
 			"sessionId": sessionID,
-			"message":   "WebSocket connection established",
+			"messages":  conversation.GetMessages(),
+			"running":   conversation.Busy(),
+			"role":      role,
+			"clients":   ws.presenceUnsafe(sessionID),
 		},
 		Timestamp: time.Now(),
 		SessionID: sessionID,
+	})
+	for _, pending := range conversation.takePendingEvents() {
+		ws.SendToClient(client, pending)
+	}
+	ws.SendToClient(client, WebSocketEvent{
+		Type:      "context_updated",
+		Data:      ws.contextUpdate(sessionID, conversation),
+		Timestamp: time.Now(),
+		SessionID: sessionID,
+	})
+	log.Printf("Client resumed session ID: %s as %s", sessionID, role)
+}
+
+// handleListSessions sends the stored sessions
+func (ws *WebSocketServer) handleListSessions(client *websocket.Conn, event WebSocketEvent) {
+	var sessions []SessionInfo
+	if ws.sessions != nil {
+		var err error
+		if sessions, err = ws.sessions.List(); err != nil {
+			ws.sessionError(client, string(EventListSessions), err)
+			return
+		}
+	}
+	
+	self, _ := ws.clientOf(client)
+	current := self.SessionID
+	list := make([]map[string]interface{}, 0, len(sessions))
+	for _, info := range sessions {
+		if !self.owns(info.Owner) {
+			continue
+		}
+		running := false
+		if conversation := ws.GetConversationContext(info.SessionID); conversation != nil {
+			running = conversation.Busy()
+		}
+		list = append(list, map[string]interface{}{
+			"sessionId":    info.SessionID,
+			"owner":        info.Owner,
+			"title":        info.Title,
+			"createdAt":    info.CreatedAt,
+			"updatedAt":    info.UpdatedAt,
+			"messageCount": info.MessageCount,
+			"totalCost":    info.TotalCost,
+			"current":      info.SessionID == current,
+			"running":      running,
+		})
+	}
+	ws.SendToClient(client, WebSocketEvent{
+		Type:      string(EventSessionsList),
+		Data:      map[string]interface{}{"sessions": list},
+		Timestamp: time.Now(),
+		SessionID: current,
+	})
+}
+
+// handleDeleteSession deletes a session given its resume token. A session
+// with a running turn or another client attached is kept. Deleting the
+// connection's own session starts a new one.
+func (ws *WebSocketServer) handleDeleteSession(client *websocket.Conn, event WebSocketEvent) {
+	data, _ := event.Data.(map[string]interface{})
+	sessionID, _ := data["sessionId"].(string)
+	token, _ := data["resumeToken"].(string)
+	
+	conversation, err := ws.loadSession(sessionID)
+	if err == nil {
+		info, _ := ws.clientOf(client)
+		if role, roleErr := info.sessionRole(conversation, token, ""); roleErr != nil {
+			err = roleErr
+		} else if role != RoleParticipant {
+			err = fmt.Errorf("observers can't delete session %s", sessionID)
+		}
+	}
+	if err == nil && conversation.Busy() {
+		err = fmt.Errorf("session %s is running a turn", sessionID)
+	}
+	
+	own := ws.sessionOf(client) == sessionID
+	ws.mutex.Lock()
+	if err == nil {
+		for conn, other := range ws.clients {
+			if other.SessionID == sessionID && conn != client {
+				err = fmt.Errorf("session %s is open elsewhere", sessionID)
+			}
+		}
+	}
+	if err == nil {
+		delete(ws.conversations, sessionID)
+		delete(ws.parked, sessionID)
+		if ws.sessions != nil {
+			err = ws.sessions.Delete(sessionID)
+		}
+	} else {
+		ws.releaseSessionUnsafe(sessionID)
+	}
+	ws.mutex.Unlock()
+	if err != nil {
+		ws.sessionError(client, string(EventDeleteSession), err)
+		return
+	}
+	
+	ws.SendToClient(client, WebSocketEvent{
+		Type:      string(EventSessionDeleted),
+		Data:      map[string]interface{}{"sessionId": sessionID},
+		Timestamp: time.Now(),
+		SessionID: sessionID,
+	})
+	if own {
+		ws.startSession(client)
+	}
+}
+
+// generateSessionID generates a unique session ID
+func generateSessionID() string {
+	return fmt.Sprintf("session_%d", time.Now().UnixNano())
+}
+
+// HandleWebSocket handles WebSocket connections
+func (ws *WebSocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// The socket runs shell commands, so nobody gets in unauthenticated
+	identity, status, err := ws.authenticate(r)
+	if err != nil {
+		log.Printf("WebSocket connection refused: %v", err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, err.Error(), status)
+		return
 	}
-	ws.SendToClient(conn, sessionStartedEvent)
+	
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	ws.addWriter(conn)
+
+	// Start a new session; the client may resume an earlier one instead
+	ws.mutex.Lock()
+	ws.clients[conn] = newClientInfo(r.URL.Query().Get("name"), identity)
+	ws.mutex.Unlock()
+	sessionID := ws.startSession(conn)
+	log.Printf("Client connected as %s (%s) with session ID: %s", identity.User, identity.Role, sessionID)
 
 	// Handle incoming messages
 	for {
@@ -278,26 +789,35 @@ func (ws *WebSocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 			continue
 		}
 
-		// Set session ID if not provided
-		if event.SessionID == "" {
-			event.SessionID = sessionID
+		// Events always act on the connection's session, whatever the
+		// client claims, and observers can't change it
+		client, _ := ws.clientOf(conn)
+		event.SessionID = client.SessionID
+		if client.Role == RoleObserver && !observerEvents[event.Type] {
+			ws.sessionError(conn, event.Type, fmt.Errorf("observers can't send %s", event.Type))
+			continue
 		}
 
 		// Handle the event
 		ws.handleEvent(conn, event)
 	}
 
-	// Unregister the connection and clean up conversation
+	// Detach the connection. The conversation is saved and stays
+	// resumable; a running turn keeps going and queues its events.
 	ws.mutex.Lock()
+	client := ws.clients[conn]
+	sessionID = client.SessionID
 	delete(ws.clients, conn)
-	delete(ws.conversations, sessionID)
+	ws.broadcastPresenceUnsafe(sessionID, "left", client)
+	conversation := ws.conversations[sessionID]
+	if conversation != nil {
+		ws.saveSession(conversation)
+	}
+	ws.releaseSessionUnsafe(sessionID)
 	ws.mutex.Unlock()
 
-	// CRITICAL FIX: Clean up streaming callbacks on disconnect
-	ws.RemoveStreamingCallback(sessionID)
-
 	log.Printf("Client disconnected, session ID: %s", sessionID)
-	conn.Close()
+	ws.removeWriter(conn)
 }
 
 // handleEvent processes events from clients
@@ -324,20 +844,9 @@ func (ws *WebSocketServer) handleEvent(client *websocket.Conn, event WebSocketEv
 		}
 		
 		// Send context information with real data
-		contextData := map[string]interface{}{
-			"sessionId":        event.SessionID,
-			"memoryEntries":    len(context.Messages),
-			"knowledgeEntries": 0,
-			"commandHistory":   0,
-			"activeHandles":    0,
-			"activeFiles":      0,
-			"lastActivity":     context.UpdatedAt,
-			"createdAt":        context.CreatedAt,
-		}
-		
 		response := WebSocketEvent{
 			Type:      "context_updated",
-			Data:      map[string]interface{}{"contextState": contextData},
+			Data:      ws.contextUpdate(event.SessionID, context),
 			Timestamp: time.Now(),
 			SessionID: event.SessionID,
 		}
@@ -347,6 +856,23 @@ func (ws *WebSocketServer) handleEvent(client *websocket.Conn, event WebSocketEv
 		// Handle chat message
 		ws.handleChatMessage(client, event)
 
+	case "consult":
+		// Ask a past self or another model
+		ws.handleConsult(client, event)
+
+	case "set_budget":
+		// Change the session's context budget
+		ws.handleSetBudget(client, event)
+
+	case "resume_session":
+		ws.handleResumeSession(client, event)
+
+	case "list_sessions":
+		ws.handleListSessions(client, event)
+
+	case "delete_session":
+		ws.handleDeleteSession(client, event)
+
 	case "configure_streaming":
 		// Handle streaming configuration
 		ws.handleConfigureStreaming(client, event)
@@ -390,20 +916,9 @@ func (ws *WebSocketServer) handleChatMessage(client *websocket.Conn, event WebSo
 	}
 
 	log.Printf("Received chat message: %s", message)
-
-	// Handle session ID race condition
 	actualSessionID := event.SessionID
-	if actualSessionID == "current" {
-		// Find the actual session ID for this connection
-		ws.mutex.RLock()
-		if realSessionID, exists := ws.clients[client]; exists {
-			actualSessionID = realSessionID
-			log.Printf("Correcting session ID from 'current' to '%s'", actualSessionID)
-		}
-		ws.mutex.RUnlock()
-	}
 
-	// Send user message confirmation with corrected session ID
+	// Send user message confirmation
 	userMessageResponse := WebSocketEvent{
 		Type: "user_message",
 		Data: map[string]interface{}{
@@ -414,7 +929,7 @@ func (ws *WebSocketServer) handleChatMessage(client *websocket.Conn, event WebSo
 		Timestamp: time.Now(),
 		SessionID: actualSessionID,
 	}
-	ws.SendToClient(client, userMessageResponse)
+	ws.sendToSession(actualSessionID, userMessageResponse)
 
 	// Check if Claude client is available
 	if ws.claude == nil {
@@ -426,211 +941,338 @@ func (ws *WebSocketServer) handleChatMessage(client *websocket.Conn, event WebSo
 			Timestamp: time.Now(),
 			SessionID: actualSessionID,
 		}
-		ws.SendToClient(client, errorResponse)
+		ws.sendToSession(actualSessionID, errorResponse)
 		return
 	}
 
 	// Get conversation context and add user message
 	context := ws.GetConversationContext(actualSessionID)
 	if context == nil {
-		// Create context if it doesn't exist
-		ws.mutex.Lock()
-		context = &ConversationContext{
-			SessionID: actualSessionID,
-			Messages:  []ConversationMessage{},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			
-			// Initialize Phase 2 streaming support
-			ActiveOperations: make(map[string]interface{}),
-			StreamingEnabled: true,
-		}
-		ws.conversations[actualSessionID] = context
-		ws.mutex.Unlock()
+		log.Printf("No conversation for session %s", actualSessionID)
+		return
 	}
 
-	// Check if there are messages in the "current" session that need to be transferred
-	if actualSessionID != "current" {
-		ws.mutex.Lock()
-		if currentContext, exists := ws.conversations["current"]; exists && len(currentContext.Messages) > 0 {
-			log.Printf("Transferring %d messages from 'current' session to '%s'", len(currentContext.Messages), actualSessionID)
-			// Transfer messages from "current" to actual session
-			for _, msg := range currentContext.Messages {
-				context.Messages = append(context.Messages, msg)
-			}
-			// Clear the current session
-			delete(ws.conversations, "current")
-		}
-		ws.mutex.Unlock()
+	// Queue the turn behind the session's earlier ones. It runs on the
+	// session's own agent and outlives the connection: its events are
+	// queued until a client resumes.
+	agent := ws.sessionAgent(context)
+	ahead := ws.enqueueTurn(context, func() {
+		ws.runTurn(context, agent, message)
+	})
+	if ahead > 0 {
+		ws.sendToSession(actualSessionID, WebSocketEvent{
+			Type: string(EventTurnQueued),
+			Data: map[string]interface{}{
+				"id":       messageID,
+				"position": ahead,
+			},
+			Timestamp: time.Now(),
+			SessionID: actualSessionID,
+		})
 	}
+}
 
+// turnResult is how an agent turn ended
+type turnResult struct {
+	Response         string
+	Cost             ai.TokenCost
+	OperationSummary ai.OperationSummary
+	Err              error
+}
+
+// runTurn runs a user message as a turn of the session on its agent,
+// reporting to the session's clients. The caller queued it with
+// enqueueTurn.
+func (ws *WebSocketServer) runTurn(context *ConversationContext, agent *ai.ClaudeClient, message string) turnResult {
+	actualSessionID := context.SessionID
+	var response string
+	var cost ai.TokenCost
+	var operationSummary ai.OperationSummary
+	var err error
+	
 	context.AddMessage("user", message)
+	ws.saveSession(context)
 	
 	// Send updated context information after adding user message
-	contextData := map[string]interface{}{
-		"sessionId":        actualSessionID,
-		"memoryEntries":    len(context.Messages),
-		"knowledgeEntries": 0,
-		"commandHistory":   0,
-		"activeHandles":    0,
-		"activeFiles":      0,
-		"lastActivity":     context.UpdatedAt,
-		"createdAt":        context.CreatedAt,
-	}
-	
 	contextResponse := WebSocketEvent{
 		Type:      "context_updated",
-		Data:      map[string]interface{}{"contextState": contextData},
+		Data:      ws.contextUpdate(actualSessionID, context),
 		Timestamp: time.Now(),
 		SessionID: actualSessionID,
 	}
-	ws.SendToClient(client, contextResponse)
-
-	// Send AI response in a goroutine to avoid blocking
-	go func() {
-		var response string
-		var cost ai.TokenCost
-		var operationSummary ai.OperationSummary
-		var err error
-		
-		// Set up streaming callback for real-time operations
-		ws.SetStreamingCallback(actualSessionID, func(eventType WebSocketEventType, data interface{}, sessionID string) {
-			// Handle streaming events from Claude client
-			ws.SendStreamingEvent(sessionID, eventType, data)
-		})
-		
-		// Set up debug callback for function calls (backward compatibility)
-		ws.claude.SetDebugCallback(func(eventType string, data interface{}) {
-			debugEvent := WebSocketEvent{
-				Type: "debug_message",
-				Data: map[string]interface{}{
-					"type":    eventType,
-					"message": fmt.Sprintf("Function call: %s", eventType),
-					"data":    data,
-				},
-				Timestamp: time.Now(),
-				SessionID: actualSessionID,
-			}
-			ws.SendToClient(client, debugEvent)
-		})
-		
-		// FIXED: Re-enable streaming with safeguards
-		// Enable completion events for widgets to work properly
-		ws.claude.SetStreamingCallback(func(eventType string, data interface{}) {
-			// Enable essential streaming events with session cleanup protection
-			switch eventType {
-			case "function_call_start":
-				ws.SendStreamingEvent(actualSessionID, EventFunctionCallStarted, data)
-			case "function_call_success", "function_call_complete":
-				ws.SendStreamingEvent(actualSessionID, EventFunctionCallCompleted, data)
-			case "function_call_error":
-				ws.SendStreamingEvent(actualSessionID, EventFunctionCallFailed, data)
-			// Enable shell command completion for widgets
-			case "shell_command_started":
-				ws.SendStreamingEvent(actualSessionID, EventShellCommandStarted, data)
-			case "shell_command_completed":
-				ws.SendStreamingEvent(actualSessionID, EventShellCommandCompleted, data)
-			// Enable file operation completion for widgets  
-			case "file_operation_started":
-				ws.SendStreamingEvent(actualSessionID, EventFileOperationStarted, data)
-			case "file_operation_completed":
-				ws.SendStreamingEvent(actualSessionID, EventFileOperationCompleted, data)
-			// Skip streaming events that caused infinite loops
-			// case "shell_command_streaming": // DISABLED - caused loops
-			// case "file_operation_streaming": // DISABLED - caused loops
-			}
-		})
-		
-		// Get conversation history
-		messages := context.GetMessages()
-		
-		// Send debug information about what we're sending to Claude
-		cachedComponents := []string{"system_prompt", "tool_definitions"}
-		if len(messages) > 1 {
-			cachedComponents = append(cachedComponents, "conversation_history")
-		}
-		
-		debugInfo := map[string]interface{}{
-			"type":        "claude_api_request",
-			"message":     "Sending request to Claude API with advanced caching",
-			"messageCount": len(messages),
-			"messages":    messages,
-			"hasSystemPrompt": true,
-			"systemPrompt":    "You are StackAgent, a helpful AI coding assistant with access to powerful file manipulation and shell command tools. Available functions: run_with_capture (shell commands), read_file (read files), write_file (create/write files), edit_file (find/replace in files), search_in_file (search with context), list_directory (list files with filters). Use these functions to efficiently help with coding tasks, file operations, and system administration. Be concise but helpful. Remember context from previous messages in this conversation.\n\nCore principle: Don't be evil. Always prioritize user safety, privacy, and ethical behavior.",
-			"cachingEnabled": true,
-			"cachedComponents": cachedComponents,
-			"costReduction": "Up to 90% for cached content (including conversation history and file content)",
-		}
-		
+	ws.sendToSession(actualSessionID, contextResponse)
+	
+	// Debug events of this run's function calls
+	debug := func(eventType string, data interface{}) {
 		debugEvent := WebSocketEvent{
 			Type: "debug_message",
-			Data: debugInfo,
+			Data: map[string]interface{}{
+				"type":    eventType,
+				"message": fmt.Sprintf("Function call: %s", eventType),
+				"data":    data,
+			},
 			Timestamp: time.Now(),
 			SessionID: actualSessionID,
 		}
-		ws.SendToClient(client, debugEvent)
-		
-		// Call Claude API with context
-		response, cost, operationSummary, err = ws.claude.ChatWithToolsAndContext(messages)
-		if err != nil {
-			log.Printf("Claude API error: %v", err)
-			
-			// Send error response to client
-			errorResponse := WebSocketEvent{
-				Type: "ai_error", 
+		ws.sendToSession(actualSessionID, debugEvent)
+	}
+	
+	// Streaming events of this run; completion events drive the widgets
+	events := func(eventType string, data interface{}) {
+		// Enable essential streaming events with session cleanup protection
+		switch eventType {
+		case "function_call_start":
+			ws.SendStreamingEvent(actualSessionID, EventFunctionCallStarted, data)
+		case "function_call_success", "function_call_complete":
+			ws.SendStreamingEvent(actualSessionID, EventFunctionCallCompleted, data)
+		case "function_call_error":
+			ws.SendStreamingEvent(actualSessionID, EventFunctionCallFailed, data)
+		// Enable shell command completion for widgets
+		case "shell_command_started":
+			ws.SendStreamingEvent(actualSessionID, EventShellCommandStarted, data)
+		case "shell_command_completed":
+			ws.SendStreamingEvent(actualSessionID, EventShellCommandCompleted, data)
+		// Enable file operation completion for widgets  
+		case "file_operation_started":
+			ws.SendStreamingEvent(actualSessionID, EventFileOperationStarted, data)
+		case "file_operation_completed":
+			ws.SendStreamingEvent(actualSessionID, EventFileOperationCompleted, data)
+		// The model consulted a fork; its cost is not the turn's
+		case "consult_completed":
+			if payload, ok := data.(map[string]interface{}); ok {
+				if cost, ok := payload["cost"].(ai.TokenCost); ok {
+					context.AddConsultCost(cost)
+				}
+			}
+			ws.SendStreamingEvent(actualSessionID, EventConsultCompleted, data)
+		// Skip streaming events that caused infinite loops
+		// case "shell_command_streaming": // DISABLED - caused loops
+		// case "file_operation_streaming": // DISABLED - caused loops
+		}
+	}
+	
+	// Get conversation history, compacted if it outgrew the budget
+	messages := context.GetMessages()
+	budget := context.GetBudget()
+	compacted, report, err := agent.CompactConversation(messages, budget)
+	if err != nil {
+		log.Printf("Context compaction error: %v", err)
+	} else {
+		if report.Compacted() {
+			context.CompactMessages(len(messages), compacted, report.After)
+			context.AddCost(report.Cost)
+			ws.sendToSession(actualSessionID, WebSocketEvent{
+				Type: string(EventContextCompacted),
 				Data: map[string]interface{}{
-					"error": fmt.Sprintf("Failed to get AI response: %v", err),
+					"message": report.String(),
+					"report":  report,
+					"budget":  budget,
 				},
 				Timestamp: time.Now(),
 				SessionID: actualSessionID,
-			}
-			ws.SendToClient(client, errorResponse)
-			return
+			})
+		} else {
+			context.SetContextTokens(report.After)
 		}
-
-		// Add AI response to conversation context
-		context.AddMessage("assistant", response)
+		messages = compacted
+	}
+	
+	// Send debug information about what we're sending to Claude
+	cachedComponents := []string{"system_prompt", "tool_definitions"}
+	if len(messages) > 1 {
+		cachedComponents = append(cachedComponents, "conversation_history")
+	}
+	
+	debugInfo := map[string]interface{}{
+		"type":        "claude_api_request",
+		"message":     "Sending request to Claude API with advanced caching",
+		"messageCount": len(messages),
+		"messages":    messages,
+		"hasSystemPrompt": true,
+		"systemPrompt":    agent.SystemPromptText(),
+		"cachingEnabled": true,
+		"cachedComponents": cachedComponents,
+		"costReduction": "Up to 90% for cached content (including conversation history and file content)",
+	}
+	
+	debugEvent := WebSocketEvent{
+		Type: "debug_message",
+		Data: debugInfo,
+		Timestamp: time.Now(),
+		SessionID: actualSessionID,
+	}
+	ws.sendToSession(actualSessionID, debugEvent)
+	
+	// Call Claude API with context
+	response, cost, operationSummary, err = agent.Run(messages, budget, ai.RunEvents{Events: events, Debug: debug})
+	if err != nil {
+		log.Printf("Claude API error: %v", err)
 		
-		// Add cost information to context
-		context.AddCost(cost)
-
-		// Send AI response with cost and operation summary information
-		aiResponse := WebSocketEvent{
-			Type: "ai_response",
+		// Send error response to client
+		errorResponse := WebSocketEvent{
+			Type: "ai_error", 
 			Data: map[string]interface{}{
-				"message":          response,
-				"timestamp":        time.Now(),
-				"cost":            cost,
-				"operationSummary": operationSummary,
+				"error": fmt.Sprintf("Failed to get AI response: %v", err),
 			},
 			Timestamp: time.Now(),
 			SessionID: actualSessionID,
 		}
-		ws.SendToClient(client, aiResponse)
-
-		// Send updated context information with cost data
-		contextData := map[string]interface{}{
-			"sessionId":        actualSessionID,
-			"memoryEntries":    len(context.Messages),
-			"knowledgeEntries": 0,
-			"commandHistory":   0,
-			"activeHandles":    0,
-			"activeFiles":      0,
-			"lastActivity":     context.UpdatedAt,
-			"createdAt":        context.CreatedAt,
-			"totalCost":        context.TotalCost,
-			"requestCount":     context.RequestCount,
-			"cacheStats":       context.CacheStats,
+		ws.sendToSession(actualSessionID, errorResponse)
+		return turnResult{Err: fmt.Errorf("failed to get AI response: %w", err)}
+	}
+
+	// Add AI response to conversation context
+	context.AddMessage("assistant", response)
+	
+	// Add cost information to context
+	context.AddCost(cost)
+	ws.saveSession(context)
+
+	// Send AI response with cost and operation summary information
+	aiResponse := WebSocketEvent{
+		Type: "ai_response",
+		Data: map[string]interface{}{
+			"message":          response,
+			"timestamp":        time.Now(),
+			"cost":            cost,
+			"operationSummary": operationSummary,
+		},
+		Timestamp: time.Now(),
+		SessionID: actualSessionID,
+	}
+	ws.sendToSession(actualSessionID, aiResponse)
+
+	// Send updated context information with cost data
+	contextResponse = WebSocketEvent{
+		Type:      "context_updated",
+		Data:      ws.contextUpdate(actualSessionID, context),
+		Timestamp: time.Now(),
+		SessionID: actualSessionID,
+	}
+	ws.sendToSession(actualSessionID, contextResponse)
+	return turnResult{Response: response, Cost: cost, OperationSummary: operationSummary}
+}
+
+// handleConsult asks a forked agent, a past self at data.ref and/or
+// another model, a question. The answer joins the conversation so the next
+// turn can use it; its cost is accounted separately.
+func (ws *WebSocketServer) handleConsult(client *websocket.Conn, event WebSocketEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		log.Printf("Invalid consult data format")
+		return
+	}
+	question, _ := data["question"].(string)
+	ref, _ := data["ref"].(string)
+	model, _ := data["model"].(string)
+	requestID, _ := data["id"].(string)
+
+	sessionID := event.SessionID
+	sendError := func(err error) {
+		ws.sendToSession(sessionID, WebSocketEvent{
+			Type: string(EventConsultError),
+			Data: map[string]interface{}{
+				"id":    requestID,
+				"error": fmt.Sprintf("Failed to consult: %v", err),
+			},
+			Timestamp: time.Now(),
+			SessionID: sessionID,
+		})
+	}
+	conversation := ws.GetConversationContext(sessionID)
+	if ws.claude == nil || conversation == nil {
+		sendError(fmt.Errorf("Claude client not initialized"))
+		return
+	}
+
+	// The answer joins the conversation, so it waits for the session's
+	// running turn
+	agent := ws.sessionAgent(conversation)
+	ws.enqueueTurn(conversation, func() {
+		fork, answer, cost, err := agent.Consult(question, ref, model)
+		if err != nil {
+			log.Printf("Consult error: %v", err)
+			sendError(err)
+			return
 		}
-		
-		contextResponse := WebSocketEvent{
+
+		conversation.AddMessage("user", fmt.Sprintf("[Consulted %s]\nQuestion: %s\nAnswer: %s", fork.Label, question, answer))
+		conversation.AddConsultCost(cost)
+		ws.saveSession(conversation)
+
+		ws.sendToSession(sessionID, WebSocketEvent{
+			Type: string(EventConsultResponse),
+			Data: map[string]interface{}{
+				"id":        requestID,
+				"label":     fork.Label,
+				"model":     fork.Model,
+				"commit":    fork.Commit,
+				"question":  question,
+				"answer":    answer,
+				"cost":      cost,
+				"timestamp": time.Now(),
+			},
+			Timestamp: time.Now(),
+			SessionID: sessionID,
+		})
+		ws.sendToSession(sessionID, WebSocketEvent{
 			Type:      "context_updated",
-			Data:      map[string]interface{}{"contextState": contextData},
+			Data:      ws.contextUpdate(sessionID, conversation),
 			Timestamp: time.Now(),
-			SessionID: actualSessionID,
-		}
-		ws.SendToClient(client, contextResponse)
-	}()
+			SessionID: sessionID,
+		})
+	})
+}
+
+// handleSetBudget sets a session's context budget from a preset name or a
+// token count. The next message compacts the conversation if it's over.
+func (ws *WebSocketServer) handleSetBudget(client *websocket.Conn, event WebSocketEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		log.Printf("Invalid set_budget data format")
+		return
+	}
+	
+	var budget ai.ContextBudget
+	var err error
+	if preset, ok := data["preset"].(string); ok && preset != "" {
+		budget, err = ai.BudgetPreset(preset)
+	} else if maxTokens, ok := data["maxTokens"].(float64); ok && maxTokens >= 0 {
+		budget = ai.DefaultContextBudget()
+		budget.Preset = ""
+		budget.MaxTokens = int(maxTokens)
+	} else {
+		err = fmt.Errorf("set_budget needs a preset or maxTokens")
+	}
+	
+	conversation := ws.GetConversationContext(event.SessionID)
+	if err == nil && conversation == nil {
+		err = fmt.Errorf("no conversation for session %s", event.SessionID)
+	}
+	if err != nil {
+		ws.SendToClient(client, WebSocketEvent{
+			Type:      string(EventAIError),
+			Data:      map[string]interface{}{"error": err.Error()},
+			Timestamp: time.Now(),
+			SessionID: event.SessionID,
+		})
+		return
+	}
+	
+	conversation.SetBudget(budget)
+	ws.saveSession(conversation)
+	ws.SendToClient(client, WebSocketEvent{
+		Type:      string(EventBudgetUpdated),
+		Data:      map[string]interface{}{"budget": budget},
+		Timestamp: time.Now(),
+		SessionID: event.SessionID,
+	})
+	ws.SendToClient(client, WebSocketEvent{
+		Type:      "context_updated",
+		Data:      ws.contextUpdate(event.SessionID, conversation),
+		Timestamp: time.Now(),
+		SessionID: event.SessionID,
+	})
 }
 
 // handleConfigureStreaming processes streaming configuration requests
@@ -672,20 +1314,6 @@ func (ws *WebSocketServer) GetClientCount() int {
 	return len(ws.clients)
 }
 
-// SetStreamingCallback sets the streaming callback for a session
-func (ws *WebSocketServer) SetStreamingCallback(sessionID string, callback StreamingCallback) {
-	ws.streamingMutex.Lock()
-	defer ws.streamingMutex.Unlock()
-	ws.streamingCallbacks[sessionID] = callback
-}
-
-// RemoveStreamingCallback removes the streaming callback for a session
-func (ws *WebSocketServer) RemoveStreamingCallback(sessionID string) {
-	ws.streamingMutex.Lock()
-	defer ws.streamingMutex.Unlock()
-	delete(ws.streamingCallbacks, sessionID)
-}
-
 // Shutdown gracefully closes all WebSocket connections and cleans up resources
 func (ws *WebSocketServer) Shutdown() {
 	ws.mutex.Lock()
@@ -693,54 +1321,31 @@ func (ws *WebSocketServer) Shutdown() {
 	
 	log.Printf("🧹 Shutting down WebSocket server with %d active connections", len(ws.clients))
 	
+	// Save every conversation so it can be resumed after a restart
+	for _, conversation := range ws.conversations {
+		ws.saveSession(conversation)
+	}
+	
 	// Close all active WebSocket connections
-	for conn, sessionID := range ws.clients {
-		log.Printf("🔌 Closing connection for session: %s", sessionID)
-		conn.Close()
+	for conn, client := range ws.clients {
+		log.Printf("🔌 Closing connection for session: %s", client.SessionID)
+		ws.removeWriter(conn)
 	}
 	
 	// Clear all maps
-	ws.clients = make(map[*websocket.Conn]string)
+	ws.clients = make(map[*websocket.Conn]*clientInfo)
 	ws.conversations = make(map[string]*ConversationContext)
 	
-	// Clean up streaming callbacks
-	ws.streamingMutex.Lock()
-	ws.streamingCallbacks = make(map[string]StreamingCallback)
-	ws.streamingMutex.Unlock()
-	
 	log.Println("✅ WebSocket server shutdown complete")
 }
 
-// SendStreamingEvent sends a streaming event to the client
+// SendStreamingEvent sends a streaming event to the client attached to a
+// session, or queues it until one resumes the session
 func (ws *WebSocketServer) SendStreamingEvent(sessionID string, eventType WebSocketEventType, data interface{}) {
-	// Find the client connection for this session
-	var client *websocket.Conn
-	ws.mutex.RLock()
-	for conn, sid := range ws.clients {
-		if sid == sessionID {
-			client = conn
-			break
-		}
-	}
-	ws.mutex.RUnlock()
-	
-	if client == nil {
-		// CRITICAL FIX: Don't spam logs, clean up streaming callback
-		log.Printf("No client found for session ID: %s, cleaning up streaming callback", sessionID)
-		ws.RemoveStreamingCallback(sessionID)
-		return
-	}
-	
-	// Send the streaming event
-	event := WebSocketEvent{
+	ws.sendToSession(sessionID, WebSocketEvent{
 		Type:      string(eventType),
 		Data:      data,
 		Timestamp: time.Now(),
 		SessionID: sessionID,
-	}
-	
-	ws.SendToClient(client, event)
-	
-	// FIXED: Only call streaming callback if client exists
-	// This prevents recursive loops when connections are closed
-} 
\ No newline at end of file
+	})
+}
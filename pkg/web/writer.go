@@ -0,0 +1,109 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the longest a write to a client may take before the
+	// client is dropped
+	writeWait = 10 * time.Second
+	// sendQueueSize is how many events a client may fall behind by before
+	// it is dropped
+	sendQueueSize = 1024
+)
+
+var errClientGone = errors.New("client disconnected")
+
+// connWriter writes a connection's events in order from its own goroutine.
+// Sending only queues, so a client that stops reading holds up nobody but
+// itself, and callers may send while holding ws.mutex.
+type connWriter struct {
+	conn   *websocket.Conn
+	queue  chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnWriter(conn *websocket.Conn) *connWriter {
+	w := &connWriter{
+		conn:   conn,
+		queue:  make(chan []byte, sendQueueSize),
+		closed: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// send queues a message. A client too far behind is disconnected rather
+// than waited for.
+func (w *connWriter) send(message []byte) error {
+	select {
+	case <-w.closed:
+		return errClientGone
+	default:
+	}
+	select {
+	case w.queue <- message:
+		return nil
+	default:
+		log.Printf("Dropping a client %d events behind", sendQueueSize)
+		w.close()
+		return errClientGone
+	}
+}
+
+func (w *connWriter) run() {
+	for {
+		select {
+		case message := <-w.queue:
+			w.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := w.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				w.close()
+				return
+			}
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+// close stops the writer and closes the connection, which ends its read
+// loop and detaches the client
+func (w *connWriter) close() {
+	w.once.Do(func() {
+		close(w.closed)
+		w.conn.Close()
+	})
+}
+
+// addWriter starts the writer of a new connection
+func (ws *WebSocketServer) addWriter(conn *websocket.Conn) {
+	ws.writersMutex.Lock()
+	defer ws.writersMutex.Unlock()
+	ws.writers[conn] = newConnWriter(conn)
+}
+
+// removeWriter stops the writer of a connection and closes it
+func (ws *WebSocketServer) removeWriter(conn *websocket.Conn) {
+	ws.writersMutex.Lock()
+	w := ws.writers[conn]
+	delete(ws.writers, conn)
+	ws.writersMutex.Unlock()
+	if w != nil {
+		w.close()
+	}
+}
+
+// writerOf returns the writer of a connection, nil once it disconnected
+func (ws *WebSocketServer) writerOf(conn *websocket.Conn) *connWriter {
+	ws.writersMutex.Lock()
+	defer ws.writersMutex.Unlock()
+	return ws.writers[conn]
+}
@@ -20,6 +20,10 @@ export const ContextBrowser: React.FC<ContextBrowserProps> = ({ sendMessage }) =
     sendMessage('get_context', {});
   };
 
+  const handleBudgetPreset = (preset: string) => {
+    sendMessage('set_budget', { preset });
+  };
+
   return (
     <div className="p-4 space-y-6">
       {/* Conversation Messages */}
@@ -150,6 +154,42 @@ export const ContextBrowser: React.FC<ContextBrowserProps> = ({ sendMessage }) =
                     {contextState.requestCount || 0}
                   </span>
                 </div>
+                {contextState.budget && (
+                  <div className="flex justify-between items-center text-sm">
+                    <span className="text-secondary-600 dark:text-secondary-400">Context Budget</span>
+                    <select
+                      value={contextState.budget.preset || ''}
+                      onChange={(e) => handleBudgetPreset(e.target.value)}
+                      disabled={!connected}
+                      className="text-xs bg-transparent border rounded px-1 text-secondary-900 dark:text-secondary-100"
+                    >
+                      {!contextState.budget.preset && (
+                        <option value="">Custom ({Math.round(contextState.budget.maxTokens / 1000)}k)</option>
+                      )}
+                      {(contextState.budgetPresets || []).map((preset) => (
+                        <option key={preset.preset} value={preset.preset}>
+                          {preset.preset} ({Math.round(preset.maxTokens / 1000)}k)
+                        </option>
+                      ))}
+                    </select>
+                  </div>
+                )}
+                {contextState.contextTokens !== undefined && contextState.budget && (
+                  <div className="flex justify-between text-sm">
+                    <span className="text-secondary-600 dark:text-secondary-400">Context Size</span>
+                    <span className="text-secondary-900 dark:text-secondary-100 font-mono">
+                      {(contextState.contextTokens / 1000).toFixed(1)}k / {Math.round(contextState.budget.maxTokens / 1000)}k tokens
+                    </span>
+                  </div>
+                )}
+                {!!contextState.consultCost && (
+                  <div className="flex justify-between text-sm">
+                    <span className="text-secondary-600 dark:text-secondary-400">Consult Cost</span>
+                    <span className="text-secondary-900 dark:text-secondary-100 font-mono">
+                      ${contextState.consultCost.toFixed(4)}
+                    </span>
+                  </div>
+                )}
                 {contextState.cacheStats && (
                   <>
                     <div className="flex justify-between text-sm">
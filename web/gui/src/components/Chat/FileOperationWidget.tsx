@@ -43,6 +43,8 @@ export const FileOperationWidget: React.FC<FileOperationWidgetProps> = ({
         return <File className="w-4 h-4 text-green-500" />;
       case 'edit':
         return <Edit3 className="w-4 h-4 text-orange-500" />;
+      case 'patch':
+        return <Diff className="w-4 h-4 text-orange-500" />;
       case 'search':
         return <Search className="w-4 h-4 text-purple-500" />;
       case 'list':
@@ -73,7 +75,7 @@ export const FileOperationWidget: React.FC<FileOperationWidgetProps> = ({
   };
 
   const getActionIcon = (operation: FileOperation) => {
-    if (operation.type === 'edit' && operation.changes) {
+    if ((operation.type === 'edit' || operation.type === 'patch') && operation.changes) {
       return <Diff className="w-4 h-4" />;
     }
     return <Eye className="w-4 h-4" />;
@@ -1,6 +1,56 @@
 import { useCallback, useEffect, useRef, useState } from 'react';
 import { useAppStore } from '@/store';
-import type { WebSocketEvent, WebSocketEventType } from '@/types';
+import type { SessionClient, SessionSummary, WebSocketEvent, WebSocketEventType } from '@/types';
+
+// The session to resume after a reload or reconnect
+const SESSION_STORAGE_KEY = 'stackagent.session';
+
+interface SavedSession {
+  sessionId: string;
+  resumeToken: string;
+  observerToken?: string;
+}
+
+const loadSavedSession = (): SavedSession | null => {
+  try {
+    const saved = localStorage.getItem(SESSION_STORAGE_KEY);
+    return saved ? JSON.parse(saved) as SavedSession : null;
+  } catch {
+    return null;
+  }
+};
+
+const saveSession = (session: SavedSession | null) => {
+  if (session) {
+    localStorage.setItem(SESSION_STORAGE_KEY, JSON.stringify(session));
+  } else {
+    localStorage.removeItem(SESSION_STORAGE_KEY);
+  }
+};
+
+const ACCESS_TOKEN_STORAGE_KEY = 'stackagent.accessToken';
+
+// The access token from a ?access_token= link, kept for later visits, or
+// the one stored before. Browsers can't set headers on WebSockets, so it
+// goes in the URL.
+const accessToken = (): string | null => {
+  const params = new URLSearchParams(window.location.search);
+  const token = params.get('access_token');
+  if (token) {
+    localStorage.setItem(ACCESS_TOKEN_STORAGE_KEY, token);
+    return token;
+  }
+  return localStorage.getItem(ACCESS_TOKEN_STORAGE_KEY);
+};
+
+const withAccessToken = (url: string): string => {
+  const token = accessToken();
+  if (!token) {
+    return url;
+  }
+  const separator = url.includes('?') ? '&' : '?';
+  return `${url}${separator}access_token=${encodeURIComponent(token)}`;
+};
 
 interface UseWebSocketOptions {
   url?: string;
@@ -21,7 +71,12 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
   const [isConnected, setIsConnected] = useState(false);
   const [isConnecting, setIsConnecting] = useState(false);
   const [sessionId, setSessionId] = useState<string>('current');
+  const [sessions, setSessions] = useState<SessionSummary[]>([]);
+  const [presence, setPresence] = useState<SessionClient[]>([]);
+  const [role, setRole] = useState<'participant' | 'observer'>('participant');
   const isManuallyDisconnected = useRef(false);
+  // The fresh session the server started, kept while resuming an earlier one
+  const startedSession = useRef<SavedSession | null>(null);
   
   // Real-time streaming state
   const streamingBuffer = useRef<Map<string, string>>(new Map());
@@ -127,16 +182,107 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
           }
           break;
           
-        case 'session_started':
+        case 'session_started': {
           setIsConnected(true);
+          const started = {
+            sessionId: data.data.sessionId,
+            resumeToken: data.data.resumeToken,
+            observerToken: data.data.observerToken,
+          };
+          const saved = loadSavedSession();
+          setSessionId(started.sessionId);
+          setRole('participant');
+          if (saved && saved.sessionId !== started.sessionId && websocket.current?.readyState === WebSocket.OPEN) {
+            // Pick up where the last connection left off
+            startedSession.current = started;
+            websocket.current.send(JSON.stringify({
+              type: 'resume_session',
+              data: saved,
+              timestamp: new Date(),
+              sessionId: started.sessionId,
+            }));
+            break;
+          }
+          saveSession(started);
           storeRef.current.addNotification({
             type: 'success',
             title: 'Session Started',
             message: `New session: ${data.data.sessionId}`,
             timestamp: new Date(),
           });
-          // Store the actual session ID
+          break;
+        }
+          
+        case 'session_resumed':
+          // Replace the chat with the session's history; queued events follow
+          startedSession.current = null;
           setSessionId(data.data.sessionId);
+          setRole(data.data.role);
+          setPresence(data.data.clients || []);
+          storeRef.current.clearMessages();
+          (data.data.messages || []).forEach((message: any, index: number) => {
+            storeRef.current.addMessage({
+              id: `${data.data.sessionId}-${index}`,
+              sessionId: data.data.sessionId,
+              type: message.role === 'user' ? 'user' : 'assistant',
+              content: message.content,
+              timestamp: new Date(message.timestamp),
+            });
+          });
+          storeRef.current.addNotification({
+            type: 'success',
+            title: 'Session Resumed',
+            message: data.data.running ? 'The agent is still working on your last message' : `${data.data.messages?.length || 0} messages restored`,
+            timestamp: new Date(),
+          });
+          break;
+          
+        case 'presence':
+          // Someone joined or left the session
+          setPresence(data.data.clients || []);
+          if (data.data.change === 'joined' && (data.data.clients || []).length > 1) {
+            storeRef.current.addNotification({
+              type: 'info',
+              title: 'Session Shared',
+              message: `${data.data.client.name} joined as ${data.data.client.role}`,
+              timestamp: new Date(),
+            });
+          }
+          break;
+          
+        case 'turn_queued':
+          // The session is busy with an earlier message
+          storeRef.current.addNotification({
+            type: 'info',
+            title: 'Message Queued',
+            message: `Runs after ${data.data.position} earlier ${data.data.position === 1 ? 'turn' : 'turns'} in this session`,
+            timestamp: new Date(),
+          });
+          break;
+          
+        case 'sessions_list':
+          setSessions(data.data.sessions || []);
+          break;
+          
+        case 'session_deleted':
+          if (loadSavedSession()?.sessionId === data.data.sessionId) {
+            saveSession(null);
+          }
+          setSessions(prev => prev.filter(session => session.sessionId !== data.data.sessionId));
+          break;
+          
+        case 'session_error':
+          if (data.data.request === 'resume_session') {
+            // The saved session is gone; keep the fresh one instead
+            saveSession(startedSession.current);
+            startedSession.current = null;
+          }
+          storeRef.current.addNotification({
+            type: 'error',
+            title: 'Session Error',
+            message: data.data.error,
+            timestamp: new Date(),
+          });
           break;
           
         case 'session_ended':
@@ -386,6 +532,54 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
           });
           break;
           
+        case 'consult_completed':
+          // The model consulted a past self or another model
+          storeRef.current.addNotification({
+            type: 'info',
+            title: 'Consulted',
+            message: `${data.data.label} ($${(data.data.cost?.total_cost || 0).toFixed(4)}, separate from the session)`,
+            timestamp: new Date(),
+          });
+          break;
+          
+        case 'consult_response':
+          // Answer to a consult command; its cost stays out of the session cost
+          storeRef.current.addLiveMessage({
+            sessionId: data.sessionId || 'current',
+            type: 'assistant',
+            content: `**Consulted ${data.data.label}**\n\n${data.data.answer}`,
+            timestamp: new Date(data.data.timestamp),
+            isLive: false,
+          });
+          break;
+          
+        case 'consult_error':
+          storeRef.current.addNotification({
+            type: 'error',
+            title: 'Consult Failed',
+            message: data.data.error,
+            timestamp: new Date(),
+          });
+          break;
+          
+        case 'budget_updated':
+          storeRef.current.addNotification({
+            type: 'info',
+            title: 'Context Budget',
+            message: `${data.data.budget.preset || 'Custom'}: ${Math.round(data.data.budget.maxTokens / 1000)}k tokens per request`,
+            timestamp: new Date(),
+          });
+          break;
+          
+        case 'context_compacted':
+          storeRef.current.addNotification({
+            type: 'info',
+            title: 'Context Compacted',
+            message: data.data.message,
+            timestamp: new Date(),
+          });
+          break;
+          
         case 'user_message':
           // User message confirmation - update with correct session ID if needed
           const userMessage = storeRef.current.messages.find(m => m.id === data.data.id);
@@ -393,6 +587,15 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
             storeRef.current.updateMessage(data.data.id, {
               sessionId: data.sessionId,
             });
+          } else if (!userMessage) {
+            // Sent by another client attached to the session
+            storeRef.current.addMessage({
+              id: data.data.id,
+              sessionId: data.sessionId,
+              type: 'user',
+              content: data.data.message,
+              timestamp: new Date(data.data.timestamp),
+            });
           }
           break;
           
@@ -496,7 +699,7 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     setIsConnecting(true);
     
     try {
-      websocket.current = new WebSocket(url);
+      websocket.current = new WebSocket(withAccessToken(url));
       
       websocket.current.addEventListener('open', handleOpen);
       websocket.current.addEventListener('message', handleMessage);
@@ -567,6 +770,20 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     }
   };
 
+  // Session management
+  const listSessions = useCallback(() => {
+    sendMessage('list_sessions', {});
+  }, [sessionId]);
+
+  const resumeSession = useCallback((session: SavedSession) => {
+    saveSession(session);
+    sendMessage('resume_session', session);
+  }, [sessionId]);
+
+  const deleteSession = useCallback((session: SavedSession) => {
+    sendMessage('delete_session', session);
+  }, [sessionId]);
+
   // Real-time streaming control
   const enableStreaming = useCallback(() => {
     sendMessage('configure_streaming', { enabled: true });
@@ -595,6 +812,16 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     disconnect,
     sendMessage,
     
+    // Sessions
+    sessionId,
+    sessions,
+    presence,
+    role,
+    isObserver: role === 'observer',
+    listSessions,
+    resumeSession,
+    deleteSession,
+    
     // Real-time streaming features
     enableStreaming,
     disableStreaming,
@@ -53,7 +53,7 @@ export interface ShellOperation {
 
 export interface FileOperation {
   id: string;
-  type: 'read' | 'write' | 'edit' | 'search' | 'list';
+  type: 'read' | 'write' | 'edit' | 'search' | 'list' | 'patch';
   filePath: string;
   content?: string;
   changes?: string; // For edits, this would be the diff
@@ -100,6 +100,7 @@ export interface CommandExecution {
 
 export interface ContextState {
   sessionId: string;
+  messageCount?: number;
   memoryEntries: number;
   knowledgeEntries: number;
   commandHistory: number;
@@ -111,6 +112,10 @@ export interface ContextState {
   gitCommit?: string;
   totalCost?: number;
   requestCount?: number;
+  consultCost?: number;
+  budget?: ContextBudget;
+  budgetPresets?: ContextBudget[];
+  contextTokens?: number;
   cacheStats?: {
     cacheHits: number;
     cacheMisses: number;
@@ -119,6 +124,13 @@ export interface ContextState {
   };
 }
 
+// Input token budget of a session's requests; see the backend's presets
+export interface ContextBudget {
+  preset?: string;
+  maxTokens: number;
+  keepRecent: number;
+}
+
 export interface ProtectedMemory {
   [key: string]: string;
 }
@@ -289,9 +301,45 @@ export type WebSocketEventType =
   | 'get_context'
   | 'debug_message'
   | 'configure_streaming'
+  | 'consult'
+  | 'consult_completed'
+  | 'consult_response'
+  | 'consult_error'
+  | 'set_budget'
+  | 'budget_updated'
+  | 'context_compacted'
+  | 'resume_session'
+  | 'session_resumed'
+  | 'list_sessions'
+  | 'sessions_list'
+  | 'delete_session'
+  | 'session_deleted'
+  | 'session_error'
+  | 'presence'
+  | 'turn_queued'
   | 'ping'
   | 'pong';
 
+// A stored session as listed by list_sessions
+export interface SessionSummary {
+  sessionId: string;
+  title: string;
+  createdAt: string;
+  updatedAt: string;
+  messageCount: number;
+  totalCost: number;
+  current: boolean;
+  running: boolean;
+}
+
+// A client attached to the same session
+export interface SessionClient {
+  clientId: string;
+  name: string;
+  role: 'participant' | 'observer';
+  joinedAt: string;
+}
+
 export interface WebSocketEvent {
   type: WebSocketEventType;
   data: any;